@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"net"
+	"net/http"
 
 	"github.com/Haya372/hlog"
 	"github.com/Haya372/smtp-server/internal/command"
 	"github.com/Haya372/smtp-server/internal/config"
 	"github.com/Haya372/smtp-server/internal/connection"
+	"github.com/Haya372/smtp-server/internal/metrics"
 	"github.com/Haya372/smtp-server/internal/server"
+	"github.com/Haya372/smtp-server/internal/service"
 	"github.com/Haya372/smtp-server/internal/session"
 	"go.uber.org/fx"
 )
@@ -27,33 +31,89 @@ func main() {
 			config.NewServerConfig,
 			config.NewSmtpConfig,
 			config.NewTlsConfig,
+			config.NewSubnetConfig,
+			config.NewRecipientConfig,
+			config.NewSrsConfig,
+			config.NewMetricsConfig,
+			config.NewRateLimitConfig,
+			config.NewGreylistConfig,
+			config.NewDnsblConfig,
+			config.NewHeloConfig,
+			config.NewEarlyTalkerConfig,
+			config.NewMilterConfig,
+			config.NewSpamConfig,
+			config.NewAntivirusConfig,
+			config.NewRuleConfig,
+			config.NewDeliveryConfig,
+			config.NewVacationConfig,
 			hlog.NewLogger,
+			metrics.NewMetrics,
+			service.NewMailboxSource,
+			service.NewSrsService,
+			service.NewRecipientService,
+			service.NewRateLimitService,
+			service.NewGreylistService,
+			service.NewDNSResolver,
+			service.NewDnsblService,
+			service.NewHeloService,
+			service.NewEarlyTalkerService,
+			service.NewMilterService,
+			service.NewSpamService,
+			service.NewAntivirusService,
+			service.NewRuleService,
+			service.NewMailSender,
+			service.NewVacationService,
+			service.NewDeliveryService,
 			command.AsCommandHandler(command.NewHeloHandler),
 			command.AsCommandHandler(command.NewEhloHandler),
 			command.AsCommandHandler(command.NewMailHandler),
 			command.AsCommandHandler(command.NewRcptHandler),
 			command.AsCommandHandler(command.NewDataHandler),
+			command.AsCommandHandler(command.NewBdatHandler),
 			command.AsCommandHandler(command.NewNoopHandler),
 			command.AsCommandHandler(command.NewRsetHandler),
 			command.AsCommandHandler(command.NewQuitHandler),
 			command.AsCommandHandler(command.NewHelpHandler),
 			command.AsCommandHandler(command.NewStartTlsHandler),
 			session.NewSessionFactory,
+			session.NewSubnetGrouping,
 			fx.Annotate(
 				connection.NewSessionHandler,
-				fx.ParamTags(``, `group:"commandhandler"`),
+				fx.ParamTags(``, ``, `group:"commandhandler"`, ``, ``, ``, ``),
 			),
-			func(lc fx.Lifecycle, log hlog.Logger, conf *config.ServerConfig, factory session.SessionFactory, handler connection.SessionHandler) *server.Server {
-				s := server.NewServer(log, conf, factory, handler)
+			func(lc fx.Lifecycle, log hlog.Logger, conf *config.ServerConfig, factory session.SessionFactory, handler connection.SessionHandler, m metrics.Metrics, rateLimit service.RateLimitService, subnets *session.SubnetGrouping) *server.Server {
+				s := server.NewServer(log, conf, factory, handler, m, rateLimit, subnets)
 				lc.Append(fx.Hook{
 					OnStart: func(ctx context.Context) error {
 						return s.ListenSmtp(ctx)
 					},
+					OnStop: func(ctx context.Context) error {
+						return s.Shutdown(ctx)
+					},
 				})
 				return &s
 			},
 		),
 		fx.Invoke(func(s *server.Server) {}),
+		fx.Invoke(func(lc fx.Lifecycle, log hlog.Logger, conf *config.MetricsConfig, m metrics.Metrics) {
+			if len(conf.ListenAddress) == 0 {
+				return
+			}
+			srv := &http.Server{Addr: conf.ListenAddress, Handler: m}
+			lc.Append(fx.Hook{
+				OnStart: func(ctx context.Context) error {
+					ln, err := net.Listen("tcp", conf.ListenAddress)
+					if err != nil {
+						return err
+					}
+					go srv.Serve(ln)
+					return nil
+				},
+				OnStop: func(ctx context.Context) error {
+					return srv.Shutdown(ctx)
+				},
+			})
+		}),
 	)
 	app.Run()
 }
@@ -8,9 +8,12 @@ require (
 	github.com/emersion/go-msgauth v0.6.6
 	github.com/golang/mock v1.6.0
 	github.com/google/uuid v1.3.1
+	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/stretchr/testify v1.8.0
 	go.uber.org/fx v1.20.0
+	golang.org/x/net v0.17.0
 	golang.org/x/sync v0.3.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -23,7 +26,7 @@ require (
 	go.uber.org/zap v1.23.0 // indirect
 	golang.org/x/crypto v0.14.0 // indirect
 	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
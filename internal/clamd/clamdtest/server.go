@@ -0,0 +1,118 @@
+package clamdtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// the only command which the server understands
+const instreamCommand = "zINSTREAM\x00"
+
+// Server is an in-process clamd for tests.
+// Streams containing a key of Signatures are reported as infected by the virus of the value.
+type Server struct {
+	signatures map[string]string
+	ln         net.Listener
+	mu         sync.Mutex
+	streams    [][]byte
+	response   string
+	wg         sync.WaitGroup
+}
+
+// NewServer listens on "tcp" or "unix" address, e.g. "127.0.0.1:0".
+func NewServer(network, address string, signatures map[string]string) (*Server, error) {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		signatures: signatures,
+		ln:         ln,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Streams returns the received streams.
+func (s *Server) Streams() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte{}, s.streams...)
+}
+
+// SetResponse makes the server reply the response such as "INSTREAM size limit exceeded. ERROR" instead of
+// scanning, empty response restores scanning.
+func (s *Server) SetResponse(response string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.response = response
+}
+
+func (s *Server) Close() {
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	command, err := reader.ReadString(0)
+	if err != nil || command != instreamCommand {
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+
+	var stream []byte
+	for {
+		var size uint32
+		if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return
+		}
+		stream = append(stream, chunk...)
+	}
+
+	s.mu.Lock()
+	s.streams = append(s.streams, stream)
+	response := s.response
+	s.mu.Unlock()
+	if len(response) == 0 {
+		response = s.scan(stream)
+	}
+	conn.Write([]byte(response + "\x00"))
+}
+
+func (s *Server) scan(stream []byte) string {
+	for signature, virus := range s.signatures {
+		if bytes.Contains(stream, []byte(signature)) {
+			return "stream: " + virus + " FOUND"
+		}
+	}
+	return "stream: OK"
+}
@@ -0,0 +1,104 @@
+package clamd
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamd INSTREAM protocol
+// https://linux.die.net/man/8/clamd
+const (
+	instreamCommand = "zINSTREAM\x00"
+	// size of the chunks of the stream
+	chunkSize = 32 * 1024
+)
+
+// Result is the verdict of clamd on the stream.
+type Result struct {
+	Infected bool
+	// name of the virus such as "Eicar-Test-Signature"
+	Virus string
+}
+
+// Client scans streams by clamd, a connection is opened for each scan.
+type Client struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// Scan sends the data in chunks followed by the chunk of zero length and reads the verdict.
+func (c *Client) Scan(ctx context.Context, data []byte) (*Result, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if c.timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	writer := bufio.NewWriter(conn)
+	writer.WriteString(instreamCommand)
+	for len(data) > 0 {
+		n := len(data)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		binary.Write(writer, binary.BigEndian, uint32(n))
+		writer.Write(data[:n])
+		data = data[n:]
+	}
+	binary.Write(writer, binary.BigEndian, uint32(0))
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	line, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return nil, err
+	}
+	return parseResponse(line)
+}
+
+// parseResponse parses "stream: OK", "stream: <virus> FOUND" or "<message> ERROR".
+func parseResponse(line string) (*Result, error) {
+	line = strings.TrimRight(line, "\x00\r\n")
+	if strings.HasSuffix(line, " ERROR") {
+		return nil, fmt.Errorf("clamd error %q", line)
+	}
+	_, status, ok := strings.Cut(line, ": ")
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("invalid clamd response %q", line)
+	case status == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return &Result{Infected: true, Virus: strings.TrimSuffix(status, " FOUND")}, nil
+	default:
+		return nil, fmt.Errorf("invalid clamd response %q", line)
+	}
+}
+
+func (c *Client) String() string {
+	return "clamd " + c.network + ":" + c.address
+}
+
+// NewClient returns the client connecting to clamd by "tcp" with "host:port" or "unix" with the socket path,
+// timeout is applied to each scan.
+func NewClient(network, address string, timeout time.Duration) *Client {
+	return &Client{
+		network: network,
+		address: address,
+		timeout: timeout,
+	}
+}
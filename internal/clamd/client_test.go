@@ -0,0 +1,110 @@
+package clamd_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/clamd"
+	"github.com/Haya372/smtp-server/internal/clamd/clamdtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// size of the chunks of the stream sent by the client
+const streamChunkSize = 32 * 1024
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+func TestClient_Scan(t *testing.T) {
+	// larger than a chunk
+	large := bytes.Repeat([]byte("0123456789\r\n"), streamChunkSize/10)
+
+	tests := []struct {
+		name     string
+		network  string
+		data     []byte
+		response string
+		expected *clamd.Result
+		err      bool
+	}{
+		{
+			name:     "clean",
+			network:  "tcp",
+			data:     []byte("Subject: test\r\n\r\nbody\r\n"),
+			expected: &clamd.Result{},
+		},
+		{
+			name:     "infected",
+			network:  "tcp",
+			data:     []byte("Subject: test\r\n\r\n" + eicar + "\r\n"),
+			expected: &clamd.Result{Infected: true, Virus: "Eicar-Test-Signature"},
+		},
+		{
+			name:     "large stream by unix socket",
+			network:  "unix",
+			data:     large,
+			expected: &clamd.Result{},
+		},
+		{
+			name:     "error",
+			network:  "tcp",
+			data:     []byte("body\r\n"),
+			response: "INSTREAM size limit exceeded. ERROR",
+			err:      true,
+		},
+		{
+			name:     "broken response",
+			network:  "tcp",
+			data:     []byte("body\r\n"),
+			response: "PONG",
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address := "127.0.0.1:0"
+			if test.network == "unix" {
+				address = filepath.Join(t.TempDir(), "clamd.sock")
+			}
+			server, err := clamdtest.NewServer(test.network, address, map[string]string{eicar: "Eicar-Test-Signature"})
+			assert.Nil(t, err)
+			defer server.Close()
+			server.SetResponse(test.response)
+
+			res, err := clamd.NewClient(test.network, server.Addr(), time.Second).Scan(context.Background(), test.data)
+			assert.Equal(t, [][]byte{test.data}, server.Streams())
+			if test.err {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestClient_Unavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := ln.Addr().String()
+	ln.Close()
+
+	_, err = clamd.NewClient("tcp", address, time.Second).Scan(context.Background(), []byte("body\r\n"))
+	assert.NotNil(t, err)
+}
+
+func TestClient_Timeout(t *testing.T) {
+	// the server accepts the connection but never replies
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	start := time.Now()
+	_, err = clamd.NewClient("tcp", ln.Addr().String(), 100*time.Millisecond).Scan(context.Background(), []byte("body\r\n"))
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,39 @@
+package command
+
+import (
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// isASCII reports whether str has only US-ASCII characters.
+func isASCII(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if str[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeAddress converts the IDN domain of the address to A-labels, which is used for DNS lookup.
+// https://tex2e.github.io/rfc-translater/html/rfc6531.html#3-2--The-SMTPUTF8-Extension
+func normalizeAddress(address *mail.Address) error {
+	at := strings.LastIndex(address.Address, "@")
+	if at < 0 {
+		return nil
+	}
+	domain := address.Address[at+1:]
+	if isASCII(domain) {
+		return nil
+	}
+
+	aLabel, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return err
+	}
+	address.Address = address.Address[:at+1] + aLabel
+	return nil
+}
@@ -0,0 +1,26 @@
+package command
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/service"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+// rejectVirus scans the message s.RawData, true is returned when the message is rejected.
+// The transaction is reset on rejection.
+func rejectVirus(ctx context.Context, antivirus service.AntivirusService, s *session.Session) bool {
+	res := antivirus.Scan(ctx, s)
+	switch res.Action {
+	case data.VirusInfected:
+		s.Reply(ReplyVirusFound.WithLines(fmt.Sprintf(MsgVirusFound, res.Virus)))
+	case data.VirusTempFail:
+		s.Reply(ReplyVirusTempFail)
+	default:
+		return false
+	}
+	s.ResetTransaction()
+	return true
+}
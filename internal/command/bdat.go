@@ -0,0 +1,138 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/service"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+// https://tex2e.github.io/rfc-translater/html/rfc3030.html
+type bdatHandler struct {
+	log       hlog.Logger
+	conf      *config.SmtpConfig
+	milter    service.MilterService
+	spam      service.SpamService
+	antivirus service.AntivirusService
+	rules     service.RuleService
+	delivery  service.DeliveryService
+}
+
+func (h *bdatHandler) Command() string {
+	return BDAT
+}
+
+func (h *bdatHandler) HandleCommand(ctx context.Context, s *session.Session, arg []string) error {
+	// BDAT <chunk-size> [LAST]
+	// chunk data follows the command whenever the size is valid, it must be consumed even if the command is rejected
+	size := int64(-1)
+	if len(arg) > 0 {
+		if n, err := strconv.ParseInt(arg[0], 10, 64); err == nil && n >= 0 {
+			size = n
+		}
+	}
+
+	if !h.conf.EnableChunking {
+		return h.rejectChunk(s, size, ReplyCommandNotImplemented)
+	}
+	if size < 0 || len(arg) > 2 {
+		return h.rejectChunk(s, size, ReplyArgumentSyntaxError)
+	}
+	last := false
+	if len(arg) == 2 {
+		if !strings.EqualFold(arg[1], "LAST") {
+			return h.rejectChunk(s, size, ReplyArgumentSyntaxError)
+		}
+		last = true
+	}
+
+	// rcpt command should be called
+	if len(s.EnvelopeTo) == 0 {
+		return h.rejectChunk(s, size, ReplyBadSequence)
+	}
+
+	// the size is checked before reading not to allocate more than the limit
+	if int64(len(s.RawData))+size > int64(h.conf.MaxMailSize) {
+		if err := h.rejectChunk(s, size, ReplyAborted); err != nil {
+			return err
+		}
+		s.ResetTransaction()
+		return errors.New("message size exceed limit")
+	}
+
+	s.EnterPhase(session.PhaseDataBlock)
+	chunk, err := s.ReadChunk(int(size))
+	if err != nil {
+		return h.chunkError(s, err)
+	}
+	s.EnterPhase(session.PhaseCommand)
+
+	s.RawData = append(s.RawData, chunk...)
+	s.Chunking = true
+
+	if !last {
+		s.Reply(ReplyOk.WithLines(fmt.Sprintf(MsgChunkReceived, size)))
+		return nil
+	}
+
+	if reject8BitData(h.conf, s, s.RawData) {
+		s.Reply(Reply8BitNotPermitted)
+		s.ResetTransaction()
+		return nil
+	}
+
+	addReceivedHeader(s)
+	if filterMessage(ctx, h.log, h.milter, s) || rejectVirus(ctx, h.antivirus, s) || rejectByRules(ctx, h.rules, s) ||
+		rejectSpam(ctx, h.log, h.spam, s) || deliverMessage(ctx, h.delivery, s) {
+		return nil
+	}
+
+	h.log.Debugf("[%s] mail data received.\n----------\n%s----------", s.Id, string(s.RawData))
+
+	s.Reply(ReplyDataOk)
+	s.MessageCount++
+	s.ResetTransaction()
+	return nil
+}
+
+// rejectChunk discards the chunk data and replies r, the data must not be read as commands.
+func (h *bdatHandler) rejectChunk(s *session.Session, size int64, r session.Reply) error {
+	if size > 0 {
+		s.EnterPhase(session.PhaseDataBlock)
+		if err := s.DiscardChunk(size); err != nil {
+			return h.chunkError(s, err)
+		}
+		s.EnterPhase(session.PhaseCommand)
+	}
+	s.Reply(r)
+	return nil
+}
+
+func (h *bdatHandler) chunkError(s *session.Session, err error) error {
+	h.log.WithError(err).Errorf("[%s] chunk reading error.", s.Id)
+	if session.IsTimeout(err) {
+		s.ReplyOnTimeout(ReplyTimeout)
+		s.ShouldClose = true
+		return err
+	}
+	s.Reply(ReplyTransactionFail)
+	return err
+}
+
+func NewBdatHandler(log hlog.Logger, conf *config.SmtpConfig, milter service.MilterService, spam service.SpamService, antivirus service.AntivirusService, rules service.RuleService, delivery service.DeliveryService) CommandHandler {
+	return &bdatHandler{
+		log:       log,
+		conf:      conf,
+		milter:    milter,
+		spam:      spam,
+		antivirus: antivirus,
+		rules:     rules,
+		delivery:  delivery,
+	}
+}
@@ -0,0 +1,182 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"testing"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/mock"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBdat_Command(t *testing.T) {
+	conf := &config.SmtpConfig{}
+	target := NewBdatHandler(nil, conf, nil, nil, nil, nil, nil)
+
+	assert.Equal(t, BDAT, target.Command())
+}
+
+func TestBdat_Err(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	conf := &config.SmtpConfig{
+		EnableChunking: true,
+		MaxMailSize:    10,
+	}
+
+	tests := []struct {
+		name      string
+		arg       []string
+		conf      *config.SmtpConfig
+		setupFunc func(s *session.MockSession)
+		reply     session.Reply
+	}{
+		{
+			name: "chunking disabled",
+			arg:  []string{"4", "LAST"},
+			conf: &config.SmtpConfig{},
+			setupFunc: func(s *session.MockSession) {
+				s.ExpectReadLine("test", nil)
+			},
+			reply: ReplyCommandNotImplemented,
+		},
+		{
+			name:  "argument is empty",
+			reply: ReplyArgumentSyntaxError,
+		},
+		{
+			name:  "size not integer",
+			arg:   []string{"hoge"},
+			reply: ReplyArgumentSyntaxError,
+		},
+		{
+			name:  "negative size",
+			arg:   []string{"-1"},
+			reply: ReplyArgumentSyntaxError,
+		},
+		{
+			name: "unknown parameter",
+			arg:  []string{"4", "hoge"},
+			setupFunc: func(s *session.MockSession) {
+				s.ExpectReadLine("test", nil)
+			},
+			reply: ReplyArgumentSyntaxError,
+		},
+		{
+			name: "rcpt not called",
+			arg:  []string{"4", "LAST"},
+			setupFunc: func(s *session.MockSession) {
+				s.ExpectReadLine("test", nil)
+			},
+			reply: ReplyBadSequence,
+		},
+		{
+			name: "read chunk err",
+			arg:  []string{"4", "LAST"},
+			setupFunc: func(s *session.MockSession) {
+				s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+				s.ExpectReadLine("", errors.New("test error"))
+			},
+			reply: ReplyTransactionFail,
+		},
+		{
+			name: "message size exceeds limit",
+			arg:  []string{"4", "LAST"},
+			setupFunc: func(s *session.MockSession) {
+				s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+				s.Session.RawData = []byte("12345678")
+				s.ExpectReadLine("test", nil)
+			},
+			reply: ReplyAborted,
+		},
+		{
+			// the chunk is discarded without allocation, the client closes the connection before sending it
+			name: "huge chunk",
+			arg:  []string{"100000000000", "LAST"},
+			setupFunc: func(s *session.MockSession) {
+				s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+				s.ExpectReadLine("test", nil)
+			},
+			reply: ReplyTransactionFail,
+		},
+		{
+			name: "8-bit data without BODY=8BITMIME",
+			arg:  []string{"6", "LAST"},
+			conf: &config.SmtpConfig{
+				EnableChunking:       true,
+				MaxMailSize:          10,
+				Reject8BitIn7BitBody: true,
+			},
+			setupFunc: func(s *session.MockSession) {
+				s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+				s.ExpectReadLine("テス", nil)
+			},
+			reply: Reply8BitNotPermitted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+
+			if test.setupFunc != nil {
+				test.setupFunc(s)
+			}
+			s.ExpectReply(test.reply)
+
+			c := conf
+			if test.conf != nil {
+				c = test.conf
+			}
+			target := NewBdatHandler(log, c, mock.NewInitializedMockMilterService(ctrl), mock.NewInitializedMockSpamService(ctrl), mock.NewInitializedMockAntivirusService(ctrl), mock.NewInitializedMockRuleService(ctrl), mock.NewInitializedMockDeliveryService(ctrl))
+			target.HandleCommand(context.TODO(), s.Session, test.arg)
+			assert.False(t, s.Session.Chunking)
+			// chunk data is never read as commands
+			assert.False(t, s.Session.HasPendingInput())
+		})
+	}
+}
+
+func TestBdat(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	conf := &config.SmtpConfig{
+		EnableChunking: true,
+		MaxMailSize:    1000,
+	}
+
+	target := NewBdatHandler(log, conf, mock.NewInitializedMockMilterService(ctrl), mock.NewInitializedMockSpamService(ctrl), mock.NewInitializedMockAntivirusService(ctrl), mock.NewInitializedMockRuleService(ctrl), mock.NewInitializedMockDeliveryService(ctrl))
+
+	s := session.NewMockSession(ctrl)
+	s.Session.SenderDomain = "example.com"
+	s.Session.EnvelopeFrom = &mail.Address{Address: "from@example.com"}
+	s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+
+	// chunk may contain bare line breaks and dots
+	s.ExpectReadLine("Subject: test\r\n\r\n.\nbody\r\n", nil)
+
+	s.ExpectReply(ReplyOk.WithLines(fmt.Sprintf(MsgChunkReceived, 17)))
+	target.HandleCommand(context.TODO(), s.Session, []string{"17"})
+	assert.True(t, s.Session.Chunking)
+	assert.Equal(t, "Subject: test\r\n\r\n", string(s.Session.RawData))
+
+	s.ExpectReply(ReplyDataOk)
+	target.HandleCommand(context.TODO(), s.Session, []string{"7", "last"})
+	assert.Equal(t, "example.com", s.Session.SenderDomain)
+	assert.Nil(t, s.Session.EnvelopeFrom)
+	assert.Empty(t, s.Session.EnvelopeTo)
+	assert.Empty(t, s.Session.RawData)
+	assert.False(t, s.Session.Chunking)
+	assert.Equal(t, 1, s.Session.MessageCount)
+}
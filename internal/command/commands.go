@@ -9,8 +9,26 @@ const (
 	MAIL     = "mail"
 	RCPT     = "rcpt"
 	DATA     = "data"
+	BDAT     = "bdat"
 	QUIT     = "quit"
 	RSET     = "rset"
 	HELP     = "help"
 	NOOP     = "noop"
 )
+
+// commands which must be the last command in a pipelined group
+// https://tex2e.github.io/rfc-translater/html/rfc2920.html#3-1--Client-use-of-pipelining
+var synchronizationPoints = map[string]bool{
+	HELO:     true,
+	EHLO:     true,
+	STARTTLS: true,
+	AUTH:     true,
+	DATA:     true,
+	NOOP:     true,
+	QUIT:     true,
+}
+
+// IsSynchronizationPoint reports whether the client must wait for the reply of cmd before sending next commands.
+func IsSynchronizationPoint(cmd string) bool {
+	return synchronizationPoints[cmd]
+}
@@ -6,12 +6,19 @@ import (
 
 	"github.com/Haya372/hlog"
 	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/service"
 	"github.com/Haya372/smtp-server/internal/session"
 )
 
 type dataHandler struct {
-	log  hlog.Logger
-	conf *config.SmtpConfig
+	log       hlog.Logger
+	conf      *config.SmtpConfig
+	milter    service.MilterService
+	spam      service.SpamService
+	antivirus service.AntivirusService
+	rules     service.RuleService
+	delivery  service.DeliveryService
 }
 
 func (h *dataHandler) Command() string {
@@ -21,39 +28,82 @@ func (h *dataHandler) Command() string {
 func (h *dataHandler) HandleCommand(ctx context.Context, s *session.Session, arg []string) error {
 	// DATA is not permit parameters
 	if len(arg) > 0 {
-		s.Response(CodeSyntaxError, MsgSyntaxError)
+		s.Reply(ReplySyntaxError)
 		return nil
 	}
 
-	// rcpt command should be called
-	if len(s.EnvelopeTo) == 0 {
-		s.Response(CodeBadSequence, MsgBadSequence)
+	// rcpt command should be called, and DATA cannot be mixed with BDAT
+	if len(s.EnvelopeTo) == 0 || s.Chunking {
+		s.Reply(ReplyBadSequence)
 		return nil
 	}
 
-	s.Response(CodeStartInput, MsgStartInput)
+	// https://tex2e.github.io/rfc-translater/html/rfc3030.html#3--Binary-MIME-Extension
+	if s.BodyType == session.BodyBinaryMime {
+		s.Reply(ReplyBinaryMimeRequiresBdat)
+		return nil
+	}
+
+	s.EnterPhase(session.PhaseDataInit)
+	s.Reply(ReplyStartInput)
+
+	s.EnterPhase(session.PhaseDataBlock)
 	rawData, err := s.ReadRawData()
 	if err != nil {
 		h.log.WithError(err).Errorf("[%s] data reading error.", s.Id)
-		s.Response(CodeTransactionFail, MsgTransactionFail)
+		if session.IsTimeout(err) {
+			s.ReplyOnTimeout(ReplyTimeout)
+			s.ShouldClose = true
+			return err
+		}
+		s.Reply(ReplyTransactionFail)
 		return err
 	}
+	s.EnterPhase(session.PhaseCommand)
 
 	if len(rawData) > h.conf.MaxMailSize {
-		s.Response(CodeAborted, MsgAborted)
+		s.Reply(ReplyAborted)
 		return errors.New("message size exceed limit")
 	}
 
-	h.log.Debugf("[%s] mail data received.\n----------\n%s----------", s.Id, string(rawData))
+	if reject8BitData(h.conf, s, rawData) {
+		s.Reply(Reply8BitNotPermitted)
+		s.ResetTransaction()
+		return nil
+	}
 
-	s.Response(CodeOk, MsgOk)
-	s.Reset()
+	s.RawData = rawData
+	addReceivedHeader(s)
+	if filterMessage(ctx, h.log, h.milter, s) || rejectVirus(ctx, h.antivirus, s) || rejectByRules(ctx, h.rules, s) ||
+		rejectSpam(ctx, h.log, h.spam, s) || deliverMessage(ctx, h.delivery, s) {
+		return nil
+	}
+
+	h.log.Debugf("[%s] mail data received.\n----------\n%s----------", s.Id, string(s.RawData))
+
+	s.Reply(ReplyDataOk)
+	s.MessageCount++
+	s.ResetTransaction()
 	return nil
 }
 
-func NewDataHandler(log hlog.Logger, conf *config.SmtpConfig) CommandHandler {
+func NewDataHandler(log hlog.Logger, conf *config.SmtpConfig, milter service.MilterService, spam service.SpamService, antivirus service.AntivirusService, rules service.RuleService, delivery service.DeliveryService) CommandHandler {
 	return &dataHandler{
-		log:  log,
-		conf: conf,
+		log:       log,
+		conf:      conf,
+		milter:    milter,
+		spam:      spam,
+		antivirus: antivirus,
+		rules:     rules,
+		delivery:  delivery,
+	}
+}
+
+// reject8BitData reports whether the message must be rejected because 8-bit octets are sent in 7BIT body.
+// https://tex2e.github.io/rfc-translater/html/rfc6152.html#3--The-8bit-MIMEtransport-service-extension
+func reject8BitData(conf *config.SmtpConfig, s *session.Session, rawData []byte) bool {
+	if !conf.Reject8BitIn7BitBody || s.BodyType.Allows8Bit() || s.SmtpUtf8 {
+		return false
 	}
+	return data.Has8BitData(rawData)
 }
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
 	"github.com/Haya372/smtp-server/internal/mock"
 	"github.com/Haya372/smtp-server/internal/session"
 	"github.com/golang/mock/gomock"
@@ -15,7 +16,7 @@ import (
 
 func TestData_Command(t *testing.T) {
 	conf := &config.SmtpConfig{}
-	target := NewDataHandler(nil, conf)
+	target := NewDataHandler(nil, conf, nil, nil, nil, nil, nil)
 
 	assert.Equal(t, target.Command(), DATA)
 }
@@ -27,37 +28,35 @@ func TestData_Err(t *testing.T) {
 	log := mock.NewInitializedMockLogger(ctrl)
 
 	conf := &config.SmtpConfig{
-		MaxMailSize: 1000,
+		MaxMailSize:          1000,
+		Reject8BitIn7BitBody: true,
 	}
 
 	tests := []struct {
 		name      string
 		arg       []string
 		setupFunc func(s *session.MockSession)
-		code      int
-		msg       string
+		reply     session.Reply
 	}{
 		{
-			name: "rcpt not called",
-			code: CodeBadSequence,
-			msg:  MsgBadSequence,
+			name:  "rcpt not called",
+			reply: ReplyBadSequence,
 		},
 		{
 			name: "read raw data err",
 			setupFunc: func(s *session.MockSession) {
 				s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
-				s.ExpectResponse(CodeStartInput, MsgStartInput)
+				s.ExpectReply(ReplyStartInput)
 				s.ExpectReadLine("", errors.New("test error"))
 
 			},
-			code: CodeTransactionFail,
-			msg:  MsgTransactionFail,
+			reply: ReplyTransactionFail,
 		},
 		{
 			name: "message size exceeds limit",
 			setupFunc: func(s *session.MockSession) {
 				s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
-				s.ExpectResponse(CodeStartInput, MsgStartInput)
+				s.ExpectReply(ReplyStartInput)
 				data := "Subject: test\r\n\r\n"
 				for i := 0; i < conf.MaxMailSize; i++ {
 					data += "a"
@@ -65,17 +64,40 @@ func TestData_Err(t *testing.T) {
 				data += "\r\n.\r\n"
 				s.ExpectReadLine(data, nil)
 			},
-			code: CodeAborted,
-			msg:  MsgAborted,
+			reply: ReplyAborted,
+		},
+		{
+			name: "BDAT transaction in progress",
+			setupFunc: func(s *session.MockSession) {
+				s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+				s.Session.Chunking = true
+			},
+			reply: ReplyBadSequence,
+		},
+		{
+			name: "BODY=BINARYMIME",
+			setupFunc: func(s *session.MockSession) {
+				s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+				s.Session.BodyType = session.BodyBinaryMime
+			},
+			reply: ReplyBinaryMimeRequiresBdat,
+		},
+		{
+			name: "8-bit data without BODY=8BITMIME",
+			setupFunc: func(s *session.MockSession) {
+				s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+				s.ExpectReply(ReplyStartInput)
+				s.ExpectReadLine("Subject: test\r\n\r\nこんにちは\r\n.\r\n", nil)
+			},
+			reply: Reply8BitNotPermitted,
 		},
 		{
 			name: "with parameter",
 			setupFunc: func(s *session.MockSession) {
 				s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
 			},
-			arg:  []string{"hoge"},
-			code: CodeSyntaxError,
-			msg:  MsgSyntaxError,
+			arg:   []string{"hoge"},
+			reply: ReplySyntaxError,
 		},
 	}
 
@@ -86,9 +108,9 @@ func TestData_Err(t *testing.T) {
 			if test.setupFunc != nil {
 				test.setupFunc(s)
 			}
-			s.ExpectResponse(test.code, test.msg)
+			s.ExpectReply(test.reply)
 
-			target := NewDataHandler(log, conf)
+			target := NewDataHandler(log, conf, mock.NewInitializedMockMilterService(ctrl), mock.NewInitializedMockSpamService(ctrl), mock.NewInitializedMockAntivirusService(ctrl), mock.NewInitializedMockRuleService(ctrl), mock.NewInitializedMockDeliveryService(ctrl))
 			target.HandleCommand(context.TODO(), s.Session, test.arg)
 		})
 	}
@@ -104,18 +126,342 @@ func TestData(t *testing.T) {
 		MaxMailSize: 1000,
 	}
 
-	target := NewDataHandler(log, conf)
+	target := NewDataHandler(log, conf, mock.NewInitializedMockMilterService(ctrl), mock.NewInitializedMockSpamService(ctrl), mock.NewInitializedMockAntivirusService(ctrl), mock.NewInitializedMockRuleService(ctrl), mock.NewInitializedMockDeliveryService(ctrl))
 
 	s := session.NewMockSession(ctrl)
+	s.Session.SenderDomain = "example.com"
 	s.Session.EnvelopeTo = make([]mail.Address, 1)
 
-	s.ExpectResponse(CodeStartInput, MsgStartInput)
+	s.ExpectReply(ReplyStartInput)
 	s.ExpectReadLine("Subject: test\r\n\r\n.\r\n", nil)
-	s.ExpectResponse(CodeOk, MsgOk)
+	s.ExpectReply(ReplyDataOk)
 
 	target.HandleCommand(context.TODO(), s.Session, make([]string, 0))
-	assert.Empty(t, s.Session.SenderDomain)
+	assert.Equal(t, "example.com", s.Session.SenderDomain)
+	assert.Equal(t, 1, s.Session.MessageCount)
 	assert.Nil(t, s.Session.EnvelopeFrom)
 	assert.Empty(t, s.Session.EnvelopeTo)
 	assert.Empty(t, s.Session.RawData)
 }
+
+func TestData_8Bit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	tests := []struct {
+		name     string
+		conf     *config.SmtpConfig
+		bodyType session.BodyType
+		smtpUtf8 bool
+	}{
+		{
+			name:     "BODY=8BITMIME",
+			conf:     &config.SmtpConfig{MaxMailSize: 1000, Reject8BitIn7BitBody: true},
+			bodyType: session.Body8BitMime,
+		},
+		{
+			name:     "SMTPUTF8",
+			conf:     &config.SmtpConfig{MaxMailSize: 1000, Reject8BitIn7BitBody: true},
+			smtpUtf8: true,
+		},
+		{
+			name:     "rejection disabled",
+			conf:     &config.SmtpConfig{MaxMailSize: 1000},
+			bodyType: session.Body7Bit,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+			s.Session.BodyType = test.bodyType
+			s.Session.SmtpUtf8 = test.smtpUtf8
+
+			s.ExpectReply(ReplyStartInput)
+			s.ExpectReadLine("Subject: test\r\n\r\nこんにちは\r\n.\r\n", nil)
+			s.ExpectReply(ReplyDataOk)
+
+			target := NewDataHandler(log, test.conf, mock.NewInitializedMockMilterService(ctrl), mock.NewInitializedMockSpamService(ctrl), mock.NewInitializedMockAntivirusService(ctrl), mock.NewInitializedMockRuleService(ctrl), mock.NewInitializedMockDeliveryService(ctrl))
+			assert.Nil(t, target.HandleCommand(context.TODO(), s.Session, nil))
+		})
+	}
+}
+
+func TestData_Milter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	tests := []struct {
+		name    string
+		res     *data.MilterResult
+		reply   session.Reply
+		count   int
+		discard bool
+	}{
+		{
+			name:  "rejected",
+			res:   &data.MilterResult{Action: data.MilterReject},
+			reply: ReplyMilterRejected,
+		},
+		{
+			name:    "discarded",
+			res:     &data.MilterResult{Action: data.MilterDiscard},
+			reply:   ReplyDataOk,
+			count:   1,
+			discard: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+			s.ExpectReply(ReplyStartInput)
+			s.ExpectReadLine("Subject: test\r\n\r\nbody\r\n.\r\n", nil)
+			s.ExpectReply(test.reply)
+
+			milter := mock.NewMockMilterService(ctrl)
+			milter.EXPECT().Data(gomock.Any(), s.Session).DoAndReturn(func(ctx context.Context, s *session.Session) *data.MilterResult {
+				// the message is sent to milters
+				assert.Contains(t, string(s.RawData), "body")
+				s.Discard = test.discard
+				return test.res
+			})
+
+			target := NewDataHandler(log, &config.SmtpConfig{MaxMailSize: 1000}, milter, mock.NewInitializedMockSpamService(ctrl), mock.NewInitializedMockAntivirusService(ctrl), mock.NewInitializedMockRuleService(ctrl), mock.NewInitializedMockDeliveryService(ctrl))
+			target.HandleCommand(context.TODO(), s.Session, make([]string, 0))
+			assert.Equal(t, test.count, s.Session.MessageCount)
+			assert.Empty(t, s.Session.EnvelopeTo)
+			assert.False(t, s.Session.Discard)
+		})
+	}
+}
+
+func TestData_Spam(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	tests := []struct {
+		name   string
+		action data.SpamAction
+		reply  session.Reply
+		count  int
+	}{
+		{
+			name:   "accepted",
+			action: data.SpamTag,
+			reply:  ReplyDataOk,
+			count:  1,
+		},
+		{
+			name:   "quarantined",
+			action: data.SpamQuarantine,
+			reply:  ReplyDataOk,
+			count:  1,
+		},
+		{
+			name:   "rejected",
+			action: data.SpamReject,
+			reply:  ReplySpamRejected,
+		},
+		{
+			name:   "scanner down",
+			action: data.SpamTempFail,
+			reply:  ReplySpamTempFail,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+			s.ExpectReply(ReplyStartInput)
+			s.ExpectReadLine("Subject: test\r\n\r\nbody\r\n.\r\n", nil)
+			s.ExpectReply(test.reply)
+
+			spam := mock.NewMockSpamService(ctrl)
+			spam.EXPECT().Scan(gomock.Any(), s.Session).DoAndReturn(func(ctx context.Context, s *session.Session) *data.SpamResult {
+				assert.Contains(t, string(s.RawData), "body")
+				return &data.SpamResult{Action: test.action}
+			})
+
+			target := NewDataHandler(log, &config.SmtpConfig{MaxMailSize: 1000}, mock.NewInitializedMockMilterService(ctrl), spam, mock.NewInitializedMockAntivirusService(ctrl), mock.NewInitializedMockRuleService(ctrl), mock.NewInitializedMockDeliveryService(ctrl))
+			target.HandleCommand(context.TODO(), s.Session, make([]string, 0))
+			assert.Equal(t, test.count, s.Session.MessageCount)
+			assert.Empty(t, s.Session.EnvelopeTo)
+		})
+	}
+}
+
+func TestData_Virus(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	tests := []struct {
+		name  string
+		res   *data.VirusResult
+		reply session.Reply
+		count int
+	}{
+		{
+			name:  "clean",
+			res:   &data.VirusResult{},
+			reply: ReplyDataOk,
+			count: 1,
+		},
+		{
+			name:  "infected",
+			res:   &data.VirusResult{Action: data.VirusInfected, Virus: "Eicar-Test-Signature"},
+			reply: ReplyVirusFound.WithLines("Message rejected, virus found: Eicar-Test-Signature"),
+		},
+		{
+			name:  "scanner down",
+			res:   &data.VirusResult{Action: data.VirusTempFail},
+			reply: ReplyVirusTempFail,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+			s.ExpectReply(ReplyStartInput)
+			s.ExpectReadLine("Subject: test\r\n\r\nbody\r\n.\r\n", nil)
+			s.ExpectReply(test.reply)
+
+			antivirus := mock.NewMockAntivirusService(ctrl)
+			antivirus.EXPECT().Scan(gomock.Any(), s.Session).Return(test.res)
+			// infected messages are not scanned for spam
+			spam := mock.NewMockSpamService(ctrl)
+			spam.EXPECT().Scan(gomock.Any(), s.Session).Return(&data.SpamResult{}).Times(test.count)
+
+			target := NewDataHandler(log, &config.SmtpConfig{MaxMailSize: 1000}, mock.NewInitializedMockMilterService(ctrl), spam, antivirus, mock.NewInitializedMockRuleService(ctrl), mock.NewInitializedMockDeliveryService(ctrl))
+			target.HandleCommand(context.TODO(), s.Session, make([]string, 0))
+			assert.Equal(t, test.count, s.Session.MessageCount)
+			assert.Empty(t, s.Session.EnvelopeTo)
+		})
+	}
+}
+
+func TestData_Rules(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+	tempFail := session.NewReply(451, session.EnhancedCode{4, 7, 1}, "try again later")
+	noText := session.NewReply(554, session.EnhancedCode{5, 7, 1})
+
+	tests := []struct {
+		name  string
+		res   *data.RuleResult
+		reply session.Reply
+		count int
+	}{
+		{
+			name:  "no rule matched",
+			res:   &data.RuleResult{},
+			reply: ReplyDataOk,
+			count: 1,
+		},
+		{
+			name:  "default reply",
+			res:   &data.RuleResult{Action: data.RuleReject, Rules: []string{"score"}},
+			reply: ReplyRuleRejected,
+		},
+		{
+			name:  "custom reply",
+			res:   &data.RuleResult{Action: data.RuleReject, Reply: &tempFail},
+			reply: tempFail,
+		},
+		{
+			name:  "custom reply without text",
+			res:   &data.RuleResult{Action: data.RuleReject, Reply: &noText},
+			reply: noText.WithLines(MsgRuleRejected),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+			s.ExpectReply(ReplyStartInput)
+			s.ExpectReadLine("Subject: test\r\n\r\nbody\r\n.\r\n", nil)
+			s.ExpectReply(test.reply)
+
+			rules := mock.NewMockRuleService(ctrl)
+			rules.EXPECT().Evaluate(gomock.Any(), s.Session).Return(test.res)
+			// rejected messages are not scanned for spam
+			spam := mock.NewMockSpamService(ctrl)
+			spam.EXPECT().Scan(gomock.Any(), s.Session).Return(&data.SpamResult{}).Times(test.count)
+
+			target := NewDataHandler(log, &config.SmtpConfig{MaxMailSize: 1000}, mock.NewInitializedMockMilterService(ctrl), spam, mock.NewInitializedMockAntivirusService(ctrl), rules, mock.NewInitializedMockDeliveryService(ctrl))
+			target.HandleCommand(context.TODO(), s.Session, make([]string, 0))
+			assert.Equal(t, test.count, s.Session.MessageCount)
+			assert.Empty(t, s.Session.EnvelopeTo)
+		})
+	}
+}
+
+func TestData_Delivery(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	tests := []struct {
+		name  string
+		res   *data.DeliveryResult
+		reply session.Reply
+		count int
+	}{
+		{
+			name:  "delivered",
+			res:   &data.DeliveryResult{},
+			reply: ReplyDataOk,
+			count: 1,
+		},
+		{
+			name:  "rejected with reason",
+			res:   &data.DeliveryResult{Action: data.DeliveryReject, Reason: "I do not want\nyour mail\n"},
+			reply: ReplySieveRejected.WithLines("I do not want", "your mail"),
+		},
+		{
+			name:  "rejected without reason",
+			res:   &data.DeliveryResult{Action: data.DeliveryReject},
+			reply: ReplySieveRejected,
+		},
+		{
+			name:  "mailbox unavailable",
+			res:   &data.DeliveryResult{Action: data.DeliveryTempFail},
+			reply: ReplyDeliveryTempFail,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			s.Session.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+			s.ExpectReply(ReplyStartInput)
+			s.ExpectReadLine("Subject: test\r\n\r\nbody\r\n.\r\n", nil)
+			s.ExpectReply(test.reply)
+
+			delivery := mock.NewMockDeliveryService(ctrl)
+			delivery.EXPECT().Deliver(gomock.Any(), s.Session).Return(test.res)
+
+			target := NewDataHandler(log, &config.SmtpConfig{MaxMailSize: 1000}, mock.NewInitializedMockMilterService(ctrl), mock.NewInitializedMockSpamService(ctrl),
+				mock.NewInitializedMockAntivirusService(ctrl), mock.NewInitializedMockRuleService(ctrl), delivery)
+			target.HandleCommand(context.TODO(), s.Session, make([]string, 0))
+			assert.Equal(t, test.count, s.Session.MessageCount)
+			assert.Empty(t, s.Session.EnvelopeTo)
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package command
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/service"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+// deliverMessage stores the message into the local mailboxes, true is returned when the message is rejected.
+// The transaction is reset on rejection.
+func deliverMessage(ctx context.Context, delivery service.DeliveryService, s *session.Session) bool {
+	res := delivery.Deliver(ctx, s)
+	switch res.Action {
+	case data.DeliveryReject:
+		reply := ReplySieveRejected
+		if reason := strings.TrimSpace(res.Reason); len(reason) > 0 {
+			reply = reply.WithLines(strings.Split(strings.ReplaceAll(reason, "\r\n", "\n"), "\n")...)
+		}
+		s.Reply(reply)
+	case data.DeliveryTempFail:
+		s.Reply(ReplyDeliveryTempFail)
+	default:
+		return false
+	}
+	s.ResetTransaction()
+	return true
+}
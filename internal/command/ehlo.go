@@ -8,12 +8,16 @@ import (
 
 	"github.com/Haya372/hlog"
 	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/service"
 	"github.com/Haya372/smtp-server/internal/session"
 )
 
 type ehloHandler struct {
-	log  hlog.Logger
-	conf *config.SmtpConfig
+	log      hlog.Logger
+	conf     *config.SmtpConfig
+	heloConf *config.HeloConfig
+	helo     service.HeloService
+	milter   service.MilterService
 }
 
 func (h *ehloHandler) Command() string {
@@ -22,7 +26,12 @@ func (h *ehloHandler) Command() string {
 
 func (h *ehloHandler) HandleCommand(ctx context.Context, s *session.Session, arg []string) error {
 	if len(arg) == 0 {
-		s.Response(CodeSyntaxError, MsgSyntaxError)
+		s.Reply(ReplySyntaxError)
+		return nil
+	}
+
+	status, ok := verifyClient(ctx, h.log, h.heloConf, h.helo, s, arg[0])
+	if !ok || rejectByMilter(s, h.milter.Helo(ctx, s, arg[0])) {
 		return nil
 	}
 
@@ -30,29 +39,44 @@ func (h *ehloHandler) HandleCommand(ctx context.Context, s *session.Session, arg
 	s.Reset()
 
 	s.SenderDomain = arg[0]
+	s.Esmtp = true
+	s.HeloStatus = status
+	s.Pipelining = h.conf.EnablePipelining
 
-	// TODO: ESMTPのレスポンス定義
 	hostname, _ := os.Hostname()
-	s.ResponseLine(fmt.Sprintf("%d-%s greets %s", CodeOk, hostname, arg[0]))
+	lines := []string{fmt.Sprintf("%s greets %s", hostname, arg[0])}
 	if h.conf.EnablePipelining {
-		s.ResponseLine(fmt.Sprintf("%d-PIPELINING", CodeOk))
+		lines = append(lines, "PIPELINING")
 	}
 	if h.conf.Enable8BitMime {
-		s.ResponseLine(fmt.Sprintf("%d-8BITMIME", CodeOk))
+		lines = append(lines, "8BITMIME")
 	}
 	if h.conf.EnableSize {
-		s.ResponseLine(fmt.Sprintf("%d-SIZE %d", CodeOk, h.conf.MaxMailSize))
+		lines = append(lines, fmt.Sprintf("SIZE %d", h.conf.MaxMailSize))
 	}
 	if h.conf.EnableStartTls && !s.IsTls() {
-		s.ResponseLine(fmt.Sprintf("%d-STARTTLS", CodeOk))
+		lines = append(lines, "STARTTLS")
+	}
+	if h.conf.EnableChunking {
+		lines = append(lines, "CHUNKING")
+		if h.conf.EnableBinaryMime {
+			lines = append(lines, "BINARYMIME")
+		}
+	}
+	if h.conf.EnableSmtpUtf8 {
+		lines = append(lines, "SMTPUTF8")
 	}
-	s.Response(CodeOk, strings.ToUpper(HELP))
+	lines = append(lines, "ENHANCEDSTATUSCODES", strings.ToUpper(HELP))
+	s.Reply(session.NewReply(CodeOk, session.NoEnhancedCode, lines...))
 	return nil
 }
 
-func NewEhloHandler(log hlog.Logger, conf *config.SmtpConfig) CommandHandler {
+func NewEhloHandler(log hlog.Logger, conf *config.SmtpConfig, heloConf *config.HeloConfig, helo service.HeloService, milter service.MilterService) CommandHandler {
 	return &ehloHandler{
-		log:  log,
-		conf: conf,
+		log:      log,
+		conf:     conf,
+		heloConf: heloConf,
+		helo:     helo,
+		milter:   milter,
 	}
 }
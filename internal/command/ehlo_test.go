@@ -17,7 +17,7 @@ import (
 
 func TestEhlo_Command(t *testing.T) {
 	conf := &config.SmtpConfig{}
-	target := NewEhloHandler(nil, conf)
+	target := NewEhloHandler(nil, conf, &config.HeloConfig{}, nil, nil)
 
 	assert.Equal(t, EHLO, target.Command())
 }
@@ -27,19 +27,18 @@ func TestEhlo_Err(t *testing.T) {
 	defer ctrl.Finish()
 
 	log := mock.NewInitializedMockLogger(ctrl)
+	helo := mock.NewInitializedMockHeloService(ctrl)
 	conf := &config.SmtpConfig{}
 
 	tests := []struct {
-		name string
-		arg  []string
-		code int
-		msg  string
+		name  string
+		arg   []string
+		reply session.Reply
 	}{
 		{
-			name: "empty argument",
-			arg:  []string{},
-			code: CodeSyntaxError,
-			msg:  MsgSyntaxError,
+			name:  "empty argument",
+			arg:   []string{},
+			reply: ReplySyntaxError,
 		},
 	}
 
@@ -47,9 +46,9 @@ func TestEhlo_Err(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			s := session.NewMockSession(ctrl)
 
-			s.ExpectResponse(test.code, test.msg)
+			s.ExpectReply(test.reply)
 
-			target := NewEhloHandler(log, conf)
+			target := NewEhloHandler(log, conf, &config.HeloConfig{}, helo, mock.NewInitializedMockMilterService(ctrl))
 			target.HandleCommand(context.TODO(), s.Session, test.arg)
 		})
 	}
@@ -60,20 +59,21 @@ func TestEhlo(t *testing.T) {
 	defer ctrl.Finish()
 
 	log := mock.NewInitializedMockLogger(ctrl)
+	helo := mock.NewInitializedMockHeloService(ctrl)
+
+	hostname, _ := os.Hostname()
+	greet := fmt.Sprintf("%s greets %s", hostname, "test")
 
 	tests := []struct {
 		name       string
 		conf       *config.SmtpConfig
-		setup      func(s *session.MockSession)
+		lines      []string
 		alreadyTls bool
 	}{
 		{
-			name: "no extension",
-			conf: &config.SmtpConfig{},
-			setup: func(s *session.MockSession) {
-				hostname, _ := os.Hostname()
-				s.ExpectResponseLine(CodeOk, fmt.Sprintf("%s greets %s", hostname, "test"))
-			},
+			name:  "no extension",
+			conf:  &config.SmtpConfig{},
+			lines: []string{greet},
 		},
 		{
 			name: "enable all",
@@ -82,16 +82,12 @@ func TestEhlo(t *testing.T) {
 				Enable8BitMime:   true,
 				EnableSize:       true,
 				EnableStartTls:   true,
+				EnableChunking:   true,
+				EnableBinaryMime: true,
+				EnableSmtpUtf8:   true,
 				MaxMailSize:      1,
 			},
-			setup: func(s *session.MockSession) {
-				hostname, _ := os.Hostname()
-				s.ExpectResponseLine(CodeOk, fmt.Sprintf("%s greets %s", hostname, "test"))
-				s.ExpectResponseLine(CodeOk, "PIPELINING")
-				s.ExpectResponseLine(CodeOk, "8BITMIME")
-				s.ExpectResponseLine(CodeOk, "SIZE 1")
-				s.ExpectResponseLine(CodeOk, "STARTTLS")
-			},
+			lines: []string{greet, "PIPELINING", "8BITMIME", "SIZE 1", "STARTTLS", "CHUNKING", "BINARYMIME", "SMTPUTF8"},
 		},
 		{
 			name: "already tls",
@@ -102,31 +98,33 @@ func TestEhlo(t *testing.T) {
 				EnableStartTls:   true,
 				MaxMailSize:      1,
 			},
-			setup: func(s *session.MockSession) {
-				hostname, _ := os.Hostname()
-				s.ExpectResponseLine(CodeOk, fmt.Sprintf("%s greets %s", hostname, "test"))
-				s.ExpectResponseLine(CodeOk, "PIPELINING")
-				s.ExpectResponseLine(CodeOk, "8BITMIME")
-				s.ExpectResponseLine(CodeOk, "SIZE 1")
-			},
+			lines:      []string{greet, "PIPELINING", "8BITMIME", "SIZE 1"},
 			alreadyTls: true,
 		},
+		{
+			name: "binarymime without chunking",
+			conf: &config.SmtpConfig{
+				EnableBinaryMime: true,
+			},
+			lines: []string{greet},
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			target := NewEhloHandler(log, test.conf)
+			target := NewEhloHandler(log, test.conf, &config.HeloConfig{}, helo, mock.NewInitializedMockMilterService(ctrl))
 
 			s := session.NewMockSession(ctrl)
 
-			test.setup(s)
-			s.ExpectResponse(CodeOk, strings.ToUpper(HELP))
+			lines := append(test.lines, "ENHANCEDSTATUSCODES", strings.ToUpper(HELP))
+			s.ExpectReply(session.NewReply(CodeOk, session.NoEnhancedCode, lines...))
 			if test.alreadyTls {
 				s.Session.Conn = &tls.Conn{}
 			}
 
 			target.HandleCommand(context.TODO(), s.Session, []string{"test"})
 			assert.Equal(t, "test", s.Session.SenderDomain)
+			assert.Equal(t, test.conf.EnablePipelining, s.Session.Pipelining)
 			assert.Nil(t, s.Session.EnvelopeFrom)
 			assert.Empty(t, s.Session.EnvelopeTo)
 			assert.Empty(t, s.Session.RawData)
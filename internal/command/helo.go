@@ -5,11 +5,16 @@ import (
 	"os"
 
 	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/service"
 	"github.com/Haya372/smtp-server/internal/session"
 )
 
 type heloHandler struct {
-	log hlog.Logger
+	log    hlog.Logger
+	conf   *config.HeloConfig
+	helo   service.HeloService
+	milter service.MilterService
 }
 
 func (h *heloHandler) Command() string {
@@ -18,7 +23,12 @@ func (h *heloHandler) Command() string {
 
 func (h *heloHandler) HandleCommand(ctx context.Context, s *session.Session, arg []string) error {
 	if len(arg) == 0 {
-		s.Response(CodeSyntaxError, MsgSyntaxError)
+		s.Reply(ReplySyntaxError)
+		return nil
+	}
+
+	status, ok := verifyClient(ctx, h.log, h.conf, h.helo, s, arg[0])
+	if !ok || rejectByMilter(s, h.milter.Helo(ctx, s, arg[0])) {
 		return nil
 	}
 
@@ -26,14 +36,53 @@ func (h *heloHandler) HandleCommand(ctx context.Context, s *session.Session, arg
 	s.Reset()
 
 	s.SenderDomain = arg[0]
+	s.HeloStatus = status
+	s.Pipelining = false
 
 	hostname, _ := os.Hostname()
-	s.Response(CodeOk, hostname)
+	s.Reply(session.NewReply(CodeOk, session.NoEnhancedCode, hostname))
 	return nil
 }
 
-func NewHeloHandler(log hlog.Logger) CommandHandler {
+// verifyClient records the reverse DNS of the client and the validation of HELO/EHLO argument on the session.
+// false is returned when the client is rejected by them.
+func verifyClient(ctx context.Context, log hlog.Logger, conf *config.HeloConfig, helo service.HeloService, s *session.Session, arg string) (session.HeloStatus, bool) {
+	// reverse DNS is looked up once per connection
+	if s.ReverseDns == session.ReverseDnsNone {
+		s.ReverseDns, s.ClientHostname = helo.VerifyReverseDns(ctx, s)
+	}
+	if conf.RejectUnknownClient && (s.ReverseDns == session.ReverseDnsFail || s.ReverseDns == session.ReverseDnsTempError) {
+		log.Infof("[%s] reverse DNS of the client is %s.", s.Id, s.ReverseDns)
+		s.Reply(ReplyUnknownClient)
+		return session.HeloNone, false
+	}
+
+	status := helo.CheckHelo(s, arg)
+	if status != session.HeloValid && status != session.HeloNone {
+		log.Infof("[%s] HELO %s is %s.", s.Id, arg, status)
+	}
+	if !conf.RejectInvalidHelo {
+		return status, true
+	}
+	switch status {
+	case session.HeloInvalid:
+		s.Reply(ReplyInvalidHelo)
+		return status, false
+	case session.HeloMismatch:
+		s.Reply(ReplyHeloMismatch)
+		return status, false
+	case session.HeloOwnHostname:
+		s.Reply(ReplyHeloOwnHostname)
+		return status, false
+	}
+	return status, true
+}
+
+func NewHeloHandler(log hlog.Logger, conf *config.HeloConfig, helo service.HeloService, milter service.MilterService) CommandHandler {
 	return &heloHandler{
-		log: log,
+		log:    log,
+		conf:   conf,
+		helo:   helo,
+		milter: milter,
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/Haya372/smtp-server/internal/config"
 	"github.com/Haya372/smtp-server/internal/mock"
 	"github.com/Haya372/smtp-server/internal/session"
 	"github.com/golang/mock/gomock"
@@ -12,7 +13,7 @@ import (
 )
 
 func TestHelo_Command(t *testing.T) {
-	target := NewHeloHandler(nil)
+	target := NewHeloHandler(nil, &config.HeloConfig{}, nil, nil)
 	assert.Equal(t, HELO, target.Command())
 }
 
@@ -21,18 +22,17 @@ func TestHelo_Err(t *testing.T) {
 	defer ctrl.Finish()
 
 	log := mock.NewInitializedMockLogger(ctrl)
+	helo := mock.NewInitializedMockHeloService(ctrl)
 
 	tests := []struct {
-		name string
-		arg  []string
-		code int
-		msg  string
+		name  string
+		arg   []string
+		reply session.Reply
 	}{
 		{
-			name: "empty argument",
-			arg:  []string{},
-			code: CodeSyntaxError,
-			msg:  MsgSyntaxError,
+			name:  "empty argument",
+			arg:   []string{},
+			reply: ReplySyntaxError,
 		},
 	}
 
@@ -40,9 +40,9 @@ func TestHelo_Err(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			s := session.NewMockSession(ctrl)
 
-			s.ExpectResponse(test.code, test.msg)
+			s.ExpectReply(test.reply)
 
-			target := NewHeloHandler(log)
+			target := NewHeloHandler(log, &config.HeloConfig{}, helo, mock.NewInitializedMockMilterService(ctrl))
 			target.HandleCommand(context.TODO(), s.Session, test.arg)
 		})
 	}
@@ -53,15 +53,16 @@ func TestHelo(t *testing.T) {
 	defer ctrl.Finish()
 
 	log := mock.NewInitializedMockLogger(ctrl)
+	helo := mock.NewInitializedMockHeloService(ctrl)
 
-	target := NewHeloHandler(log)
+	target := NewHeloHandler(log, &config.HeloConfig{}, helo, mock.NewInitializedMockMilterService(ctrl))
 
 	s := session.NewMockSession(ctrl)
 
 	arg := []string{"test"}
 
 	hostname, _ := os.Hostname()
-	s.ExpectResponse(CodeOk, hostname)
+	s.ExpectReply(session.NewReply(CodeOk, session.NoEnhancedCode, hostname))
 
 	target.HandleCommand(context.TODO(), s.Session, arg)
 	assert.Equal(t, "test", s.Session.SenderDomain)
@@ -69,3 +70,114 @@ func TestHelo(t *testing.T) {
 	assert.Empty(t, s.Session.EnvelopeTo)
 	assert.Empty(t, s.Session.RawData)
 }
+
+func TestHelo_VerifyClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+	hostname, _ := os.Hostname()
+
+	tests := []struct {
+		name       string
+		conf       *config.HeloConfig
+		reverseDns session.ReverseDnsStatus
+		status     session.HeloStatus
+		reply      session.Reply
+		accepted   bool
+	}{
+		{
+			name:       "valid",
+			conf:       &config.HeloConfig{RejectUnknownClient: true, RejectInvalidHelo: true},
+			reverseDns: session.ReverseDnsPass,
+			status:     session.HeloValid,
+			reply:      session.NewReply(CodeOk, session.NoEnhancedCode, hostname),
+			accepted:   true,
+		},
+		{
+			name:       "unknown client",
+			conf:       &config.HeloConfig{RejectUnknownClient: true},
+			reverseDns: session.ReverseDnsFail,
+			reply:      ReplyUnknownClient,
+		},
+		{
+			name:       "temporary failure of reverse dns",
+			conf:       &config.HeloConfig{RejectUnknownClient: true},
+			reverseDns: session.ReverseDnsTempError,
+			reply:      ReplyUnknownClient,
+		},
+		{
+			name:       "unknown client is not rejected",
+			conf:       &config.HeloConfig{},
+			reverseDns: session.ReverseDnsFail,
+			status:     session.HeloInvalid,
+			reply:      session.NewReply(CodeOk, session.NoEnhancedCode, hostname),
+			accepted:   true,
+		},
+		{
+			name:       "invalid helo",
+			conf:       &config.HeloConfig{RejectInvalidHelo: true},
+			reverseDns: session.ReverseDnsPass,
+			status:     session.HeloInvalid,
+			reply:      ReplyInvalidHelo,
+		},
+		{
+			name:       "address literal mismatch",
+			conf:       &config.HeloConfig{RejectInvalidHelo: true},
+			reverseDns: session.ReverseDnsPass,
+			status:     session.HeloMismatch,
+			reply:      ReplyHeloMismatch,
+		},
+		{
+			name:       "own hostname",
+			conf:       &config.HeloConfig{RejectInvalidHelo: true},
+			reverseDns: session.ReverseDnsPass,
+			status:     session.HeloOwnHostname,
+			reply:      ReplyHeloOwnHostname,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			helo := mock.NewMockHeloService(ctrl)
+			helo.EXPECT().VerifyReverseDns(gomock.Any(), gomock.Any()).Return(test.reverseDns, "client.example.com").Times(1)
+			helo.EXPECT().CheckHelo(gomock.Any(), "test").Return(test.status).MaxTimes(1)
+
+			s := session.NewMockSession(ctrl)
+			s.ExpectReply(test.reply)
+
+			target := NewHeloHandler(log, test.conf, helo, mock.NewInitializedMockMilterService(ctrl))
+			target.HandleCommand(context.TODO(), s.Session, []string{"test"})
+
+			assert.Equal(t, test.reverseDns, s.Session.ReverseDns)
+			if test.accepted {
+				assert.Equal(t, "test", s.Session.SenderDomain)
+				assert.Equal(t, test.status, s.Session.HeloStatus)
+			} else {
+				assert.Empty(t, s.Session.SenderDomain)
+			}
+		})
+	}
+}
+
+func TestHelo_ReverseDnsOnce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+	hostname, _ := os.Hostname()
+
+	helo := mock.NewMockHeloService(ctrl)
+	helo.EXPECT().VerifyReverseDns(gomock.Any(), gomock.Any()).Return(session.ReverseDnsPass, "client.example.com").Times(1)
+	helo.EXPECT().CheckHelo(gomock.Any(), gomock.Any()).Return(session.HeloValid).Times(2)
+
+	s := session.NewMockSession(ctrl)
+	s.ExpectReply(session.NewReply(CodeOk, session.NoEnhancedCode, hostname))
+	s.ExpectReply(session.NewReply(CodeOk, session.NoEnhancedCode, hostname))
+
+	target := NewHeloHandler(log, &config.HeloConfig{}, helo, mock.NewInitializedMockMilterService(ctrl))
+	target.HandleCommand(context.TODO(), s.Session, []string{"test"})
+	target.HandleCommand(context.TODO(), s.Session, []string{"test"})
+
+	assert.Equal(t, "client.example.com", s.Session.ClientHostname)
+}
@@ -2,7 +2,6 @@ package command
 
 import (
 	"context"
-	"fmt"
 	"strings"
 
 	"github.com/Haya372/hlog"
@@ -18,13 +17,12 @@ func (h *helpHandler) Command() string {
 }
 
 func (h *helpHandler) HandleCommand(ctx context.Context, s *session.Session, arg []string) error {
-	s.ResponseLine(fmt.Sprintf("%d-%s", CodeHelp, MsgHelp))
 	supportCommands := []string{
-		HELO, EHLO, MAIL, RCPT, DATA, QUIT, RSET, NOOP, HELP,
+		HELO, EHLO, MAIL, RCPT, DATA, BDAT, QUIT, RSET, NOOP, HELP,
 	}
 
 	respStr := strings.ToUpper(strings.Join(supportCommands, " "))
-	s.Response(CodeHelp, respStr)
+	s.Reply(session.NewReply(CodeHelp, EnhancedOk, MsgHelp, respStr))
 	return nil
 }
 
@@ -26,12 +26,11 @@ func TestHelp(t *testing.T) {
 
 	s := session.NewMockSession(ctrl)
 
-	s.ExpectResponseLine(CodeHelp, MsgHelp)
 	supportCommands := []string{
-		HELO, EHLO, MAIL, RCPT, DATA, QUIT, RSET, NOOP, HELP,
+		HELO, EHLO, MAIL, RCPT, DATA, BDAT, QUIT, RSET, NOOP, HELP,
 	}
 	respStr := strings.ToUpper(strings.Join(supportCommands, " "))
-	s.ExpectResponse(CodeHelp, respStr)
+	s.ExpectReply(session.NewReply(CodeHelp, EnhancedOk, MsgHelp, respStr))
 
 	target.HandleCommand(context.TODO(), s.Session, make([]string, 0))
 }
@@ -9,12 +9,15 @@ import (
 
 	"github.com/Haya372/hlog"
 	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/service"
 	"github.com/Haya372/smtp-server/internal/session"
 )
 
 type mailHandler struct {
-	log  hlog.Logger
-	conf *config.SmtpConfig
+	log       hlog.Logger
+	conf      *config.SmtpConfig
+	rateLimit service.RateLimitService
+	milter    service.MilterService
 }
 
 func (h *mailHandler) Command() string {
@@ -24,86 +27,148 @@ func (h *mailHandler) Command() string {
 func (h *mailHandler) HandleCommand(ctx context.Context, s *session.Session, arg []string) error {
 	// helo or ehlo command should be called
 	if len(s.SenderDomain) == 0 {
-		s.Response(CodeBadSequence, MsgBadSequence)
+		s.Reply(ReplyBadSequence)
 		return nil
 	}
 
 	if s.EnvelopeFrom != nil {
-		s.Response(CodeBadSequence, MsgBadSequence)
+		s.Reply(ReplyBadSequence)
 		return nil
 	}
 
-	if len(arg) == 0 {
-		s.Response(CodeSyntaxError, MsgSyntaxError)
+	if h.conf.MaxMessagesPerConnection > 0 && s.MessageCount >= h.conf.MaxMessagesPerConnection {
+		h.log.Infof("[%s] too many messages in the connection.", s.Id)
+		s.Reply(ReplyTooManyMessages)
+		s.ShouldClose = true
 		return nil
 	}
 
-	addr := strings.Replace(arg[0], "from:", "", 1)
-	addr = strings.Replace(addr, "FROM:", "", 1)
+	if len(arg) == 0 {
+		s.Reply(ReplyArgumentSyntaxError)
+		return nil
+	}
 
-	// check ESMTP arguments
-	for _, line := range arg[1:] {
-		keyVal := strings.Split(line, "=")
-		if len(keyVal) != 2 {
-			h.log.Errorf("[%s] failed to recognized option %s", s.Id, line)
-			s.Response(CodeOptionParamNotRecognized, MsgOptionParamNotRecognized)
-			return nil
+	mailbox, params, err := parseMailArgument(s.Argument)
+	if err != nil {
+		h.log.WithError(err).Debugf("[%s] failed to parse argument %s", s.Id, s.Argument)
+		if errors.Is(err, errInvalidParam) {
+			s.Reply(ReplyArgumentSyntaxError)
+		} else {
+			s.Reply(ReplyBadSenderSyntax)
 		}
+		return nil
+	}
 
-		opt := keyVal[0]
-		val := keyVal[1]
+	// parameters of a previously rejected MAIL must not remain
+	s.ResetTransaction()
+
+	// check ESMTP arguments
+	for _, param := range params {
 		var err error
-		switch opt {
+		switch param.Keyword {
 		case "SIZE":
-			err = h.handleSizeOption(ctx, s, val)
+			err = h.handleSizeOption(ctx, s, param)
+		case "BODY":
+			err = h.handleBodyOption(ctx, s, param)
+		case "SMTPUTF8":
+			err = h.handleSmtpUtf8Option(ctx, s, param)
 		default:
-			err = errors.New("option not implemented")
-			s.Response(CodeCommandParamNotImplemented, MsgCommandParamNotImplemented)
+			err = errors.New("option not recognized")
+			s.Reply(ReplyOptionParamNotRecognized)
 		}
 		if err != nil {
-			h.log.WithError(err).Errorf("[%s] failed to handle option %s", s.Id, opt)
+			h.log.WithError(err).Errorf("[%s] failed to handle option %s", s.Id, param.Keyword)
 			return err
 		}
 	}
 
-	if addr == "<>" {
-		// Envelope From is null
-		s.EnvelopeFrom = &mail.Address{}
-	} else {
-		address, err := mail.ParseAddress(addr)
-		if err != nil {
-			h.log.WithError(err).Debugf("[%s] failed to parse address %s", s.Id, arg[0])
-			s.Response(CodeSyntaxError, MsgSyntaxError)
-			return nil
-		}
+	// UTF-8 is checked before the IDN domain is converted to A-labels
+	if !s.SmtpUtf8 && !isASCII(mailbox) {
+		s.Reply(ReplyNonAsciiAddress)
+		return nil
+	}
 
-		s.EnvelopeFrom = address
+	// Envelope From is null when mailbox is empty
+	address := &mail.Address{Address: mailbox}
+	if err := normalizeAddress(address); err != nil {
+		h.log.WithError(err).Debugf("[%s] failed to normalize address %s", s.Id, mailbox)
+		s.Reply(ReplyBadSenderSyntax)
+		return nil
 	}
 
-	s.Response(CodeOk, MsgOk)
+	s.EnvelopeFrom = address
+	if !h.rateLimit.AllowMessage(s) {
+		h.log.Warnf("[%s] message rate limit exceeded by %s.", s.Id, address.Address)
+		s.ResetTransaction()
+		s.Reply(ReplyMessageRate)
+		return nil
+	}
+	if rejectByMilter(s, h.milter.Mail(ctx, s, esmtpArgs(params))) {
+		s.ResetTransaction()
+		return nil
+	}
+	s.Reply(ReplySenderOk)
 	return nil
 }
 
-func (h *mailHandler) handleSizeOption(ctx context.Context, s *session.Session, arg string) error {
+func (h *mailHandler) handleSizeOption(ctx context.Context, s *session.Session, param esmtpParam) error {
 	if !h.conf.EnableSize {
-		s.Response(CodeCommandParamNotImplemented, MsgCommandParamNotImplemented)
+		s.Reply(ReplyCommandParamNotImplemented)
 		return errors.New("option SIZE not enabled")
 	}
-	size, err := strconv.Atoi(arg)
+	size, err := strconv.Atoi(param.Value)
 	if err != nil {
-		s.Response(CodeArgumentSyntaxError, MsgArgumentSyntaxError)
+		s.Reply(ReplyArgumentSyntaxError)
 		return err
 	}
 	if size > h.conf.MaxMailSize {
-		s.Response(CodeAborted, MsgAborted)
+		s.Reply(ReplyAborted)
 		return errors.New("message size exceed limit")
 	}
 	return nil
 }
 
-func NewMailHandler(log hlog.Logger, conf *config.SmtpConfig) CommandHandler {
+func (h *mailHandler) handleBodyOption(ctx context.Context, s *session.Session, param esmtpParam) error {
+	body := session.BodyType(strings.ToUpper(param.Value))
+	switch body {
+	case session.Body7Bit:
+	case session.Body8BitMime:
+		if !h.conf.Enable8BitMime {
+			s.Reply(ReplyCommandParamNotImplemented)
+			return errors.New("option BODY=8BITMIME not enabled")
+		}
+	case session.BodyBinaryMime:
+		if !h.conf.EnableChunking || !h.conf.EnableBinaryMime {
+			s.Reply(ReplyCommandParamNotImplemented)
+			return errors.New("option BODY=BINARYMIME not enabled")
+		}
+	default:
+		s.Reply(ReplyCommandParamNotImplemented)
+		return errors.New("option BODY not implemented")
+	}
+	s.BodyType = body
+	return nil
+}
+
+func (h *mailHandler) handleSmtpUtf8Option(ctx context.Context, s *session.Session, param esmtpParam) error {
+	if !h.conf.EnableSmtpUtf8 {
+		s.Reply(ReplyCommandParamNotImplemented)
+		return errors.New("option SMTPUTF8 not enabled")
+	}
+	// SMTPUTF8 has no value
+	if param.HasValue {
+		s.Reply(ReplyArgumentSyntaxError)
+		return errors.New("option SMTPUTF8 has value")
+	}
+	s.SmtpUtf8 = true
+	return nil
+}
+
+func NewMailHandler(log hlog.Logger, conf *config.SmtpConfig, rateLimit service.RateLimitService, milter service.MilterService) CommandHandler {
 	return &mailHandler{
-		log:  log,
-		conf: conf,
+		log:       log,
+		conf:      conf,
+		rateLimit: rateLimit,
+		milter:    milter,
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
 	"github.com/Haya372/smtp-server/internal/mock"
 	"github.com/Haya372/smtp-server/internal/session"
 	"github.com/golang/mock/gomock"
@@ -14,7 +15,7 @@ import (
 
 func TestMail_Command(t *testing.T) {
 	conf := &config.SmtpConfig{}
-	target := NewMailHandler(nil, conf)
+	target := NewMailHandler(nil, conf, nil, nil)
 	assert.Equal(t, MAIL, target.Command())
 }
 
@@ -26,38 +27,93 @@ func TestMail(t *testing.T) {
 
 	tests := []struct {
 		name                      string
-		arg                       []string
+		arg                       string
 		conf                      *config.SmtpConfig
 		expectEnvelopeFromAddress string
+		expectBodyType            session.BodyType
+		expectSmtpUtf8            bool
 	}{
 		{
 			name:                      "no param",
-			arg:                       []string{"from:<from@example.com>"},
+			arg:                       "from:<from@example.com>",
 			expectEnvelopeFromAddress: "<from@example.com>",
 		},
 		{
 			name: "empty from address",
-			arg:  []string{"from:<>"},
+			arg:  "from:<>",
+		},
+		{
+			name:                      "space after colon",
+			arg:                       "FROM: <from@example.com>",
+			expectEnvelopeFromAddress: "<from@example.com>",
+		},
+		{
+			name:                      "mixed case",
+			arg:                       "From:<from@example.com>",
+			expectEnvelopeFromAddress: "<from@example.com>",
+		},
+		{
+			name:                      "source route",
+			arg:                       "from:<@a.example,@b.example:from@example.com>",
+			expectEnvelopeFromAddress: "<from@example.com>",
 		},
 		{
 			name: "with SIZE param",
-			arg:  []string{"from:<from@example.com>", "SIZE=100"},
+			arg:  "from:<from@example.com> SIZE=100",
 			conf: &config.SmtpConfig{
 				EnableSize:  true,
 				MaxMailSize: 1000,
 			},
 			expectEnvelopeFromAddress: "<from@example.com>",
 		},
+		{
+			name: "with BODY=BINARYMIME param",
+			arg:  "from:<from@example.com> BODY=BINARYMIME",
+			conf: &config.SmtpConfig{
+				EnableChunking:   true,
+				EnableBinaryMime: true,
+			},
+			expectEnvelopeFromAddress: "<from@example.com>",
+			expectBodyType:            session.BodyBinaryMime,
+		},
+		{
+			name:                      "with BODY=7BIT param",
+			arg:                       "from:<from@example.com> BODY=7BIT",
+			expectEnvelopeFromAddress: "<from@example.com>",
+			expectBodyType:            session.Body7Bit,
+		},
+		{
+			name: "with BODY=8BITMIME param",
+			arg:  "from:<from@example.com> body=8bitmime",
+			conf: &config.SmtpConfig{
+				Enable8BitMime: true,
+			},
+			expectEnvelopeFromAddress: "<from@example.com>",
+			expectBodyType:            session.Body8BitMime,
+		},
+		{
+			name: "with SMTPUTF8 param",
+			arg:  "from:<josé@exämple.com> SMTPUTF8",
+			conf: &config.SmtpConfig{
+				EnableSmtpUtf8: true,
+			},
+			expectEnvelopeFromAddress: "<josé@xn--exmple-cua.com>",
+			expectSmtpUtf8:            true,
+		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			s := session.NewMockSession(ctrl)
 			s.Session.SenderDomain = "example.com"
-			s.ExpectResponse(CodeOk, MsgOk)
+			s.ExpectReply(ReplySenderOk)
 
-			target := NewMailHandler(log, test.conf)
-			target.HandleCommand(context.TODO(), s.Session, test.arg)
+			conf := test.conf
+			if conf == nil {
+				conf = &config.SmtpConfig{}
+			}
+			target := NewMailHandler(log, conf, mock.NewInitializedMockRateLimitService(ctrl), mock.NewInitializedMockMilterService(ctrl))
+			handleArgument(target, s.Session, test.arg)
 			var expect *mail.Address
 			if len(test.expectEnvelopeFromAddress) != 0 {
 				expect, _ = mail.ParseAddress(test.expectEnvelopeFromAddress)
@@ -65,6 +121,8 @@ func TestMail(t *testing.T) {
 				expect = &mail.Address{}
 			}
 			assert.Equal(t, s.Session.EnvelopeFrom, expect)
+			assert.Equal(t, test.expectBodyType, s.Session.BodyType)
+			assert.Equal(t, test.expectSmtpUtf8, s.Session.SmtpUtf8)
 		})
 	}
 }
@@ -77,93 +135,167 @@ func TestMail_Err(t *testing.T) {
 
 	tests := []struct {
 		name          string
-		arg           []string
+		arg           string
 		conf          *config.SmtpConfig
 		senderDomain  string
 		alreadyCalled bool
-		code          int
-		msg           string
+		messageCount  int
+		reply         session.Reply
 	}{
 		{
-			name: "hello not called",
-			arg:  []string{"from:<from@example.com>"},
-			code: CodeBadSequence,
-			msg:  MsgBadSequence,
+			name:  "hello not called",
+			arg:   "from:<from@example.com>",
+			reply: ReplyBadSequence,
+		},
+		{
+			name:         "too many messages",
+			arg:          "from:<from@example.com>",
+			conf:         &config.SmtpConfig{MaxMessagesPerConnection: 2},
+			senderDomain: "example.com",
+			messageCount: 2,
+			reply:        ReplyTooManyMessages,
 		},
 		{
 			name:          "mail already called",
-			arg:           []string{"from:<from@example.com>"},
+			arg:           "from:<from@example.com>",
 			senderDomain:  "example.com",
 			alreadyCalled: true,
-			code:          CodeBadSequence,
-			msg:           MsgBadSequence,
+			reply:         ReplyBadSequence,
 		},
 		{
 			name:         "argument is empty",
 			senderDomain: "example.com",
-			code:         CodeSyntaxError,
-			msg:          MsgSyntaxError,
+			reply:        ReplyArgumentSyntaxError,
 		},
 		{
 			name:         "invalid from address",
 			senderDomain: "example.com",
-			arg:          []string{"from:from@example.com>"},
-			code:         CodeSyntaxError,
-			msg:          MsgSyntaxError,
+			arg:          "from:from@example.com>",
+			reply:        ReplyBadSenderSyntax,
+		},
+		{
+			name:         "display name",
+			senderDomain: "example.com",
+			arg:          "from:Sender <from@example.com>",
+			reply:        ReplyBadSenderSyntax,
+		},
+		{
+			name:         "invalid parameter syntax",
+			senderDomain: "example.com",
+			arg:          "from:<from@example.com> SIZE=",
+			reply:        ReplyArgumentSyntaxError,
 		},
 		{
 			name: "param error '=' not found",
-			arg:  []string{"from:<from@example.com>", "SIZE100"},
+			arg:  "from:<from@example.com> SIZE100",
 			conf: &config.SmtpConfig{
 				EnableSize:  true,
 				MaxMailSize: 1000,
 			},
 			senderDomain: "example.com",
-			code:         CodeOptionParamNotRecognized,
-			msg:          MsgOptionParamNotRecognized,
+			reply:        ReplyOptionParamNotRecognized,
 		},
 		{
 			name: "param error SIZE value not integer",
-			arg:  []string{"from:<from@example.com>", "SIZE=hoge"},
+			arg:  "from:<from@example.com> SIZE=hoge",
 			conf: &config.SmtpConfig{
 				EnableSize:  true,
 				MaxMailSize: 1000,
 			},
 			senderDomain: "example.com",
-			code:         CodeArgumentSyntaxError,
-			msg:          MsgArgumentSyntaxError,
+			reply:        ReplyArgumentSyntaxError,
 		},
 		{
 			name: "message size exceed limit",
-			arg:  []string{"from:<from@example.com>", "SIZE=1000000000"},
+			arg:  "from:<from@example.com> SIZE=1000000000",
 			conf: &config.SmtpConfig{
 				EnableSize:  true,
 				MaxMailSize: 1000,
 			},
 			senderDomain: "example.com",
-			code:         CodeAborted,
-			msg:          MsgAborted,
+			reply:        ReplyAborted,
 		},
 		{
 			name: "size option disabled",
-			arg:  []string{"from:<from@example.com>", "SIZE=1000000000"},
+			arg:  "from:<from@example.com> SIZE=1000000000",
 			conf: &config.SmtpConfig{
 				EnableSize: false,
 			},
 			senderDomain: "example.com",
-			code:         CodeCommandParamNotImplemented,
-			msg:          MsgCommandParamNotImplemented,
+			reply:        ReplyCommandParamNotImplemented,
+		},
+		{
+			name: "8bitmime disabled",
+			arg:  "from:<from@example.com> BODY=8BITMIME",
+			conf: &config.SmtpConfig{
+				Enable8BitMime: false,
+			},
+			senderDomain: "example.com",
+			reply:        ReplyCommandParamNotImplemented,
+		},
+		{
+			name: "unknown body type",
+			arg:  "from:<from@example.com> BODY=HOGE",
+			conf: &config.SmtpConfig{
+				Enable8BitMime: true,
+			},
+			senderDomain: "example.com",
+			reply:        ReplyCommandParamNotImplemented,
+		},
+		{
+			name: "binarymime disabled",
+			arg:  "from:<from@example.com> BODY=BINARYMIME",
+			conf: &config.SmtpConfig{
+				EnableChunking: true,
+			},
+			senderDomain: "example.com",
+			reply:        ReplyCommandParamNotImplemented,
+		},
+		{
+			name: "smtputf8 disabled",
+			arg:  "from:<josé@example.com> SMTPUTF8",
+			conf: &config.SmtpConfig{
+				EnableSmtpUtf8: false,
+			},
+			senderDomain: "example.com",
+			reply:        ReplyCommandParamNotImplemented,
+		},
+		{
+			name: "UTF-8 address without SMTPUTF8",
+			arg:  "from:<josé@example.com>",
+			conf: &config.SmtpConfig{
+				EnableSmtpUtf8: true,
+			},
+			senderDomain: "example.com",
+			reply:        ReplyNonAsciiAddress,
+		},
+		{
+			name: "IDN domain without SMTPUTF8",
+			arg:  "from:<from@exämple.com>",
+			conf: &config.SmtpConfig{
+				EnableSmtpUtf8: true,
+			},
+			senderDomain: "example.com",
+			reply:        ReplyNonAsciiAddress,
+		},
+		{
+			name: "invalid IDN domain",
+			arg:  "from:<from@ä\u200d.com> SMTPUTF8",
+			conf: &config.SmtpConfig{
+				EnableSmtpUtf8: true,
+			},
+			senderDomain: "example.com",
+			reply:        ReplyBadSenderSyntax,
 		},
 		{
 			name: "unknown option",
-			arg:  []string{"from:<from@example.com>", "UNKNOWN=hoge"},
+			arg:  "from:<from@example.com> UNKNOWN=hoge",
 			conf: &config.SmtpConfig{
 				EnableSize:  true,
 				MaxMailSize: 1000,
 			},
 			senderDomain: "example.com",
-			code:         CodeCommandParamNotImplemented,
-			msg:          MsgCommandParamNotImplemented,
+			reply:        ReplyOptionParamNotRecognized,
 		},
 	}
 
@@ -174,12 +306,61 @@ func TestMail_Err(t *testing.T) {
 			if test.alreadyCalled {
 				s.Session.EnvelopeFrom = &mail.Address{Address: "test@example.com"}
 			}
+			s.Session.MessageCount = test.messageCount
 
-			s.ExpectResponse(test.code, test.msg)
+			s.ExpectReply(test.reply)
 
-			target := NewMailHandler(log, test.conf)
+			conf := test.conf
+			if conf == nil {
+				conf = &config.SmtpConfig{}
+			}
+			target := NewMailHandler(log, conf, mock.NewInitializedMockRateLimitService(ctrl), mock.NewInitializedMockMilterService(ctrl))
 
-			target.HandleCommand(context.TODO(), s.Session, test.arg)
+			handleArgument(target, s.Session, test.arg)
 		})
 	}
 }
+
+func TestMail_RateLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	s := session.NewMockSession(ctrl)
+	s.Session.SenderDomain = "example.com"
+	s.ExpectReply(ReplyMessageRate)
+
+	rateLimit := mock.NewMockRateLimitService(ctrl)
+	rateLimit.EXPECT().AllowMessage(s.Session).DoAndReturn(func(s *session.Session) bool {
+		// the sender domain is available for the limit
+		assert.Equal(t, "from@example.com", s.EnvelopeFrom.Address)
+		return false
+	})
+
+	target := NewMailHandler(log, &config.SmtpConfig{}, rateLimit, mock.NewInitializedMockMilterService(ctrl))
+	handleArgument(target, s.Session, "from:<from@example.com>")
+	assert.Nil(t, s.Session.EnvelopeFrom)
+}
+
+func TestMail_Milter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	s := session.NewMockSession(ctrl)
+	s.Session.SenderDomain = "example.com"
+	s.ExpectReply(ReplyMilterTempFail)
+
+	milter := mock.NewMockMilterService(ctrl)
+	milter.EXPECT().Mail(gomock.Any(), s.Session, []string{"BODY=8BITMIME"}).DoAndReturn(func(ctx context.Context, s *session.Session, args []string) *data.MilterResult {
+		// the sender is sent to milters
+		assert.Equal(t, "from@example.com", s.EnvelopeFrom.Address)
+		return &data.MilterResult{Action: data.MilterTempFail}
+	})
+
+	target := NewMailHandler(log, &config.SmtpConfig{Enable8BitMime: true}, mock.NewInitializedMockRateLimitService(ctrl), milter)
+	handleArgument(target, s.Session, "from:<from@example.com> BODY=8BITMIME")
+	assert.Nil(t, s.Session.EnvelopeFrom)
+}
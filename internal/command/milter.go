@@ -0,0 +1,57 @@
+package command
+
+import (
+	"context"
+
+	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/service"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+// MilterReply returns the reply given by the milter, or the default reply of the action.
+func MilterReply(res *data.MilterResult, reject, tempFail session.Reply) session.Reply {
+	if res.Reply != nil {
+		return *res.Reply
+	}
+	if res.Action == data.MilterTempFail {
+		return tempFail
+	}
+	return reject
+}
+
+// rejectByMilter replies the rejection by milters, false is returned when the command is continued.
+// Discarded messages are continued.
+func rejectByMilter(s *session.Session, res *data.MilterResult) bool {
+	if res.Action != data.MilterReject && res.Action != data.MilterTempFail {
+		return false
+	}
+	s.Reply(MilterReply(res, ReplyMilterRejected, ReplyMilterTempFail))
+	return true
+}
+
+// filterMessage sends the message s.RawData to milters, true is returned when the message is rejected.
+// The transaction is reset on rejection, s.RawData and the envelope may be changed by milters.
+func filterMessage(ctx context.Context, log hlog.Logger, milter service.MilterService, s *session.Session) bool {
+	if rejectByMilter(s, milter.Data(ctx, s)) {
+		s.ResetTransaction()
+		return true
+	}
+	if s.Discard {
+		log.Infof("[%s] message is discarded by milter.", s.Id)
+	}
+	return false
+}
+
+// esmtpArgs formats ESMTP parameters such as "BODY=8BITMIME".
+func esmtpArgs(params []esmtpParam) []string {
+	args := make([]string, 0, len(params))
+	for _, param := range params {
+		if param.HasValue {
+			args = append(args, param.Keyword+"="+param.Value)
+		} else {
+			args = append(args, param.Keyword)
+		}
+	}
+	return args
+}
@@ -0,0 +1,50 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMilterReply(t *testing.T) {
+	custom := session.NewReply(550, session.EnhancedCode{5, 7, 0}, "spam")
+
+	tests := []struct {
+		name     string
+		res      *data.MilterResult
+		expected session.Reply
+	}{
+		{
+			name:     "reject",
+			res:      &data.MilterResult{Action: data.MilterReject},
+			expected: ReplyMilterRejected,
+		},
+		{
+			name:     "tempfail",
+			res:      &data.MilterResult{Action: data.MilterTempFail},
+			expected: ReplyMilterTempFail,
+		},
+		{
+			name:     "reply by milter",
+			res:      &data.MilterResult{Action: data.MilterReject, Reply: &custom},
+			expected: custom,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, MilterReply(test.res, ReplyMilterRejected, ReplyMilterTempFail))
+		})
+	}
+}
+
+func TestEsmtpArgs(t *testing.T) {
+	params := []esmtpParam{
+		{Keyword: "BODY", Value: "8BITMIME", HasValue: true},
+		{Keyword: "SMTPUTF8"},
+	}
+
+	assert.Equal(t, []string{"BODY=8BITMIME", "SMTPUTF8"}, esmtpArgs(params))
+}
@@ -16,7 +16,7 @@ func (h *noopHandler) Command() string {
 }
 
 func (h *noopHandler) HandleCommand(ctx context.Context, s *session.Session, arg []string) error {
-	s.Response(CodeOk, MsgOk)
+	s.Reply(ReplyOk)
 	return nil
 }
 
@@ -24,7 +24,7 @@ func TestNoop(t *testing.T) {
 	target := NewNoopHandler(log)
 
 	s := session.NewMockSession(ctrl)
-	s.ExpectResponse(CodeOk, MsgOk)
+	s.ExpectReply(ReplyOk)
 
 	target.HandleCommand(context.TODO(), s.Session, make([]string, 0))
 }
@@ -0,0 +1,367 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"unicode/utf8"
+)
+
+// https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-5-3-1--Size-Limits-and-Minimums
+const (
+	maxLocalPartLength = 64
+	maxDomainLength    = 255
+	maxPathLength      = 256
+)
+
+var (
+	errInvalidPath  = errors.New("invalid path")
+	errInvalidParam = errors.New("invalid esmtp parameter")
+)
+
+// ESMTP parameter of MAIL and RCPT
+type esmtpParam struct {
+	// upper case keyword
+	Keyword string
+	// raw value, empty when the parameter has no value
+	Value    string
+	HasValue bool
+}
+
+// XtextValue returns the value decoded as xtext.
+// https://tex2e.github.io/rfc-translater/html/rfc3461.html#4--Additional-parameters-for-RCPT-and-MAIL-commands
+func (p esmtpParam) XtextValue() (string, error) {
+	return decodeXtext(p.Value)
+}
+
+// parseMailArgument parses `FROM:<reverse-path> [SP Mail-parameters]`.
+// Empty string is returned for the null reverse-path "<>".
+func parseMailArgument(arg string) (string, []esmtpParam, error) {
+	return parseCommandArgument(arg, "from:", true)
+}
+
+// parseRcptArgument parses `TO:<forward-path> [SP Rcpt-parameters]`.
+func parseRcptArgument(arg string) (string, []esmtpParam, error) {
+	return parseCommandArgument(arg, "to:", false)
+}
+
+// https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-1-2--Command-Argument-Syntax
+func parseCommandArgument(arg, prefix string, reversePath bool) (string, []esmtpParam, error) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, fmt.Errorf("%w: %s not found", errInvalidPath, strings.ToUpper(prefix))
+	}
+	// some clients put a space after the colon
+	rest := strings.TrimLeft(arg[len(prefix):], " ")
+
+	pathLen, err := scanPath(rest)
+	if err != nil {
+		return "", nil, err
+	}
+	path := rest[:pathLen]
+	if len(path) > maxPathLength {
+		return "", nil, fmt.Errorf("%w: path too long", errInvalidPath)
+	}
+
+	mailbox, err := parsePath(path, reversePath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	rest = rest[pathLen:]
+	if len(rest) > 0 && rest[0] != ' ' {
+		return "", nil, fmt.Errorf("%w: unexpected character after path", errInvalidPath)
+	}
+	params, err := parseEsmtpParams(strings.TrimLeft(rest, " "))
+	if err != nil {
+		return "", nil, err
+	}
+	return mailbox, params, nil
+}
+
+// scanPath returns the length of the path enclosed in angle brackets, ">" in quoted strings is skipped.
+func scanPath(str string) (int, error) {
+	if len(str) == 0 || str[0] != '<' {
+		return 0, fmt.Errorf("%w: path must start with '<'", errInvalidPath)
+	}
+	quoted := false
+	for i := 1; i < len(str); i++ {
+		switch {
+		case quoted && str[i] == '\\':
+			i++
+		case str[i] == '"':
+			quoted = !quoted
+		case !quoted && str[i] == '>':
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: path must end with '>'", errInvalidPath)
+}
+
+// parsePath parses `"<" [ A-d-l ":" ] Mailbox ">"` and returns the mailbox.
+func parsePath(path string, reversePath bool) (string, error) {
+	inner := path[1 : len(path)-1]
+	if len(inner) == 0 {
+		if reversePath {
+			return "", nil
+		}
+		return "", fmt.Errorf("%w: null forward-path", errInvalidPath)
+	}
+
+	// "Postmaster" without domain is permitted as forward-path
+	if !reversePath && strings.EqualFold(inner, "postmaster") {
+		return inner, nil
+	}
+
+	// source route is obsolete, it must be accepted but ignored
+	if inner[0] == '@' {
+		colon := strings.Index(inner, ":")
+		if colon < 0 {
+			return "", fmt.Errorf("%w: invalid source route", errInvalidPath)
+		}
+		for _, atDomain := range strings.Split(inner[:colon], ",") {
+			if len(atDomain) < 2 || atDomain[0] != '@' || !isDomain(atDomain[1:]) {
+				return "", fmt.Errorf("%w: invalid source route", errInvalidPath)
+			}
+		}
+		inner = inner[colon+1:]
+	}
+
+	return parseMailbox(inner)
+}
+
+// parseMailbox parses `Local-part "@" ( Domain / address-literal )`.
+// Quoted local part is kept quoted as the client sent it, the unquoted form may be a different mailbox.
+func parseMailbox(mailbox string) (string, error) {
+	at := strings.LastIndex(mailbox, "@")
+	if at < 0 {
+		return "", fmt.Errorf("%w: domain not found", errInvalidPath)
+	}
+	localPart, domain := mailbox[:at], mailbox[at+1:]
+
+	if err := validateLocalPart(localPart); err != nil {
+		return "", err
+	}
+	if len(localPart) > maxLocalPartLength {
+		return "", fmt.Errorf("%w: local part too long", errInvalidPath)
+	}
+
+	if len(domain) > maxDomainLength {
+		return "", fmt.Errorf("%w: domain too long", errInvalidPath)
+	}
+	if strings.HasPrefix(domain, "[") {
+		if !isAddressLiteral(domain) {
+			return "", fmt.Errorf("%w: invalid address literal", errInvalidPath)
+		}
+	} else if !isDomain(domain) {
+		return "", fmt.Errorf("%w: invalid domain", errInvalidPath)
+	}
+
+	return mailbox, nil
+}
+
+// validateLocalPart checks `Dot-string / Quoted-string`.
+func validateLocalPart(localPart string) error {
+	if len(localPart) == 0 {
+		return fmt.Errorf("%w: empty local part", errInvalidPath)
+	}
+
+	if localPart[0] != '"' {
+		for _, atom := range strings.Split(localPart, ".") {
+			if len(atom) == 0 {
+				return fmt.Errorf("%w: invalid dot-string", errInvalidPath)
+			}
+			for _, r := range atom {
+				if !isAtext(r) {
+					return fmt.Errorf("%w: invalid character in local part", errInvalidPath)
+				}
+			}
+		}
+		return nil
+	}
+
+	if len(localPart) < 2 || localPart[len(localPart)-1] != '"' {
+		return fmt.Errorf("%w: invalid quoted-string", errInvalidPath)
+	}
+	content := localPart[1 : len(localPart)-1]
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '\\':
+			// quoted-pairSMTP = %d92 %d32-126
+			if i+1 >= len(content) || content[i+1] < 32 || content[i+1] > 126 {
+				return fmt.Errorf("%w: invalid quoted-pair", errInvalidPath)
+			}
+			i++
+		case c == '"':
+			return fmt.Errorf("%w: invalid quoted-string", errInvalidPath)
+		case c >= 32 && c <= 126, c >= utf8.RuneSelf:
+			// qtextSMTP = %d32-33 / %d35-91 / %d93-126 / UTF8-non-ascii
+		default:
+			return fmt.Errorf("%w: invalid character in quoted-string", errInvalidPath)
+		}
+	}
+	return nil
+}
+
+// atext of RFC 5322 extended by UTF8-non-ascii of RFC 6531
+func isAtext(r rune) bool {
+	if r >= utf8.RuneSelf {
+		return r != utf8.RuneError
+	}
+	if isAlphaNum(byte(r)) {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-/=?^_`{|}~", r)
+}
+
+func isAlphaNum(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// isDomain checks `sub-domain *("." sub-domain)`, U-labels are permitted for SMTPUTF8.
+func isDomain(domain string) bool {
+	if len(domain) == 0 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if r >= utf8.RuneSelf {
+				if r == utf8.RuneError {
+					return false
+				}
+				continue
+			}
+			if !isAlphaNum(byte(r)) && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isAddressLiteral checks `"[" ( IPv4-address-literal / IPv6-address-literal / General-address-literal ) "]"`.
+func isAddressLiteral(literal string) bool {
+	if len(literal) < 3 || literal[0] != '[' || literal[len(literal)-1] != ']' {
+		return false
+	}
+	content := literal[1 : len(literal)-1]
+
+	if strings.HasPrefix(strings.ToUpper(content), "IPV6:") {
+		ip := net.ParseIP(content[5:])
+		return ip != nil && strings.Contains(content[5:], ":")
+	}
+	if ip := net.ParseIP(content); ip != nil {
+		return ip.To4() != nil && !strings.Contains(content, ":")
+	}
+
+	// General-address-literal = Standardized-tag ":" 1*dcontent
+	colon := strings.Index(content, ":")
+	if colon <= 0 || colon == len(content)-1 {
+		return false
+	}
+	tag := content[:colon]
+	if !isLdhStr(tag) {
+		return false
+	}
+	for i := colon + 1; i < len(content); i++ {
+		// dcontent = %d33-90 / %d94-126
+		if c := content[i]; c < 33 || c > 126 || (c >= 91 && c <= 93) {
+			return false
+		}
+	}
+	return true
+}
+
+func isLdhStr(str string) bool {
+	if len(str) == 0 || str[len(str)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(str); i++ {
+		if !isAlphaNum(str[i]) && str[i] != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseEsmtpParams parses `esmtp-param *(SP esmtp-param)`.
+func parseEsmtpParams(str string) ([]esmtpParam, error) {
+	params := make([]esmtpParam, 0)
+	for _, field := range strings.Fields(str) {
+		keyword, value, hasValue := strings.Cut(field, "=")
+
+		if !isEsmtpKeyword(keyword) || (hasValue && !isEsmtpValue(value)) {
+			return nil, fmt.Errorf("%w: %s", errInvalidParam, field)
+		}
+
+		params = append(params, esmtpParam{
+			Keyword:  strings.ToUpper(keyword),
+			Value:    value,
+			HasValue: hasValue,
+		})
+	}
+	return params, nil
+}
+
+// esmtp-keyword = (ALPHA / DIGIT) *(ALPHA / DIGIT / "-")
+func isEsmtpKeyword(str string) bool {
+	if len(str) == 0 || !isAlphaNum(str[0]) {
+		return false
+	}
+	for i := 1; i < len(str); i++ {
+		if !isAlphaNum(str[i]) && str[i] != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// esmtp-value = 1*(%d33-60 / %d62-126 / UTF8-non-ascii)
+func isEsmtpValue(str string) bool {
+	if len(str) == 0 || !utf8.ValidString(str) {
+		return false
+	}
+	for i := 0; i < len(str); i++ {
+		if c := str[i]; c < 33 || c == '=' || c == 127 {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeXtext decodes `xtext = *( xchar / hexchar )`.
+func decodeXtext(str string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		switch {
+		case c == '+':
+			// hexchar = "+" 2(%x30-39 / %x41-46)
+			if i+2 >= len(str) || !isUpperHex(str[i+1]) || !isUpperHex(str[i+2]) {
+				return "", fmt.Errorf("%w: invalid hexchar in xtext", errInvalidParam)
+			}
+			sb.WriteByte(unhex(str[i+1])<<4 | unhex(str[i+2]))
+			i += 2
+		case c >= 33 && c <= 126 && c != '=':
+			sb.WriteByte(c)
+		default:
+			return "", fmt.Errorf("%w: invalid character in xtext", errInvalidParam)
+		}
+	}
+	return sb.String(), nil
+}
+
+func isUpperHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	if c <= '9' {
+		return c - '0'
+	}
+	return c - 'A' + 10
+}
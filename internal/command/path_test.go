@@ -0,0 +1,313 @@
+package command
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/stretchr/testify/assert"
+)
+
+// handleArgument runs the handler with the argument as the connection handler does for a command line.
+func handleArgument(h CommandHandler, s *session.Session, arg string) error {
+	s.Argument = arg
+	return h.HandleCommand(context.TODO(), s, strings.Fields(arg))
+}
+
+func TestParseMailArgument(t *testing.T) {
+	tests := []struct {
+		name         string
+		arg          string
+		expectPath   string
+		expectParams []esmtpParam
+		expectErr    error
+	}{
+		{
+			name:       "mailbox",
+			arg:        "FROM:<from@example.com>",
+			expectPath: "from@example.com",
+		},
+		{
+			name:       "null reverse-path",
+			arg:        "FROM:<>",
+			expectPath: "",
+		},
+		{
+			name:       "lower case",
+			arg:        "from:<from@example.com>",
+			expectPath: "from@example.com",
+		},
+		{
+			name:       "space after colon",
+			arg:        "FROM: <from@example.com>",
+			expectPath: "from@example.com",
+		},
+		{
+			name:       "source route",
+			arg:        "FROM:<@a.example,@b.example:from@example.com>",
+			expectPath: "from@example.com",
+		},
+		{
+			name:       "dot-string",
+			arg:        "FROM:<first.last+tag@sub.example.com>",
+			expectPath: "first.last+tag@sub.example.com",
+		},
+		{
+			name:       "quoted local part",
+			arg:        `FROM:<"john \"doe\" @home"@example.com>`,
+			expectPath: `"john \"doe\" @home"@example.com`,
+		},
+		{
+			name:       "quoted local part with '>'",
+			arg:        `FROM:<"a>b"@example.com>`,
+			expectPath: `"a>b"@example.com`,
+		},
+		{
+			name:         "whitespace in quoted local part",
+			arg:          `FROM:<"john  doe"@example.com> SIZE=100`,
+			expectPath:   `"john  doe"@example.com`,
+			expectParams: []esmtpParam{{Keyword: "SIZE", Value: "100", HasValue: true}},
+		},
+		{
+			name:       "IPv4 address literal",
+			arg:        "FROM:<from@[192.0.2.1]>",
+			expectPath: "from@[192.0.2.1]",
+		},
+		{
+			name:       "IPv6 address literal",
+			arg:        "FROM:<from@[IPv6:2001:db8::1]>",
+			expectPath: "from@[IPv6:2001:db8::1]",
+		},
+		{
+			name:       "general address literal",
+			arg:        "FROM:<from@[x-tag:content]>",
+			expectPath: "from@[x-tag:content]",
+		},
+		{
+			name:       "UTF-8 mailbox",
+			arg:        "FROM:<用户@例子.广告>",
+			expectPath: "用户@例子.广告",
+		},
+		{
+			name:       "parameters",
+			arg:        "FROM:<from@example.com> SIZE=100 BODY=8BITMIME SMTPUTF8",
+			expectPath: "from@example.com",
+			expectParams: []esmtpParam{
+				{Keyword: "SIZE", Value: "100", HasValue: true},
+				{Keyword: "BODY", Value: "8BITMIME", HasValue: true},
+				{Keyword: "SMTPUTF8"},
+			},
+		},
+		{
+			name:       "lower case keyword",
+			arg:        "FROM:<from@example.com> size=100",
+			expectPath: "from@example.com",
+			expectParams: []esmtpParam{
+				{Keyword: "SIZE", Value: "100", HasValue: true},
+			},
+		},
+		{
+			name:      "prefix not found",
+			arg:       "<from@example.com>",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "angle brackets not found",
+			arg:       "FROM:from@example.com",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "display name",
+			arg:       "FROM:Sender <from@example.com>",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "close bracket not found",
+			arg:       "FROM:<from@example.com",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "domain not found",
+			arg:       "FROM:<from>",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "empty local part",
+			arg:       "FROM:<@example.com>",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "consecutive dots",
+			arg:       "FROM:<first..last@example.com>",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "invalid character in local part",
+			arg:       "FROM:<fr(om@example.com>",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "unterminated quoted-string",
+			arg:       `FROM:<"from@example.com>`,
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "invalid domain",
+			arg:       "FROM:<from@-example.com>",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "empty domain label",
+			arg:       "FROM:<from@example..com>",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "invalid IPv4 address literal",
+			arg:       "FROM:<from@[192.0.2.256]>",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "invalid IPv6 address literal",
+			arg:       "FROM:<from@[IPv6:2001:db8::g]>",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "invalid source route",
+			arg:       "FROM:<@a.example,b.example:from@example.com>",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "local part too long",
+			arg:       "FROM:<" + strings.Repeat("a", maxLocalPartLength+1) + "@example.com>",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "no space before parameters",
+			arg:       "FROM:<from@example.com>SIZE=100",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "empty parameter value",
+			arg:       "FROM:<from@example.com> SIZE=",
+			expectErr: errInvalidParam,
+		},
+		{
+			name:      "invalid parameter keyword",
+			arg:       "FROM:<from@example.com> -SIZE=100",
+			expectErr: errInvalidParam,
+		},
+		{
+			name:      "'=' in parameter value",
+			arg:       "FROM:<from@example.com> SIZE=1=0",
+			expectErr: errInvalidParam,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, params, err := parseMailArgument(test.arg)
+			if test.expectErr != nil {
+				assert.ErrorIs(t, err, test.expectErr)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expectPath, path)
+			if test.expectParams == nil {
+				assert.Empty(t, params)
+			} else {
+				assert.Equal(t, test.expectParams, params)
+			}
+		})
+	}
+}
+
+func TestParseRcptArgument(t *testing.T) {
+	tests := []struct {
+		name       string
+		arg        string
+		expectPath string
+		expectErr  error
+	}{
+		{
+			name:       "mailbox",
+			arg:        "TO:<to@example.com>",
+			expectPath: "to@example.com",
+		},
+		{
+			name:       "postmaster",
+			arg:        "TO:<Postmaster>",
+			expectPath: "Postmaster",
+		},
+		{
+			name:       "mixed case",
+			arg:        "To: <to@example.com>",
+			expectPath: "to@example.com",
+		},
+		{
+			name:      "null forward-path",
+			arg:       "TO:<>",
+			expectErr: errInvalidPath,
+		},
+		{
+			name:      "FROM prefix",
+			arg:       "FROM:<to@example.com>",
+			expectErr: errInvalidPath,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, _, err := parseRcptArgument(test.arg)
+			if test.expectErr != nil {
+				assert.ErrorIs(t, err, test.expectErr)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expectPath, path)
+		})
+	}
+}
+
+func TestEsmtpParam_XtextValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		expect    string
+		expectErr bool
+	}{
+		{
+			name:   "plain",
+			value:  "rfc822;to@example.com",
+			expect: "rfc822;to@example.com",
+		},
+		{
+			name:   "hexchar",
+			value:  "a+2Bb+3Dc",
+			expect: "a+b=c",
+		},
+		{
+			name:      "lower case hexchar",
+			value:     "a+2b",
+			expectErr: true,
+		},
+		{
+			name:      "truncated hexchar",
+			value:     "a+2",
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			param := esmtpParam{Keyword: "ORCPT", Value: test.value, HasValue: true}
+			value, err := param.XtextValue()
+			if test.expectErr {
+				assert.ErrorIs(t, err, errInvalidParam)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expect, value)
+		})
+	}
+}
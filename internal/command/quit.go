@@ -18,11 +18,11 @@ func (h *quitHandler) Command() string {
 func (h *quitHandler) HandleCommand(ctx context.Context, s *session.Session, arg []string) error {
 	// QUIT is not permit parameters
 	if len(arg) > 0 {
-		s.Response(CodeSyntaxError, MsgSyntaxError)
+		s.Reply(ReplySyntaxError)
 		return nil
 	}
 
-	s.Response(CodeQuit, MsgQuit)
+	s.Reply(ReplyQuit)
 	s.ShouldClose = true
 	return nil
 }
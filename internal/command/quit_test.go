@@ -24,7 +24,7 @@ func TestQuit(t *testing.T) {
 	target := NewQuitHandler(log)
 
 	s := session.NewMockSession(ctrl)
-	s.ExpectResponse(CodeQuit, MsgQuit)
+	s.ExpectReply(ReplyQuit)
 
 	target.HandleCommand(context.TODO(), s.Session, make([]string, 0))
 	assert.True(t, s.Session.ShouldClose)
@@ -37,16 +37,14 @@ func TestQuit_Err(t *testing.T) {
 	log := mock.NewInitializedMockLogger(ctrl)
 
 	tests := []struct {
-		name string
-		arg  []string
-		code int
-		msg  string
+		name  string
+		arg   []string
+		reply session.Reply
 	}{
 		{
-			name: "with parameter",
-			arg:  []string{"hoge"},
-			code: CodeSyntaxError,
-			msg:  MsgSyntaxError,
+			name:  "with parameter",
+			arg:   []string{"hoge"},
+			reply: ReplySyntaxError,
 		},
 	}
 
@@ -54,7 +52,7 @@ func TestQuit_Err(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			s := session.NewMockSession(ctrl)
 
-			s.ExpectResponse(test.code, test.msg)
+			s.ExpectReply(test.reply)
 
 			target := NewQuitHandler(log)
 			target.HandleCommand(context.TODO(), s.Session, test.arg)
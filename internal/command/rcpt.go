@@ -2,15 +2,25 @@ package command
 
 import (
 	"context"
+	"errors"
 	"net/mail"
 	"strings"
 
 	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/service"
 	"github.com/Haya372/smtp-server/internal/session"
 )
 
 type rcptHandler struct {
-	log hlog.Logger
+	log       hlog.Logger
+	conf      *config.SmtpConfig
+	recipient service.RecipientService
+	srs       service.SrsService
+	rateLimit service.RateLimitService
+	greylist  service.GreylistService
+	milter    service.MilterService
 }
 
 func (h *rcptHandler) Command() string {
@@ -20,35 +30,148 @@ func (h *rcptHandler) Command() string {
 func (h *rcptHandler) HandleCommand(ctx context.Context, s *session.Session, arg []string) error {
 	// mail command should be called
 	if s.EnvelopeFrom == nil {
-		s.Response(CodeBadSequence, MsgBadSequence)
+		s.Reply(ReplyBadSequence)
 		return nil
 	}
 
 	if len(arg) == 0 {
-		s.Response(CodeSyntaxError, MsgSyntaxError)
+		s.Reply(ReplyArgumentSyntaxError)
 		return nil
 	}
 
-	addr := strings.Replace(arg[0], "to:", "", 1)
-	addr = strings.Replace(addr, "TO:", "", 1)
+	// https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-5-3-1-10--Recipients-Buffer
+	if h.exceedsMaxRecipients(len(s.EnvelopeTo) + 1) {
+		s.Reply(ReplyTooManyRecipients)
+		return nil
+	}
+
+	mailbox, params, err := parseRcptArgument(s.Argument)
+	if err != nil {
+		h.log.WithError(err).Debugf("[%s] failed to parse argument %s", s.Id, s.Argument)
+		if errors.Is(err, errInvalidParam) {
+			s.Reply(ReplyArgumentSyntaxError)
+		} else {
+			s.Reply(ReplyBadDestSyntax)
+		}
+		return nil
+	}
+
+	// no RCPT parameters are implemented
+	if len(params) > 0 {
+		h.log.Errorf("[%s] failed to handle option %s", s.Id, params[0].Keyword)
+		s.Reply(ReplyOptionParamNotRecognized)
+		return nil
+	}
+
+	// UTF-8 recipients are permitted only when SMTPUTF8 is received by MAIL, IDN domains included
+	if !s.SmtpUtf8 && !isASCII(mailbox) {
+		s.Reply(ReplyNonAsciiAddress)
+		return nil
+	}
+
+	address := &mail.Address{Address: mailbox}
+	if err := normalizeAddress(address); err != nil {
+		h.log.WithError(err).Debugf("[%s] failed to normalize address %s", s.Id, mailbox)
+		s.Reply(ReplyBadDestSyntax)
+		return nil
+	}
 
-	// TODO: rcpt コマンドのオプションチェック(ESMTP)
+	if !h.rateLimit.AllowRecipient(s) {
+		h.log.Warnf("[%s] recipient rate limit exceeded.", s.Id)
+		s.Reply(ReplyRecipientRate)
+		return nil
+	}
 
-	address, err := mail.ParseAddress(addr)
+	res, err := h.recipient.Resolve(ctx, *address, len(s.AuthUser) > 0)
 	if err != nil {
-		h.log.WithError(err).Debugf("[%s] failed to parse address %s", s.Id, arg[0])
-		s.Response(CodeSyntaxError, MsgSyntaxError)
+		h.log.WithError(err).Errorf("[%s] failed to resolve recipient %s", s.Id, address.Address)
+		s.Reply(ReplyLocalError)
+		return nil
+	}
+	switch res.Status {
+	case data.RecipientUnknownUser:
+		s.Reply(ReplyUnknownUser)
+		return nil
+	case data.RecipientRelayDenied:
+		h.log.Infof("[%s] relay to %s denied", s.Id, address.Address)
+		s.Reply(ReplyRelayDenied)
+		return nil
+	case data.RecipientLoop:
+		s.Reply(ReplyRoutingLoop)
+		return nil
+	}
+
+	// limit applies to the recipients expanded by aliases
+	added := 0
+	for _, expanded := range res.Addresses {
+		if !s.HasEnvelopeTo(expanded) {
+			added++
+		}
+	}
+	if h.exceedsMaxRecipients(len(s.EnvelopeTo) + added) {
+		h.log.Infof("[%s] too many recipients by %s.", s.Id, address.Address)
+		s.Reply(ReplyTooManyRecipients)
+		return nil
+	}
+
+	// greylisting is applied only to acceptable recipients, errors of the store do not block mail
+	if ok, err := h.greylist.Check(ctx, s, *address); err != nil {
+		h.log.WithError(err).Errorf("[%s] failed to check greylist for %s", s.Id, address.Address)
+	} else if !ok {
+		h.log.Infof("[%s] greylisted %s", s.Id, address.Address)
+		s.Reply(ReplyGreylisted)
 		return nil
 	}
 
-	s.AddEnvelopeTo(*address)
+	if rejectByMilter(s, h.milter.Rcpt(ctx, s, *address, esmtpArgs(params))) {
+		return nil
+	}
+
+	if res.Forwarded && s.ForwardFrom == nil {
+		if err := h.rewriteForwardSender(s); err != nil {
+			h.log.WithError(err).Errorf("[%s] failed to rewrite sender %s", s.Id, s.EnvelopeFrom.Address)
+			s.Reply(ReplyLocalError)
+			return nil
+		}
+	}
+
+	// recipient is expanded by aliases, the expanded addresses are delivered
+	for _, expanded := range res.Addresses {
+		s.AddEnvelopeTo(expanded)
+	}
+
+	s.Reply(ReplyRecipientOk)
+	return nil
+}
+
+func (h *rcptHandler) exceedsMaxRecipients(count int) bool {
+	return h.conf.MaxRecipients > 0 && count > h.conf.MaxRecipients
+}
 
-	s.Response(CodeOk, MsgOk)
+// rewriteForwardSender rewrites the sender by SRS so that the forwarded message passes SPF check of the destination.
+// Null sender and senders of the local domains are not rewritten.
+func (h *rcptHandler) rewriteForwardSender(s *session.Session) error {
+	at := strings.LastIndex(s.EnvelopeFrom.Address, "@")
+	if at < 0 || h.recipient.IsLocalDomain(s.EnvelopeFrom.Address[at+1:]) {
+		return nil
+	}
+
+	from, err := h.srs.Forward(*s.EnvelopeFrom)
+	if err != nil {
+		return err
+	}
+	s.ForwardFrom = &from
 	return nil
 }
 
-func NewRcptHandler(log hlog.Logger) CommandHandler {
+func NewRcptHandler(log hlog.Logger, conf *config.SmtpConfig, recipient service.RecipientService, srs service.SrsService, rateLimit service.RateLimitService, greylist service.GreylistService, milter service.MilterService) CommandHandler {
 	return &rcptHandler{
-		log: log,
+		log:       log,
+		conf:      conf,
+		recipient: recipient,
+		srs:       srs,
+		rateLimit: rateLimit,
+		greylist:  greylist,
+		milter:    milter,
 	}
 }
@@ -2,9 +2,12 @@ package command
 
 import (
 	"context"
+	"errors"
 	"net/mail"
 	"testing"
 
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
 	"github.com/Haya372/smtp-server/internal/mock"
 	"github.com/Haya372/smtp-server/internal/session"
 	"github.com/golang/mock/gomock"
@@ -12,7 +15,7 @@ import (
 )
 
 func TestRcpt_Command(t *testing.T) {
-	target := NewRcptHandler(nil)
+	target := NewRcptHandler(nil, nil, nil, nil, nil, nil, nil)
 	assert.Equal(t, RCPT, target.Command())
 }
 
@@ -24,29 +27,49 @@ func TestRcpt_Err(t *testing.T) {
 
 	tests := []struct {
 		name         string
-		arg          []string
+		arg          string
 		envelopeFrom string
-		code         int
-		msg          string
+		reply        session.Reply
 	}{
 		{
-			name: "mail not called",
-			arg:  []string{"to:<to@example.com>"},
-			code: CodeBadSequence,
-			msg:  MsgBadSequence,
+			name:  "mail not called",
+			arg:   "to:<to@example.com>",
+			reply: ReplyBadSequence,
 		},
 		{
 			name:         "argument is empty",
 			envelopeFrom: "from@example.com",
-			code:         CodeSyntaxError,
-			msg:          MsgSyntaxError,
+			reply:        ReplyArgumentSyntaxError,
+		},
+		{
+			name:         "UTF-8 address without SMTPUTF8",
+			envelopeFrom: "from@example.com",
+			arg:          "to:<josé@example.com>",
+			reply:        ReplyNonAsciiAddress,
+		},
+		{
+			name:         "IDN domain without SMTPUTF8",
+			envelopeFrom: "from@example.com",
+			arg:          "to:<to@exämple.com>",
+			reply:        ReplyNonAsciiAddress,
 		},
 		{
 			name:         "invalid to address",
 			envelopeFrom: "from@example.com",
-			arg:          []string{"to:to@example.com>"},
-			code:         CodeSyntaxError,
-			msg:          MsgSyntaxError,
+			arg:          "to:to@example.com>",
+			reply:        ReplyBadDestSyntax,
+		},
+		{
+			name:         "null forward-path",
+			envelopeFrom: "from@example.com",
+			arg:          "to:<>",
+			reply:        ReplyBadDestSyntax,
+		},
+		{
+			name:         "unknown option",
+			envelopeFrom: "from@example.com",
+			arg:          "to:<to@example.com> NOTIFY=NEVER",
+			reply:        ReplyOptionParamNotRecognized,
 		},
 	}
 
@@ -57,10 +80,10 @@ func TestRcpt_Err(t *testing.T) {
 				s.Session.EnvelopeFrom = &mail.Address{Address: test.envelopeFrom}
 			}
 
-			s.ExpectResponse(test.code, test.msg)
+			s.ExpectReply(test.reply)
 
-			target := NewRcptHandler(log)
-			target.HandleCommand(context.TODO(), s.Session, test.arg)
+			target := NewRcptHandler(log, &config.SmtpConfig{}, nil, nil, mock.NewInitializedMockRateLimitService(ctrl), mock.NewInitializedMockGreylistService(ctrl), mock.NewInitializedMockMilterService(ctrl))
+			handleArgument(target, s.Session, test.arg)
 		})
 	}
 }
@@ -73,13 +96,269 @@ func TestRcpt(t *testing.T) {
 
 	tests := []struct {
 		name               string
-		arg                []string
+		arg                string
+		smtpUtf8           bool
 		expectedEnvelopeTo string
 	}{
 		{
 			name:               "no param",
-			arg:                []string{"to:<to@example.com>"},
-			expectedEnvelopeTo: "<to@example.com>",
+			arg:                "to:<to@example.com>",
+			expectedEnvelopeTo: "to@example.com",
+		},
+		{
+			name:               "space after colon",
+			arg:                "TO: <to@example.com>",
+			expectedEnvelopeTo: "to@example.com",
+		},
+		{
+			name:               "quoted local part",
+			arg:                `to:<"john doe"@example.com>`,
+			expectedEnvelopeTo: `"john doe"@example.com`,
+		},
+		{
+			name:               "whitespace in quoted local part",
+			arg:                `to:<"john  doe"@example.com>`,
+			expectedEnvelopeTo: `"john  doe"@example.com`,
+		},
+		{
+			name:               "UTF-8 address with SMTPUTF8",
+			arg:                "to:<josé@exämple.com>",
+			smtpUtf8:           true,
+			expectedEnvelopeTo: "josé@xn--exmple-cua.com",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			s.Session.EnvelopeFrom = &mail.Address{Address: "from@example.com"}
+			s.Session.SmtpUtf8 = test.smtpUtf8
+
+			s.ExpectReply(ReplyRecipientOk)
+
+			recipient := mock.NewMockRecipientService(ctrl)
+			recipient.EXPECT().Resolve(gomock.Any(), gomock.Any(), false).DoAndReturn(
+				func(ctx context.Context, address mail.Address, authenticated bool) (*data.RecipientResult, error) {
+					return &data.RecipientResult{Status: data.RecipientAccepted, Addresses: []mail.Address{address}}, nil
+				},
+			)
+
+			target := NewRcptHandler(log, &config.SmtpConfig{}, recipient, nil, mock.NewInitializedMockRateLimitService(ctrl), mock.NewInitializedMockGreylistService(ctrl), mock.NewInitializedMockMilterService(ctrl))
+			handleArgument(target, s.Session, test.arg)
+
+			assert.Contains(t, s.Session.EnvelopeTo, mail.Address{Address: test.expectedEnvelopeTo})
+		})
+	}
+}
+
+func TestRcpt_Resolve(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	tests := []struct {
+		name             string
+		authUser         string
+		result           *data.RecipientResult
+		err              error
+		reply            session.Reply
+		expectEnvelopeTo []mail.Address
+	}{
+		{
+			name: "accepted",
+			result: &data.RecipientResult{
+				Status:    data.RecipientAccepted,
+				Addresses: []mail.Address{{Address: "to@example.com"}},
+			},
+			reply:            ReplyRecipientOk,
+			expectEnvelopeTo: []mail.Address{{Address: "to@example.com"}},
+		},
+		{
+			name:     "relay by authenticated client",
+			authUser: "user",
+			result: &data.RecipientResult{
+				Status:    data.RecipientAccepted,
+				Addresses: []mail.Address{{Address: "to@example.com"}},
+			},
+			reply:            ReplyRecipientOk,
+			expectEnvelopeTo: []mail.Address{{Address: "to@example.com"}},
+		},
+		{
+			name: "expanded by alias",
+			result: &data.RecipientResult{
+				Status:    data.RecipientAccepted,
+				Addresses: []mail.Address{{Address: "a@example.com"}, {Address: "b@example.org"}},
+			},
+			reply:            ReplyRecipientOk,
+			expectEnvelopeTo: []mail.Address{{Address: "a@example.com"}, {Address: "b@example.org"}},
+		},
+		{
+			name:   "unknown user",
+			result: &data.RecipientResult{Status: data.RecipientUnknownUser},
+			reply:  ReplyUnknownUser,
+		},
+		{
+			name:   "relay denied",
+			result: &data.RecipientResult{Status: data.RecipientRelayDenied},
+			reply:  ReplyRelayDenied,
+		},
+		{
+			name:   "alias loop",
+			result: &data.RecipientResult{Status: data.RecipientLoop},
+			reply:  ReplyRoutingLoop,
+		},
+		{
+			name:  "lookup error",
+			err:   errors.New("test error"),
+			reply: ReplyLocalError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			s.Session.EnvelopeFrom = &mail.Address{Address: "from@example.com"}
+			s.Session.AuthUser = test.authUser
+			s.ExpectReply(test.reply)
+
+			recipient := mock.NewMockRecipientService(ctrl)
+			recipient.EXPECT().
+				Resolve(gomock.Any(), mail.Address{Address: "to@example.com"}, len(test.authUser) > 0).
+				Return(test.result, test.err)
+
+			target := NewRcptHandler(log, &config.SmtpConfig{}, recipient, nil, mock.NewInitializedMockRateLimitService(ctrl), mock.NewInitializedMockGreylistService(ctrl), mock.NewInitializedMockMilterService(ctrl))
+			handleArgument(target, s.Session, "to:<to@example.com>")
+			if len(test.expectEnvelopeTo) > 0 {
+				assert.Equal(t, test.expectEnvelopeTo, s.Session.EnvelopeTo)
+			} else {
+				assert.Empty(t, s.Session.EnvelopeTo)
+			}
+		})
+	}
+}
+
+func TestRcpt_Forward(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	tests := []struct {
+		name              string
+		envelopeFrom      string
+		forwardFrom       *mail.Address
+		localSender       bool
+		rewrite           bool
+		srsErr            error
+		reply             session.Reply
+		expectForwardFrom *mail.Address
+	}{
+		{
+			name:              "sender rewritten",
+			envelopeFrom:      "from@example.org",
+			rewrite:           true,
+			reply:             ReplyRecipientOk,
+			expectForwardFrom: &mail.Address{Address: "SRS0=hash=TT=example.org=from@example.com"},
+		},
+		{
+			name:         "local sender",
+			envelopeFrom: "from@example.com",
+			localSender:  true,
+			reply:        ReplyRecipientOk,
+		},
+		{
+			name:  "null sender",
+			reply: ReplyRecipientOk,
+		},
+		{
+			name:              "already rewritten",
+			envelopeFrom:      "from@example.org",
+			forwardFrom:       &mail.Address{Address: "SRS0=prev@example.com"},
+			reply:             ReplyRecipientOk,
+			expectForwardFrom: &mail.Address{Address: "SRS0=prev@example.com"},
+		},
+		{
+			name:         "rewrite error",
+			envelopeFrom: "from@example.org",
+			rewrite:      true,
+			srsErr:       errors.New("test error"),
+			reply:        ReplyLocalError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			s.Session.EnvelopeFrom = &mail.Address{Address: test.envelopeFrom}
+			s.Session.ForwardFrom = test.forwardFrom
+			s.ExpectReply(test.reply)
+
+			recipient := mock.NewMockRecipientService(ctrl)
+			recipient.EXPECT().Resolve(gomock.Any(), gomock.Any(), false).Return(&data.RecipientResult{
+				Status:    data.RecipientAccepted,
+				Addresses: []mail.Address{{Address: "forward@example.net"}},
+				Forwarded: true,
+			}, nil)
+			if len(test.envelopeFrom) > 0 && test.forwardFrom == nil {
+				recipient.EXPECT().IsLocalDomain(gomock.Any()).Return(test.localSender)
+			}
+
+			srs := mock.NewMockSrsService(ctrl)
+			if test.rewrite {
+				srs.EXPECT().Forward(mail.Address{Address: test.envelopeFrom}).
+					Return(mail.Address{Address: "SRS0=hash=TT=example.org=from@example.com"}, test.srsErr)
+			}
+
+			target := NewRcptHandler(log, &config.SmtpConfig{}, recipient, srs, mock.NewInitializedMockRateLimitService(ctrl), mock.NewInitializedMockGreylistService(ctrl), mock.NewInitializedMockMilterService(ctrl))
+			handleArgument(target, s.Session, "to:<alias@example.com>")
+			assert.Equal(t, test.expectForwardFrom, s.Session.ForwardFrom)
+		})
+	}
+}
+
+func TestRcpt_MaxRecipients(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+	conf := &config.SmtpConfig{
+		MaxRecipients: 3,
+	}
+
+	tests := []struct {
+		name             string
+		envelopeTo       []mail.Address
+		resolved         []mail.Address
+		reply            session.Reply
+		expectEnvelopeTo int
+	}{
+		{
+			name:             "within limit",
+			envelopeTo:       []mail.Address{{Address: "a@example.com"}, {Address: "b@example.com"}},
+			resolved:         []mail.Address{{Address: "c@example.com"}},
+			reply:            ReplyRecipientOk,
+			expectEnvelopeTo: 3,
+		},
+		{
+			name:             "limit reached",
+			envelopeTo:       []mail.Address{{Address: "a@example.com"}, {Address: "b@example.com"}, {Address: "c@example.com"}},
+			reply:            ReplyTooManyRecipients,
+			expectEnvelopeTo: 3,
+		},
+		{
+			name:             "expanded recipients exceed limit",
+			envelopeTo:       []mail.Address{{Address: "a@example.com"}, {Address: "b@example.com"}},
+			resolved:         []mail.Address{{Address: "c@example.com"}, {Address: "d@example.com"}},
+			reply:            ReplyTooManyRecipients,
+			expectEnvelopeTo: 2,
+		},
+		{
+			name:             "duplicated recipients are not counted",
+			envelopeTo:       []mail.Address{{Address: "a@example.com"}, {Address: "b@example.com"}},
+			resolved:         []mail.Address{{Address: "A@example.com"}, {Address: "c@example.com"}},
+			reply:            ReplyRecipientOk,
+			expectEnvelopeTo: 3,
 		},
 	}
 
@@ -87,14 +366,93 @@ func TestRcpt(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			s := session.NewMockSession(ctrl)
 			s.Session.EnvelopeFrom = &mail.Address{Address: "from@example.com"}
+			s.Session.EnvelopeTo = test.envelopeTo
+			s.ExpectReply(test.reply)
+
+			recipient := mock.NewMockRecipientService(ctrl)
+			if test.resolved != nil {
+				recipient.EXPECT().Resolve(gomock.Any(), gomock.Any(), false).Return(&data.RecipientResult{
+					Status:    data.RecipientAccepted,
+					Addresses: test.resolved,
+				}, nil)
+			}
 
-			s.ExpectResponse(CodeOk, MsgOk)
+			target := NewRcptHandler(log, conf, recipient, nil, mock.NewInitializedMockRateLimitService(ctrl), mock.NewInitializedMockGreylistService(ctrl), mock.NewInitializedMockMilterService(ctrl))
+			handleArgument(target, s.Session, "to:<alias@example.com>")
+			assert.Len(t, s.Session.EnvelopeTo, test.expectEnvelopeTo)
+		})
+	}
+}
+
+func TestRcpt_RateLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	s := session.NewMockSession(ctrl)
+	s.Session.EnvelopeFrom = &mail.Address{Address: "from@example.com"}
+	s.ExpectReply(ReplyRecipientRate)
+
+	rateLimit := mock.NewMockRateLimitService(ctrl)
+	rateLimit.EXPECT().AllowRecipient(s.Session).Return(false)
+
+	// the recipient is not resolved
+	target := NewRcptHandler(log, &config.SmtpConfig{}, mock.NewMockRecipientService(ctrl), nil, rateLimit, nil, mock.NewInitializedMockMilterService(ctrl))
+	handleArgument(target, s.Session, "to:<to@example.com>")
+	assert.Empty(t, s.Session.EnvelopeTo)
+}
+
+func TestRcpt_Greylist(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	tests := []struct {
+		name             string
+		allowed          bool
+		err              error
+		reply            session.Reply
+		expectEnvelopeTo int
+	}{
+		{
+			name:             "accepted",
+			allowed:          true,
+			reply:            ReplyRecipientOk,
+			expectEnvelopeTo: 1,
+		},
+		{
+			name:             "greylisted",
+			allowed:          false,
+			reply:            ReplyGreylisted,
+			expectEnvelopeTo: 0,
+		},
+		{
+			name:             "store error is ignored",
+			err:              errors.New("test error"),
+			reply:            ReplyRecipientOk,
+			expectEnvelopeTo: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			s.Session.EnvelopeFrom = &mail.Address{Address: "from@example.com"}
+			s.ExpectReply(test.reply)
 
-			target := NewRcptHandler(log)
-			target.HandleCommand(context.TODO(), s.Session, test.arg)
+			recipient := mock.NewMockRecipientService(ctrl)
+			recipient.EXPECT().Resolve(gomock.Any(), gomock.Any(), false).Return(&data.RecipientResult{
+				Status:    data.RecipientAccepted,
+				Addresses: []mail.Address{{Address: "user@example.com"}},
+			}, nil)
+			greylist := mock.NewMockGreylistService(ctrl)
+			greylist.EXPECT().Check(gomock.Any(), s.Session, mail.Address{Address: "alias@example.com"}).Return(test.allowed, test.err)
 
-			expect, _ := mail.ParseAddress(test.expectedEnvelopeTo)
-			assert.Contains(t, s.Session.EnvelopeTo, *expect)
+			target := NewRcptHandler(log, &config.SmtpConfig{}, recipient, nil, mock.NewInitializedMockRateLimitService(ctrl), greylist, mock.NewInitializedMockMilterService(ctrl))
+			handleArgument(target, s.Session, "to:<alias@example.com>")
+			assert.Len(t, s.Session.EnvelopeTo, test.expectEnvelopeTo)
 		})
 	}
 }
@@ -0,0 +1,43 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+// addReceivedHeader prepends the trace information of this server to the accepted message.
+// https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-4--Trace-Information
+func addReceivedHeader(s *session.Session) {
+	hostname, _ := os.Hostname()
+	field := fmt.Sprintf("Received: from %s by %s with %s id %s", s.ReceivedFrom(), hostname, receivedProtocol(s), s.Id)
+	// recipients are not disclosed to each other
+	if len(s.EnvelopeTo) == 1 {
+		field += fmt.Sprintf(" for <%s>", s.EnvelopeTo[0].Address)
+	}
+	field += "; " + time.Now().Format(time.RFC1123Z)
+	s.RawData = data.PrependHeaders(s.RawData, field)
+}
+
+// receivedProtocol returns the protocol type of "with" clause.
+// https://tex2e.github.io/rfc-translater/html/rfc3848.html
+// https://tex2e.github.io/rfc-translater/html/rfc6531.html#3-7-3--The-Received-Header-Field
+func receivedProtocol(s *session.Session) string {
+	if !s.Esmtp {
+		return "SMTP"
+	}
+	protocol := "ESMTP"
+	if s.SmtpUtf8 {
+		protocol = "UTF8SMTP"
+	}
+	if s.IsTls() {
+		protocol += "S"
+	}
+	if len(s.AuthUser) > 0 {
+		protocol += "A"
+	}
+	return protocol
+}
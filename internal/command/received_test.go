@@ -0,0 +1,68 @@
+package command
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddReceivedHeader(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tests := []struct {
+		name   string
+		setup  func(s *session.Session)
+		expect []string
+	}{
+		{
+			name: "HELO",
+			setup: func(s *session.Session) {
+				s.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+			},
+			expect: []string{"Received: from client.example.net ([unknown]) by ", " with SMTP id ", " for <to@example.com>; "},
+		},
+		{
+			name: "EHLO with SMTPUTF8 to recipients",
+			setup: func(s *session.Session) {
+				s.Esmtp = true
+				s.SmtpUtf8 = true
+				s.ClientHostname = "ptr.example.net"
+				s.EnvelopeTo = []mail.Address{{Address: "a@example.com"}, {Address: "b@example.com"}}
+			},
+			expect: []string{"Received: from client.example.net (ptr.example.net [unknown]) by ", " with UTF8SMTP id "},
+		},
+		{
+			name: "authenticated",
+			setup: func(s *session.Session) {
+				s.Esmtp = true
+				s.AuthUser = "user"
+			},
+			expect: []string{" with ESMTPA id "},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl).Session
+			s.SenderDomain = "client.example.net"
+			s.RawData = []byte("Subject: test\n\nbody\n")
+			test.setup(s)
+
+			addReceivedHeader(s)
+			header, rest, ok := strings.Cut(string(s.RawData), "\n")
+			assert.True(t, ok)
+			assert.Equal(t, "Subject: test\n\nbody\n", rest)
+			for _, expect := range test.expect {
+				assert.Contains(t, header, expect)
+			}
+			if len(s.EnvelopeTo) != 1 {
+				assert.NotContains(t, header, " for ")
+			}
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package command
 
+import "github.com/Haya372/smtp-server/internal/session"
+
 // https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-2--SMTP-Replies
 const (
 	// 正常系
@@ -12,6 +14,9 @@ const (
 
 	// Temporary Error
 	CodeServiceNotAvailable = 421
+	CodeActionNotTaken      = 450
+	CodeLocalError          = 451
+	CodeInsufficientStorage = 452
 
 	// Permanent Error
 	CodeSyntaxError                = 500
@@ -19,7 +24,9 @@ const (
 	CodeCommandNotImplemented      = 502
 	CodeBadSequence                = 503
 	CodeCommandParamNotImplemented = 504
+	CodeMailboxUnavailable         = 550
 	CodeAborted                    = 552
+	CodeMailboxNameNotAllowed      = 553
 	CodeTransactionFail            = 554
 	CodeOptionParamNotRecognized   = 555
 )
@@ -32,20 +39,156 @@ const (
 	MsgOk      = "OK"
 	MsgGoAhead = "Go ahead"
 
-	MsgStartInput = "Start mail input; end with <CRLF>.<CRLF>"
+	MsgStartInput    = "Start mail input; end with <CRLF>.<CRLF>"
+	MsgChunkReceived = "%d octets received"
 
 	// Temporary Error
 	MsgServiceNotAvailable = "Service not available, closing transmission channel"
+	MsgLocalError          = "Requested action aborted: local error in processing"
+	MsgTooManyRecipients   = "Too many recipients"
+	MsgTooManyMessages     = "Too many messages in this connection, closing transmission channel"
+	MsgTooManyErrors       = "Too many invalid commands, closing transmission channel"
+	MsgTimeout             = "Timeout exceeded, closing transmission channel"
+	MsgShuttingDown        = "Service shutting down, closing transmission channel"
+	MsgTooManyConnections  = "Too many connections, try again later"
+	MsgConnectionRate      = "Connection rate limit exceeded, try again later"
+	MsgMessageRate         = "Message rate limit exceeded, try again later"
+	MsgRecipientRate       = "Recipient rate limit exceeded, try again later"
+	MsgGreylisted          = "Greylisted, try again later"
+	MsgUnknownClient       = "Client host rejected: cannot find your hostname"
+	MsgMilterTempFail      = "Temporarily rejected by content filter, try again later"
+	MsgSpamTempFail        = "Spam scanner unavailable, try again later"
+	MsgVirusTempFail       = "Virus scanner unavailable, try again later"
+	MsgDeliveryTempFail    = "Delivery failed, try again later"
 
 	// Permanent Error
 	MsgSyntaxError                = "Syntax error, command unrecognized"
 	MsgArgumentSyntaxError        = "Syntax error in parameters or arguments"
 	MsgBadSequence                = "Bad sequence of commands"
+	MsgBinaryMimeRequiresBdat     = "BINARYMIME requires BDAT"
 	MsgAlreadyTls                 = "TLS connection had already started"
 	MsgCommandParamNotImplemented = "Command parameter not implemented"
 	MsgCommandNotImplemented      = "Command not implemented"
 	MsgGreetFail                  = "No SMTP service here"
 	MsgAborted                    = "Requested mail action aborted"
 	MsgTransactionFail            = "Transaction failed"
-	MsgOptionParamNotRecognized   = "Message size exceeds limit"
+	MsgOptionParamNotRecognized   = "Parameters not recognized or not implemented"
+	MsgIllegalPipelining          = "Improper use of SMTP command pipelining"
+	MsgNonAsciiAddress            = "Non-ASCII addresses are not permitted without SMTPUTF8"
+	Msg8BitNotPermitted           = "8-bit data is not permitted without BODY=8BITMIME"
+	MsgUnknownUser                = "Requested action not taken: mailbox unavailable"
+	MsgRelayDenied                = "Relay access denied"
+	MsgRoutingLoop                = "Routing loop detected"
+	MsgDnsblListed                = "Service unavailable; client [%s] blocked using %s"
+	MsgInvalidHelo                = "Helo command rejected: need fully-qualified hostname or address literal"
+	MsgHeloMismatch               = "Helo command rejected: address literal does not match your address"
+	MsgHeloOwnHostname            = "Helo command rejected: you are not me"
+	MsgEarlyTalker                = "Protocol error: data sent before the greeting"
+	MsgMilterRejected             = "Rejected by content filter"
+	MsgSpamRejected               = "Message rejected as spam"
+	MsgVirusFound                 = "Message rejected, virus found: %s"
+	MsgRuleRejected               = "Message rejected by content rules"
+	MsgSieveRejected              = "Message rejected by the recipient's filter"
+)
+
+// https://tex2e.github.io/rfc-translater/html/rfc3463.html
+var (
+	// 正常系
+	EnhancedOk          = session.EnhancedCode{2, 0, 0}
+	EnhancedSenderOk    = session.EnhancedCode{2, 1, 0}
+	EnhancedRecipientOk = session.EnhancedCode{2, 1, 5}
+	EnhancedDataOk      = session.EnhancedCode{2, 6, 0}
+
+	// Temporary Error
+	EnhancedServiceNotAvailable = session.EnhancedCode{4, 3, 0}
+	EnhancedLocalError          = session.EnhancedCode{4, 3, 0}
+	EnhancedTooManyRecipients   = session.EnhancedCode{4, 5, 3}
+	EnhancedPolicyTempError     = session.EnhancedCode{4, 7, 0}
+	EnhancedConnectionTimeout   = session.EnhancedCode{4, 4, 2}
+	EnhancedNotAccepting        = session.EnhancedCode{4, 3, 2}
+	EnhancedGreylisted          = session.EnhancedCode{4, 7, 1}
+	EnhancedReverseDnsFailed    = session.EnhancedCode{4, 7, 25}
+	EnhancedFilterTempFail      = session.EnhancedCode{4, 7, 1}
+
+	// Permanent Error
+	EnhancedProtocolError    = session.EnhancedCode{5, 5, 0}
+	EnhancedInvalidCommand   = session.EnhancedCode{5, 5, 1}
+	EnhancedSyntaxError      = session.EnhancedCode{5, 5, 2}
+	EnhancedInvalidArguments = session.EnhancedCode{5, 5, 4}
+	EnhancedBadDestSyntax    = session.EnhancedCode{5, 1, 3}
+	EnhancedBadSenderSyntax  = session.EnhancedCode{5, 1, 7}
+	EnhancedMessageTooBig    = session.EnhancedCode{5, 3, 4}
+	EnhancedNonAsciiAddress  = session.EnhancedCode{5, 6, 7}
+	EnhancedMediaError       = session.EnhancedCode{5, 6, 0}
+	EnhancedUnknownUser      = session.EnhancedCode{5, 1, 1}
+	EnhancedRelayDenied      = session.EnhancedCode{5, 7, 1}
+	EnhancedRoutingLoop      = session.EnhancedCode{5, 4, 6}
+	EnhancedBlocked          = session.EnhancedCode{5, 7, 1}
+	EnhancedSecurityError    = session.EnhancedCode{5, 7, 0}
+	EnhancedTransactionFail  = session.EnhancedCode{5, 0, 0}
+)
+
+var (
+	// 正常系
+	ReplyGreet       = session.NewReply(CodeGreet, session.NoEnhancedCode, MsgGreet)
+	ReplyQuit        = session.NewReply(CodeQuit, EnhancedOk, MsgQuit)
+	ReplyOk          = session.NewReply(CodeOk, EnhancedOk, MsgOk)
+	ReplySenderOk    = session.NewReply(CodeOk, EnhancedSenderOk, MsgOk)
+	ReplyRecipientOk = session.NewReply(CodeOk, EnhancedRecipientOk, MsgOk)
+	ReplyDataOk      = session.NewReply(CodeOk, EnhancedDataOk, MsgOk)
+	ReplyGoAhead     = session.NewReply(CodeGreet, EnhancedOk, MsgGoAhead)
+	ReplyStartInput  = session.NewReply(CodeStartInput, session.NoEnhancedCode, MsgStartInput)
+
+	// Temporary Error
+	ReplyServiceNotAvailable   = session.NewReply(CodeServiceNotAvailable, EnhancedServiceNotAvailable, MsgServiceNotAvailable)
+	ReplyLocalError            = session.NewReply(CodeLocalError, EnhancedLocalError, MsgLocalError)
+	ReplyTooManyRecipients     = session.NewReply(CodeInsufficientStorage, EnhancedTooManyRecipients, MsgTooManyRecipients)
+	ReplyTooManyMessages       = session.NewReply(CodeServiceNotAvailable, EnhancedPolicyTempError, MsgTooManyMessages)
+	ReplyTooManyErrors         = session.NewReply(CodeServiceNotAvailable, EnhancedPolicyTempError, MsgTooManyErrors)
+	ReplyTimeout               = session.NewReply(CodeServiceNotAvailable, EnhancedConnectionTimeout, MsgTimeout)
+	ReplyShuttingDown          = session.NewReply(CodeServiceNotAvailable, EnhancedNotAccepting, MsgShuttingDown)
+	ReplyTooManyConnections    = session.NewReply(CodeServiceNotAvailable, EnhancedPolicyTempError, MsgTooManyConnections)
+	ReplyConnectionRate        = session.NewReply(CodeServiceNotAvailable, EnhancedPolicyTempError, MsgConnectionRate)
+	ReplyMessageRate           = session.NewReply(CodeLocalError, EnhancedPolicyTempError, MsgMessageRate)
+	ReplyRecipientRate         = session.NewReply(CodeLocalError, EnhancedPolicyTempError, MsgRecipientRate)
+	ReplyGreylisted            = session.NewReply(CodeLocalError, EnhancedGreylisted, MsgGreylisted)
+	ReplyUnknownClient         = session.NewReply(CodeActionNotTaken, EnhancedReverseDnsFailed, MsgUnknownClient)
+	ReplyMilterTempFail        = session.NewReply(CodeLocalError, EnhancedFilterTempFail, MsgMilterTempFail)
+	ReplyMilterConnectTempFail = session.NewReply(CodeServiceNotAvailable, EnhancedPolicyTempError, MsgMilterTempFail)
+	ReplySpamTempFail          = session.NewReply(CodeLocalError, EnhancedLocalError, MsgSpamTempFail)
+	ReplyVirusTempFail         = session.NewReply(CodeLocalError, EnhancedLocalError, MsgVirusTempFail)
+	ReplyDeliveryTempFail      = session.NewReply(CodeLocalError, EnhancedLocalError, MsgDeliveryTempFail)
+
+	// Permanent Error
+	ReplySyntaxError                = session.NewReply(CodeSyntaxError, EnhancedSyntaxError, MsgSyntaxError)
+	ReplyArgumentSyntaxError        = session.NewReply(CodeArgumentSyntaxError, EnhancedInvalidArguments, MsgArgumentSyntaxError)
+	ReplyBadSenderSyntax            = session.NewReply(CodeArgumentSyntaxError, EnhancedBadSenderSyntax, MsgArgumentSyntaxError)
+	ReplyBadDestSyntax              = session.NewReply(CodeArgumentSyntaxError, EnhancedBadDestSyntax, MsgArgumentSyntaxError)
+	ReplyCommandNotImplemented      = session.NewReply(CodeCommandNotImplemented, EnhancedInvalidCommand, MsgCommandNotImplemented)
+	ReplyBadSequence                = session.NewReply(CodeBadSequence, EnhancedInvalidCommand, MsgBadSequence)
+	ReplyBinaryMimeRequiresBdat     = session.NewReply(CodeBadSequence, EnhancedInvalidCommand, MsgBinaryMimeRequiresBdat)
+	ReplyAlreadyTls                 = session.NewReply(CodeBadSequence, EnhancedInvalidCommand, MsgAlreadyTls)
+	ReplyCommandParamNotImplemented = session.NewReply(CodeCommandParamNotImplemented, EnhancedInvalidArguments, MsgCommandParamNotImplemented)
+	ReplyAborted                    = session.NewReply(CodeAborted, EnhancedMessageTooBig, MsgAborted)
+	ReplyTransactionFail            = session.NewReply(CodeTransactionFail, EnhancedTransactionFail, MsgTransactionFail)
+	ReplyOptionParamNotRecognized   = session.NewReply(CodeOptionParamNotRecognized, EnhancedInvalidArguments, MsgOptionParamNotRecognized)
+	ReplyIllegalPipelining          = session.NewReply(CodeTransactionFail, EnhancedProtocolError, MsgIllegalPipelining)
+	ReplyNonAsciiAddress            = session.NewReply(CodeMailboxNameNotAllowed, EnhancedNonAsciiAddress, MsgNonAsciiAddress)
+	Reply8BitNotPermitted           = session.NewReply(CodeTransactionFail, EnhancedMediaError, Msg8BitNotPermitted)
+	ReplyUnknownUser                = session.NewReply(CodeMailboxUnavailable, EnhancedUnknownUser, MsgUnknownUser)
+	ReplyRelayDenied                = session.NewReply(CodeTransactionFail, EnhancedRelayDenied, MsgRelayDenied)
+	ReplyRoutingLoop                = session.NewReply(CodeMailboxUnavailable, EnhancedRoutingLoop, MsgRoutingLoop)
+	ReplyInvalidHelo                = session.NewReply(CodeArgumentSyntaxError, EnhancedSyntaxError, MsgInvalidHelo)
+	ReplyHeloMismatch               = session.NewReply(CodeMailboxUnavailable, EnhancedBlocked, MsgHeloMismatch)
+	ReplyHeloOwnHostname            = session.NewReply(CodeMailboxUnavailable, EnhancedBlocked, MsgHeloOwnHostname)
+	ReplyEarlyTalker                = session.NewReply(CodeTransactionFail, EnhancedProtocolError, MsgEarlyTalker)
+	ReplyMilterRejected             = session.NewReply(CodeMailboxUnavailable, EnhancedBlocked, MsgMilterRejected)
+	ReplyMilterConnectRejected      = session.NewReply(CodeTransactionFail, EnhancedBlocked, MsgMilterRejected)
+	ReplySpamRejected               = session.NewReply(CodeMailboxUnavailable, EnhancedBlocked, MsgSpamRejected)
+	ReplyRuleRejected               = session.NewReply(CodeMailboxUnavailable, EnhancedBlocked, MsgRuleRejected)
+	ReplySieveRejected              = session.NewReply(CodeMailboxUnavailable, EnhancedBlocked, MsgSieveRejected)
+	// the text is formatted with the client IP address and the name of the list
+	ReplyDnsblListed = session.NewReply(CodeTransactionFail, EnhancedBlocked, MsgDnsblListed)
+	// the text is formatted with the name of the virus
+	ReplyVirusFound = session.NewReply(CodeTransactionFail, EnhancedSecurityError, MsgVirusFound)
 )
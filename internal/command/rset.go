@@ -18,12 +18,13 @@ func (h *rsetHandler) Command() string {
 func (h *rsetHandler) HandleCommand(ctx context.Context, s *session.Session, arg []string) error {
 	// RSET is not permit parameters
 	if len(arg) > 0 {
-		s.Response(CodeSyntaxError, MsgSyntaxError)
+		s.Reply(ReplySyntaxError)
 		return nil
 	}
 
-	s.Reset()
-	s.Response(CodeOk, MsgOk)
+	// HELO/EHLO state is kept
+	s.ResetTransaction()
+	s.Reply(ReplyOk)
 	return nil
 }
 
@@ -2,6 +2,7 @@ package command
 
 import (
 	"context"
+	"net/mail"
 	"testing"
 
 	"github.com/Haya372/smtp-server/internal/mock"
@@ -24,13 +25,18 @@ func TestRset(t *testing.T) {
 	target := NewRsetHandler(log)
 
 	s := session.NewMockSession(ctrl)
-	s.ExpectResponse(CodeOk, MsgOk)
+	s.Session.SenderDomain = "example.com"
+	s.Session.EnvelopeFrom = &mail.Address{Address: "from@example.com"}
+	s.Session.Chunking = true
+	s.ExpectReply(ReplyOk)
 
 	target.HandleCommand(context.TODO(), s.Session, make([]string, 0))
-	assert.Empty(t, s.Session.SenderDomain)
+	// HELO/EHLO state is kept
+	assert.Equal(t, "example.com", s.Session.SenderDomain)
 	assert.Nil(t, s.Session.EnvelopeFrom)
 	assert.Empty(t, s.Session.EnvelopeTo)
 	assert.Empty(t, s.Session.RawData)
+	assert.False(t, s.Session.Chunking)
 }
 
 func TestRset_Err(t *testing.T) {
@@ -40,16 +46,14 @@ func TestRset_Err(t *testing.T) {
 	log := mock.NewInitializedMockLogger(ctrl)
 
 	tests := []struct {
-		name string
-		arg  []string
-		code int
-		msg  string
+		name  string
+		arg   []string
+		reply session.Reply
 	}{
 		{
-			name: "with parameter",
-			arg:  []string{"hoge"},
-			code: CodeSyntaxError,
-			msg:  MsgSyntaxError,
+			name:  "with parameter",
+			arg:   []string{"hoge"},
+			reply: ReplySyntaxError,
 		},
 	}
 
@@ -58,7 +62,7 @@ func TestRset_Err(t *testing.T) {
 			s := session.NewMockSession(ctrl)
 			s.Session.SenderDomain = "test"
 
-			s.ExpectResponse(test.code, test.msg)
+			s.ExpectReply(test.reply)
 
 			target := NewRsetHandler(log)
 			target.HandleCommand(context.TODO(), s.Session, test.arg)
@@ -0,0 +1,28 @@
+package command
+
+import (
+	"context"
+
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/service"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+// rejectByRules evaluates the content rules, true is returned when the message is rejected.
+// The transaction is reset on rejection.
+func rejectByRules(ctx context.Context, rules service.RuleService, s *session.Session) bool {
+	res := rules.Evaluate(ctx, s)
+	if res.Action != data.RuleReject {
+		return false
+	}
+	reply := ReplyRuleRejected
+	if res.Reply != nil {
+		reply = *res.Reply
+		if len(reply.Lines) == 0 {
+			reply.Lines = ReplyRuleRejected.Lines
+		}
+	}
+	s.Reply(reply)
+	s.ResetTransaction()
+	return true
+}
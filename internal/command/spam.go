@@ -0,0 +1,30 @@
+package command
+
+import (
+	"context"
+
+	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/service"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+// rejectSpam scans the message s.RawData, true is returned when the message is rejected.
+// The transaction is reset on rejection.
+func rejectSpam(ctx context.Context, log hlog.Logger, spam service.SpamService, s *session.Session) bool {
+	res := spam.Scan(ctx, s)
+	switch res.Action {
+	case data.SpamReject:
+		log.Infof("[%s] message is rejected as spam.", s.Id)
+		s.Reply(ReplySpamRejected)
+	case data.SpamTempFail:
+		s.Reply(ReplySpamTempFail)
+	case data.SpamQuarantine:
+		log.Infof("[%s] message is quarantined as spam.", s.Id)
+		return false
+	default:
+		return false
+	}
+	s.ResetTransaction()
+	return true
+}
@@ -19,18 +19,20 @@ func (h *startTlsHandler) Command() string {
 
 func (h startTlsHandler) HandleCommand(ctx context.Context, s *session.Session, arg []string) error {
 	if s.IsTls() {
-		s.Response(CodeBadSequence, MsgAlreadyTls)
+		s.Reply(ReplyAlreadyTls)
 		return nil
 	}
 
-	s.Response(CodeGreet, MsgGoAhead)
+	s.Reply(ReplyGoAhead)
 	if err := s.ConvertToTls(h.conf.TlsConfig); err != nil {
 		h.log.Errorf("[%d] tls error, err=%v", s.Id, err)
-		s.Response(CodeTransactionFail, MsgTransactionFail)
+		s.Reply(ReplyTransactionFail)
 		return err
 	}
 
+	// knowledge obtained from the client before TLS negotiation must be discarded
 	s.Reset()
+	s.Pipelining = false
 	return nil
 }
 
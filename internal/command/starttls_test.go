@@ -38,7 +38,7 @@ func TestStartTls(t *testing.T) {
 			name: "Already TLS",
 			setup: func(s *session.MockSession) {
 				s.Session.Conn = &tls.Conn{}
-				s.ExpectResponse(CodeBadSequence, MsgAlreadyTls)
+				s.ExpectReply(ReplyAlreadyTls)
 			},
 		},
 		// NOTE: モックだとテストが難しいため後回し
@@ -46,17 +46,17 @@ func TestStartTls(t *testing.T) {
 		// {
 		// 	name: "TLS Error",
 		// 	setup: func(s *session.MockSession) {
-		// 		s.ExpectResponse(CodeGreet, MsgGoAhead)
+		// 		s.ExpectReply(ReplyGoAhead)
 		// 		cer, _ := tls.LoadX509KeyPair("./testdata/server.crt", "server.key")
 		// 		tlsConf.TlsConfig.Certificates = []tls.Certificate{cer}
-		// 		s.ExpectResponse(CodeTransactionFail, MsgTransactionFail)
+		// 		s.ExpectReply(ReplyTransactionFail)
 		// 	},
 		// 	expectErr: true,
 		// },
 		// {
 		// 	name: "Success",
 		// 	setup: func(s *session.MockSession) {
-		// 		s.ExpectResponse(CodeGreet, MsgGoAhead)
+		// 		s.ExpectReply(ReplyGoAhead)
 		// 		cer, err := tls.LoadX509KeyPair("../../testdata/server.crt", "../../testdata/server.key")
 		// 		dir, _ := os.Getwd()
 		// 		t.Log(dir)
@@ -0,0 +1,18 @@
+package config
+
+import "time"
+
+type AntivirusConfig struct {
+	Enable bool `yaml:"enable"`
+	// clamd is connected by "tcp" with "host:port" or "unix" with the socket path
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+	// timeout of each scan
+	Timeout time.Duration `yaml:"timeout"`
+	// messages are accepted when clamd is not available, otherwise rejected with a temporary error
+	FailOpen bool `yaml:"failOpen"`
+}
+
+func NewAntivirusConfig(conf *Config) *AntivirusConfig {
+	return conf.Antivirus
+}
@@ -6,6 +6,23 @@ type Config struct {
 	Server *ServerConfig `yaml:"server"`
 	Smtp   *SmtpConfig   `yaml:"smtp"`
 	Tls    *TlsConfig    `yaml:"tls"`
+	Subnet *SubnetConfig `yaml:"subnet"`
+
+	Recipient *RecipientConfig `yaml:"recipient"`
+	Srs       *SrsConfig       `yaml:"srs"`
+	Metrics   *MetricsConfig   `yaml:"metrics"`
+	RateLimit *RateLimitConfig `yaml:"rateLimit"`
+	Greylist  *GreylistConfig  `yaml:"greylist"`
+	Dnsbl     *DnsblConfig     `yaml:"dnsbl"`
+	Helo      *HeloConfig      `yaml:"helo"`
+
+	EarlyTalker *EarlyTalkerConfig `yaml:"earlyTalker"`
+	Milter      *MilterConfig      `yaml:"milter"`
+	Spam        *SpamConfig        `yaml:"spam"`
+	Antivirus   *AntivirusConfig   `yaml:"antivirus"`
+	Rule        *RuleConfig        `yaml:"rule"`
+	Delivery    *DeliveryConfig    `yaml:"delivery"`
+	Vacation    *VacationConfig    `yaml:"vacation"`
 }
 
 func NewDefaultConfig() *Config {
@@ -13,19 +30,130 @@ func NewDefaultConfig() *Config {
 		Server: &ServerConfig{
 			Port:              25,
 			MaxConnection:     10,
-			ConnectionTimeout: 30 * time.Second,
+			ConnectionTimeout: 30 * time.Minute,
+			ShutdownTimeout:   10 * time.Second,
+			GreetingTimeout:   5 * time.Minute,
+			CommandTimeout:    5 * time.Minute,
+			DataInitTimeout:   2 * time.Minute,
+			DataBlockTimeout:  3 * time.Minute,
+
+			MaxConnectionWait:       10 * time.Second,
+			MaxConnectionsPerIp:     5,
+			MaxConnectionsPerSubnet: 20,
+		},
+		Subnet: &SubnetConfig{
+			PrefixV4: 24,
+			PrefixV6: 64,
 		},
 		Smtp: &SmtpConfig{
 			EnablePipelining: true,
 			Enable8BitMime:   true,
 			EnableSize:       true,
 			EnableStartTls:   true,
+			EnableChunking:   true,
+			EnableBinaryMime: true,
+			EnableSmtpUtf8:   true,
 
-			MaxMailSize: 1048576,
+			MaxMailSize:              1048576,
+			MaxRecipients:            100,
+			MaxMessagesPerConnection: 100,
+			MaxInvalidCommands:       20,
+			TarpitDelay:              time.Second,
+			TarpitMaxDelay:           10 * time.Second,
+			TarpitBudget:             time.Minute,
+			RejectIllegalPipelining:  true,
+			Downgrade8BitMime:        true,
 		},
 		Tls: &TlsConfig{
 			CertFilePath: "server.crt",
 			KeyFilePath:  "server.key",
 		},
+		Recipient: &RecipientConfig{
+			LocalDomains:       []string{"localhost"},
+			MailboxSource:      MailboxSourceNone,
+			RecipientDelimiter: "+",
+			MaxAliasDepth:      10,
+		},
+		Srs: &SrsConfig{
+			Enable:     false,
+			MaxAgeDays: 21,
+		},
+		Metrics: &MetricsConfig{},
+		RateLimit: &RateLimitConfig{
+			Connections: RateLimitRule{
+				PerIp:     RateLimit{Limit: 60, Window: time.Minute},
+				PerSubnet: RateLimit{Limit: 300, Window: time.Minute},
+			},
+			Messages: RateLimitRule{
+				PerIp:           RateLimit{Limit: 100, Window: time.Hour},
+				PerUser:         RateLimit{Limit: 500, Window: time.Hour},
+				PerSenderDomain: RateLimit{Limit: 1000, Window: time.Hour},
+			},
+			Recipients: RateLimitRule{
+				PerIp:   RateLimit{Limit: 500, Window: time.Hour},
+				PerUser: RateLimit{Limit: 2000, Window: time.Hour},
+			},
+		},
+		Greylist: &GreylistConfig{
+			Enable:             false,
+			Delay:              5 * time.Minute,
+			RetryWindow:        48 * time.Hour,
+			Expire:             35 * 24 * time.Hour,
+			AutoWhitelistCount: 5,
+			DbPath:             "greylist.db",
+		},
+		Dnsbl: &DnsblConfig{
+			Enable:      false,
+			RejectScore: 1,
+			Timeout:     5 * time.Second,
+		},
+		Helo: &HeloConfig{
+			CheckReverseDns: false,
+			CheckHelo:       true,
+			Timeout:         5 * time.Second,
+		},
+		EarlyTalker: &EarlyTalkerConfig{
+			GreetingDelay:     0,
+			ExemptAllowlisted: true,
+		},
+		Milter: &MilterConfig{
+			Enable: false,
+		},
+		Spam: &SpamConfig{
+			Enable:      false,
+			Scanner:     SpamScannerSpamd,
+			Network:     "tcp",
+			Address:     "127.0.0.1:783",
+			Timeout:     30 * time.Second,
+			MaxSize:     512000,
+			HeaderScore: 5,
+			RejectScore: 15,
+			FailOpen:    true,
+		},
+		Antivirus: &AntivirusConfig{
+			Enable:   false,
+			Network:  "tcp",
+			Address:  "127.0.0.1:3310",
+			Timeout:  30 * time.Second,
+			FailOpen: false,
+		},
+		Rule: &RuleConfig{
+			Enable:         false,
+			FilePath:       "rules.yaml",
+			ReloadInterval: 10 * time.Second,
+		},
+		Delivery: &DeliveryConfig{
+			Enable:           false,
+			MaildirPath:      "/var/mail/{domain}/{localpart}",
+			SievePath:        "/var/mail/sieve/{domain}/{localpart}.sieve",
+			QuarantineFolder: "Junk",
+			RelayTimeout:     30 * time.Second,
+		},
+		Vacation: &VacationConfig{
+			Enable:      false,
+			MessagePath: "/var/mail/vacation/{domain}/{localpart}.msg",
+			Period:      7 * 24 * time.Hour,
+			DbPath:      "vacation.db",
+		},
 	}
 }
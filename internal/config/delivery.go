@@ -0,0 +1,25 @@
+package config
+
+import "time"
+
+type DeliveryConfig struct {
+	Enable bool `yaml:"enable"`
+	// Maildir of each local mailbox, "{domain}" and "{localpart}" are replaced by the lower-cased recipient
+	// without subaddress
+	MaildirPath string `yaml:"maildirPath"`
+	// Sieve script which the user uploads to filter the mailbox, the message is kept in the inbox when
+	// the file does not exist. The placeholders are the same as MaildirPath.
+	SievePath string `yaml:"sievePath"`
+	// folder in the mailbox which quarantined messages are delivered to without Sieve
+	QuarantineFolder string `yaml:"quarantineFolder"`
+	// name of this server in Maildir file names and generated messages, the host name of the system when empty
+	Hostname string `yaml:"hostname"`
+	// relay host "host:port" which sends redirected messages, rejection notices and auto-replies,
+	// they are not sent when empty
+	RelayHost    string        `yaml:"relayHost"`
+	RelayTimeout time.Duration `yaml:"relayTimeout"`
+}
+
+func NewDeliveryConfig(conf *Config) *DeliveryConfig {
+	return conf.Delivery
+}
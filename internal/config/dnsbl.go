@@ -0,0 +1,31 @@
+package config
+
+import "time"
+
+type DnsList struct {
+	// name shown in replies and logs, the zone is used when empty
+	Name string `yaml:"name"`
+	// DNS zone of the list such as "zen.spamhaus.org"
+	Zone string `yaml:"zone"`
+	// score added (blocklists) or subtracted (allowlists) when the client is listed, 1 is used when 0
+	Weight float64 `yaml:"weight"`
+	// A records which mean the client is listed, IP addresses or CIDRs such as "127.0.0.2" or "127.0.0.0/24"
+	// any address in 127.0.0.0/8 except 127.255.255.0/24 (errors of the list) matches when empty
+	ReturnCodes []string `yaml:"returnCodes"`
+}
+
+type DnsblConfig struct {
+	Enable bool `yaml:"enable"`
+	// DNSBL, the scores of listed entries are summed up
+	Blocklists []DnsList `yaml:"blocklists"`
+	// DNSWL, the scores of listed entries are subtracted
+	Allowlists []DnsList `yaml:"allowlists"`
+	// clients whose score reaches this value are rejected before the greeting, no client is rejected when 0
+	RejectScore float64 `yaml:"rejectScore"`
+	// time to wait for all lists
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func NewDnsblConfig(conf *Config) *DnsblConfig {
+	return conf.Dnsbl
+}
@@ -0,0 +1,16 @@
+package config
+
+import "time"
+
+type EarlyTalkerConfig struct {
+	// time to wait before the greeting, clients sending anything in it are rejected, no delay when 0
+	GreetingDelay time.Duration `yaml:"greetingDelay"`
+	// IP addresses or CIDRs such as "192.0.2.1" or "2001:db8::/32" which are greeted without the delay
+	Exempt []string `yaml:"exempt"`
+	// clients whose DNSBL score is negative (listed in allowlists) are greeted without the delay
+	ExemptAllowlisted bool `yaml:"exemptAllowlisted"`
+}
+
+func NewEarlyTalkerConfig(conf *Config) *EarlyTalkerConfig {
+	return conf.EarlyTalker
+}
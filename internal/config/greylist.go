@@ -0,0 +1,21 @@
+package config
+
+import "time"
+
+type GreylistConfig struct {
+	Enable bool `yaml:"enable"`
+	// first attempts of unknown (client subnet, sender, recipient) are rejected until this delay passes
+	Delay time.Duration `yaml:"delay"`
+	// attempts which are not retried within this period are forgotten
+	RetryWindow time.Duration `yaml:"retryWindow"`
+	// passed triplets and whitelisted clients are kept while they are active in this period
+	Expire time.Duration `yaml:"expire"`
+	// clients which pass greylisting this many times are not greylisted anymore, 0 disables auto-whitelisting
+	AutoWhitelistCount int `yaml:"autoWhitelistCount"`
+	// SQLite database which keeps the state across restarts, the state is kept in memory when empty
+	DbPath string `yaml:"dbPath"`
+}
+
+func NewGreylistConfig(conf *Config) *GreylistConfig {
+	return conf.Greylist
+}
@@ -0,0 +1,22 @@
+package config
+
+import "time"
+
+type HeloConfig struct {
+	// look up forward-confirmed reverse DNS of the client when HELO/EHLO is received
+	CheckReverseDns bool `yaml:"checkReverseDns"`
+	// clients whose reverse DNS is not confirmed are rejected at HELO/EHLO
+	RejectUnknownClient bool `yaml:"rejectUnknownClient"`
+	// validate the argument of HELO/EHLO, the result is recorded on the session
+	CheckHelo bool `yaml:"checkHelo"`
+	// invalid arguments of HELO/EHLO are rejected instead of being only recorded
+	RejectInvalidHelo bool `yaml:"rejectInvalidHelo"`
+	// names of this server which clients must not use in HELO/EHLO, the host name of the system is always included
+	OwnHostnames []string `yaml:"ownHostnames"`
+	// time to wait for DNS lookups
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func NewHeloConfig(conf *Config) *HeloConfig {
+	return conf.Helo
+}
@@ -0,0 +1,10 @@
+package config
+
+type MetricsConfig struct {
+	// address of HTTP server which exposes metrics as JSON, e.g. "127.0.0.1:9025", metrics are not exposed when empty
+	ListenAddress string `yaml:"listenAddress"`
+}
+
+func NewMetricsConfig(conf *Config) *MetricsConfig {
+	return conf.Metrics
+}
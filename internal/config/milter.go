@@ -0,0 +1,38 @@
+package config
+
+import "time"
+
+// actions taken when a milter is not available
+const (
+	// the milter is skipped
+	MilterActionAccept = "accept"
+	// the command is rejected with a temporary error
+	MilterActionTempFail = "tempfail"
+	// the command is rejected with a permanent error
+	MilterActionReject = "reject"
+)
+
+type Milter struct {
+	// name shown in logs, the address is used when empty
+	Name string `yaml:"name"`
+	// "tcp" with "host:port", or "unix" with the socket path
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+	// action when the milter cannot be connected or breaks the protocol, "tempfail" is used when empty
+	DefaultAction  string        `yaml:"defaultAction"`
+	ConnectTimeout time.Duration `yaml:"connectTimeout"`
+	// timeout of each command
+	CommandTimeout time.Duration `yaml:"commandTimeout"`
+}
+
+type MilterConfig struct {
+	Enable bool `yaml:"enable"`
+	// milters are called in this order, changes of the message by a milter are seen by the following milters
+	Milters []Milter `yaml:"milters"`
+	// value of the macro "j", the hostname of the system is used when empty
+	Hostname string `yaml:"hostname"`
+}
+
+func NewMilterConfig(conf *Config) *MilterConfig {
+	return conf.Milter
+}
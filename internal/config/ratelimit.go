@@ -0,0 +1,33 @@
+package config
+
+import "time"
+
+// RateLimit permits Limit events per Window, bursts up to Limit are allowed.
+// No limit is applied when Limit is 0.
+type RateLimit struct {
+	Limit  int           `yaml:"limit"`
+	Window time.Duration `yaml:"window"`
+}
+
+// RateLimitRule has the limits of an event for each key.
+type RateLimitRule struct {
+	PerIp     RateLimit `yaml:"perIp"`
+	PerSubnet RateLimit `yaml:"perSubnet"`
+	// authenticated user, it is not applied to unauthenticated clients
+	PerUser RateLimit `yaml:"perUser"`
+	// domain of the envelope sender, it is not applied to the null sender
+	PerSenderDomain RateLimit `yaml:"perSenderDomain"`
+}
+
+type RateLimitConfig struct {
+	// new connections, only PerIp and PerSubnet are applied
+	Connections RateLimitRule `yaml:"connections"`
+	// transactions started by MAIL
+	Messages RateLimitRule `yaml:"messages"`
+	// recipients received by RCPT
+	Recipients RateLimitRule `yaml:"recipients"`
+}
+
+func NewRateLimitConfig(conf *Config) *RateLimitConfig {
+	return conf.RateLimit
+}
@@ -0,0 +1,35 @@
+package config
+
+const (
+	// any local part of the local domains is accepted
+	MailboxSourceNone   = ""
+	MailboxSourceFile   = "file"
+	MailboxSourceSqlite = "sqlite"
+)
+
+type RecipientConfig struct {
+	// domains which this server accepts mail for, mail for other domains is relayed only for authenticated clients
+	LocalDomains []string `yaml:"localDomains"`
+
+	// where existing mailboxes are looked up: "", "file" or "sqlite"
+	MailboxSource string `yaml:"mailboxSource"`
+	// file which has one mailbox "local-part@domain" per line
+	MailboxFilePath string `yaml:"mailboxFilePath"`
+	// SQLite database which has a table "mailboxes(local_part, domain)"
+	MailboxDbPath string `yaml:"mailboxDbPath"`
+
+	// sendmail-style aliases "name: target, target" applied to every local domain
+	AliasFilePath string `yaml:"aliasFilePath"`
+	// domains which have no mailboxes, their addresses are resolved only by the virtual map
+	VirtualDomains []string `yaml:"virtualDomains"`
+	// postfix-style virtual map "address target, target", "@domain" is the catch-all of the domain
+	VirtualFilePath string `yaml:"virtualFilePath"`
+	// separator of subaddress such as "user+tag@example.com", subaddressing is disabled when empty
+	RecipientDelimiter string `yaml:"recipientDelimiter"`
+	// aliases nested deeper than this are treated as a loop
+	MaxAliasDepth int `yaml:"maxAliasDepth"`
+}
+
+func NewRecipientConfig(conf *Config) *RecipientConfig {
+	return conf.Recipient
+}
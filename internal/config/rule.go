@@ -0,0 +1,15 @@
+package config
+
+import "time"
+
+type RuleConfig struct {
+	Enable bool `yaml:"enable"`
+	// YAML file of the rules evaluated after DATA
+	FilePath string `yaml:"filePath"`
+	// the file is reloaded when it is changed, checked at most once in the interval
+	ReloadInterval time.Duration `yaml:"reloadInterval"`
+}
+
+func NewRuleConfig(conf *Config) *RuleConfig {
+	return conf.Rule
+}
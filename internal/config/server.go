@@ -3,9 +3,24 @@ package config
 import "time"
 
 type ServerConfig struct {
-	Port              int           `yaml:"port"`
-	MaxConnection     int           `yaml:"maxConnection"`
+	Port          int `yaml:"port"`
+	MaxConnection int `yaml:"maxConnection"`
+	// connections over MaxConnection wait for a free slot up to this time before they are refused
+	MaxConnectionWait time.Duration `yaml:"maxConnectionWait"`
+	// concurrent connections from an IP address or a subnet, no limit is applied when the value is 0
+	MaxConnectionsPerIp     int `yaml:"maxConnectionsPerIp"`
+	MaxConnectionsPerSubnet int `yaml:"maxConnectionsPerSubnet"`
+	// total lifetime of a session
 	ConnectionTimeout time.Duration `yaml:"connectionTimeout"`
+	// time to wait for sessions to finish on shutdown, remaining sessions are closed forcibly after it
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+
+	// timeouts of each phase, no timeout is applied when the value is 0
+	// https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-5-3-2--Timeouts
+	GreetingTimeout  time.Duration `yaml:"greetingTimeout"`
+	CommandTimeout   time.Duration `yaml:"commandTimeout"`
+	DataInitTimeout  time.Duration `yaml:"dataInitTimeout"`
+	DataBlockTimeout time.Duration `yaml:"dataBlockTimeout"`
 }
 
 func NewServerConfig(conf *Config) *ServerConfig {
@@ -1,13 +1,41 @@
 package config
 
+import "time"
+
 type SmtpConfig struct {
 	// ESMTP extensions
 	EnablePipelining bool `yaml:"enablePipelining"`
 	Enable8BitMime   bool `yaml:"enable8BitMime"`
 	EnableSize       bool `yaml:"enableSize"`
 	EnableStartTls   bool `yaml:"enableStartTls"`
+	EnableChunking   bool `yaml:"enableChunking"`
+	// BINARYMIME is available only when CHUNKING is enabled
+	EnableBinaryMime bool `yaml:"enableBinaryMime"`
+	EnableSmtpUtf8   bool `yaml:"enableSmtpUtf8"`
 
 	MaxMailSize int `yaml:"maxMailSize"`
+	// limits of a session, no limit is applied when the value is 0
+	MaxRecipients            int `yaml:"maxRecipients"`
+	MaxMessagesPerConnection int `yaml:"maxMessagesPerConnection"`
+	// the connection is closed with 421 after the client sends this number of invalid commands
+	MaxInvalidCommands int `yaml:"maxInvalidCommands"`
+
+	// replies to a misbehaving client are delayed, the delay doubles on each bad command, unknown recipient
+	// or blocklist hit up to TarpitMaxDelay, no delay is applied when TarpitDelay is 0
+	TarpitDelay    time.Duration `yaml:"tarpitDelay"`
+	TarpitMaxDelay time.Duration `yaml:"tarpitMaxDelay"`
+	// total delay of a session, the client is not delayed any more after it
+	TarpitBudget time.Duration `yaml:"tarpitBudget"`
+
+	// reply 554 and close the connection when the client sends commands without waiting for the reply
+	// of a synchronization point, or pipelines without negotiating PIPELINING
+	RejectIllegalPipelining bool `yaml:"rejectIllegalPipelining"`
+
+	// reject message content which has 8-bit octets without BODY=8BITMIME, BODY=BINARYMIME or SMTPUTF8
+	Reject8BitIn7BitBody bool `yaml:"reject8BitIn7BitBody"`
+	// convert 8-bit content to quoted-printable when relaying to a server which does not advertise 8BITMIME,
+	// the message is not relayed when this flag is false
+	Downgrade8BitMime bool `yaml:"downgrade8BitMime"`
 }
 
 func NewSmtpConfig(conf *Config) *SmtpConfig {
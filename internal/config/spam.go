@@ -0,0 +1,38 @@
+package config
+
+import "time"
+
+// spam scanners
+const (
+	SpamScannerSpamd  = "spamd"
+	SpamScannerRspamd = "rspamd"
+)
+
+type SpamConfig struct {
+	Enable bool `yaml:"enable"`
+	// "spamd" or "rspamd"
+	Scanner string `yaml:"scanner"`
+	// spamd is connected by "tcp" with "host:port" or "unix" with the socket path
+	Network string `yaml:"network"`
+	// address of spamd, or the URL of the rspamd worker such as "http://localhost:11333"
+	Address string `yaml:"address"`
+	// user whose preferences are used by spamd
+	User string `yaml:"user"`
+	// password of rspamd
+	Password string        `yaml:"password"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// messages larger than this are accepted without scanning, no limit when 0
+	MaxSize int `yaml:"maxSize"`
+	// X-Spam headers are added to messages whose score reaches this value, thresholds are disabled when 0
+	HeaderScore float64 `yaml:"headerScore"`
+	// messages are delivered to the quarantine
+	QuarantineScore float64 `yaml:"quarantineScore"`
+	// messages are rejected
+	RejectScore float64 `yaml:"rejectScore"`
+	// messages are accepted when the scanner is not available, otherwise rejected with a temporary error
+	FailOpen bool `yaml:"failOpen"`
+}
+
+func NewSpamConfig(conf *Config) *SpamConfig {
+	return conf.Spam
+}
@@ -0,0 +1,15 @@
+package config
+
+type SrsConfig struct {
+	Enable bool `yaml:"enable"`
+	// domain of rewritten addresses, it must be one of the local domains to receive bounces
+	Domain string `yaml:"domain"`
+	// the first secret signs new addresses, all secrets are used for verification to rotate secrets
+	Secrets []string `yaml:"secrets"`
+	// rewritten addresses expire after the days
+	MaxAgeDays int `yaml:"maxAgeDays"`
+}
+
+func NewSrsConfig(conf *Config) *SrsConfig {
+	return conf.Srs
+}
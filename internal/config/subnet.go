@@ -0,0 +1,12 @@
+package config
+
+// SubnetConfig groups client addresses into subnets, it is shared by connection limits, rate limits and greylisting.
+type SubnetConfig struct {
+	// prefix lengths of subnets, e.g. 24 for IPv4 /24 and 64 for IPv6 /64
+	PrefixV4 int `yaml:"prefixV4"`
+	PrefixV6 int `yaml:"prefixV6"`
+}
+
+func NewSubnetConfig(conf *Config) *SubnetConfig {
+	return conf.Subnet
+}
@@ -0,0 +1,20 @@
+package config
+
+import "time"
+
+type VacationConfig struct {
+	// auto-replies of the mailboxes and the vacation action of Sieve are sent only when enabled
+	Enable bool `yaml:"enable"`
+	// auto-reply message of each mailbox, it is replied while the file exists. The file has header fields
+	// "Subject" and "From", an empty line and the text, a file without header section is the text.
+	// "{domain}" and "{localpart}" are replaced like the Maildir path of the delivery
+	MessagePath string `yaml:"messagePath"`
+	// the same sender is replied once in this period by the auto-reply of the mailbox
+	Period time.Duration `yaml:"period"`
+	// SQLite database of the senders who have been replied, the state is kept in memory when empty
+	DbPath string `yaml:"dbPath"`
+}
+
+func NewVacationConfig(conf *Config) *VacationConfig {
+	return conf.Vacation
+}
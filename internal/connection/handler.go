@@ -3,51 +3,257 @@ package connection
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/Haya372/hlog"
 	"github.com/Haya372/smtp-server/internal/command"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/metrics"
+	"github.com/Haya372/smtp-server/internal/service"
 	"github.com/Haya372/smtp-server/internal/session"
 )
 
 type SessionHandler struct {
 	log             hlog.Logger
+	conf            *config.SmtpConfig
 	commandHandlers map[string]command.CommandHandler
+	dnsbl           service.DnsblService
+	earlyTalker     service.EarlyTalkerService
+	milter          service.MilterService
+	metrics         metrics.Metrics
+	// replaced in tests
+	sleep func(ctx context.Context, d time.Duration)
+}
+
+// isIllegalPipelining reports whether the client sent next input without waiting for the reply of cmd.
+// https://tex2e.github.io/rfc-translater/html/rfc2920.html#3-1--Client-use-of-pipelining
+// Chunk data of BDAT follows the command line, it is checked after the chunk is read.
+func (h *SessionHandler) isIllegalPipelining(s *session.Session, cmd string) bool {
+	// input after QUIT is never processed
+	if cmd == command.QUIT || !s.HasPendingInput() {
+		return false
+	}
+	// includes message content sent before 354 reply of DATA
+	return !s.Pipelining || command.IsSynchronizationPoint(cmd)
+}
+
+// isInvalidCommandReply reports whether the reply code means the client sent a syntax error or bad sequence.
+func isInvalidCommandReply(code int) bool {
+	switch code {
+	case command.CodeSyntaxError,
+		command.CodeArgumentSyntaxError,
+		command.CodeCommandNotImplemented,
+		command.CodeBadSequence,
+		command.CodeCommandParamNotImplemented,
+		command.CodeOptionParamNotRecognized:
+		return true
+	default:
+		return false
+	}
+}
+
+// countInvalidCommand closes the connection when the client keeps sending invalid commands.
+func (h *SessionHandler) countInvalidCommand(s *session.Session) {
+	s.InvalidCommands++
+	if h.conf.MaxInvalidCommands > 0 && s.InvalidCommands >= h.conf.MaxInvalidCommands {
+		h.log.Warnf("[%s] too many invalid commands.", s.Id)
+		s.Reply(command.ReplyTooManyErrors)
+		s.Flush()
+		s.ShouldClose = true
+	}
+}
+
+// commandArgument returns the rest of the line after the command verb as the client sent it.
+func commandArgument(line, verb string) string {
+	line = strings.TrimSpace(line)
+	return strings.TrimSpace(line[len(verb):])
 }
 
 func (h *SessionHandler) handleCommand(ctx context.Context, s *session.Session, line string) {
-	cmd := strings.ToLower(strings.Fields(line)[0])
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		h.tarpit(ctx, s)
+		s.Reply(command.ReplySyntaxError)
+		s.Strikes++
+		h.countInvalidCommand(s)
+		return
+	}
+	cmd := strings.ToLower(fields[0])
+	s.Argument = commandArgument(line, fields[0])
 	cmdHandler := h.commandHandlers[cmd]
 
+	// the client is allowed to leave without delay
+	if cmd != command.QUIT {
+		h.tarpit(ctx, s)
+	}
+
+	if cmd != command.BDAT && h.rejectIllegalPipelining(s, cmd) {
+		return
+	}
+	if cmd == command.BDAT {
+		// replies of pipelined commands are sent before waiting for the chunk data
+		s.Flush()
+	}
+
 	if cmdHandler != nil {
-		cmdHandler.HandleCommand(ctx, s, strings.Fields(line)[1:])
+		cmdHandler.HandleCommand(ctx, s, fields[1:])
 	} else {
 		h.log.Errorf("[%s] receive illegal command %s.", s.Id, cmd)
-		s.Response(command.CodeCommandNotImplemented, command.MsgCommandNotImplemented)
+		s.Reply(command.ReplyCommandNotImplemented)
+	}
+
+	if isStrike(cmd, s.LastReplyCode()) {
+		s.Strikes++
+	}
+	if isInvalidCommandReply(s.LastReplyCode()) {
+		h.countInvalidCommand(s)
+		if s.ShouldClose {
+			return
+		}
+	}
+
+	if cmd == command.BDAT && h.rejectIllegalPipelining(s, cmd) {
+		return
+	}
+
+	// the client waits for the reply before sending next commands, BDAT replies are sent as well
+	if command.IsSynchronizationPoint(cmd) || cmd == command.BDAT {
+		s.Flush()
+	}
+}
+
+// rejectIllegalPipelining closes the connection when the client pipelines commands improperly and it is not allowed.
+func (h *SessionHandler) rejectIllegalPipelining(s *session.Session, cmd string) bool {
+	if !h.isIllegalPipelining(s, cmd) {
+		return false
+	}
+	h.log.Warnf("[%s] improper command pipelining after %s.", s.Id, cmd)
+	if !h.conf.RejectIllegalPipelining {
+		return false
+	}
+	s.Reply(command.ReplyIllegalPipelining)
+	s.Flush()
+	s.ShouldClose = true
+	return true
+}
+
+// rejectByDnsbl looks up the client in DNS lists, the score is kept in the session for later policy.
+// Listed clients are rejected before they send anything.
+// https://tex2e.github.io/rfc-translater/html/rfc5321.html#3-1--Session-Initiation
+func (h *SessionHandler) rejectByDnsbl(ctx context.Context, s *session.Session) bool {
+	res, err := h.dnsbl.Check(ctx, s)
+	if err != nil {
+		h.log.WithError(err).Errorf("[%s] failed to check DNSBL.", s.Id)
+		return false
+	}
+	s.DnsblScore = res.Score
+	s.DnsblListed = res.Blocklisted
+	if !h.dnsbl.IsRejected(res) {
+		// listed clients which are not rejected are tarpitted
+		s.Strikes += len(res.Blocklisted)
+		return false
+	}
+
+	list := "DNSBL"
+	if len(res.Blocklisted) > 0 {
+		list = res.Blocklisted[0]
+	}
+	h.log.Infof("[%s] client %s is listed in %s.", s.Id, s.IP(), strings.Join(res.Blocklisted, ", "))
+	s.Reply(command.ReplyDnsblListed.WithLines(fmt.Sprintf(command.MsgDnsblListed, s.IP(), list)))
+	return true
+}
+
+// rejectEarlyTalker delays the greeting and rejects the client which sends commands before it.
+// https://tex2e.github.io/rfc-translater/html/rfc5321.html#3-1--Session-Initiation
+func (h *SessionHandler) rejectEarlyTalker(s *session.Session) bool {
+	delay := h.earlyTalker.GreetingDelay()
+	if delay <= 0 {
+		return false
+	}
+	if h.earlyTalker.IsExempt(s) {
+		h.metrics.Inc(metrics.EarlyTalkers, "exempt")
+		return false
+	}
+
+	received, err := s.ReceivesWithin(delay)
+	if err != nil {
+		// the client has gone before the greeting
+		h.log.WithError(err).Infof("[%s] connection closed before the greeting.", s.Id)
+		return true
+	}
+	if !received {
+		h.metrics.Inc(metrics.EarlyTalkers, "passed")
+		return false
 	}
+
+	h.log.Infof("[%s] client %s sent data before the greeting.", s.Id, s.IP())
+	h.metrics.Inc(metrics.EarlyTalkers, "rejected")
+	h.metrics.Inc(metrics.RejectedConnections, "early_talker")
+	s.Reply(command.ReplyEarlyTalker)
+	return true
+}
+
+// rejectByMilter sends the client information to milters before the greeting.
+func (h *SessionHandler) rejectByMilter(ctx context.Context, s *session.Session) bool {
+	res := h.milter.Connect(ctx, s)
+	if res.Action != data.MilterReject && res.Action != data.MilterTempFail {
+		return false
+	}
+	h.log.Infof("[%s] client %s is rejected by milter %s.", s.Id, s.IP(), res.Milter)
+	s.Reply(command.MilterReply(res, command.ReplyMilterConnectRejected, command.ReplyMilterConnectTempFail))
+	return true
 }
 
 func (h *SessionHandler) HandleSession(ctx context.Context, s *session.Session) {
 	h.log.Debugf("[%s] receive connection", s.Id)
-	s.Response(command.CodeGreet, command.MsgGreet)
 	defer s.Close()
+	defer h.milter.Close(s)
+
+	s.EnterPhase(session.PhaseGreeting)
+	if h.rejectByDnsbl(ctx, s) {
+		return
+	}
+	if h.rejectEarlyTalker(s) {
+		return
+	}
+	if h.rejectByMilter(ctx, s) {
+		return
+	}
+	if err := s.Reply(command.ReplyGreet); err != nil {
+		h.log.WithError(err).Errorf("[%s] could not send greeting.", s.Id)
+		return
+	}
 
 	for {
+		s.EnterPhase(session.PhaseCommand)
 		line, err := s.ReadLine()
 		if err != nil {
 			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
 				// closed by client
 				h.log.Infof("[%s] connection closed.", s.Id)
+			} else if session.IsTimeout(err) {
+				h.log.Infof("[%s] connection timed out.", s.Id)
+				s.ReplyOnTimeout(command.ReplyTimeout)
 			} else {
 				h.log.WithError(err).Errorf("[%s] could not read line. %v", s.Id, err)
-				s.Response(command.CodeServiceNotAvailable, command.MsgServiceNotAvailable)
+				s.Reply(command.ReplyServiceNotAvailable)
 			}
 			return
 		}
 		h.log.Debugf("[%s] received line: %s", s.Id, line)
 
+		// the transaction in progress has finished, no more command is accepted
+		if s.IsDraining() {
+			h.log.Infof("[%s] server is shutting down.", s.Id)
+			s.Reply(command.ReplyShuttingDown)
+			return
+		}
+
 		h.handleCommand(ctx, s, line)
 
 		if s.ShouldClose {
@@ -56,7 +262,7 @@ func (h *SessionHandler) HandleSession(ctx context.Context, s *session.Session)
 	}
 }
 
-func NewSessionHandler(log hlog.Logger, cmdHandlers []command.CommandHandler) SessionHandler {
+func NewSessionHandler(log hlog.Logger, conf *config.SmtpConfig, cmdHandlers []command.CommandHandler, dnsbl service.DnsblService, earlyTalker service.EarlyTalkerService, milter service.MilterService, m metrics.Metrics) SessionHandler {
 	commandHandlers := make(map[string]command.CommandHandler, 0)
 
 	for _, cmdHandler := range cmdHandlers {
@@ -65,6 +271,12 @@ func NewSessionHandler(log hlog.Logger, cmdHandlers []command.CommandHandler) Se
 
 	return SessionHandler{
 		log:             log,
+		conf:            conf,
 		commandHandlers: commandHandlers,
+		dnsbl:           dnsbl,
+		earlyTalker:     earlyTalker,
+		milter:          milter,
+		metrics:         m,
+		sleep:           sleep,
 	}
 }
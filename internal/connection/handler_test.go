@@ -1,17 +1,25 @@
 package connection
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"io"
 	"net"
+	"net/textproto"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/Haya372/smtp-server/internal/command"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/metrics"
 	"github.com/Haya372/smtp-server/internal/mock"
 	"github.com/Haya372/smtp-server/internal/mock/oss"
 	"github.com/Haya372/smtp-server/internal/session"
 	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestSessionHandler(t *testing.T) {
@@ -19,6 +27,9 @@ func TestSessionHandler(t *testing.T) {
 	defer ctrl.Finish()
 
 	log := mock.NewInitializedMockLogger(ctrl)
+	conf := &config.SmtpConfig{
+		RejectIllegalPipelining: true,
+	}
 
 	tests := []struct {
 		name  string
@@ -48,15 +59,61 @@ func TestSessionHandler(t *testing.T) {
 			name: "read line error (others)",
 			setup: func(s *session.MockSession, h *mock.MockCommandHandler) {
 				s.ExpectReadLine("", errors.New("test error"))
-				s.ExpectResponse(command.CodeServiceNotAvailable, command.MsgServiceNotAvailable)
+				s.ExpectReply(command.ReplyServiceNotAvailable)
 			},
 			close: true,
 		},
+		{
+			name: "read line timeout",
+			setup: func(s *session.MockSession, h *mock.MockCommandHandler) {
+				s.ExpectReadLine("", os.ErrDeadlineExceeded)
+				s.Session.Conn.(*oss.MockConn).EXPECT().SetWriteDeadline(gomock.Any()).Return(nil)
+				s.ExpectReply(command.ReplyTimeout)
+			},
+		},
+		{
+			name: "pipelining without negotiation",
+			setup: func(s *session.MockSession, h *mock.MockCommandHandler) {
+				s.ExpectReadLine("helo example.com\r\nmail from:<from@example.com>\r\n", nil)
+				s.ExpectReply(command.ReplyIllegalPipelining)
+			},
+		},
+		{
+			name: "premature input after DATA",
+			setup: func(s *session.MockSession, h *mock.MockCommandHandler) {
+				s.Session.Pipelining = true
+				s.ExpectReadLine("data\r\nSubject: test\r\n", nil)
+				s.ExpectReply(command.ReplyIllegalPipelining)
+			},
+		},
+		{
+			name: "pipelining after synchronization point",
+			setup: func(s *session.MockSession, h *mock.MockCommandHandler) {
+				s.Session.Pipelining = true
+				s.ExpectReadLine("helo example.com\r\nmail from:<from@example.com>\r\n", nil)
+				s.ExpectReply(command.ReplyIllegalPipelining)
+			},
+		},
+		{
+			name: "empty line",
+			setup: func(s *session.MockSession, h *mock.MockCommandHandler) {
+				s.ExpectReadLine("\r\n", nil)
+				s.ExpectReply(command.ReplySyntaxError)
+			},
+		},
+		{
+			name: "server is shutting down",
+			setup: func(s *session.MockSession, h *mock.MockCommandHandler) {
+				s.Session.Drain()
+				s.ExpectReadLine("helo example.com", nil)
+				s.ExpectReply(command.ReplyShuttingDown)
+			},
+		},
 		{
 			name: "command not implemented",
 			setup: func(s *session.MockSession, h *mock.MockCommandHandler) {
 				s.ExpectReadLine("test", nil)
-				s.ExpectResponse(command.CodeCommandNotImplemented, command.MsgCommandNotImplemented)
+				s.ExpectReply(command.ReplyCommandNotImplemented)
 			},
 		},
 	}
@@ -66,12 +123,12 @@ func TestSessionHandler(t *testing.T) {
 			s := session.NewMockSession(ctrl)
 			h := mock.NewInitializedMockCommandHandler(ctrl, command.HELO)
 
-			s.ExpectResponse(command.CodeGreet, command.MsgGreet)
+			s.ExpectReply(command.ReplyGreet)
 
 			conn := oss.NewMockConn(ctrl)
 			conn.EXPECT().Close().Times(1)
 			s.Session.Conn = conn
-			target := NewSessionHandler(log, []command.CommandHandler{h})
+			target := NewSessionHandler(log, conf, []command.CommandHandler{h}, mock.NewInitializedMockDnsblService(ctrl), mock.NewInitializedMockEarlyTalkerService(ctrl), mock.NewInitializedMockMilterService(ctrl), metrics.NewMetrics())
 
 			if test.setup != nil {
 				test.setup(s, h)
@@ -81,3 +138,390 @@ func TestSessionHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestSessionHandler_Pipelining(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	tests := []struct {
+		name       string
+		conf       *config.SmtpConfig
+		pipelining bool
+	}{
+		{
+			name: "replies are flushed when input buffer is drained",
+			conf: &config.SmtpConfig{
+				RejectIllegalPipelining: true,
+			},
+			pipelining: true,
+		},
+		{
+			name:       "illegal pipelining is not rejected",
+			conf:       &config.SmtpConfig{},
+			pipelining: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			mailHandler := mock.NewInitializedMockCommandHandler(ctrl, command.MAIL)
+			rcptHandler := mock.NewInitializedMockCommandHandler(ctrl, command.RCPT)
+
+			s.ExpectReply(command.ReplyGreet)
+
+			conn := oss.NewMockConn(ctrl)
+			conn.EXPECT().Close().Times(1)
+			s.Session.Conn = conn
+			s.Session.Pipelining = test.pipelining
+			target := NewSessionHandler(log, test.conf, []command.CommandHandler{mailHandler, rcptHandler}, mock.NewInitializedMockDnsblService(ctrl), mock.NewInitializedMockEarlyTalkerService(ctrl), mock.NewInitializedMockMilterService(ctrl), metrics.NewMetrics())
+
+			s.ExpectReadLine("mail from:<from@example.com>\r\nrcpt to:<to@example.com>\r\n", nil)
+			mailHandler.EXPECT().HandleCommand(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, s *session.Session, arg []string) error {
+					return s.Reply(command.ReplySenderOk)
+				},
+			)
+			rcptHandler.EXPECT().HandleCommand(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, s *session.Session, arg []string) error {
+					return s.Reply(command.ReplyRecipientOk)
+				},
+			)
+			if test.pipelining {
+				// both replies are sent at once
+				replies := []byte(command.ReplySenderOk.String() + command.ReplyRecipientOk.String())
+				s.Writer.EXPECT().Write(replies).Return(len(replies), nil)
+			} else {
+				s.ExpectReply(command.ReplySenderOk)
+				s.ExpectReply(command.ReplyRecipientOk)
+			}
+
+			target.HandleSession(context.TODO(), s.Session)
+		})
+	}
+}
+
+func TestSessionHandler_Bdat(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+	conf := &config.SmtpConfig{
+		RejectIllegalPipelining: true,
+	}
+
+	tests := []struct {
+		name       string
+		input      string
+		pipelining bool
+		replies    []session.Reply
+	}{
+		{
+			name:    "chunk data follows the command without pipelining",
+			input:   "bdat 4 last\r\ntest",
+			replies: []session.Reply{command.ReplyDataOk},
+		},
+		{
+			name:    "command after chunk data without pipelining",
+			input:   "bdat 4 last\r\ntestmail from:<>\r\n",
+			replies: []session.Reply{command.ReplyDataOk, command.ReplyIllegalPipelining},
+		},
+		{
+			// pending replies are sent before the chunk, the reply of BDAT is sent at once
+			name:       "pipelined before BDAT",
+			input:      "mail from:<>\r\nbdat 4 last\r\ntest",
+			pipelining: true,
+			replies:    []session.Reply{command.ReplySenderOk, command.ReplyDataOk},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			mailHandler := mock.NewInitializedMockCommandHandler(ctrl, command.MAIL)
+			bdatHandler := mock.NewInitializedMockCommandHandler(ctrl, command.BDAT)
+
+			s.ExpectReply(command.ReplyGreet)
+
+			conn := oss.NewMockConn(ctrl)
+			conn.EXPECT().Close().Times(1)
+			s.Session.Conn = conn
+			s.Session.Pipelining = test.pipelining
+			target := NewSessionHandler(log, conf, []command.CommandHandler{mailHandler, bdatHandler}, mock.NewInitializedMockDnsblService(ctrl), mock.NewInitializedMockEarlyTalkerService(ctrl), mock.NewInitializedMockMilterService(ctrl), metrics.NewMetrics())
+
+			s.ExpectReadLine(test.input, nil)
+			mailHandler.EXPECT().HandleCommand(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, s *session.Session, arg []string) error {
+					return s.Reply(command.ReplySenderOk)
+				},
+			).AnyTimes()
+			bdatHandler.EXPECT().HandleCommand(gomock.Any(), gomock.Any(), []string{"4", "last"}).DoAndReturn(
+				func(ctx context.Context, s *session.Session, arg []string) error {
+					chunk, err := s.ReadChunk(4)
+					assert.Nil(t, err)
+					assert.Equal(t, "test", string(chunk))
+					return s.Reply(command.ReplyDataOk)
+				},
+			)
+			for _, reply := range test.replies {
+				s.ExpectReply(reply)
+			}
+
+			target.HandleSession(context.TODO(), s.Session)
+		})
+	}
+}
+
+func TestSessionHandler_MaxInvalidCommands(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+	conf := &config.SmtpConfig{
+		MaxInvalidCommands: 3,
+	}
+
+	s := session.NewMockSession(ctrl)
+	h := mock.NewInitializedMockCommandHandler(ctrl, command.MAIL)
+
+	s.ExpectReply(command.ReplyGreet)
+
+	conn := oss.NewMockConn(ctrl)
+	conn.EXPECT().Close().Times(1)
+	s.Session.Conn = conn
+	target := NewSessionHandler(log, conf, []command.CommandHandler{h}, mock.NewInitializedMockDnsblService(ctrl), mock.NewInitializedMockEarlyTalkerService(ctrl), mock.NewInitializedMockMilterService(ctrl), metrics.NewMetrics())
+
+	// successful command is not counted, the connection is closed before the last command
+	s.ExpectReadLine("foo\r\nmail from:<from@example.com>\r\n\r\nmail from:<>\r\nnoop\r\n", nil)
+	s.Session.Pipelining = true
+	h.EXPECT().HandleCommand(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, s *session.Session, arg []string) error {
+			return s.Reply(command.ReplySenderOk)
+		},
+	)
+	h.EXPECT().HandleCommand(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, s *session.Session, arg []string) error {
+			return s.Reply(command.ReplyBadSequence)
+		},
+	)
+	replies := []byte(command.ReplyCommandNotImplemented.String() +
+		command.ReplySenderOk.String() +
+		command.ReplySyntaxError.String() +
+		command.ReplyBadSequence.String() +
+		command.ReplyTooManyErrors.String())
+	s.Writer.EXPECT().Write(replies).Return(len(replies), nil)
+
+	target.HandleSession(context.TODO(), s.Session)
+	assert.Equal(t, 3, s.Session.InvalidCommands)
+	assert.True(t, s.Session.ShouldClose)
+}
+
+func TestSessionHandler_Dnsbl(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	tests := []struct {
+		name     string
+		result   *data.DnsblResult
+		rejected bool
+	}{
+		{
+			name:   "not listed",
+			result: &data.DnsblResult{Score: -1, Allowlisted: []string{"allow"}},
+		},
+		{
+			name:     "listed",
+			result:   &data.DnsblResult{Score: 3, Blocklisted: []string{"heavy", "light"}},
+			rejected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			conn := oss.NewMockConn(ctrl)
+			conn.EXPECT().Close().Times(1)
+			conn.EXPECT().RemoteAddr().Return(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 12345}).AnyTimes()
+			s.Session.Conn = conn
+
+			dnsbl := mock.NewMockDnsblService(ctrl)
+			dnsbl.EXPECT().Check(gomock.Any(), s.Session).Return(test.result, nil)
+			dnsbl.EXPECT().IsRejected(test.result).Return(test.rejected)
+
+			if test.rejected {
+				// no greeting is sent
+				s.ExpectReply(command.ReplyDnsblListed.WithLines("Service unavailable; client [192.0.2.1] blocked using heavy"))
+			} else {
+				s.ExpectReply(command.ReplyGreet)
+				s.ExpectReadLine("", io.EOF)
+			}
+
+			target := NewSessionHandler(log, &config.SmtpConfig{}, nil, dnsbl, mock.NewInitializedMockEarlyTalkerService(ctrl), mock.NewInitializedMockMilterService(ctrl), metrics.NewMetrics())
+			target.HandleSession(context.TODO(), s.Session)
+			assert.Equal(t, test.result.Score, s.Session.DnsblScore)
+			assert.Equal(t, test.result.Blocklisted, s.Session.DnsblListed)
+		})
+	}
+}
+
+func TestSessionHandler_EarlyTalker(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+
+	tests := []struct {
+		name   string
+		exempt bool
+		talk   bool
+		reply  session.Reply
+		label  string
+	}{
+		{
+			name:  "patient client",
+			reply: command.ReplyGreet,
+			label: "passed",
+		},
+		{
+			name:  "early talker",
+			talk:  true,
+			reply: command.ReplyEarlyTalker,
+			label: "rejected",
+		},
+		{
+			name:   "exempt client",
+			exempt: true,
+			reply:  command.ReplyGreet,
+			label:  "exempt",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			s := session.NewSessionFactory(log, &config.ServerConfig{}).CreateSession(server)
+
+			earlyTalker := mock.NewMockEarlyTalkerService(ctrl)
+			earlyTalker.EXPECT().GreetingDelay().Return(100 * time.Millisecond)
+			earlyTalker.EXPECT().IsExempt(s).Return(test.exempt)
+			m := metrics.NewMetrics()
+
+			target := NewSessionHandler(log, &config.SmtpConfig{}, nil, mock.NewInitializedMockDnsblService(ctrl), earlyTalker, mock.NewInitializedMockMilterService(ctrl), m)
+			done := make(chan struct{})
+			go func() {
+				target.HandleSession(context.TODO(), s)
+				close(done)
+			}()
+
+			if test.talk {
+				_, err := client.Write([]byte("EHLO example.com\r\n"))
+				assert.Nil(t, err)
+			}
+			reader := textproto.NewReader(bufio.NewReader(client))
+			line, err := reader.ReadLine()
+			assert.Nil(t, err)
+			assert.Equal(t, test.reply.String(), line+"\r\n")
+
+			client.Close()
+			<-done
+			assert.Equal(t, int64(1), m.Get(metrics.EarlyTalkers, test.label))
+		})
+	}
+}
+
+func TestSessionHandler_Milter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+	custom := session.NewReply(554, session.EnhancedCode{5, 7, 1}, "go away")
+
+	tests := []struct {
+		name  string
+		res   *data.MilterResult
+		reply session.Reply
+	}{
+		{
+			name:  "continue",
+			res:   &data.MilterResult{},
+			reply: command.ReplyGreet,
+		},
+		{
+			name:  "reject",
+			res:   &data.MilterResult{Action: data.MilterReject},
+			reply: command.ReplyMilterConnectRejected,
+		},
+		{
+			name:  "tempfail",
+			res:   &data.MilterResult{Action: data.MilterTempFail},
+			reply: command.ReplyMilterConnectTempFail,
+		},
+		{
+			name:  "reply by milter",
+			res:   &data.MilterResult{Action: data.MilterReject, Reply: &custom},
+			reply: custom,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := session.NewMockSession(ctrl)
+			conn := oss.NewMockConn(ctrl)
+			conn.EXPECT().Close().Times(1)
+			conn.EXPECT().RemoteAddr().Return(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 12345}).AnyTimes()
+			s.Session.Conn = conn
+
+			s.ExpectReply(test.reply)
+			if test.res.Action == data.MilterContinue {
+				s.ExpectReadLine("", io.EOF)
+			}
+
+			milter := mock.NewMockMilterService(ctrl)
+			milter.EXPECT().Connect(gomock.Any(), s.Session).Return(test.res)
+			// the milters are closed in any case
+			milter.EXPECT().Close(s.Session)
+
+			target := NewSessionHandler(log, &config.SmtpConfig{}, nil, mock.NewInitializedMockDnsblService(ctrl), mock.NewInitializedMockEarlyTalkerService(ctrl), milter, metrics.NewMetrics())
+			target.HandleSession(context.TODO(), s.Session)
+		})
+	}
+}
+
+func TestCommandArgument(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		verb   string
+		expect string
+	}{
+		{
+			name:   "no argument",
+			line:   "NOOP",
+			verb:   "NOOP",
+			expect: "",
+		},
+		{
+			name:   "whitespace in quoted string",
+			line:   `RCPT TO:<"john  doe"@example.com>`,
+			verb:   "RCPT",
+			expect: `TO:<"john  doe"@example.com>`,
+		},
+		{
+			name:   "surrounding whitespace",
+			line:   " MAIL  FROM:<from@example.com> SIZE=100 ",
+			verb:   "MAIL",
+			expect: "FROM:<from@example.com> SIZE=100",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expect, commandArgument(test.line, test.verb))
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package connection
+
+import (
+	"context"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/command"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+// upper limit of the delay when TarpitMaxDelay is not configured
+const defaultTarpitMaxDelay = 30 * time.Second
+
+// isStrike reports whether the reply means the client misbehaved.
+// Rejected recipients are counted so that dictionary attacks slow down.
+func isStrike(cmd string, code int) bool {
+	if isInvalidCommandReply(code) {
+		return true
+	}
+	return cmd == command.RCPT && code >= 500
+}
+
+// tarpitDelay returns the delay before handling the next command, it doubles on each strike.
+// The delay is limited by the remaining budget of the session.
+func (h *SessionHandler) tarpitDelay(s *session.Session) time.Duration {
+	if h.conf.TarpitDelay <= 0 || s.Strikes == 0 {
+		return 0
+	}
+	maxDelay := h.conf.TarpitMaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultTarpitMaxDelay
+	}
+
+	delay := h.conf.TarpitDelay
+	for i := 1; i < s.Strikes && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	if h.conf.TarpitBudget > 0 {
+		if remaining := h.conf.TarpitBudget - s.TarpitDelay; remaining < delay {
+			delay = remaining
+		}
+	}
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// tarpit delays the misbehaving client before the command is handled.
+func (h *SessionHandler) tarpit(ctx context.Context, s *session.Session) {
+	delay := h.tarpitDelay(s)
+	if delay <= 0 {
+		return
+	}
+	h.log.Debugf("[%s] tarpit for %s after %d strikes.", s.Id, delay, s.Strikes)
+	s.TarpitDelay += delay
+	h.sleep(ctx, delay)
+}
+
+// sleep waits for d, it returns early when ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
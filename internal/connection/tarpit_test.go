@@ -0,0 +1,181 @@
+package connection
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/command"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/metrics"
+	"github.com/Haya372/smtp-server/internal/mock"
+	"github.com/Haya372/smtp-server/internal/mock/oss"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsStrike(t *testing.T) {
+	assert.True(t, isStrike(command.MAIL, command.CodeSyntaxError))
+	assert.True(t, isStrike(command.RCPT, command.CodeMailboxUnavailable))
+	assert.True(t, isStrike(command.RCPT, command.CodeTransactionFail))
+	assert.False(t, isStrike(command.RCPT, command.CodeOk))
+	assert.False(t, isStrike(command.RCPT, command.CodeLocalError))
+	assert.False(t, isStrike(command.DATA, command.CodeTransactionFail))
+}
+
+func TestTarpitDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     *config.SmtpConfig
+		strikes  int
+		spent    time.Duration
+		expected time.Duration
+	}{
+		{
+			name:     "disabled",
+			conf:     &config.SmtpConfig{},
+			strikes:  3,
+			expected: 0,
+		},
+		{
+			name:     "no strike",
+			conf:     &config.SmtpConfig{TarpitDelay: time.Second},
+			expected: 0,
+		},
+		{
+			name:     "first strike",
+			conf:     &config.SmtpConfig{TarpitDelay: time.Second, TarpitMaxDelay: 10 * time.Second},
+			strikes:  1,
+			expected: time.Second,
+		},
+		{
+			name:     "doubled",
+			conf:     &config.SmtpConfig{TarpitDelay: time.Second, TarpitMaxDelay: 10 * time.Second},
+			strikes:  3,
+			expected: 4 * time.Second,
+		},
+		{
+			name:     "capped",
+			conf:     &config.SmtpConfig{TarpitDelay: time.Second, TarpitMaxDelay: 10 * time.Second},
+			strikes:  100,
+			expected: 10 * time.Second,
+		},
+		{
+			name:     "default cap",
+			conf:     &config.SmtpConfig{TarpitDelay: time.Second},
+			strikes:  100,
+			expected: defaultTarpitMaxDelay,
+		},
+		{
+			name:     "remaining budget",
+			conf:     &config.SmtpConfig{TarpitDelay: time.Second, TarpitMaxDelay: 10 * time.Second, TarpitBudget: time.Minute},
+			strikes:  5,
+			spent:    55 * time.Second,
+			expected: 5 * time.Second,
+		},
+		{
+			name:     "budget exhausted",
+			conf:     &config.SmtpConfig{TarpitDelay: time.Second, TarpitMaxDelay: 10 * time.Second, TarpitBudget: time.Minute},
+			strikes:  5,
+			spent:    time.Minute,
+			expected: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := SessionHandler{conf: test.conf}
+			s := &session.Session{Strikes: test.strikes, TarpitDelay: test.spent}
+			assert.Equal(t, test.expected, target.tarpitDelay(s))
+		})
+	}
+}
+
+func TestSessionHandler_Tarpit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+	conf := &config.SmtpConfig{
+		TarpitDelay:    time.Second,
+		TarpitMaxDelay: 10 * time.Second,
+		TarpitBudget:   time.Minute,
+	}
+
+	rcptHandler := mock.NewInitializedMockCommandHandler(ctrl, command.RCPT)
+	rcptHandler.EXPECT().HandleCommand(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, s *session.Session, arg []string) error {
+			return s.Reply(command.ReplyUnknownUser)
+		},
+	).Times(3)
+	quitHandler := mock.NewInitializedMockCommandHandler(ctrl, command.QUIT)
+	quitHandler.EXPECT().HandleCommand(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, s *session.Session, arg []string) error {
+			s.ShouldClose = true
+			return s.Reply(command.ReplyQuit)
+		},
+	)
+
+	s := session.NewMockSession(ctrl)
+	conn := oss.NewMockConn(ctrl)
+	conn.EXPECT().Close().Times(1)
+	s.Session.Conn = conn
+	s.ExpectReply(command.ReplyGreet)
+	s.ExpectReply(command.ReplyUnknownUser)
+	s.ExpectReply(command.ReplyUnknownUser)
+	s.ExpectReply(command.ReplyUnknownUser)
+	s.ExpectReply(command.ReplyQuit)
+	s.ExpectReadLine("rcpt to:<a@example.com>\r\nrcpt to:<b@example.com>\r\nrcpt to:<c@example.com>\r\nquit\r\n", nil)
+
+	target := NewSessionHandler(log, conf, []command.CommandHandler{rcptHandler, quitHandler}, mock.NewInitializedMockDnsblService(ctrl), mock.NewInitializedMockEarlyTalkerService(ctrl), mock.NewInitializedMockMilterService(ctrl), metrics.NewMetrics())
+	delays := make([]time.Duration, 0)
+	target.sleep = func(ctx context.Context, d time.Duration) {
+		delays = append(delays, d)
+	}
+
+	target.HandleSession(context.TODO(), s.Session)
+	// QUIT is not delayed
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, delays)
+	assert.Equal(t, 3, s.Session.Strikes)
+	assert.Equal(t, 3*time.Second, s.Session.TarpitDelay)
+}
+
+func TestSessionHandler_TarpitBlocklisted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+	conf := &config.SmtpConfig{
+		TarpitDelay: time.Second,
+	}
+
+	dnsbl := mock.NewMockDnsblService(ctrl)
+	dnsbl.EXPECT().Check(gomock.Any(), gomock.Any()).Return(&data.DnsblResult{Score: 0.5, Blocklisted: []string{"light"}}, nil)
+	dnsbl.EXPECT().IsRejected(gomock.Any()).Return(false)
+
+	noopHandler := mock.NewInitializedMockCommandHandler(ctrl, command.NOOP)
+	noopHandler.EXPECT().HandleCommand(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, s *session.Session, arg []string) error {
+			return s.Reply(command.ReplyOk)
+		},
+	)
+
+	s := session.NewMockSession(ctrl)
+	conn := oss.NewMockConn(ctrl)
+	conn.EXPECT().Close().Times(1)
+	s.Session.Conn = conn
+	s.ExpectReply(command.ReplyGreet)
+	s.ExpectReply(command.ReplyOk)
+	s.ExpectReadLine("noop\r\n", nil)
+
+	target := NewSessionHandler(log, conf, []command.CommandHandler{noopHandler}, dnsbl, mock.NewInitializedMockEarlyTalkerService(ctrl), mock.NewInitializedMockMilterService(ctrl), metrics.NewMetrics())
+	delays := make([]time.Duration, 0)
+	target.sleep = func(ctx context.Context, d time.Duration) {
+		delays = append(delays, d)
+	}
+
+	target.HandleSession(context.TODO(), s.Session)
+	assert.Equal(t, []time.Duration{time.Second}, delays)
+}
@@ -0,0 +1,17 @@
+package data
+
+// VirusAction is the decision on the message by the virus scanner.
+type VirusAction string
+
+const (
+	VirusClean    VirusAction = ""
+	VirusInfected VirusAction = "infected"
+	// the scanner is not available and the message is rejected with a temporary error
+	VirusTempFail VirusAction = "tempfail"
+)
+
+type VirusResult struct {
+	Action VirusAction
+	// name of the virus found in the message
+	Virus string
+}
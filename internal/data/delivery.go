@@ -0,0 +1,18 @@
+package data
+
+// DeliveryAction is the result of the local delivery.
+type DeliveryAction string
+
+const (
+	DeliveryOk DeliveryAction = ""
+	// Sieve scripts of all recipients reject the message
+	DeliveryReject DeliveryAction = "reject"
+	// no recipient could be delivered, the message is rejected with a temporary error
+	DeliveryTempFail DeliveryAction = "tempfail"
+)
+
+type DeliveryResult struct {
+	Action DeliveryAction
+	// reason given by the reject action
+	Reason string
+}
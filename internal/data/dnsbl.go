@@ -0,0 +1,11 @@
+package data
+
+// DnsblResult is the result of DNSBL and DNSWL lookups for the client.
+type DnsblResult struct {
+	// sum of the weights of blocklists minus the weights of allowlists
+	Score float64
+	// names of the blocklists which list the client, the heaviest one comes first
+	Blocklisted []string
+	// names of the allowlists which list the client
+	Allowlisted []string
+}
@@ -0,0 +1,326 @@
+package data
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+var (
+	Err8BitContent = errors.New("8-bit content is not permitted")
+	Err8BitHeader  = errors.New("8-bit header cannot be downgraded")
+)
+
+const (
+	// https://tex2e.github.io/rfc-translater/html/rfc2045.html#2-8--Base64-Content-Transfer-Encoding
+	base64LineLength = 76
+	// https://tex2e.github.io/rfc-translater/html/rfc5322.html#2-1-1--Line-Length-Limits
+	maxLineLength = 998
+)
+
+type downgradeMode int
+
+const (
+	// binary parts are encoded, 8-bit text is kept for 8BITMIME transport
+	downgradeBinary downgradeMode = iota
+	// binary and 8-bit parts are encoded for 7-bit transport
+	downgrade7Bit
+)
+
+// Has8BitData reports whether raw has octets outside of US-ASCII.
+func Has8BitData(raw []byte) bool {
+	for _, b := range raw {
+		if b >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+// PrepareFor7BitTransport makes the message deliverable to a server which does not advertise 8BITMIME.
+// When downgrade is false, Err8BitContent is returned for 8-bit content instead of converting it.
+// https://tex2e.github.io/rfc-translater/html/rfc6152.html#3--The-8bit-MIMEtransport-service-extension
+func (m *MimeData) PrepareFor7BitTransport(downgrade bool) error {
+	if !Has8BitData(m.RawData) {
+		return nil
+	}
+	if !downgrade {
+		return Err8BitContent
+	}
+
+	raw, err := downgradeTo7Bit(m.RawData)
+	if err != nil {
+		return err
+	}
+	m.RawData = raw
+	m.BodyType = session.Body7Bit
+	return nil
+}
+
+// PrepareForDataTransport converts the binary parts of BODY=BINARYMIME message to base64, so that the message can
+// be sent by DATA as 8BITMIME to a server which does not support BINARYMIME and CHUNKING.
+// https://tex2e.github.io/rfc-translater/html/rfc3030.html#3--Binary-MIME-Extension
+func (m *MimeData) PrepareForDataTransport() error {
+	if m.BodyType != session.BodyBinaryMime {
+		return nil
+	}
+
+	raw, err := downgradeToText(m.RawData)
+	if err != nil {
+		return err
+	}
+	m.RawData = raw
+	m.BodyType = session.Body8BitMime
+	return nil
+}
+
+// downgradeTo7Bit converts 8-bit MIME parts to quoted-printable (text) or base64 (others).
+func downgradeTo7Bit(raw []byte) ([]byte, error) {
+	return downgradeEntity(raw, downgrade7Bit)
+}
+
+// downgradeToText converts binary MIME parts to base64, 8-bit text parts are kept.
+func downgradeToText(raw []byte) ([]byte, error) {
+	return downgradeEntity(raw, downgradeBinary)
+}
+
+func downgradeEntity(raw []byte, mode downgradeMode) ([]byte, error) {
+	// message received by DATA has LF line endings, by BDAT has CRLF
+	eol := "\n"
+	if bytes.Contains(raw, []byte("\r\n")) {
+		eol = "\r\n"
+	}
+
+	e := parseEntity(raw, eol)
+	if err := e.downgrade(mode); err != nil {
+		return nil, err
+	}
+	return e.bytes(), nil
+}
+
+// isLineData reports whether body consists of lines which can be sent by DATA, that is no NUL, no bare CR or LF
+// and no line longer than 998 octets.
+// https://tex2e.github.io/rfc-translater/html/rfc2045.html#2-8--8bit-Data
+func isLineData(body []byte, eol string) bool {
+	for _, line := range bytes.Split(body, []byte(eol)) {
+		if len(line) > maxLineLength || bytes.ContainsAny(line, "\x00\r\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// MIME entity which keeps raw header fields in order
+type entity struct {
+	eol string
+	// raw header fields including folded lines
+	fields []string
+	body   []byte
+}
+
+func parseEntity(raw []byte, eol string) *entity {
+	e := &entity{eol: eol}
+	rest := raw
+	for len(rest) > 0 {
+		var line []byte
+		if idx := bytes.Index(rest, []byte(eol)); idx < 0 {
+			line, rest = rest, nil
+		} else {
+			line, rest = rest[:idx], rest[idx+len(eol):]
+		}
+		// end of header
+		if len(line) == 0 {
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(e.fields) > 0 {
+			e.fields[len(e.fields)-1] += eol + string(line)
+			continue
+		}
+		e.fields = append(e.fields, string(line))
+	}
+	e.body = rest
+	return e
+}
+
+func (e *entity) header(key string) string {
+	for _, field := range e.fields {
+		name, value, ok := strings.Cut(field, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), key) {
+			return strings.TrimSpace(strings.ReplaceAll(value, e.eol, ""))
+		}
+	}
+	return ""
+}
+
+func (e *entity) setHeader(key, value string) {
+	for idx, field := range e.fields {
+		name, _, ok := strings.Cut(field, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), key) {
+			e.fields[idx] = key + ": " + value
+			return
+		}
+	}
+	e.fields = append(e.fields, key+": "+value)
+}
+
+func (e *entity) bytes() []byte {
+	var buf bytes.Buffer
+	for _, field := range e.fields {
+		buf.WriteString(field)
+		buf.WriteString(e.eol)
+	}
+	buf.WriteString(e.eol)
+	buf.Write(e.body)
+	return buf.Bytes()
+}
+
+func (e *entity) downgrade(mode downgradeMode) error {
+	for _, field := range e.fields {
+		// non-ASCII header needs RFC 2047 encoding, which cannot be done without knowing its charset
+		if mode == downgrade7Bit && Has8BitData([]byte(field)) {
+			return Err8BitHeader
+		}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(e.header("Content-Type"))
+	if err != nil {
+		// https://tex2e.github.io/rfc-translater/html/rfc2045.html#5-2--Content-Type-Defaults
+		mediaType = "text/plain"
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		boundary := params["boundary"]
+		if len(boundary) == 0 {
+			return errors.New("multipart boundary not found")
+		}
+		body, err := downgradeMultipart(e.body, boundary, e.eol, mode)
+		if err != nil {
+			return err
+		}
+		e.body = body
+		return nil
+	case mediaType == "message/rfc822":
+		inner := parseEntity(e.body, e.eol)
+		if err := inner.downgrade(mode); err != nil {
+			return err
+		}
+		e.body = inner.bytes()
+		return nil
+	}
+
+	encoding := strings.ToLower(e.header("Content-Transfer-Encoding"))
+	binary := encoding == "binary" || !isLineData(e.body, e.eol)
+	if mode == downgradeBinary {
+		if !binary {
+			return nil
+		}
+		switch encoding {
+		case "base64", "quoted-printable":
+			// encoded body is always line data
+			return errors.New("binary data in " + encoding + " content")
+		}
+		e.body = encodeBase64(e.body, e.eol)
+		e.setHeader("Content-Transfer-Encoding", "base64")
+		return nil
+	}
+
+	if !binary && !Has8BitData(e.body) {
+		return nil
+	}
+	switch encoding {
+	case "base64", "quoted-printable":
+		// encoded body never has 8-bit octets
+		return Err8BitContent
+	}
+
+	// quoted-printable does not keep bare CR and LF of binary text
+	if strings.HasPrefix(mediaType, "text/") && !binary {
+		e.body = encodeQuotedPrintable(e.body, e.eol)
+		e.setHeader("Content-Transfer-Encoding", "quoted-printable")
+	} else {
+		e.body = encodeBase64(e.body, e.eol)
+		e.setHeader("Content-Transfer-Encoding", "base64")
+	}
+	return nil
+}
+
+// downgradeMultipart downgrades each body part, preamble and epilogue are kept as they are.
+func downgradeMultipart(body []byte, boundary, eol string, mode downgradeMode) ([]byte, error) {
+	delimiter := "--" + boundary
+	closeDelimiter := delimiter + "--"
+
+	res := make([]string, 0)
+	var part []string
+	inPart, closed := false, false
+	flush := func() error {
+		if !inPart {
+			return nil
+		}
+		e := parseEntity([]byte(strings.Join(part, eol)), eol)
+		if err := e.downgrade(mode); err != nil {
+			return err
+		}
+		res = append(res, string(e.bytes()))
+		return nil
+	}
+
+	for _, line := range strings.Split(string(body), eol) {
+		// transport padding is permitted after the boundary
+		trimmed := strings.TrimRight(line, " \t")
+		switch {
+		case closed || (!inPart && trimmed != delimiter):
+			// preamble or epilogue
+			res = append(res, line)
+		case trimmed == delimiter || trimmed == closeDelimiter:
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			res = append(res, line)
+			part = make([]string, 0)
+			inPart = trimmed == delimiter
+			closed = trimmed == closeDelimiter
+		default:
+			part = append(part, line)
+		}
+	}
+	if !closed {
+		return nil, errors.New("multipart close delimiter not found")
+	}
+	return []byte(strings.Join(res, eol)), nil
+}
+
+func encodeQuotedPrintable(body []byte, eol string) []byte {
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	w.Write(body)
+	w.Close()
+
+	// quotedprintable.Writer always uses CRLF
+	if eol == "\r\n" {
+		return buf.Bytes()
+	}
+	return bytes.ReplaceAll(buf.Bytes(), []byte("\r\n"), []byte(eol))
+}
+
+func encodeBase64(body []byte, eol string) []byte {
+	encoded := base64.StdEncoding.EncodeToString(body)
+
+	var buf bytes.Buffer
+	for len(encoded) > base64LineLength {
+		buf.WriteString(encoded[:base64LineLength])
+		buf.WriteString(eol)
+		encoded = encoded[base64LineLength:]
+	}
+	buf.WriteString(encoded)
+	// line break before the multipart delimiter belongs to the delimiter
+	if bytes.HasSuffix(body, []byte(eol)) {
+		buf.WriteString(eol)
+	}
+	return buf.Bytes()
+}
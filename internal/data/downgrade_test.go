@@ -0,0 +1,149 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHas8BitData(t *testing.T) {
+	assert.False(t, Has8BitData([]byte("Subject: test\r\n\r\nhello\r\n")))
+	assert.True(t, Has8BitData([]byte("Subject: test\r\n\r\nこんにちは\r\n")))
+}
+
+func TestPrepareFor7BitTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		downgrade bool
+		expect    string
+		expectErr error
+	}{
+		{
+			name:      "7-bit message is kept",
+			raw:       "Subject: test\r\n\r\nhello\r\n",
+			downgrade: false,
+			expect:    "Subject: test\r\n\r\nhello\r\n",
+		},
+		{
+			name:      "downgrade disabled",
+			raw:       "Subject: test\r\n\r\ncafé\r\n",
+			downgrade: false,
+			expectErr: Err8BitContent,
+		},
+		{
+			name:      "text body",
+			raw:       "Subject: test\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\ncafé\r\n",
+			downgrade: true,
+			expect:    "Subject: test\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\ncaf=C3=A9\r\n",
+		},
+		{
+			name:      "LF line endings",
+			raw:       "Subject: test\n\ncafé\n",
+			downgrade: true,
+			expect:    "Subject: test\nContent-Transfer-Encoding: quoted-printable\n\ncaf=C3=A9\n",
+		},
+		{
+			name: "multipart",
+			raw: "Content-Type: multipart/mixed; boundary=\"b1\"\r\n\r\n" +
+				"preamble\r\n" +
+				"--b1\r\nContent-Type: text/plain\r\n\r\nascii\r\n" +
+				"--b1\r\nContent-Type: text/plain; charset=utf-8\r\n\r\ncafé\r\n" +
+				"--b1\r\nContent-Type: application/octet-stream\r\n\r\n\xff\xfe\r\n" +
+				"--b1--\r\n",
+			downgrade: true,
+			expect: "Content-Type: multipart/mixed; boundary=\"b1\"\r\n\r\n" +
+				"preamble\r\n" +
+				"--b1\r\nContent-Type: text/plain\r\n\r\nascii\r\n" +
+				"--b1\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\ncaf=C3=A9\r\n" +
+				"--b1\r\nContent-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n\r\n//4=\r\n" +
+				"--b1--\r\n",
+		},
+		{
+			name:      "8-bit header",
+			raw:       "Subject: café\r\n\r\ncafé\r\n",
+			downgrade: true,
+			expectErr: Err8BitHeader,
+		},
+		{
+			name:      "8-bit in base64 part",
+			raw:       "Content-Transfer-Encoding: base64\r\n\r\ncafé\r\n",
+			downgrade: true,
+			expectErr: Err8BitContent,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &MimeData{RawData: []byte(test.raw), BodyType: session.Body8BitMime}
+
+			err := m.PrepareFor7BitTransport(test.downgrade)
+			if test.expectErr != nil {
+				assert.ErrorIs(t, err, test.expectErr)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expect, string(m.RawData))
+			assert.False(t, Has8BitData(m.RawData))
+		})
+	}
+}
+
+func TestPrepareForDataTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		bodyType  session.BodyType
+		expect    string
+		expectErr bool
+	}{
+		{
+			name:     "8-bit message is kept",
+			raw:      "Subject: test\r\n\r\ncafé\x00\r\n",
+			bodyType: session.Body8BitMime,
+			expect:   "Subject: test\r\n\r\ncafé\x00\r\n",
+		},
+		{
+			name:     "binary body",
+			raw:      "Subject: test\r\nContent-Type: application/octet-stream\r\nContent-Transfer-Encoding: binary\r\n\r\n\x00\xff\r\n",
+			bodyType: session.BodyBinaryMime,
+			expect:   "Subject: test\r\nContent-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n\r\nAP8NCg==\r\n",
+		},
+		{
+			name: "multipart",
+			raw: "Subject: café\r\nContent-Type: multipart/mixed; boundary=\"b1\"\r\n\r\n" +
+				"--b1\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\ncafé\r\n" +
+				"--b1\r\nContent-Type: text/plain\r\n\r\nbare\rCR\r\n" +
+				"--b1\r\nContent-Type: image/png\r\nContent-Transfer-Encoding: binary\r\n\r\n\x89PNG\r\n" +
+				"--b1--\r\n",
+			bodyType: session.BodyBinaryMime,
+			expect: "Subject: café\r\nContent-Type: multipart/mixed; boundary=\"b1\"\r\n\r\n" +
+				"--b1\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\ncafé\r\n" +
+				"--b1\r\nContent-Type: text/plain\r\nContent-Transfer-Encoding: base64\r\n\r\nYmFyZQ1DUg==\r\n" +
+				"--b1\r\nContent-Type: image/png\r\nContent-Transfer-Encoding: base64\r\n\r\niVBORw==\r\n" +
+				"--b1--\r\n",
+		},
+		{
+			name:      "binary data in base64 part",
+			raw:       "Content-Transfer-Encoding: base64\r\n\r\n\x00\r\n",
+			bodyType:  session.BodyBinaryMime,
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &MimeData{RawData: []byte(test.raw), BodyType: test.bodyType}
+
+			err := m.PrepareForDataTransport()
+			if test.expectErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expect, string(m.RawData))
+			assert.NotEqual(t, session.BodyBinaryMime, m.BodyType)
+		})
+	}
+}
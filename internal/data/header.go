@@ -0,0 +1,20 @@
+package data
+
+import "bytes"
+
+// PrependHeaders adds the header fields such as trace fields at the top of the message.
+// Line breaks follow the message, LF for DATA and CRLF for BDAT.
+func PrependHeaders(raw []byte, fields ...string) []byte {
+	eol := "\n"
+	if bytes.Contains(raw, []byte("\r\n")) {
+		eol = "\r\n"
+	}
+
+	var buf bytes.Buffer
+	for _, field := range fields {
+		buf.WriteString(field)
+		buf.WriteString(eol)
+	}
+	buf.Write(raw)
+	return buf.Bytes()
+}
@@ -0,0 +1,32 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrependHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{
+			name:     "lf",
+			raw:      "Subject: test\n\nbody\n",
+			expected: "X-A: 1\nX-B: 2\nSubject: test\n\nbody\n",
+		},
+		{
+			name:     "crlf",
+			raw:      "Subject: test\r\n\r\nbody\r\n",
+			expected: "X-A: 1\r\nX-B: 2\r\nSubject: test\r\n\r\nbody\r\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, string(PrependHeaders([]byte(test.raw), "X-A: 1", "X-B: 2")))
+		})
+	}
+}
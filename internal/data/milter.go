@@ -0,0 +1,22 @@
+package data
+
+import "github.com/Haya372/smtp-server/internal/session"
+
+// MilterAction is the decision of milters on a command or a message.
+type MilterAction string
+
+const (
+	MilterContinue MilterAction = ""
+	MilterReject   MilterAction = "reject"
+	MilterTempFail MilterAction = "tempfail"
+	// the message is accepted and thrown away
+	MilterDiscard MilterAction = "discard"
+)
+
+type MilterResult struct {
+	Action MilterAction
+	// reply given by the milter, the default reply of the command is used when nil
+	Reply *session.Reply
+	// name of the milter which decided the action
+	Milter string
+}
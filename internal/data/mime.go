@@ -14,10 +14,16 @@ type MimeData struct {
 	EnvelopeFrom *mail.Address
 	// envelope to address
 	EnvelopeTo []mail.Address
+	// envelope from address rewritten by SRS for forwarded recipients
+	ForwardFrom *mail.Address
 	// ehlo domain
 	SenderDomain string
 	// raw mime data
 	RawData []byte
+	// body type declared by BODY parameter of MAIL
+	BodyType session.BodyType
+	// the message must be delivered with SMTPUTF8
+	SmtpUtf8 bool
 	// authentication result
 	AuthResult AuthResult
 
@@ -34,7 +40,10 @@ func NewMimeData(session session.Session) *MimeData {
 	return &MimeData{
 		EnvelopeFrom: session.EnvelopeFrom,
 		EnvelopeTo:   session.EnvelopeTo,
+		ForwardFrom:  session.ForwardFrom,
 		SenderDomain: session.SenderDomain,
 		RawData:      session.RawData,
+		BodyType:     session.BodyType,
+		SmtpUtf8:     session.SmtpUtf8,
 	}
 }
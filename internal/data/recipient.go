@@ -0,0 +1,23 @@
+package data
+
+import "net/mail"
+
+type RecipientStatus int
+
+const (
+	RecipientAccepted RecipientStatus = iota
+	// the domain is local but the mailbox does not exist
+	RecipientUnknownUser
+	// the domain is not local and the client is not permitted to relay
+	RecipientRelayDenied
+	// aliases of the recipient refer to each other
+	RecipientLoop
+)
+
+type RecipientResult struct {
+	Status RecipientStatus
+	// mailboxes and forward addresses which the recipient is expanded to
+	Addresses []mail.Address
+	// some addresses are forwarded to other domains by aliases
+	Forwarded bool
+}
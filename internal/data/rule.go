@@ -0,0 +1,21 @@
+package data
+
+import "github.com/Haya372/smtp-server/internal/session"
+
+// RuleAction is the decision on the message by the content rules.
+type RuleAction string
+
+const (
+	RuleAccept RuleAction = ""
+	RuleReject RuleAction = "reject"
+)
+
+type RuleResult struct {
+	Action RuleAction
+	// reply given by the rule, the default reply is used when nil
+	Reply *session.Reply
+	// names of the matched rules
+	Rules []string
+	// total score of the matched rules
+	Score float64
+}
@@ -0,0 +1,21 @@
+package data
+
+// SpamAction is the decision on the message by the score of the spam scanner.
+type SpamAction string
+
+const (
+	SpamAccept SpamAction = ""
+	// X-Spam headers are added
+	SpamTag        SpamAction = "tag"
+	SpamQuarantine SpamAction = "quarantine"
+	SpamReject     SpamAction = "reject"
+	// the scanner is not available and the message is rejected with a temporary error
+	SpamTempFail SpamAction = "tempfail"
+)
+
+type SpamResult struct {
+	Action SpamAction
+	Score  float64
+	// names of the rules matched by the message
+	Symbols []string
+}
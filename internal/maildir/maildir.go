@@ -0,0 +1,106 @@
+package maildir
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Inbox is the folder name of the root of the Maildir.
+const Inbox = "INBOX"
+
+var deliveries atomic.Uint64
+
+// Maildir is a mailbox of Maildir++ format whose subfolders are ".Folder.Subfolder" in the root directory.
+// https://cr.yp.to/proto/maildir.html
+type Maildir struct {
+	root     string
+	hostname string
+}
+
+func New(root, hostname string) *Maildir {
+	// "/" and ":" are not allowed in the unique name
+	hostname = strings.NewReplacer("/", "\\057", ":", "\\072").Replace(hostname)
+	return &Maildir{root: root, hostname: hostname}
+}
+
+// Path returns the directory of the folder, folder names are separated by ".".
+func (m *Maildir) Path(folder string) (string, error) {
+	if len(folder) == 0 || strings.EqualFold(folder, Inbox) {
+		return m.root, nil
+	}
+	for _, name := range strings.Split(folder, ".") {
+		if len(name) == 0 || strings.ContainsAny(name, "/\\\x00") {
+			return "", fmt.Errorf("invalid folder name %q", folder)
+		}
+	}
+	return filepath.Join(m.root, "."+folder), nil
+}
+
+// Deliver writes the message into "new" of the folder, the folder is created when it does not exist.
+// Line breaks are converted to LF. The unique file name is returned.
+func (m *Maildir) Deliver(folder string, msg []byte) (string, error) {
+	dir, err := m.Path(folder)
+	if err != nil {
+		return "", err
+	}
+	if err := m.create(dir); err != nil {
+		return "", err
+	}
+
+	name := m.uniqueName()
+	tmp := filepath.Join(dir, "tmp", name)
+	if err := writeFile(tmp, bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "new", name)); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return name, nil
+}
+
+func (m *Maildir) create(dir string) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return err
+		}
+	}
+	if dir == m.root {
+		return nil
+	}
+	// marks the directory as a Maildir++ folder
+	f, err := os.OpenFile(filepath.Join(dir, "maildirfolder"), os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// uniqueName is "time.MmicrosecondsPpidQdeliveries.hostname".
+func (m *Maildir) uniqueName() string {
+	now := time.Now()
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), deliveries.Add(1), m.hostname)
+}
+
+// writeFile syncs the file before it is moved to "new".
+func writeFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
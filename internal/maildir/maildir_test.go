@@ -0,0 +1,60 @@
+package maildir
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaildir_Path(t *testing.T) {
+	m := New("/var/mail/bob", "mx.example.com")
+
+	tests := []struct {
+		folder   string
+		expected string
+		err      bool
+	}{
+		{folder: "", expected: "/var/mail/bob"},
+		{folder: "inbox", expected: "/var/mail/bob"},
+		{folder: "Lists.Dev", expected: "/var/mail/bob/.Lists.Dev"},
+		{folder: "../other", err: true},
+		{folder: "a/b", err: true},
+		{folder: ".hidden", err: true},
+		{folder: "a..b", err: true},
+	}
+
+	for _, test := range tests {
+		path, err := m.Path(test.folder)
+		assert.Equal(t, test.err, err != nil, test.folder)
+		assert.Equal(t, test.expected, path, test.folder)
+	}
+}
+
+func TestMaildir_Deliver(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "bob")
+	m := New(root, "mx/example:com")
+
+	name, err := m.Deliver(Inbox, []byte("Subject: test\r\n\r\nbody\r\n"))
+	assert.Nil(t, err)
+	assert.Contains(t, name, ".mx\\057example\\072com")
+	data, err := os.ReadFile(filepath.Join(root, "new", name))
+	assert.Nil(t, err)
+	assert.Equal(t, "Subject: test\n\nbody\n", string(data))
+	for _, sub := range []string{"tmp", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(root, sub))
+		assert.Nil(t, err)
+		assert.Empty(t, entries)
+	}
+
+	// names are unique in the same process
+	other, err := m.Deliver("Lists.Dev", []byte("Subject: test\n\nbody\n"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, name, other)
+	assert.FileExists(t, filepath.Join(root, ".Lists.Dev", "new", other))
+	assert.FileExists(t, filepath.Join(root, ".Lists.Dev", "maildirfolder"))
+
+	_, err = m.Deliver("../escape", []byte("Subject: test\n\nbody\n"))
+	assert.NotNil(t, err)
+}
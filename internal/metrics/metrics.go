@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// names of counters
+const (
+	// connections refused before the greeting, labeled by the reason
+	RejectedConnections = "rejected_connections"
+	// connections delayed before the greeting, labeled by "passed", "rejected" or "exempt"
+	EarlyTalkers = "early_talkers"
+)
+
+type Metrics interface {
+	// Inc increments the counter of name labeled by label.
+	Inc(name, label string)
+	// Get returns the current value of the counter.
+	Get(name, label string) int64
+	// ServeHTTP writes all counters as JSON.
+	http.Handler
+}
+
+type metricsImpl struct {
+	mu       sync.Mutex
+	counters map[string]map[string]int64
+}
+
+func (m *metricsImpl) Inc(name, label string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counter, ok := m.counters[name]
+	if !ok {
+		counter = make(map[string]int64)
+		m.counters[name] = counter
+	}
+	counter[label]++
+}
+
+func (m *metricsImpl) Get(name, label string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[name][label]
+}
+
+func (m *metricsImpl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	body, err := json.Marshal(m.counters)
+	m.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func NewMetrics() Metrics {
+	return &metricsImpl{
+		counters: make(map[string]map[string]int64),
+	}
+}
@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+
+	m.Inc(RejectedConnections, "ip")
+	m.Inc(RejectedConnections, "ip")
+	m.Inc(RejectedConnections, "subnet")
+
+	assert.Equal(t, int64(2), m.Get(RejectedConnections, "ip"))
+	assert.Equal(t, int64(1), m.Get(RejectedConnections, "subnet"))
+	assert.Equal(t, int64(0), m.Get(RejectedConnections, "global"))
+	assert.Equal(t, int64(0), m.Get("unknown", "ip"))
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"rejected_connections":{"ip":2,"subnet":1}}`, w.Body.String())
+}
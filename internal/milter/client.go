@@ -0,0 +1,366 @@
+package milter
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response is the final reply of the milter to a command.
+type Response struct {
+	// one of RespAccept, RespContinue, RespDiscard, RespReject, RespTempFail, RespReplyCode or RespSkip
+	Code byte
+	// SMTP reply such as "550 5.7.1 rejected" sent with RespReplyCode
+	Text string
+	// modifications requested at the end of message
+	Modifications []Modification
+}
+
+// Modification is a change of the message requested at the end of message.
+type Modification struct {
+	Code byte
+	// header index of RespChgHeader and RespInsHeader
+	Index uint32
+	// header name, or address of recipient and sender changes
+	Name string
+	// header value, or ESMTP arguments of recipient and sender changes
+	Value string
+	// replaced body
+	Body []byte
+}
+
+var continueResponse = &Response{Code: RespContinue}
+
+// Client is a connection to a milter, it is used by one SMTP session.
+type Client struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	// negotiated actions and protocol steps
+	actions  uint32
+	protocol uint32
+}
+
+// Dial connects to the milter and negotiates the protocol.
+// network is "tcp" or "unix", timeout is applied to each command.
+func Dial(ctx context.Context, network, address string, timeout time.Duration) (*Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}
+	if err := c.negotiate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) negotiate() error {
+	if err := c.send(CmdOptNeg, EncodeUint32s(Version, offeredActions, offeredProtocol)); err != nil {
+		return err
+	}
+	p, err := c.receive()
+	if err != nil {
+		return err
+	}
+	if p.Code != CmdOptNeg || len(p.Data) < 12 {
+		return fmt.Errorf("unexpected milter negotiation response %q", p.Code)
+	}
+	version := binary.BigEndian.Uint32(p.Data)
+	// milters of version 2 or later understand the negotiation
+	if version < 2 || version > Version {
+		return fmt.Errorf("unsupported milter version %d", version)
+	}
+	c.actions = binary.BigEndian.Uint32(p.Data[4:])
+	c.protocol = binary.BigEndian.Uint32(p.Data[8:])
+	if c.actions&^offeredActions != 0 {
+		return fmt.Errorf("milter requests unsupported actions 0x%x", c.actions&^offeredActions)
+	}
+	if c.protocol&^offeredProtocol != 0 {
+		return fmt.Errorf("milter requests unsupported protocol steps 0x%x", c.protocol&^offeredProtocol)
+	}
+	return nil
+}
+
+func (c *Client) send(code byte, data []byte) error {
+	if c.timeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+			return err
+		}
+	}
+	return WritePacket(c.conn, &Packet{Code: code, Data: data})
+}
+
+func (c *Client) receive() (*Packet, error) {
+	if c.timeout > 0 {
+		if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			return nil, err
+		}
+	}
+	return ReadPacket(c.reader)
+}
+
+// call sends the command and reads the response unless the milter has disabled the step (skip)
+// or its reply (noReply).
+func (c *Client) call(code byte, data []byte, skip, noReply uint32) (*Response, error) {
+	if c.protocol&skip != 0 {
+		return continueResponse, nil
+	}
+	if err := c.send(code, data); err != nil {
+		return nil, err
+	}
+	if c.protocol&noReply != 0 {
+		return continueResponse, nil
+	}
+	return c.response(false)
+}
+
+// response reads progress and modifications until the final response.
+func (c *Client) response(endOfMessage bool) (*Response, error) {
+	res := &Response{}
+	for {
+		p, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch p.Code {
+		case RespProgress:
+			continue
+		case RespAccept, RespContinue, RespDiscard, RespReject, RespTempFail, RespSkip:
+			res.Code = p.Code
+			return res, nil
+		case RespReplyCode:
+			res.Code = p.Code
+			res.Text = string(trimNul(p.Data))
+			return res, nil
+		}
+
+		if !endOfMessage {
+			return nil, fmt.Errorf("unexpected milter response %q", p.Code)
+		}
+		mod, err := c.modification(p)
+		if err != nil {
+			return nil, err
+		}
+		res.Modifications = append(res.Modifications, *mod)
+	}
+}
+
+// modification decodes the change, changes which are not negotiated are refused.
+func (c *Client) modification(p *Packet) (*Modification, error) {
+	var action uint32
+	mod := &Modification{Code: p.Code}
+	switch p.Code {
+	case RespAddHeader, RespInsHeader, RespChgHeader:
+		action = ActAddHeader
+		if p.Code == RespChgHeader {
+			action = ActChgHeader
+		}
+		values := decodeStrings(p.Data)
+		if p.Code != RespAddHeader {
+			index, v, err := decodeIndexed(p.Data)
+			if err != nil {
+				return nil, err
+			}
+			mod.Index = index
+			values = v
+		}
+		if len(values) < 1 {
+			return nil, fmt.Errorf("broken milter header change %q", p.Data)
+		}
+		mod.Name = values[0]
+		if len(values) > 1 {
+			mod.Value = values[1]
+		}
+	case RespReplBody:
+		action = ActChgBody
+		mod.Body = p.Data
+	case RespAddRcpt, RespDelRcpt, RespAddRcptPar, RespChgFrom:
+		switch p.Code {
+		case RespAddRcpt:
+			action = ActAddRcpt
+		case RespDelRcpt:
+			action = ActDelRcpt
+		case RespAddRcptPar:
+			action = ActAddRcptPar
+		case RespChgFrom:
+			action = ActChgFrom
+		}
+		values := decodeStrings(p.Data)
+		if len(values) < 1 {
+			return nil, fmt.Errorf("broken milter envelope change %q", p.Data)
+		}
+		mod.Name = values[0]
+		if len(values) > 1 {
+			mod.Value = values[1]
+		}
+	default:
+		return nil, fmt.Errorf("unexpected milter response %q", p.Code)
+	}
+	if c.actions&action == 0 {
+		return nil, fmt.Errorf("milter response %q is not negotiated", p.Code)
+	}
+	return mod, nil
+}
+
+func trimNul(data []byte) []byte {
+	for len(data) > 0 && data[len(data)-1] == 0 {
+		data = data[:len(data)-1]
+	}
+	return data
+}
+
+// Macros sends the values of macros such as "j" or "{mail_addr}" used by the next command.
+// names and values are given in pairs.
+func (c *Client) Macros(code byte, pairs ...string) error {
+	if len(pairs) == 0 {
+		return nil
+	}
+	return c.send(CmdMacro, append([]byte{code}, EncodeStrings(pairs...)...))
+}
+
+// Connect sends the information of the SMTP client, ip is nil when the address is unknown.
+func (c *Client) Connect(hostname string, ip net.IP, port int) (*Response, error) {
+	data := EncodeStrings(hostname)
+	switch {
+	case ip == nil:
+		data = append(data, 'U')
+	case ip.To4() != nil:
+		data = append(data, '4')
+	default:
+		data = append(data, '6')
+	}
+	if ip != nil {
+		data = binary.BigEndian.AppendUint16(data, uint16(port))
+		data = append(data, EncodeStrings(ip.String())...)
+	}
+	return c.call(CmdConnect, data, ProtoNoConnect, ProtoNoReplyConnect)
+}
+
+func (c *Client) Helo(helo string) (*Response, error) {
+	return c.call(CmdHelo, EncodeStrings(helo), ProtoNoHelo, ProtoNoReplyHelo)
+}
+
+// Mail sends the sender such as "<from@example.com>" and ESMTP arguments.
+func (c *Client) Mail(from string, args []string) (*Response, error) {
+	return c.call(CmdMail, EncodeStrings(append([]string{from}, args...)...), ProtoNoMail, ProtoNoReplyMail)
+}
+
+// Rcpt sends the recipient such as "<to@example.com>" and ESMTP arguments.
+func (c *Client) Rcpt(rcpt string, args []string) (*Response, error) {
+	return c.call(CmdRcpt, EncodeStrings(append([]string{rcpt}, args...)...), ProtoNoRcpt, ProtoNoReplyRcpt)
+}
+
+func (c *Client) Data() (*Response, error) {
+	return c.call(CmdData, nil, ProtoNoData, ProtoNoReplyData)
+}
+
+// Header sends a header field, value has no leading space.
+func (c *Client) Header(name, value string) (*Response, error) {
+	return c.call(CmdHeader, EncodeStrings(name, value), ProtoNoHeaders, ProtoNoReplyHeader)
+}
+
+func (c *Client) EndOfHeaders() (*Response, error) {
+	return c.call(CmdEndOfHdrs, nil, ProtoNoEndOfHdrs, ProtoNoReplyEndOfHdrs)
+}
+
+// Body sends the body in chunks, the rest is not sent when the milter replies other than continue.
+func (c *Client) Body(body []byte) (*Response, error) {
+	for len(body) > 0 {
+		n := len(body)
+		if n > maxBodyChunk {
+			n = maxBodyChunk
+		}
+		res, err := c.call(CmdBody, body[:n], ProtoNoBody, ProtoNoReplyBody)
+		if err != nil {
+			return nil, err
+		}
+		if res.Code != RespContinue {
+			return res, nil
+		}
+		body = body[n:]
+	}
+	return continueResponse, nil
+}
+
+// EndOfMessage finishes the message, the response has the modifications of the message.
+func (c *Client) EndOfMessage() (*Response, error) {
+	if err := c.send(CmdEndOfBody, nil); err != nil {
+		return nil, err
+	}
+	return c.response(true)
+}
+
+// Abort cancels the message, the milter waits for the next message of the connection.
+func (c *Client) Abort() error {
+	return c.send(CmdAbort, nil)
+}
+
+// Close says goodbye to the milter and closes the connection.
+func (c *Client) Close() error {
+	c.send(CmdQuit, nil)
+	return c.conn.Close()
+}
+
+// String returns the address of the milter for logging.
+func (c *Client) String() string {
+	return c.conn.RemoteAddr().Network() + ":" + c.conn.RemoteAddr().String()
+}
+
+// ParseReplyCode splits the text of RespReplyCode such as "550 5.7.1 rejected" into the reply code,
+// the enhanced status code and the lines. The enhanced status code is zero when the text has none.
+func ParseReplyCode(text string) (int, [3]int, []string, error) {
+	var enhanced [3]int
+	if len(text) < 3 {
+		return 0, enhanced, nil, fmt.Errorf("invalid milter reply %q", text)
+	}
+	code, err := strconv.Atoi(text[:3])
+	if err != nil || code < 400 || code >= 600 {
+		return 0, enhanced, nil, fmt.Errorf("invalid milter reply %q", text)
+	}
+
+	lines := make([]string, 0)
+	for i, line := range splitLines(text) {
+		// multiline replies repeat the code, e.g. "550-5.7.1 first\r\n550 5.7.1 last"
+		if strings.HasPrefix(line, text[:3]) {
+			line = strings.TrimLeft(line[3:], " -")
+		}
+		if e, rest, ok := cutEnhancedCode(line); ok {
+			if i == 0 {
+				enhanced = e
+			}
+			line = rest
+		}
+		lines = append(lines, line)
+	}
+	return code, enhanced, lines, nil
+}
+
+// cutEnhancedCode removes the leading enhanced status code such as "5.7.1 ".
+func cutEnhancedCode(line string) ([3]int, string, bool) {
+	var enhanced [3]int
+	field, rest, _ := strings.Cut(line, " ")
+	parts := strings.Split(field, ".")
+	if len(parts) != 3 {
+		return enhanced, line, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return enhanced, line, false
+		}
+		enhanced[i] = n
+	}
+	return enhanced, rest, true
+}
@@ -0,0 +1,242 @@
+package milter_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/milter"
+	"github.com/Haya372/smtp-server/internal/milter/miltertest"
+	"github.com/stretchr/testify/assert"
+)
+
+// largest body data sent in a command by the client
+const bodyChunkSize = 65535
+
+func newTestClient(t *testing.T, actions, protocol uint32, handler func(cmd *milter.Packet) []*milter.Packet) (*milter.Client, *miltertest.Milter) {
+	f, err := miltertest.NewMilter(actions, protocol, handler)
+	assert.Nil(t, err)
+	t.Cleanup(f.Close)
+
+	c, err := milter.Dial(context.Background(), "tcp", f.Addr(), time.Second)
+	assert.Nil(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c, f
+}
+
+func TestDial_UnsupportedActions(t *testing.T) {
+	f, err := miltertest.NewMilter(milter.ActQuarantine, 0, func(cmd *milter.Packet) []*milter.Packet { return nil })
+	assert.Nil(t, err)
+	defer f.Close()
+
+	_, err = milter.Dial(context.Background(), "tcp", f.Addr(), time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestClient_Commands(t *testing.T) {
+	c, f := newTestClient(t, 0, 0, func(cmd *milter.Packet) []*milter.Packet { return nil })
+
+	assert.Nil(t, c.Macros(milter.CmdConnect, "j", "mx.example.com"))
+	res, err := c.Connect("[192.0.2.1]", net.ParseIP("192.0.2.1"), 25)
+	assert.Nil(t, err)
+	assert.Equal(t, milter.RespContinue, res.Code)
+	res, err = c.Helo("client.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, milter.RespContinue, res.Code)
+	res, err = c.Mail("<a@example.com>", []string{"BODY=8BITMIME"})
+	assert.Nil(t, err)
+	assert.Equal(t, milter.RespContinue, res.Code)
+	res, err = c.Rcpt("<b@example.com>", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, milter.RespContinue, res.Code)
+	res, err = c.Data()
+	assert.Nil(t, err)
+	assert.Equal(t, milter.RespContinue, res.Code)
+	c.Close()
+
+	// the fake milter receives QUIT asynchronously
+	assert.Eventually(t, func() bool { return f.CommandCodes() == "DCHMRTQ" }, time.Second, 10*time.Millisecond)
+
+	commands := f.Commands()
+	assert.Equal(t, []string{"j", "mx.example.com"}, (&milter.Packet{Data: commands[0].Data[1:]}).Strings())
+	assert.Equal(t, append(append(milter.EncodeStrings("[192.0.2.1]"), '4', 0, 25), milter.EncodeStrings("192.0.2.1")...), commands[1].Data)
+	assert.Equal(t, []string{"<a@example.com>", "BODY=8BITMIME"}, commands[3].Strings())
+	assert.Equal(t, []string{"<b@example.com>"}, commands[4].Strings())
+}
+
+func TestClient_NegotiatedSteps(t *testing.T) {
+	c, f := newTestClient(t, 0, milter.ProtoNoHelo|milter.ProtoNoReplyMail, func(cmd *milter.Packet) []*milter.Packet {
+		return []*milter.Packet{{Code: milter.RespReject}}
+	})
+
+	// skipped step is not sent
+	res, err := c.Helo("client.example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, milter.RespContinue, res.Code)
+	// the milter does not reply
+	res, err = c.Mail("<a@example.com>", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, milter.RespContinue, res.Code)
+	res, err = c.Rcpt("<b@example.com>", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, milter.RespReject, res.Code)
+
+	assert.Equal(t, "MR", f.CommandCodes())
+}
+
+func TestClient_Body(t *testing.T) {
+	body := make([]byte, bodyChunkSize+10)
+	calls := 0
+	c, f := newTestClient(t, 0, 0, func(cmd *milter.Packet) []*milter.Packet {
+		calls++
+		if calls == 3 {
+			return []*milter.Packet{{Code: milter.RespSkip}}
+		}
+		return nil
+	})
+
+	res, err := c.Body(body)
+	assert.Nil(t, err)
+	assert.Equal(t, milter.RespContinue, res.Code)
+	res, err = c.Body(append(body, body...))
+	assert.Nil(t, err)
+	assert.Equal(t, milter.RespSkip, res.Code)
+
+	// the second body is stopped at the first chunk by skip
+	commands := f.Commands()
+	assert.Equal(t, "BBB", f.CommandCodes())
+	assert.Equal(t, bodyChunkSize, len(commands[0].Data))
+	assert.Equal(t, 10, len(commands[1].Data))
+}
+
+func TestClient_EndOfMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		actions  uint32
+		replies  []*milter.Packet
+		expected *milter.Response
+		err      bool
+	}{
+		{
+			name:    "modifications",
+			actions: milter.ActAddHeader | milter.ActChgHeader | milter.ActChgBody | milter.ActAddRcpt | milter.ActDelRcpt | milter.ActChgFrom,
+			replies: []*milter.Packet{
+				{Code: milter.RespProgress},
+				miltertest.AddHeader("X-Spam", "yes"),
+				miltertest.InsertHeader(0, "Received", "by filter"),
+				miltertest.ChangeHeader(1, "Subject", ""),
+				miltertest.ReplaceBody([]byte("new body")),
+				miltertest.AddRcpt("<c@example.com>"),
+				miltertest.DeleteRcpt("<b@example.com>"),
+				miltertest.ChangeFrom("<d@example.com>"),
+				{Code: milter.RespAccept},
+			},
+			expected: &milter.Response{
+				Code: milter.RespAccept,
+				Modifications: []milter.Modification{
+					{Code: milter.RespAddHeader, Name: "X-Spam", Value: "yes"},
+					{Code: milter.RespInsHeader, Index: 0, Name: "Received", Value: "by filter"},
+					{Code: milter.RespChgHeader, Index: 1, Name: "Subject"},
+					{Code: milter.RespReplBody, Body: []byte("new body")},
+					{Code: milter.RespAddRcpt, Name: "<c@example.com>"},
+					{Code: milter.RespDelRcpt, Name: "<b@example.com>"},
+					{Code: milter.RespChgFrom, Name: "<d@example.com>"},
+				},
+			},
+		},
+		{
+			name:     "reply code",
+			replies:  []*milter.Packet{miltertest.ReplyCode("550 5.7.1 spam")},
+			expected: &milter.Response{Code: milter.RespReplyCode, Text: "550 5.7.1 spam"},
+		},
+		{
+			name:    "not negotiated modification",
+			actions: milter.ActAddHeader,
+			replies: []*milter.Packet{miltertest.ReplaceBody([]byte("new body")), {Code: milter.RespContinue}},
+			err:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := newTestClient(t, test.actions, 0, func(cmd *milter.Packet) []*milter.Packet {
+				return test.replies
+			})
+
+			res, err := c.EndOfMessage()
+			if test.err {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	f, err := miltertest.NewMilter(0, 0, func(cmd *milter.Packet) []*milter.Packet {
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	})
+	assert.Nil(t, err)
+	defer f.Close()
+
+	c, err := milter.Dial(context.Background(), "tcp", f.Addr(), 50*time.Millisecond)
+	assert.Nil(t, err)
+	defer c.Close()
+
+	_, err = c.Helo("client.example.com")
+	assert.NotNil(t, err)
+}
+
+func TestParseReplyCode(t *testing.T) {
+	tests := []struct {
+		text     string
+		code     int
+		enhanced [3]int
+		lines    []string
+		err      bool
+	}{
+		{
+			text:     "550 5.7.1 rejected as spam",
+			code:     550,
+			enhanced: [3]int{5, 7, 1},
+			lines:    []string{"rejected as spam"},
+		},
+		{
+			text:  "451 try later",
+			code:  451,
+			lines: []string{"try later"},
+		},
+		{
+			text:     "550-5.7.1 first\r\n550 5.7.1 last",
+			code:     550,
+			enhanced: [3]int{5, 7, 1},
+			lines:    []string{"first", "last"},
+		},
+		{
+			text: "250 ok",
+			err:  true,
+		},
+		{
+			text: "5x",
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			code, enhanced, lines, err := milter.ParseReplyCode(test.text)
+			if test.err {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.code, code)
+			assert.Equal(t, test.enhanced, enhanced)
+			assert.Equal(t, test.lines, lines)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package milter
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Header is a header field of the message.
+type Header struct {
+	Name string
+	// text after the colon with the line breaks of folding, the leading space is kept
+	Value string
+}
+
+// Message is split into the header fields and the body to send them to milters and apply their changes.
+// Line breaks of the original message (CRLF or LF) are kept.
+type Message struct {
+	Headers []Header
+	Body    []byte
+	eol     string
+	// the message has the empty line between the header section and the body
+	separator bool
+}
+
+// ParseMessage splits the raw message, lines which are not header fields are treated as the body.
+func ParseMessage(raw []byte) *Message {
+	m := &Message{eol: "\n"}
+	if bytes.Contains(raw, []byte("\r\n")) {
+		m.eol = "\r\n"
+	}
+
+	rest := raw
+	for len(rest) > 0 {
+		line, next := cutLine(rest, m.eol)
+		if len(line) == 0 {
+			// empty line separates the body
+			m.separator = true
+			rest = next
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(m.Headers) > 0 {
+			// folded line
+			m.Headers[len(m.Headers)-1].Value += m.eol + string(line)
+			rest = next
+			continue
+		}
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 {
+			// not a header field, the message has no header section
+			break
+		}
+		m.Headers = append(m.Headers, Header{Name: string(line[:colon]), Value: string(line[colon+1:])})
+		rest = next
+	}
+	m.Body = rest
+	return m
+}
+
+func cutLine(data []byte, eol string) ([]byte, []byte) {
+	i := bytes.Index(data, []byte(eol))
+	if i < 0 {
+		return data, nil
+	}
+	return data[:i], data[i+len(eol):]
+}
+
+// Bytes returns the raw message.
+func (m *Message) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, h := range m.Headers {
+		buf.WriteString(h.Name + ":" + h.Value + m.eol)
+	}
+	if m.separator || len(m.Headers) > 0 {
+		buf.WriteString(m.eol)
+	}
+	buf.Write(m.Body)
+	return buf.Bytes()
+}
+
+// MilterValue returns the header value sent to milters, it has no leading space and folds are LF.
+func (h Header) MilterValue() string {
+	value := strings.ReplaceAll(h.Value, "\r\n", "\n")
+	return strings.TrimPrefix(value, " ")
+}
+
+// MilterBody returns the body sent to milters, whose line breaks are CRLF.
+func (m *Message) MilterBody() []byte {
+	if m.eol == "\r\n" {
+		return m.Body
+	}
+	return bytes.ReplaceAll(m.Body, []byte("\n"), []byte("\r\n"))
+}
+
+// header value given by milters, the leading space is added and line breaks follow the message
+func (m *Message) headerValue(value string) string {
+	value = strings.ReplaceAll(value, "\r\n", "\n")
+	return " " + strings.ReplaceAll(value, "\n", m.eol)
+}
+
+// Apply applies the header and body changes in order, envelope changes are ignored.
+func (m *Message) Apply(mods []Modification) {
+	var body [][]byte
+	for _, mod := range mods {
+		switch mod.Code {
+		case RespAddHeader:
+			m.Headers = append(m.Headers, Header{Name: mod.Name, Value: m.headerValue(mod.Value)})
+		case RespInsHeader:
+			index := int(mod.Index)
+			if index > len(m.Headers) {
+				index = len(m.Headers)
+			}
+			inserted := Header{Name: mod.Name, Value: m.headerValue(mod.Value)}
+			m.Headers = append(m.Headers[:index], append([]Header{inserted}, m.Headers[index:]...)...)
+		case RespChgHeader:
+			m.changeHeader(int(mod.Index), mod.Name, mod.Value)
+		case RespReplBody:
+			// the replaced body is sent in chunks
+			body = append(body, mod.Body)
+		}
+	}
+	if body != nil {
+		m.replaceBody(body)
+	}
+}
+
+// changeHeader replaces the index-th (1-origin) header of the name, the header is deleted when value is empty.
+// The header is added when the message has less headers of the name.
+func (m *Message) changeHeader(index int, name, value string) {
+	// index 0 is treated as 1 as sendmail does
+	if index < 1 {
+		index = 1
+	}
+	count := 0
+	for i, h := range m.Headers {
+		if !strings.EqualFold(h.Name, name) {
+			continue
+		}
+		count++
+		if count != index {
+			continue
+		}
+		if len(value) == 0 {
+			m.Headers = append(m.Headers[:i], m.Headers[i+1:]...)
+		} else {
+			m.Headers[i].Value = m.headerValue(value)
+		}
+		return
+	}
+	if len(value) > 0 {
+		m.Headers = append(m.Headers, Header{Name: name, Value: m.headerValue(value)})
+	}
+}
+
+// replaceBody replaces the body, CRLF of milters is converted to the line breaks of the message.
+func (m *Message) replaceBody(chunks [][]byte) {
+	body := bytes.Join(chunks, nil)
+	if m.eol != "\r\n" {
+		body = bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n"))
+	}
+	m.Body = body
+}
+
+// splitLines splits the text by CRLF or LF.
+func splitLines(text string) []string {
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
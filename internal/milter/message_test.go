@@ -0,0 +1,145 @@
+package milter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		headers []Header
+		body    string
+	}{
+		{
+			name: "crlf",
+			raw:  "From: a@example.com\r\nSubject: hello\r\n\r\nbody\r\n",
+			headers: []Header{
+				{Name: "From", Value: " a@example.com"},
+				{Name: "Subject", Value: " hello"},
+			},
+			body: "body\r\n",
+		},
+		{
+			name: "lf with folded header",
+			raw:  "Subject: hello\n world\nTo: b@example.com\n\nbody\n",
+			headers: []Header{
+				{Name: "Subject", Value: " hello\n world"},
+				{Name: "To", Value: " b@example.com"},
+			},
+			body: "body\n",
+		},
+		{
+			name: "no header section",
+			raw:  "body only\n",
+			body: "body only\n",
+		},
+		{
+			name: "no body",
+			raw:  "Subject: hello\r\n",
+			headers: []Header{
+				{Name: "Subject", Value: " hello"},
+			},
+			body: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := ParseMessage([]byte(test.raw))
+			assert.Equal(t, test.headers, m.Headers)
+			assert.Equal(t, test.body, string(m.Body))
+		})
+	}
+}
+
+func TestMessage_Bytes(t *testing.T) {
+	tests := []string{
+		"From: a@example.com\r\nSubject: hello\r\n world\r\n\r\nbody\r\n",
+		"Subject: hello\n\nbody\n",
+		"Subject: hello\n\n",
+		"body only\n",
+	}
+
+	for _, raw := range tests {
+		assert.Equal(t, raw, string(ParseMessage([]byte(raw)).Bytes()))
+	}
+}
+
+func TestMessage_MilterValues(t *testing.T) {
+	m := ParseMessage([]byte("Subject: hello\n world\n\nline1\nline2\n"))
+
+	assert.Equal(t, "hello\n world", m.Headers[0].MilterValue())
+	assert.Equal(t, "line1\r\nline2\r\n", string(m.MilterBody()))
+}
+
+func TestMessage_Apply(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		mods     []Modification
+		expected string
+	}{
+		{
+			name:     "add header",
+			raw:      "Subject: hello\r\n\r\nbody\r\n",
+			mods:     []Modification{{Code: RespAddHeader, Name: "X-Spam", Value: "yes\nno"}},
+			expected: "Subject: hello\r\nX-Spam: yes\r\nno\r\n\r\nbody\r\n",
+		},
+		{
+			name:     "insert header",
+			raw:      "Subject: hello\n\nbody\n",
+			mods:     []Modification{{Code: RespInsHeader, Index: 0, Name: "Received", Value: "by filter"}},
+			expected: "Received: by filter\nSubject: hello\n\nbody\n",
+		},
+		{
+			name:     "insert header beyond the last",
+			raw:      "Subject: hello\n\nbody\n",
+			mods:     []Modification{{Code: RespInsHeader, Index: 5, Name: "X-Last", Value: "1"}},
+			expected: "Subject: hello\nX-Last: 1\n\nbody\n",
+		},
+		{
+			name:     "change second header",
+			raw:      "X-A: 1\nX-B: 2\nx-a: 3\n\nbody\n",
+			mods:     []Modification{{Code: RespChgHeader, Index: 2, Name: "X-A", Value: "changed"}},
+			expected: "X-A: 1\nX-B: 2\nx-a: changed\n\nbody\n",
+		},
+		{
+			name:     "delete header",
+			raw:      "X-A: 1\nX-B: 2\n\nbody\n",
+			mods:     []Modification{{Code: RespChgHeader, Index: 1, Name: "X-A"}},
+			expected: "X-B: 2\n\nbody\n",
+		},
+		{
+			name:     "change missing header adds it",
+			raw:      "X-A: 1\n\nbody\n",
+			mods:     []Modification{{Code: RespChgHeader, Index: 1, Name: "X-B", Value: "2"}},
+			expected: "X-A: 1\nX-B: 2\n\nbody\n",
+		},
+		{
+			name: "replace body in chunks",
+			raw:  "X-A: 1\n\nbody\n",
+			mods: []Modification{
+				{Code: RespReplBody, Body: []byte("new\r\n")},
+				{Code: RespReplBody, Body: []byte("body\r\n")},
+			},
+			expected: "X-A: 1\n\nnew\nbody\n",
+		},
+		{
+			name:     "envelope changes are ignored",
+			raw:      "X-A: 1\n\nbody\n",
+			mods:     []Modification{{Code: RespAddRcpt, Name: "<c@example.com>"}},
+			expected: "X-A: 1\n\nbody\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := ParseMessage([]byte(test.raw))
+			m.Apply(test.mods)
+			assert.Equal(t, test.expected, string(m.Bytes()))
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package miltertest
+
+import (
+	"bufio"
+	"net"
+	"sync"
+
+	"github.com/Haya372/smtp-server/internal/milter"
+)
+
+// Milter is an in-process milter for tests.
+// It records the commands and replies by Handler, continue is replied when Handler returns nil.
+type Milter struct {
+	// negotiated actions and protocol steps
+	Actions  uint32
+	Protocol uint32
+	// Handler returns the packets replied to the command, the last one must be a final response
+	Handler func(cmd *milter.Packet) []*milter.Packet
+
+	ln       net.Listener
+	mu       sync.Mutex
+	commands []*milter.Packet
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewMilter listens on a TCP port of localhost.
+func NewMilter(actions, protocol uint32, handler func(cmd *milter.Packet) []*milter.Packet) (*Milter, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	m := &Milter{
+		Actions:  actions,
+		Protocol: protocol,
+		Handler:  handler,
+		ln:       ln,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	m.wg.Add(1)
+	go m.serve()
+	return m, nil
+}
+
+func (m *Milter) Addr() string {
+	return m.ln.Addr().String()
+}
+
+// Commands returns the received commands except the negotiation.
+func (m *Milter) Commands() []*milter.Packet {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*milter.Packet{}, m.commands...)
+}
+
+// CommandCodes returns the codes of received commands, e.g. "DCHMR".
+func (m *Milter) CommandCodes() string {
+	codes := make([]byte, 0)
+	for _, cmd := range m.Commands() {
+		codes = append(codes, cmd.Code)
+	}
+	return string(codes)
+}
+
+// Close stops the milter and closes the connections from MTAs.
+func (m *Milter) Close() {
+	m.ln.Close()
+	m.mu.Lock()
+	m.closed = true
+	for conn := range m.conns {
+		conn.Close()
+	}
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+func (m *Milter) serve() {
+	defer m.wg.Done()
+	for {
+		conn, err := m.ln.Accept()
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			conn.Close()
+			return
+		}
+		m.conns[conn] = struct{}{}
+		m.wg.Add(1)
+		m.mu.Unlock()
+		go func() {
+			defer m.wg.Done()
+			defer func() {
+				m.mu.Lock()
+				delete(m.conns, conn)
+				m.mu.Unlock()
+				conn.Close()
+			}()
+			m.handle(conn)
+		}()
+	}
+}
+
+func (m *Milter) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		cmd, err := milter.ReadPacket(reader)
+		if err != nil {
+			return
+		}
+		if cmd.Code == milter.CmdOptNeg {
+			milter.WritePacket(conn, &milter.Packet{Code: milter.CmdOptNeg, Data: milter.EncodeUint32s(milter.Version, m.Actions, m.Protocol)})
+			continue
+		}
+
+		m.mu.Lock()
+		m.commands = append(m.commands, cmd)
+		m.mu.Unlock()
+
+		if cmd.Code == milter.CmdQuit {
+			return
+		}
+		if !m.replies(cmd.Code) {
+			continue
+		}
+		packets := m.Handler(cmd)
+		if packets == nil {
+			packets = []*milter.Packet{{Code: milter.RespContinue}}
+		}
+		for _, p := range packets {
+			if err := milter.WritePacket(conn, p); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// replies reports whether the MTA waits for the reply to the command.
+func (m *Milter) replies(code byte) bool {
+	noReply := map[byte]uint32{
+		milter.CmdConnect:   milter.ProtoNoReplyConnect,
+		milter.CmdHelo:      milter.ProtoNoReplyHelo,
+		milter.CmdMail:      milter.ProtoNoReplyMail,
+		milter.CmdRcpt:      milter.ProtoNoReplyRcpt,
+		milter.CmdData:      milter.ProtoNoReplyData,
+		milter.CmdHeader:    milter.ProtoNoReplyHeader,
+		milter.CmdEndOfHdrs: milter.ProtoNoReplyEndOfHdrs,
+		milter.CmdBody:      milter.ProtoNoReplyBody,
+	}
+	switch code {
+	case milter.CmdMacro, milter.CmdAbort, milter.CmdQuit:
+		return false
+	case milter.CmdEndOfBody:
+		return true
+	}
+	flag, ok := noReply[code]
+	return ok && m.Protocol&flag == 0
+}
+
+// responses of the fake milter
+
+func AddHeader(name, value string) *milter.Packet {
+	return &milter.Packet{Code: milter.RespAddHeader, Data: milter.EncodeStrings(name, value)}
+}
+
+func InsertHeader(index uint32, name, value string) *milter.Packet {
+	return &milter.Packet{Code: milter.RespInsHeader, Data: append(milter.EncodeUint32s(index), milter.EncodeStrings(name, value)...)}
+}
+
+func ChangeHeader(index uint32, name, value string) *milter.Packet {
+	return &milter.Packet{Code: milter.RespChgHeader, Data: append(milter.EncodeUint32s(index), milter.EncodeStrings(name, value)...)}
+}
+
+func ReplaceBody(body []byte) *milter.Packet {
+	return &milter.Packet{Code: milter.RespReplBody, Data: body}
+}
+
+func AddRcpt(rcpt string) *milter.Packet {
+	return &milter.Packet{Code: milter.RespAddRcpt, Data: milter.EncodeStrings(rcpt)}
+}
+
+func DeleteRcpt(rcpt string) *milter.Packet {
+	return &milter.Packet{Code: milter.RespDelRcpt, Data: milter.EncodeStrings(rcpt)}
+}
+
+func ChangeFrom(from string) *milter.Packet {
+	return &milter.Packet{Code: milter.RespChgFrom, Data: milter.EncodeStrings(from)}
+}
+
+func ReplyCode(text string) *milter.Packet {
+	return &milter.Packet{Code: milter.RespReplyCode, Data: milter.EncodeStrings(text)}
+}
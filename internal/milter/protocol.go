@@ -0,0 +1,189 @@
+package milter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Sendmail milter protocol version 6
+// https://github.com/emersion/go-milter/blob/master/milter-protocol.txt
+const Version = 6
+
+// commands sent by the MTA
+const (
+	CmdAbort      byte = 'A'
+	CmdBody       byte = 'B'
+	CmdConnect    byte = 'C'
+	CmdMacro      byte = 'D'
+	CmdEndOfBody  byte = 'E'
+	CmdHelo       byte = 'H'
+	CmdHeader     byte = 'L'
+	CmdMail       byte = 'M'
+	CmdEndOfHdrs  byte = 'N'
+	CmdOptNeg     byte = 'O'
+	CmdQuit       byte = 'Q'
+	CmdRcpt       byte = 'R'
+	CmdData       byte = 'T'
+	CmdUnknown    byte = 'U'
+	CmdQuitNewCon byte = 'K'
+)
+
+// responses sent by the milter
+const (
+	RespAddRcpt    byte = '+'
+	RespDelRcpt    byte = '-'
+	RespAddRcptPar byte = '2'
+	RespAccept     byte = 'a'
+	RespReplBody   byte = 'b'
+	RespContinue   byte = 'c'
+	RespDiscard    byte = 'd'
+	RespChgFrom    byte = 'e'
+	RespAddHeader  byte = 'h'
+	RespInsHeader  byte = 'i'
+	RespChgHeader  byte = 'm'
+	RespProgress   byte = 'p'
+	RespQuarantine byte = 'q'
+	RespReject     byte = 'r'
+	RespSkip       byte = 's'
+	RespTempFail   byte = 't'
+	RespReplyCode  byte = 'y'
+)
+
+// actions which the milter may perform at the end of message
+const (
+	ActAddHeader  uint32 = 0x01
+	ActChgBody    uint32 = 0x02
+	ActAddRcpt    uint32 = 0x04
+	ActDelRcpt    uint32 = 0x08
+	ActChgHeader  uint32 = 0x10
+	ActQuarantine uint32 = 0x20
+	ActChgFrom    uint32 = 0x40
+	ActAddRcptPar uint32 = 0x80
+	ActSetSymList uint32 = 0x100
+)
+
+// protocol steps which the milter does not need (No*) or does not reply to (NoReply*)
+const (
+	ProtoNoConnect        uint32 = 0x01
+	ProtoNoHelo           uint32 = 0x02
+	ProtoNoMail           uint32 = 0x04
+	ProtoNoRcpt           uint32 = 0x08
+	ProtoNoBody           uint32 = 0x10
+	ProtoNoHeaders        uint32 = 0x20
+	ProtoNoEndOfHdrs      uint32 = 0x40
+	ProtoNoReplyHeader    uint32 = 0x80
+	ProtoNoUnknown        uint32 = 0x100
+	ProtoNoData           uint32 = 0x200
+	ProtoSkip             uint32 = 0x400
+	ProtoRcptRejected     uint32 = 0x800
+	ProtoNoReplyConnect   uint32 = 0x1000
+	ProtoNoReplyHelo      uint32 = 0x2000
+	ProtoNoReplyMail      uint32 = 0x4000
+	ProtoNoReplyRcpt      uint32 = 0x8000
+	ProtoNoReplyData      uint32 = 0x10000
+	ProtoNoReplyUnknown   uint32 = 0x20000
+	ProtoNoReplyEndOfHdrs uint32 = 0x40000
+	ProtoNoReplyBody      uint32 = 0x80000
+	ProtoHeaderLeadSpace  uint32 = 0x100000
+)
+
+// actions offered to milters, quarantine and symbol lists are not supported
+const offeredActions = ActAddHeader | ActChgBody | ActAddRcpt | ActDelRcpt | ActChgHeader | ActChgFrom | ActAddRcptPar
+
+// protocol steps offered to milters, rejected recipients are not sent and header values have no leading space
+const offeredProtocol = ProtoNoConnect | ProtoNoHelo | ProtoNoMail | ProtoNoRcpt | ProtoNoBody | ProtoNoHeaders |
+	ProtoNoEndOfHdrs | ProtoNoReplyHeader | ProtoNoUnknown | ProtoNoData | ProtoSkip |
+	ProtoNoReplyConnect | ProtoNoReplyHelo | ProtoNoReplyMail | ProtoNoReplyRcpt | ProtoNoReplyData |
+	ProtoNoReplyUnknown | ProtoNoReplyEndOfHdrs | ProtoNoReplyBody
+
+const (
+	// body is sent in chunks of this size
+	maxBodyChunk = 65535
+	// packets larger than this are treated as broken
+	maxPacketSize = 1 << 20
+)
+
+var errPacketTooLarge = errors.New("milter packet too large")
+
+// Packet is a command or a response of the milter protocol.
+type Packet struct {
+	Code byte
+	Data []byte
+}
+
+// ReadPacket reads "uint32 length, code, data", the length includes the code.
+func ReadPacket(r io.Reader) (*Packet, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return nil, errors.New("empty milter packet")
+	}
+	if length > maxPacketSize {
+		return nil, errPacketTooLarge
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return &Packet{Code: buf[0], Data: buf[1:]}, nil
+}
+
+// WritePacket writes the packet in the format read by ReadPacket.
+func WritePacket(w io.Writer, p *Packet) error {
+	buf := make([]byte, 5+len(p.Data))
+	binary.BigEndian.PutUint32(buf, uint32(len(p.Data)+1))
+	buf[4] = p.Code
+	copy(buf[5:], p.Data)
+	_, err := w.Write(buf)
+	return err
+}
+
+// EncodeStrings joins the values with NUL terminators.
+func EncodeStrings(values ...string) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		buf.WriteString(v)
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// Strings returns the NUL terminated values of the packet.
+func (p *Packet) Strings() []string {
+	return decodeStrings(p.Data)
+}
+
+// decodeStrings splits NUL terminated values.
+func decodeStrings(data []byte) []string {
+	data = bytes.TrimSuffix(data, []byte{0})
+	if len(data) == 0 {
+		return nil
+	}
+	values := make([]string, 0)
+	for _, v := range bytes.Split(data, []byte{0}) {
+		values = append(values, string(v))
+	}
+	return values
+}
+
+// EncodeUint32s encodes the values in network byte order.
+func EncodeUint32s(values ...uint32) []byte {
+	buf := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(buf[4*i:], v)
+	}
+	return buf
+}
+
+// decodeIndexed decodes "uint32 index, name, value" of header changes.
+func decodeIndexed(data []byte) (uint32, []string, error) {
+	if len(data) < 4 {
+		return 0, nil, fmt.Errorf("short milter packet %q", data)
+	}
+	return binary.BigEndian.Uint32(data), decodeStrings(data[4:]), nil
+}
@@ -2,7 +2,10 @@ package mock
 
 import (
 	mail "net/mail"
+	time "time"
 
+	data "github.com/Haya372/smtp-server/internal/data"
+	session "github.com/Haya372/smtp-server/internal/session"
 	gomock "github.com/golang/mock/gomock"
 )
 
@@ -60,3 +63,98 @@ func NewInitializedMockCommandHandler(ctrl *gomock.Controller, command string) *
 
 	return h
 }
+
+// NewInitializedMockRateLimitService allows every event.
+func NewInitializedMockRateLimitService(ctrl *gomock.Controller) *MockRateLimitService {
+	r := NewMockRateLimitService(ctrl)
+
+	r.EXPECT().AllowConnection(gomock.Any()).Return(true).AnyTimes()
+	r.EXPECT().AllowMessage(gomock.Any()).Return(true).AnyTimes()
+	r.EXPECT().AllowRecipient(gomock.Any()).Return(true).AnyTimes()
+
+	return r
+}
+
+// NewInitializedMockGreylistService accepts every recipient.
+func NewInitializedMockGreylistService(ctrl *gomock.Controller) *MockGreylistService {
+	g := NewMockGreylistService(ctrl)
+
+	g.EXPECT().Check(gomock.Any(), gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+
+	return g
+}
+
+// NewInitializedMockDnsblService treats every client as not listed.
+func NewInitializedMockDnsblService(ctrl *gomock.Controller) *MockDnsblService {
+	d := NewMockDnsblService(ctrl)
+
+	d.EXPECT().Check(gomock.Any(), gomock.Any()).Return(&data.DnsblResult{}, nil).AnyTimes()
+	d.EXPECT().IsRejected(gomock.Any()).Return(false).AnyTimes()
+
+	return d
+}
+
+// NewInitializedMockHeloService performs no check.
+func NewInitializedMockHeloService(ctrl *gomock.Controller) *MockHeloService {
+	h := NewMockHeloService(ctrl)
+
+	h.EXPECT().VerifyReverseDns(gomock.Any(), gomock.Any()).Return(session.ReverseDnsNone, "").AnyTimes()
+	h.EXPECT().CheckHelo(gomock.Any(), gomock.Any()).Return(session.HeloNone).AnyTimes()
+
+	return h
+}
+
+// NewInitializedMockEarlyTalkerService sends the greeting without delay.
+func NewInitializedMockEarlyTalkerService(ctrl *gomock.Controller) *MockEarlyTalkerService {
+	e := NewMockEarlyTalkerService(ctrl)
+
+	e.EXPECT().GreetingDelay().Return(time.Duration(0)).AnyTimes()
+	e.EXPECT().IsExempt(gomock.Any()).Return(false).AnyTimes()
+
+	return e
+}
+
+func NewInitializedMockMilterService(ctrl *gomock.Controller) *MockMilterService {
+	m := NewMockMilterService(ctrl)
+
+	m.EXPECT().Connect(gomock.Any(), gomock.Any()).Return(&data.MilterResult{}).AnyTimes()
+	m.EXPECT().Helo(gomock.Any(), gomock.Any(), gomock.Any()).Return(&data.MilterResult{}).AnyTimes()
+	m.EXPECT().Mail(gomock.Any(), gomock.Any(), gomock.Any()).Return(&data.MilterResult{}).AnyTimes()
+	m.EXPECT().Rcpt(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&data.MilterResult{}).AnyTimes()
+	m.EXPECT().Data(gomock.Any(), gomock.Any()).Return(&data.MilterResult{}).AnyTimes()
+	m.EXPECT().Close(gomock.Any()).AnyTimes()
+
+	return m
+}
+
+func NewInitializedMockSpamService(ctrl *gomock.Controller) *MockSpamService {
+	m := NewMockSpamService(ctrl)
+
+	m.EXPECT().Scan(gomock.Any(), gomock.Any()).Return(&data.SpamResult{}).AnyTimes()
+
+	return m
+}
+
+func NewInitializedMockAntivirusService(ctrl *gomock.Controller) *MockAntivirusService {
+	a := NewMockAntivirusService(ctrl)
+
+	a.EXPECT().Scan(gomock.Any(), gomock.Any()).Return(&data.VirusResult{}).AnyTimes()
+
+	return a
+}
+
+func NewInitializedMockRuleService(ctrl *gomock.Controller) *MockRuleService {
+	r := NewMockRuleService(ctrl)
+
+	r.EXPECT().Evaluate(gomock.Any(), gomock.Any()).Return(&data.RuleResult{}).AnyTimes()
+
+	return r
+}
+
+func NewInitializedMockDeliveryService(ctrl *gomock.Controller) *MockDeliveryService {
+	d := NewMockDeliveryService(ctrl)
+
+	d.EXPECT().Deliver(gomock.Any(), gomock.Any()).Return(&data.DeliveryResult{}).AnyTimes()
+
+	return d
+}
@@ -0,0 +1,51 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/service/antivirus.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	reflect "reflect"
+
+	data "github.com/Haya372/smtp-server/internal/data"
+	session "github.com/Haya372/smtp-server/internal/session"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockAntivirusService is a mock of AntivirusService interface.
+type MockAntivirusService struct {
+	ctrl     *gomock.Controller
+	recorder *MockAntivirusServiceMockRecorder
+}
+
+// MockAntivirusServiceMockRecorder is the mock recorder for MockAntivirusService.
+type MockAntivirusServiceMockRecorder struct {
+	mock *MockAntivirusService
+}
+
+// NewMockAntivirusService creates a new mock instance.
+func NewMockAntivirusService(ctrl *gomock.Controller) *MockAntivirusService {
+	mock := &MockAntivirusService{ctrl: ctrl}
+	mock.recorder = &MockAntivirusServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAntivirusService) EXPECT() *MockAntivirusServiceMockRecorder {
+	return m.recorder
+}
+
+// Scan mocks base method.
+func (m *MockAntivirusService) Scan(ctx context.Context, s *session.Session) *data.VirusResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Scan", ctx, s)
+	ret0, _ := ret[0].(*data.VirusResult)
+	return ret0
+}
+
+// Scan indicates an expected call of Scan.
+func (mr *MockAntivirusServiceMockRecorder) Scan(ctx, s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Scan", reflect.TypeOf((*MockAntivirusService)(nil).Scan), ctx, s)
+}
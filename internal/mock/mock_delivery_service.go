@@ -0,0 +1,51 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/service/delivery.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	reflect "reflect"
+
+	data "github.com/Haya372/smtp-server/internal/data"
+	session "github.com/Haya372/smtp-server/internal/session"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockDeliveryService is a mock of DeliveryService interface.
+type MockDeliveryService struct {
+	ctrl     *gomock.Controller
+	recorder *MockDeliveryServiceMockRecorder
+}
+
+// MockDeliveryServiceMockRecorder is the mock recorder for MockDeliveryService.
+type MockDeliveryServiceMockRecorder struct {
+	mock *MockDeliveryService
+}
+
+// NewMockDeliveryService creates a new mock instance.
+func NewMockDeliveryService(ctrl *gomock.Controller) *MockDeliveryService {
+	mock := &MockDeliveryService{ctrl: ctrl}
+	mock.recorder = &MockDeliveryServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDeliveryService) EXPECT() *MockDeliveryServiceMockRecorder {
+	return m.recorder
+}
+
+// Deliver mocks base method.
+func (m *MockDeliveryService) Deliver(ctx context.Context, s *session.Session) *data.DeliveryResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Deliver", ctx, s)
+	ret0, _ := ret[0].(*data.DeliveryResult)
+	return ret0
+}
+
+// Deliver indicates an expected call of Deliver.
+func (mr *MockDeliveryServiceMockRecorder) Deliver(ctx, s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Deliver", reflect.TypeOf((*MockDeliveryService)(nil).Deliver), ctx, s)
+}
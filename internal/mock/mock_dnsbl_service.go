@@ -0,0 +1,66 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/service/dnsbl.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	reflect "reflect"
+
+	data "github.com/Haya372/smtp-server/internal/data"
+	session "github.com/Haya372/smtp-server/internal/session"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockDnsblService is a mock of DnsblService interface.
+type MockDnsblService struct {
+	ctrl     *gomock.Controller
+	recorder *MockDnsblServiceMockRecorder
+}
+
+// MockDnsblServiceMockRecorder is the mock recorder for MockDnsblService.
+type MockDnsblServiceMockRecorder struct {
+	mock *MockDnsblService
+}
+
+// NewMockDnsblService creates a new mock instance.
+func NewMockDnsblService(ctrl *gomock.Controller) *MockDnsblService {
+	mock := &MockDnsblService{ctrl: ctrl}
+	mock.recorder = &MockDnsblServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDnsblService) EXPECT() *MockDnsblServiceMockRecorder {
+	return m.recorder
+}
+
+// Check mocks base method.
+func (m *MockDnsblService) Check(ctx context.Context, s *session.Session) (*data.DnsblResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Check", ctx, s)
+	ret0, _ := ret[0].(*data.DnsblResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Check indicates an expected call of Check.
+func (mr *MockDnsblServiceMockRecorder) Check(ctx, s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Check", reflect.TypeOf((*MockDnsblService)(nil).Check), ctx, s)
+}
+
+// IsRejected mocks base method.
+func (m *MockDnsblService) IsRejected(res *data.DnsblResult) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsRejected", res)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsRejected indicates an expected call of IsRejected.
+func (mr *MockDnsblServiceMockRecorder) IsRejected(res interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsRejected", reflect.TypeOf((*MockDnsblService)(nil).IsRejected), res)
+}
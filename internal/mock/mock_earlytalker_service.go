@@ -0,0 +1,64 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/service/earlytalker.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	reflect "reflect"
+	time "time"
+
+	session "github.com/Haya372/smtp-server/internal/session"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockEarlyTalkerService is a mock of EarlyTalkerService interface.
+type MockEarlyTalkerService struct {
+	ctrl     *gomock.Controller
+	recorder *MockEarlyTalkerServiceMockRecorder
+}
+
+// MockEarlyTalkerServiceMockRecorder is the mock recorder for MockEarlyTalkerService.
+type MockEarlyTalkerServiceMockRecorder struct {
+	mock *MockEarlyTalkerService
+}
+
+// NewMockEarlyTalkerService creates a new mock instance.
+func NewMockEarlyTalkerService(ctrl *gomock.Controller) *MockEarlyTalkerService {
+	mock := &MockEarlyTalkerService{ctrl: ctrl}
+	mock.recorder = &MockEarlyTalkerServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockEarlyTalkerService) EXPECT() *MockEarlyTalkerServiceMockRecorder {
+	return m.recorder
+}
+
+// GreetingDelay mocks base method.
+func (m *MockEarlyTalkerService) GreetingDelay() time.Duration {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GreetingDelay")
+	ret0, _ := ret[0].(time.Duration)
+	return ret0
+}
+
+// GreetingDelay indicates an expected call of GreetingDelay.
+func (mr *MockEarlyTalkerServiceMockRecorder) GreetingDelay() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GreetingDelay", reflect.TypeOf((*MockEarlyTalkerService)(nil).GreetingDelay))
+}
+
+// IsExempt mocks base method.
+func (m *MockEarlyTalkerService) IsExempt(s *session.Session) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsExempt", s)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsExempt indicates an expected call of IsExempt.
+func (mr *MockEarlyTalkerServiceMockRecorder) IsExempt(s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsExempt", reflect.TypeOf((*MockEarlyTalkerService)(nil).IsExempt), s)
+}
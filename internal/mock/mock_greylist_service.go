@@ -0,0 +1,52 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/service/greylist.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	mail "net/mail"
+	reflect "reflect"
+
+	session "github.com/Haya372/smtp-server/internal/session"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockGreylistService is a mock of GreylistService interface.
+type MockGreylistService struct {
+	ctrl     *gomock.Controller
+	recorder *MockGreylistServiceMockRecorder
+}
+
+// MockGreylistServiceMockRecorder is the mock recorder for MockGreylistService.
+type MockGreylistServiceMockRecorder struct {
+	mock *MockGreylistService
+}
+
+// NewMockGreylistService creates a new mock instance.
+func NewMockGreylistService(ctrl *gomock.Controller) *MockGreylistService {
+	mock := &MockGreylistService{ctrl: ctrl}
+	mock.recorder = &MockGreylistServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockGreylistService) EXPECT() *MockGreylistServiceMockRecorder {
+	return m.recorder
+}
+
+// Check mocks base method.
+func (m *MockGreylistService) Check(ctx context.Context, s *session.Session, recipient mail.Address) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Check", ctx, s, recipient)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Check indicates an expected call of Check.
+func (mr *MockGreylistServiceMockRecorder) Check(ctx, s, recipient interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Check", reflect.TypeOf((*MockGreylistService)(nil).Check), ctx, s, recipient)
+}
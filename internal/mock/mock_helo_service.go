@@ -0,0 +1,65 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/service/helo.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	reflect "reflect"
+
+	session "github.com/Haya372/smtp-server/internal/session"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockHeloService is a mock of HeloService interface.
+type MockHeloService struct {
+	ctrl     *gomock.Controller
+	recorder *MockHeloServiceMockRecorder
+}
+
+// MockHeloServiceMockRecorder is the mock recorder for MockHeloService.
+type MockHeloServiceMockRecorder struct {
+	mock *MockHeloService
+}
+
+// NewMockHeloService creates a new mock instance.
+func NewMockHeloService(ctrl *gomock.Controller) *MockHeloService {
+	mock := &MockHeloService{ctrl: ctrl}
+	mock.recorder = &MockHeloServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockHeloService) EXPECT() *MockHeloServiceMockRecorder {
+	return m.recorder
+}
+
+// CheckHelo mocks base method.
+func (m *MockHeloService) CheckHelo(s *session.Session, helo string) session.HeloStatus {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckHelo", s, helo)
+	ret0, _ := ret[0].(session.HeloStatus)
+	return ret0
+}
+
+// CheckHelo indicates an expected call of CheckHelo.
+func (mr *MockHeloServiceMockRecorder) CheckHelo(s, helo interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckHelo", reflect.TypeOf((*MockHeloService)(nil).CheckHelo), s, helo)
+}
+
+// VerifyReverseDns mocks base method.
+func (m *MockHeloService) VerifyReverseDns(ctx context.Context, s *session.Session) (session.ReverseDnsStatus, string) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyReverseDns", ctx, s)
+	ret0, _ := ret[0].(session.ReverseDnsStatus)
+	ret1, _ := ret[1].(string)
+	return ret0, ret1
+}
+
+// VerifyReverseDns indicates an expected call of VerifyReverseDns.
+func (mr *MockHeloServiceMockRecorder) VerifyReverseDns(ctx, s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyReverseDns", reflect.TypeOf((*MockHeloService)(nil).VerifyReverseDns), ctx, s)
+}
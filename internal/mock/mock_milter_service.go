@@ -0,0 +1,120 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/service/milter.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	mail "net/mail"
+	reflect "reflect"
+
+	data "github.com/Haya372/smtp-server/internal/data"
+	session "github.com/Haya372/smtp-server/internal/session"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockMilterService is a mock of MilterService interface.
+type MockMilterService struct {
+	ctrl     *gomock.Controller
+	recorder *MockMilterServiceMockRecorder
+}
+
+// MockMilterServiceMockRecorder is the mock recorder for MockMilterService.
+type MockMilterServiceMockRecorder struct {
+	mock *MockMilterService
+}
+
+// NewMockMilterService creates a new mock instance.
+func NewMockMilterService(ctrl *gomock.Controller) *MockMilterService {
+	mock := &MockMilterService{ctrl: ctrl}
+	mock.recorder = &MockMilterServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMilterService) EXPECT() *MockMilterServiceMockRecorder {
+	return m.recorder
+}
+
+// Close mocks base method.
+func (m *MockMilterService) Close(s *session.Session) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Close", s)
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockMilterServiceMockRecorder) Close(s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockMilterService)(nil).Close), s)
+}
+
+// Connect mocks base method.
+func (m *MockMilterService) Connect(ctx context.Context, s *session.Session) *data.MilterResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Connect", ctx, s)
+	ret0, _ := ret[0].(*data.MilterResult)
+	return ret0
+}
+
+// Connect indicates an expected call of Connect.
+func (mr *MockMilterServiceMockRecorder) Connect(ctx, s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Connect", reflect.TypeOf((*MockMilterService)(nil).Connect), ctx, s)
+}
+
+// Data mocks base method.
+func (m *MockMilterService) Data(ctx context.Context, s *session.Session) *data.MilterResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Data", ctx, s)
+	ret0, _ := ret[0].(*data.MilterResult)
+	return ret0
+}
+
+// Data indicates an expected call of Data.
+func (mr *MockMilterServiceMockRecorder) Data(ctx, s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Data", reflect.TypeOf((*MockMilterService)(nil).Data), ctx, s)
+}
+
+// Helo mocks base method.
+func (m *MockMilterService) Helo(ctx context.Context, s *session.Session, helo string) *data.MilterResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Helo", ctx, s, helo)
+	ret0, _ := ret[0].(*data.MilterResult)
+	return ret0
+}
+
+// Helo indicates an expected call of Helo.
+func (mr *MockMilterServiceMockRecorder) Helo(ctx, s, helo interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Helo", reflect.TypeOf((*MockMilterService)(nil).Helo), ctx, s, helo)
+}
+
+// Mail mocks base method.
+func (m *MockMilterService) Mail(ctx context.Context, s *session.Session, args []string) *data.MilterResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Mail", ctx, s, args)
+	ret0, _ := ret[0].(*data.MilterResult)
+	return ret0
+}
+
+// Mail indicates an expected call of Mail.
+func (mr *MockMilterServiceMockRecorder) Mail(ctx, s, args interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Mail", reflect.TypeOf((*MockMilterService)(nil).Mail), ctx, s, args)
+}
+
+// Rcpt mocks base method.
+func (m *MockMilterService) Rcpt(ctx context.Context, s *session.Session, recipient mail.Address, args []string) *data.MilterResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Rcpt", ctx, s, recipient, args)
+	ret0, _ := ret[0].(*data.MilterResult)
+	return ret0
+}
+
+// Rcpt indicates an expected call of Rcpt.
+func (mr *MockMilterServiceMockRecorder) Rcpt(ctx, s, recipient, args interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Rcpt", reflect.TypeOf((*MockMilterService)(nil).Rcpt), ctx, s, recipient, args)
+}
@@ -0,0 +1,77 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/service/ratelimit.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	reflect "reflect"
+
+	session "github.com/Haya372/smtp-server/internal/session"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockRateLimitService is a mock of RateLimitService interface.
+type MockRateLimitService struct {
+	ctrl     *gomock.Controller
+	recorder *MockRateLimitServiceMockRecorder
+}
+
+// MockRateLimitServiceMockRecorder is the mock recorder for MockRateLimitService.
+type MockRateLimitServiceMockRecorder struct {
+	mock *MockRateLimitService
+}
+
+// NewMockRateLimitService creates a new mock instance.
+func NewMockRateLimitService(ctrl *gomock.Controller) *MockRateLimitService {
+	mock := &MockRateLimitService{ctrl: ctrl}
+	mock.recorder = &MockRateLimitServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRateLimitService) EXPECT() *MockRateLimitServiceMockRecorder {
+	return m.recorder
+}
+
+// AllowConnection mocks base method.
+func (m *MockRateLimitService) AllowConnection(s *session.Session) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AllowConnection", s)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// AllowConnection indicates an expected call of AllowConnection.
+func (mr *MockRateLimitServiceMockRecorder) AllowConnection(s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllowConnection", reflect.TypeOf((*MockRateLimitService)(nil).AllowConnection), s)
+}
+
+// AllowMessage mocks base method.
+func (m *MockRateLimitService) AllowMessage(s *session.Session) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AllowMessage", s)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// AllowMessage indicates an expected call of AllowMessage.
+func (mr *MockRateLimitServiceMockRecorder) AllowMessage(s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllowMessage", reflect.TypeOf((*MockRateLimitService)(nil).AllowMessage), s)
+}
+
+// AllowRecipient mocks base method.
+func (m *MockRateLimitService) AllowRecipient(s *session.Session) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AllowRecipient", s)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// AllowRecipient indicates an expected call of AllowRecipient.
+func (mr *MockRateLimitServiceMockRecorder) AllowRecipient(s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AllowRecipient", reflect.TypeOf((*MockRateLimitService)(nil).AllowRecipient), s)
+}
@@ -0,0 +1,66 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/service/recipient.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	mail "net/mail"
+	reflect "reflect"
+
+	data "github.com/Haya372/smtp-server/internal/data"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockRecipientService is a mock of RecipientService interface.
+type MockRecipientService struct {
+	ctrl     *gomock.Controller
+	recorder *MockRecipientServiceMockRecorder
+}
+
+// MockRecipientServiceMockRecorder is the mock recorder for MockRecipientService.
+type MockRecipientServiceMockRecorder struct {
+	mock *MockRecipientService
+}
+
+// NewMockRecipientService creates a new mock instance.
+func NewMockRecipientService(ctrl *gomock.Controller) *MockRecipientService {
+	mock := &MockRecipientService{ctrl: ctrl}
+	mock.recorder = &MockRecipientServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRecipientService) EXPECT() *MockRecipientServiceMockRecorder {
+	return m.recorder
+}
+
+// IsLocalDomain mocks base method.
+func (m *MockRecipientService) IsLocalDomain(domain string) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsLocalDomain", domain)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsLocalDomain indicates an expected call of IsLocalDomain.
+func (mr *MockRecipientServiceMockRecorder) IsLocalDomain(domain interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsLocalDomain", reflect.TypeOf((*MockRecipientService)(nil).IsLocalDomain), domain)
+}
+
+// Resolve mocks base method.
+func (m *MockRecipientService) Resolve(ctx context.Context, address mail.Address, authenticated bool) (*data.RecipientResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Resolve", ctx, address, authenticated)
+	ret0, _ := ret[0].(*data.RecipientResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Resolve indicates an expected call of Resolve.
+func (mr *MockRecipientServiceMockRecorder) Resolve(ctx, address, authenticated interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Resolve", reflect.TypeOf((*MockRecipientService)(nil).Resolve), ctx, address, authenticated)
+}
@@ -0,0 +1,51 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/service/rule.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	reflect "reflect"
+
+	data "github.com/Haya372/smtp-server/internal/data"
+	session "github.com/Haya372/smtp-server/internal/session"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockRuleService is a mock of RuleService interface.
+type MockRuleService struct {
+	ctrl     *gomock.Controller
+	recorder *MockRuleServiceMockRecorder
+}
+
+// MockRuleServiceMockRecorder is the mock recorder for MockRuleService.
+type MockRuleServiceMockRecorder struct {
+	mock *MockRuleService
+}
+
+// NewMockRuleService creates a new mock instance.
+func NewMockRuleService(ctrl *gomock.Controller) *MockRuleService {
+	mock := &MockRuleService{ctrl: ctrl}
+	mock.recorder = &MockRuleServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRuleService) EXPECT() *MockRuleServiceMockRecorder {
+	return m.recorder
+}
+
+// Evaluate mocks base method.
+func (m *MockRuleService) Evaluate(ctx context.Context, s *session.Session) *data.RuleResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Evaluate", ctx, s)
+	ret0, _ := ret[0].(*data.RuleResult)
+	return ret0
+}
+
+// Evaluate indicates an expected call of Evaluate.
+func (mr *MockRuleServiceMockRecorder) Evaluate(ctx, s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Evaluate", reflect.TypeOf((*MockRuleService)(nil).Evaluate), ctx, s)
+}
@@ -0,0 +1,51 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/service/spam.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	reflect "reflect"
+
+	data "github.com/Haya372/smtp-server/internal/data"
+	session "github.com/Haya372/smtp-server/internal/session"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockSpamService is a mock of SpamService interface.
+type MockSpamService struct {
+	ctrl     *gomock.Controller
+	recorder *MockSpamServiceMockRecorder
+}
+
+// MockSpamServiceMockRecorder is the mock recorder for MockSpamService.
+type MockSpamServiceMockRecorder struct {
+	mock *MockSpamService
+}
+
+// NewMockSpamService creates a new mock instance.
+func NewMockSpamService(ctrl *gomock.Controller) *MockSpamService {
+	mock := &MockSpamService{ctrl: ctrl}
+	mock.recorder = &MockSpamServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSpamService) EXPECT() *MockSpamServiceMockRecorder {
+	return m.recorder
+}
+
+// Scan mocks base method.
+func (m *MockSpamService) Scan(ctx context.Context, s *session.Session) *data.SpamResult {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Scan", ctx, s)
+	ret0, _ := ret[0].(*data.SpamResult)
+	return ret0
+}
+
+// Scan indicates an expected call of Scan.
+func (mr *MockSpamServiceMockRecorder) Scan(ctx, s interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Scan", reflect.TypeOf((*MockSpamService)(nil).Scan), ctx, s)
+}
@@ -0,0 +1,79 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/service/srs.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	mail "net/mail"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockSrsService is a mock of SrsService interface.
+type MockSrsService struct {
+	ctrl     *gomock.Controller
+	recorder *MockSrsServiceMockRecorder
+}
+
+// MockSrsServiceMockRecorder is the mock recorder for MockSrsService.
+type MockSrsServiceMockRecorder struct {
+	mock *MockSrsService
+}
+
+// NewMockSrsService creates a new mock instance.
+func NewMockSrsService(ctrl *gomock.Controller) *MockSrsService {
+	mock := &MockSrsService{ctrl: ctrl}
+	mock.recorder = &MockSrsServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSrsService) EXPECT() *MockSrsServiceMockRecorder {
+	return m.recorder
+}
+
+// Forward mocks base method.
+func (m *MockSrsService) Forward(sender mail.Address) (mail.Address, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Forward", sender)
+	ret0, _ := ret[0].(mail.Address)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Forward indicates an expected call of Forward.
+func (mr *MockSrsServiceMockRecorder) Forward(sender interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Forward", reflect.TypeOf((*MockSrsService)(nil).Forward), sender)
+}
+
+// IsSrsAddress mocks base method.
+func (m *MockSrsService) IsSrsAddress(address mail.Address) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsSrsAddress", address)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsSrsAddress indicates an expected call of IsSrsAddress.
+func (mr *MockSrsServiceMockRecorder) IsSrsAddress(address interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsSrsAddress", reflect.TypeOf((*MockSrsService)(nil).IsSrsAddress), address)
+}
+
+// Reverse mocks base method.
+func (m *MockSrsService) Reverse(address mail.Address) (mail.Address, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reverse", address)
+	ret0, _ := ret[0].(mail.Address)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reverse indicates an expected call of Reverse.
+func (mr *MockSrsServiceMockRecorder) Reverse(address interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reverse", reflect.TypeOf((*MockSrsService)(nil).Reverse), address)
+}
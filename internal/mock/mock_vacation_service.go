@@ -0,0 +1,64 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/service/vacation.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	context "context"
+	reflect "reflect"
+
+	sieve "github.com/Haya372/smtp-server/internal/sieve"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockVacationService is a mock of VacationService interface.
+type MockVacationService struct {
+	ctrl     *gomock.Controller
+	recorder *MockVacationServiceMockRecorder
+}
+
+// MockVacationServiceMockRecorder is the mock recorder for MockVacationService.
+type MockVacationServiceMockRecorder struct {
+	mock *MockVacationService
+}
+
+// NewMockVacationService creates a new mock instance.
+func NewMockVacationService(ctrl *gomock.Controller) *MockVacationService {
+	mock := &MockVacationService{ctrl: ctrl}
+	mock.recorder = &MockVacationServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockVacationService) EXPECT() *MockVacationServiceMockRecorder {
+	return m.recorder
+}
+
+// AutoReply mocks base method.
+func (m *MockVacationService) AutoReply(ctx context.Context, recipient, mailbox string, msg *sieve.Message) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AutoReply", ctx, recipient, mailbox, msg)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AutoReply indicates an expected call of AutoReply.
+func (mr *MockVacationServiceMockRecorder) AutoReply(ctx, recipient, mailbox, msg interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AutoReply", reflect.TypeOf((*MockVacationService)(nil).AutoReply), ctx, recipient, mailbox, msg)
+}
+
+// Respond mocks base method.
+func (m *MockVacationService) Respond(ctx context.Context, recipient string, msg *sieve.Message, v *sieve.Vacation) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Respond", ctx, recipient, msg, v)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Respond indicates an expected call of Respond.
+func (mr *MockVacationServiceMockRecorder) Respond(ctx, recipient, msg, v interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Respond", reflect.TypeOf((*MockVacationService)(nil).Respond), ctx, recipient, msg, v)
+}
@@ -84,4 +84,4 @@ func (m *MockReader) Read(arg0 []byte) (int, error) {
 func (mr *MockReaderMockRecorder) Read(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Read", reflect.TypeOf((*MockReader)(nil).Read), arg0)
-}
\ No newline at end of file
+}
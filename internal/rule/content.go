@@ -0,0 +1,137 @@
+package rule
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// nested multiparts deeper than this are not parsed
+const maxMimeDepth = 10
+
+// leaf MIME part of the message
+type part struct {
+	contentType string
+	// file name of the attachment, empty for inline parts without name
+	filename string
+	// decoded content of text parts
+	text string
+}
+
+type content struct {
+	headers textproto.MIMEHeader
+	parts   []part
+}
+
+var wordDecoder = &mime.WordDecoder{
+	// other charsets are matched as they are encoded
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	},
+}
+
+func decodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// header returns the decoded values of the header fields.
+func (c *content) header(name string) []string {
+	values := make([]string, 0)
+	for _, v := range c.headers.Values(name) {
+		values = append(values, decodeHeader(v))
+	}
+	return values
+}
+
+// parseContent splits the message into MIME parts, broken parts are treated as text/plain.
+func parseContent(raw []byte) *content {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		// the message has no header section
+		return &content{headers: textproto.MIMEHeader{}, parts: []part{{contentType: "text/plain", text: string(raw)}}}
+	}
+	c := &content{headers: textproto.MIMEHeader(msg.Header)}
+	c.walk(c.headers, msg.Body, 0)
+	return c
+}
+
+func (c *content) walk(header textproto.MIMEHeader, body io.Reader, depth int) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < maxMimeDepth {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := reader.NextRawPart()
+			if err != nil {
+				return
+			}
+			c.walk(p.Header, p, depth+1)
+		}
+	}
+	if mediaType == "message/rfc822" && depth < maxMimeDepth {
+		if msg, err := mail.ReadMessage(body); err == nil {
+			c.walk(textproto.MIMEHeader(msg.Header), msg.Body, depth+1)
+			return
+		}
+	}
+
+	p := part{contentType: mediaType, filename: filename(header, params)}
+	if strings.HasPrefix(mediaType, "text/") {
+		data, _ := io.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body))
+		p.text = string(data)
+	}
+	c.parts = append(c.parts, p)
+}
+
+// filename returns the file name in Content-Disposition, or the name parameter of Content-Type.
+func filename(header textproto.MIMEHeader, contentTypeParams map[string]string) string {
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && len(params["filename"]) > 0 {
+		return decodeHeader(params["filename"])
+	}
+	return decodeHeader(contentTypeParams["name"])
+}
+
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineRemover{r: body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// newlineRemover removes line breaks in base64 content.
+type newlineRemover struct {
+	r io.Reader
+}
+
+func (n *newlineRemover) Read(p []byte) (int, error) {
+	for {
+		size, err := n.r.Read(p)
+		kept := 0
+		for _, b := range p[:size] {
+			if b != '\r' && b != '\n' {
+				p[kept] = b
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package rule
+
+import (
+	"path"
+	"strings"
+)
+
+// Message is the message and its envelope evaluated by the rules.
+type Message struct {
+	// envelope sender, empty for the null sender
+	From string
+	To   []string
+	Helo string
+	Raw  []byte
+}
+
+// Result has the actions of the matched rules.
+type Result struct {
+	// names of the matched rules
+	Matched []string
+	// reply of the first rejecting rule, or the default reply when the score reaches the threshold
+	Reject     *Reply
+	Quarantine bool
+	Discard    bool
+	Redirect   []string
+	// header fields added to the message
+	Headers []string
+	// total score of the matched rules
+	Score float64
+}
+
+// Evaluate applies the rules in order.
+func (rs *RuleSet) Evaluate(msg *Message) *Result {
+	res := &Result{}
+	if len(rs.rules) == 0 {
+		return res
+	}
+
+	content := parseContent(msg.Raw)
+	for _, r := range rs.rules {
+		if !r.matches(msg, content) {
+			continue
+		}
+		res.Matched = append(res.Matched, r.name)
+		for _, action := range r.actions {
+			res.apply(action)
+		}
+		if r.stop {
+			break
+		}
+	}
+
+	if res.Reject == nil && rs.rejectScore > 0 && res.Score >= rs.rejectScore {
+		res.Reject = &Reply{Code: 550, Enhanced: "5.7.1", enhanced: [3]int{5, 7, 1}}
+	}
+	if rs.quarantineScore > 0 && res.Score >= rs.quarantineScore {
+		res.Quarantine = true
+	}
+	return res
+}
+
+func (res *Result) apply(action Action) {
+	if action.Reject != nil && res.Reject == nil {
+		res.Reject = action.Reject
+	}
+	if len(action.AddHeader) > 0 {
+		res.Headers = append(res.Headers, action.AddHeader)
+	}
+	if len(action.Redirect) > 0 {
+		res.Redirect = append(res.Redirect, action.Redirect)
+	}
+	res.Quarantine = res.Quarantine || action.Quarantine
+	res.Discard = res.Discard || action.Discard
+	res.Score += action.Score
+}
+
+func (r *compiledRule) matches(msg *Message, content *content) bool {
+	if r.from != nil && !r.from.MatchString(msg.From) {
+		return false
+	}
+	if r.to != nil && !anyMatch(msg.To, func(to string) bool { return r.to.MatchString(to) }) {
+		return false
+	}
+	if r.helo != nil && !r.helo.MatchString(msg.Helo) {
+		return false
+	}
+	for _, h := range r.headers {
+		if !anyMatch(content.header(h.name), h.pattern.MatchString) {
+			return false
+		}
+	}
+	if r.contentType != nil && !anyPart(content.parts, func(p part) bool { return r.contentType.MatchString(p.contentType) }) {
+		return false
+	}
+	if r.extensions != nil && !anyPart(content.parts, func(p part) bool {
+		return len(p.filename) > 0 && r.extensions[normalizeExtension(path.Ext(p.filename))]
+	}) {
+		return false
+	}
+	if r.body != nil && !anyPart(content.parts, func(p part) bool {
+		return strings.HasPrefix(p.contentType, "text/") && r.body.MatchString(p.text)
+	}) {
+		return false
+	}
+	return true
+}
+
+func anyMatch(values []string, f func(string) bool) bool {
+	for _, v := range values {
+		if f(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyPart(parts []part, f func(part) bool) bool {
+	for _, p := range parts {
+		if f(p) {
+			return true
+		}
+	}
+	return false
+}
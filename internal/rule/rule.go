@@ -0,0 +1,242 @@
+package rule
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is the YAML file of the rules.
+type File struct {
+	// total score of the matched rules from which the message is rejected, disabled when 0
+	RejectScore float64 `yaml:"rejectScore"`
+	// total score from which the message is quarantined, disabled when 0
+	QuarantineScore float64 `yaml:"quarantineScore"`
+	// rules are evaluated in order
+	Rules []Rule `yaml:"rules"`
+}
+
+type Rule struct {
+	Name    string   `yaml:"name"`
+	Match   Match    `yaml:"match"`
+	Actions []Action `yaml:"actions"`
+	// the following rules are not evaluated when the rule matches
+	Stop bool `yaml:"stop"`
+}
+
+// Match has the conditions of the rule, all of them must be satisfied.
+// Patterns are regular expressions of RE2 syntax, empty patterns are not checked.
+type Match struct {
+	// envelope sender, empty for the null sender
+	From string `yaml:"from"`
+	// matched by any envelope recipient
+	To   string `yaml:"to"`
+	Helo string `yaml:"helo"`
+	// every header must match
+	Headers []HeaderMatch `yaml:"headers"`
+	// matched by the content type of any MIME part such as "application/zip"
+	ContentType string `yaml:"contentType"`
+	// file name extensions of attachments such as "exe", compared case-insensitively
+	Extensions []string `yaml:"extensions"`
+	// matched by the decoded text of any text part
+	Body string `yaml:"body"`
+}
+
+// HeaderMatch is matched by any header field of the name, the value is decoded by RFC 2047.
+type HeaderMatch struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+}
+
+// Action is taken when the rule matches, one action may have several fields.
+type Action struct {
+	Reject *Reply `yaml:"reject"`
+	// header field such as "X-Rule: matched" added at the top of the message
+	AddHeader string `yaml:"addHeader"`
+	// address which receives the message instead of the recipients
+	Redirect   string  `yaml:"redirect"`
+	Quarantine bool    `yaml:"quarantine"`
+	Discard    bool    `yaml:"discard"`
+	Score      float64 `yaml:"score"`
+}
+
+// Reply is the SMTP reply of the rejection, 550 5.7.1 is used when the code is 0.
+type Reply struct {
+	Code int `yaml:"code"`
+	// enhanced status code such as "5.7.1"
+	Enhanced string `yaml:"enhanced"`
+	Message  string `yaml:"message"`
+
+	enhanced [3]int
+}
+
+func (r *Reply) EnhancedCode() [3]int {
+	return r.enhanced
+}
+
+func (r *Reply) validate() error {
+	if r.Code == 0 {
+		r.Code = 550
+		if len(r.Enhanced) == 0 {
+			r.Enhanced = "5.7.1"
+		}
+	}
+	if r.Code < 400 || r.Code >= 600 {
+		return fmt.Errorf("reply code %d is not an error", r.Code)
+	}
+	if len(r.Enhanced) == 0 {
+		return nil
+	}
+	parts := strings.Split(r.Enhanced, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid enhanced status code %q", r.Enhanced)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid enhanced status code %q", r.Enhanced)
+		}
+		r.enhanced[i] = n
+	}
+	// the class must be the same as the reply code
+	if r.enhanced[0] != r.Code/100 {
+		return fmt.Errorf("enhanced status code %q does not match reply code %d", r.Enhanced, r.Code)
+	}
+	return nil
+}
+
+type headerMatcher struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+type compiledRule struct {
+	name        string
+	from        *regexp.Regexp
+	to          *regexp.Regexp
+	helo        *regexp.Regexp
+	headers     []headerMatcher
+	contentType *regexp.Regexp
+	extensions  map[string]bool
+	body        *regexp.Regexp
+	actions     []Action
+	stop        bool
+}
+
+// RuleSet is the compiled rules.
+type RuleSet struct {
+	rejectScore     float64
+	quarantineScore float64
+	rules           []*compiledRule
+}
+
+// Parse compiles the rules in YAML.
+func Parse(data []byte) (*RuleSet, error) {
+	var file File
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	rs := &RuleSet{
+		rejectScore:     file.RejectScore,
+		quarantineScore: file.QuarantineScore,
+	}
+	for i, r := range file.Rules {
+		name := r.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("rule#%d", i+1)
+		}
+		compiled, err := compile(name, r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+		rs.rules = append(rs.rules, compiled)
+	}
+	return rs, nil
+}
+
+// Load compiles the rules in the YAML file.
+func Load(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rs, nil
+}
+
+func compile(name string, r Rule) (*compiledRule, error) {
+	if len(r.Actions) == 0 {
+		return nil, fmt.Errorf("no action")
+	}
+	c := &compiledRule{
+		name:    name,
+		actions: r.Actions,
+		stop:    r.Stop,
+	}
+
+	var err error
+	patterns := []struct {
+		pattern string
+		re      **regexp.Regexp
+	}{
+		{r.Match.From, &c.from},
+		{r.Match.To, &c.to},
+		{r.Match.Helo, &c.helo},
+		{r.Match.ContentType, &c.contentType},
+		{r.Match.Body, &c.body},
+	}
+	for _, p := range patterns {
+		if len(p.pattern) == 0 {
+			continue
+		}
+		if *p.re, err = regexp.Compile(p.pattern); err != nil {
+			return nil, err
+		}
+	}
+	for _, h := range r.Match.Headers {
+		if len(h.Name) == 0 {
+			return nil, fmt.Errorf("header without name")
+		}
+		re, err := regexp.Compile(h.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		c.headers = append(c.headers, headerMatcher{name: h.Name, pattern: re})
+	}
+	if len(r.Match.Extensions) > 0 {
+		c.extensions = make(map[string]bool)
+		for _, ext := range r.Match.Extensions {
+			c.extensions[normalizeExtension(ext)] = true
+		}
+	}
+
+	for i := range c.actions {
+		action := &c.actions[i]
+		if action.Reject != nil {
+			if err := action.Reject.validate(); err != nil {
+				return nil, err
+			}
+		}
+		if len(action.AddHeader) > 0 {
+			if field, _, ok := strings.Cut(action.AddHeader, ":"); !ok || len(strings.TrimSpace(field)) == 0 {
+				return nil, fmt.Errorf("invalid header field %q", action.AddHeader)
+			}
+		}
+		if len(action.Redirect) > 0 && !strings.Contains(action.Redirect, "@") {
+			return nil, fmt.Errorf("invalid redirect address %q", action.Redirect)
+		}
+	}
+	return c, nil
+}
+
+func normalizeExtension(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(ext, "."))
+}
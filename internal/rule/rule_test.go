@@ -0,0 +1,267 @@
+package rule
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		err  bool
+	}{
+		{
+			name: "valid",
+			yaml: `
+rejectScore: 10
+rules:
+  - name: exe
+    match:
+      extensions: [".EXE", scr]
+    actions:
+      - reject: {code: 554, enhanced: "5.7.1", message: "executable attachments are not accepted"}
+  - match:
+      headers:
+        - {name: Subject, pattern: "(?i)viagra"}
+    actions:
+      - score: 5
+        addHeader: "X-Rule: viagra"
+`,
+		},
+		{
+			name: "empty",
+			yaml: "",
+		},
+		{
+			name: "invalid yaml",
+			yaml: "rules: [",
+			err:  true,
+		},
+		{
+			name: "invalid pattern",
+			yaml: "rules: [{match: {body: '('}, actions: [{discard: true}]}]",
+			err:  true,
+		},
+		{
+			name: "no action",
+			yaml: "rules: [{match: {body: 'x'}}]",
+			err:  true,
+		},
+		{
+			name: "not an error code",
+			yaml: "rules: [{actions: [{reject: {code: 250}}]}]",
+			err:  true,
+		},
+		{
+			name: "enhanced code of other class",
+			yaml: "rules: [{actions: [{reject: {code: 450, enhanced: '5.7.1'}}]}]",
+			err:  true,
+		},
+		{
+			name: "invalid header field",
+			yaml: "rules: [{actions: [{addHeader: 'no colon'}]}]",
+			err:  true,
+		},
+		{
+			name: "invalid redirect",
+			yaml: "rules: [{actions: [{redirect: 'postmaster'}]}]",
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse([]byte(test.yaml))
+			assert.Equal(t, test.err, err != nil, err)
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("rules: [{actions: [{discard: true}]}]"), 0o644))
+
+	rs, err := Load(path)
+	assert.Nil(t, err)
+	assert.True(t, rs.Evaluate(&Message{Raw: []byte("Subject: test\n\nbody\n")}).Discard)
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.NotNil(t, err)
+}
+
+const multipartMessage = "From: a@example.com\r\n" +
+	"Subject: =?UTF-8?B?44GK55+l44KJ44Gb?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"cGxlYXNlIG9wZW4gdGhl\r\nIGludm9pY2U=\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/octet-stream; name=\"invoice.pdf.EXE\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"TVqQAAMAAAAEAAAA\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/zip\r\n" +
+	"Content-Disposition: attachment; filename=\"archive.zip\"\r\n" +
+	"\r\n" +
+	"PK\r\n" +
+	"--b1--\r\n"
+
+func TestRuleSet_Evaluate(t *testing.T) {
+	msg := &Message{
+		From: "a@example.com",
+		To:   []string{"b@example.com", "c@example.org"},
+		Helo: "client.example.com",
+		Raw:  []byte(multipartMessage),
+	}
+
+	tests := []struct {
+		name     string
+		yaml     string
+		expected *Result
+	}{
+		{
+			name: "envelope",
+			yaml: `
+rules:
+  - name: from
+    match: {from: "@example\\.com$", to: "@example\\.org$", helo: "^client\\."}
+    actions: [{quarantine: true}]
+  - name: not matched
+    match: {from: "@example\\.net$"}
+    actions: [{discard: true}]
+`,
+			expected: &Result{Matched: []string{"from"}, Quarantine: true},
+		},
+		{
+			name: "decoded header",
+			yaml: `
+rules:
+  - name: subject
+    match:
+      headers: [{name: subject, pattern: "お知らせ"}]
+    actions: [{addHeader: "X-Rule: subject"}, {redirect: "review@example.com"}]
+`,
+			expected: &Result{Matched: []string{"subject"}, Headers: []string{"X-Rule: subject"}, Redirect: []string{"review@example.com"}},
+		},
+		{
+			name: "missing header",
+			yaml: `
+rules:
+  - match:
+      headers: [{name: X-Mailer, pattern: ""}]
+    actions: [{discard: true}]
+`,
+			expected: &Result{},
+		},
+		{
+			name: "attachment",
+			yaml: `
+rules:
+  - name: exe
+    match: {extensions: [exe]}
+    actions: [{reject: {code: 554, message: "executable attachment"}}]
+  - name: zip
+    match: {contentType: "^application/zip$"}
+    actions: [{score: 1}]
+`,
+			expected: &Result{
+				Matched: []string{"exe", "zip"},
+				Reject:  &Reply{Code: 554, Message: "executable attachment", enhanced: [3]int{}},
+				Score:   1,
+			},
+		},
+		{
+			name: "decoded body and stop",
+			yaml: `
+rules:
+  - name: invoice
+    match: {body: "open the invoice"}
+    actions: [{discard: true}]
+    stop: true
+  - name: after stop
+    actions: [{score: 1}]
+`,
+			expected: &Result{Matched: []string{"invoice"}, Discard: true},
+		},
+		{
+			name: "body does not match attachments",
+			yaml: `
+rules:
+  - match: {body: "^PK"}
+    actions: [{discard: true}]
+`,
+			expected: &Result{},
+		},
+		{
+			name: "score thresholds",
+			yaml: `
+rejectScore: 5
+quarantineScore: 3
+rules:
+  - name: a
+    actions: [{score: 3}]
+  - name: b
+    actions: [{score: 2.5}]
+`,
+			expected: &Result{
+				Matched:    []string{"a", "b"},
+				Reject:     &Reply{Code: 550, Enhanced: "5.7.1", enhanced: [3]int{5, 7, 1}},
+				Quarantine: true,
+				Score:      5.5,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rs, err := Parse([]byte(test.yaml))
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, rs.Evaluate(msg))
+		})
+	}
+}
+
+func TestParseContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected []part
+	}{
+		{
+			name:     "plain lf",
+			raw:      "Subject: test\n\nbody\n",
+			expected: []part{{contentType: "text/plain", text: "body\n"}},
+		},
+		{
+			name:     "quoted printable",
+			raw:      "Content-Type: text/html\nContent-Transfer-Encoding: quoted-printable\n\n<p>caf=C3=A9</p>\n",
+			expected: []part{{contentType: "text/html", text: "<p>café</p>\n"}},
+		},
+		{
+			name: "nested message",
+			raw: "Content-Type: multipart/mixed; boundary=b\n\n--b\nContent-Type: message/rfc822\n\n" +
+				"Subject: inner\nContent-Type: application/pdf; name=a.pdf\n\n%PDF\n--b--\n",
+			expected: []part{{contentType: "application/pdf", filename: "a.pdf"}},
+		},
+		{
+			name:     "no header section",
+			raw:      "body only",
+			expected: []part{{contentType: "text/plain", text: "body only"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, parseContent([]byte(test.raw)).parts)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/session"
+	"golang.org/x/sync/semaphore"
+)
+
+// reasons of refused connections, which are used as labels of metrics
+const (
+	rejectGlobal   = "global"
+	rejectIp       = "ip"
+	rejectSubnet   = "subnet"
+	rejectShutdown = "shutdown"
+	rejectRate     = "rate"
+)
+
+var (
+	errTooManyConnections       = errors.New("too many connections")
+	errTooManyIpConnections     = errors.New("too many connections from the IP address")
+	errTooManySubnetConnections = errors.New("too many connections from the subnet")
+)
+
+// admission limits concurrent connections in total, per IP address and per subnet.
+// Connections over the total limit wait for a free slot, the others are refused immediately.
+type admission struct {
+	s            *semaphore.Weighted
+	wait         time.Duration
+	maxPerIp     int
+	maxPerSubnet int
+	subnets      *session.SubnetGrouping
+
+	mu        sync.Mutex
+	perIp     map[string]int
+	perSubnet map[string]int
+}
+
+// acquire reserves a slot for the client, release must be called when err is nil.
+func (a *admission) acquire(ctx context.Context, ip net.IP) error {
+	if err := a.acquireAddress(ip); err != nil {
+		return err
+	}
+
+	if a.wait <= 0 {
+		if !a.s.TryAcquire(1) {
+			a.releaseAddress(ip)
+			return errTooManyConnections
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.wait)
+	defer cancel()
+	if err := a.s.Acquire(ctx, 1); err != nil {
+		a.releaseAddress(ip)
+		return errTooManyConnections
+	}
+	return nil
+}
+
+func (a *admission) release(ip net.IP) {
+	a.s.Release(1)
+	a.releaseAddress(ip)
+}
+
+func (a *admission) acquireAddress(ip net.IP) error {
+	// connections without IP address such as tests are limited only in total
+	if ip == nil {
+		return nil
+	}
+	ipKey, subnetKey := a.keys(ip)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.maxPerIp > 0 && a.perIp[ipKey] >= a.maxPerIp {
+		return errTooManyIpConnections
+	}
+	if a.maxPerSubnet > 0 && a.perSubnet[subnetKey] >= a.maxPerSubnet {
+		return errTooManySubnetConnections
+	}
+	a.perIp[ipKey]++
+	a.perSubnet[subnetKey]++
+	return nil
+}
+
+func (a *admission) releaseAddress(ip net.IP) {
+	if ip == nil {
+		return
+	}
+	ipKey, subnetKey := a.keys(ip)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.perIp[ipKey]--; a.perIp[ipKey] <= 0 {
+		delete(a.perIp, ipKey)
+	}
+	if a.perSubnet[subnetKey]--; a.perSubnet[subnetKey] <= 0 {
+		delete(a.perSubnet, subnetKey)
+	}
+}
+
+func (a *admission) keys(ip net.IP) (string, string) {
+	return ip.String(), a.subnets.Subnet(ip).String()
+}
+
+// rejectReason converts the error of acquire to the label of metrics.
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, errTooManyIpConnections):
+		return rejectIp
+	case errors.Is(err, errTooManySubnetConnections):
+		return rejectSubnet
+	default:
+		return rejectGlobal
+	}
+}
+
+func newAdmission(conf *config.ServerConfig, subnets *session.SubnetGrouping) *admission {
+	return &admission{
+		s:            semaphore.NewWeighted(int64(conf.MaxConnection)),
+		wait:         conf.MaxConnectionWait,
+		maxPerIp:     conf.MaxConnectionsPerIp,
+		maxPerSubnet: conf.MaxConnectionsPerSubnet,
+		subnets:      subnets,
+		perIp:        make(map[string]int),
+		perSubnet:    make(map[string]int),
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmission_AddressLimit(t *testing.T) {
+	a := newAdmission(&config.ServerConfig{
+		MaxConnection:           10,
+		MaxConnectionsPerIp:     2,
+		MaxConnectionsPerSubnet: 3,
+	}, session.NewSubnetGrouping(&config.SubnetConfig{}))
+	ctx := context.Background()
+
+	assert.Nil(t, a.acquire(ctx, net.ParseIP("192.0.2.1")))
+	assert.Nil(t, a.acquire(ctx, net.ParseIP("192.0.2.1")))
+	assert.ErrorIs(t, a.acquire(ctx, net.ParseIP("192.0.2.1")), errTooManyIpConnections)
+
+	assert.Nil(t, a.acquire(ctx, net.ParseIP("192.0.2.2")))
+	assert.ErrorIs(t, a.acquire(ctx, net.ParseIP("192.0.2.3")), errTooManySubnetConnections)
+	// other subnet
+	assert.Nil(t, a.acquire(ctx, net.ParseIP("198.51.100.1")))
+	// IPv6 addresses in the same /64
+	assert.Nil(t, a.acquire(ctx, net.ParseIP("2001:db8::1")))
+	assert.Nil(t, a.acquire(ctx, net.ParseIP("2001:db8::2")))
+	assert.Nil(t, a.acquire(ctx, net.ParseIP("2001:db8::3")))
+	assert.ErrorIs(t, a.acquire(ctx, net.ParseIP("2001:db8::4")), errTooManySubnetConnections)
+
+	// released slots are reused
+	a.release(net.ParseIP("192.0.2.1"))
+	assert.Nil(t, a.acquire(ctx, net.ParseIP("192.0.2.3")))
+
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "198.51.100.1", "2001:db8::1", "2001:db8::2", "2001:db8::3"} {
+		a.release(net.ParseIP(ip))
+	}
+	assert.Empty(t, a.perIp)
+	assert.Empty(t, a.perSubnet)
+}
+
+func TestAdmission_Wait(t *testing.T) {
+	a := newAdmission(&config.ServerConfig{
+		MaxConnection:     1,
+		MaxConnectionWait: 50 * time.Millisecond,
+	}, session.NewSubnetGrouping(&config.SubnetConfig{}))
+	ctx := context.Background()
+	ip := net.ParseIP("192.0.2.1")
+
+	assert.Nil(t, a.acquire(ctx, ip))
+
+	// no free slot within the wait time
+	start := time.Now()
+	assert.ErrorIs(t, a.acquire(ctx, net.ParseIP("192.0.2.2")), errTooManyConnections)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.NotContains(t, a.perIp, "192.0.2.2")
+
+	// a slot is released while waiting
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		a.release(ip)
+	}()
+	assert.Nil(t, a.acquire(ctx, net.ParseIP("192.0.2.2")))
+}
+
+func TestAdmission_NoWait(t *testing.T) {
+	a := newAdmission(&config.ServerConfig{
+		MaxConnection: 1,
+	}, session.NewSubnetGrouping(&config.SubnetConfig{}))
+	ctx := context.Background()
+
+	assert.Nil(t, a.acquire(ctx, nil))
+	assert.ErrorIs(t, a.acquire(ctx, nil), errTooManyConnections)
+}
+
+func TestRejectReason(t *testing.T) {
+	assert.Equal(t, rejectIp, rejectReason(errTooManyIpConnections))
+	assert.Equal(t, rejectSubnet, rejectReason(errTooManySubnetConnections))
+	assert.Equal(t, rejectGlobal, rejectReason(errTooManyConnections))
+}
@@ -12,22 +12,38 @@ import (
 	"github.com/Haya372/smtp-server/internal/command"
 	"github.com/Haya372/smtp-server/internal/config"
 	"github.com/Haya372/smtp-server/internal/connection"
+	"github.com/Haya372/smtp-server/internal/metrics"
+	"github.com/Haya372/smtp-server/internal/service"
 	"github.com/Haya372/smtp-server/internal/session"
-	"golang.org/x/sync/semaphore"
 )
 
 type Server struct {
 	Port              string
 	ConnectionTimeout time.Duration
+	ShutdownTimeout   time.Duration
 
-	s       *semaphore.Weighted
-	ln      net.Listener
-	log     hlog.Logger
-	wg      sync.WaitGroup
-	factory session.SessionFactory
-	handler connection.SessionHandler
+	admission *admission
+	ln        net.Listener
+	log       hlog.Logger
+	wg        sync.WaitGroup
+	factory   session.SessionFactory
+	handler   connection.SessionHandler
+	metrics   metrics.Metrics
+	rateLimit service.RateLimitService
+
+	// sessions in progress, the raw connection is kept because STARTTLS replaces session.Conn
+	mu       sync.Mutex
+	sessions map[net.Conn]*session.Session
+	draining bool
+	// canceled when the remaining sessions are force-closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	// canceled when the shutdown starts, connections waiting for a free slot are refused
+	drainCtx context.Context
+	drain    context.CancelFunc
 }
 
+// ListenSmtp starts listening and accepts connections in background until Shutdown is called.
 func (s *Server) ListenSmtp(ctx context.Context) error {
 	tcpAddr, err := net.ResolveTCPAddr("tcp", s.Port)
 	if err != nil {
@@ -39,11 +55,89 @@ func (s *Server) ListenSmtp(ctx context.Context) error {
 		return err
 	}
 
-	s.ln = ln
-	s.waitConnection(ctx)
+	s.serve(ln)
 	return nil
 }
 
+func (s *Server) serve(ln net.Listener) {
+	s.ln = ln
+	// the context of ListenSmtp ends when the server has started, sessions live until Shutdown
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.drainCtx, s.drain = context.WithCancel(s.ctx)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.waitConnection(s.ctx)
+	}()
+}
+
+// Shutdown stops accepting connections and waits for the sessions to finish.
+// Idle sessions are closed with 421 on their next command and transactions in progress are allowed to complete.
+// Sessions remaining after ShutdownTimeout or the end of ctx are closed forcibly.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.ln == nil {
+		return nil
+	}
+	s.ln.Close()
+	s.drain()
+
+	s.mu.Lock()
+	s.draining = true
+	for _, smtpSession := range s.sessions {
+		smtpSession.Drain()
+	}
+	s.mu.Unlock()
+
+	if s.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.ShutdownTimeout)
+		defer cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancel()
+		s.log.Info("all sessions finished.", nil)
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	s.log.Warnf("force closing %d sessions.", len(s.sessions))
+	for conn := range s.sessions {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.cancel()
+
+	<-done
+	return ctx.Err()
+}
+
+// track registers the session, false is returned when the server is shutting down.
+func (s *Server) track(conn net.Conn, smtpSession *session.Session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return false
+	}
+	s.sessions[conn] = smtpSession
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, conn)
+}
+
 func (s *Server) waitConnection(parentCtx context.Context) {
 	if s.ln == nil {
 		s.log.Fatal("TCPLister is not defined.", nil)
@@ -65,30 +159,62 @@ func (s *Server) waitConnection(parentCtx context.Context) {
 		go func() {
 			defer s.wg.Done()
 			smtpSession := s.factory.CreateSession(conn)
-			ctx, cancel := context.WithTimeout(parentCtx, 10*time.Millisecond)
-			defer cancel()
 
-			err = s.s.Acquire(ctx, 1)
-			if err != nil {
-				s.log.WithError(err).Error("could not get semaphore.", nil)
-				smtpSession.Response(command.CodeTransactionFail, command.MsgBadSequence)
+			if !s.track(conn, smtpSession) {
+				s.metrics.Inc(metrics.RejectedConnections, rejectShutdown)
+				smtpSession.Reply(command.ReplyShuttingDown)
 				conn.Close()
 				return
 			}
-			defer s.s.Release(1)
+			defer s.untrack(conn)
 
+			if !s.rateLimit.AllowConnection(smtpSession) {
+				s.log.Warnf("[%s] connection rate limit exceeded by %s.", smtpSession.Id, smtpSession.IP())
+				s.metrics.Inc(metrics.RejectedConnections, rejectRate)
+				smtpSession.Reply(command.ReplyConnectionRate)
+				conn.Close()
+				return
+			}
+
+			// the greeting is delayed while the connection waits for a free slot
+			ip := session.RemoteIP(conn.RemoteAddr())
+			if err := s.admission.acquire(s.drainCtx, ip); err != nil {
+				if s.drainCtx.Err() != nil {
+					s.metrics.Inc(metrics.RejectedConnections, rejectShutdown)
+					smtpSession.Reply(command.ReplyShuttingDown)
+				} else {
+					s.log.WithError(err).Warnf("[%s] refused connection from %s.", smtpSession.Id, ip)
+					s.metrics.Inc(metrics.RejectedConnections, rejectReason(err))
+					smtpSession.Reply(command.ReplyTooManyConnections)
+				}
+				conn.Close()
+				return
+			}
+			defer s.admission.release(ip)
+
+			// connection deadline is set by the session, the context is canceled as well
+			ctx := parentCtx
+			if s.ConnectionTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(parentCtx, s.ConnectionTimeout)
+				defer cancel()
+			}
 			s.handler.HandleSession(ctx, smtpSession)
 		}()
 	}
 }
 
-func NewServer(log hlog.Logger, conf *config.ServerConfig, factory session.SessionFactory, handler connection.SessionHandler) Server {
+func NewServer(log hlog.Logger, conf *config.ServerConfig, factory session.SessionFactory, handler connection.SessionHandler, m metrics.Metrics, rateLimit service.RateLimitService, subnets *session.SubnetGrouping) Server {
 	return Server{
 		Port:              fmt.Sprintf(":%d", conf.Port),
 		ConnectionTimeout: conf.ConnectionTimeout,
+		ShutdownTimeout:   conf.ShutdownTimeout,
 		log:               log,
 		factory:           factory,
-		s:                 semaphore.NewWeighted(int64(conf.MaxConnection)),
+		admission:         newAdmission(conf, subnets),
 		handler:           handler,
+		metrics:           m,
+		rateLimit:         rateLimit,
+		sessions:          make(map[net.Conn]*session.Session),
 	}
 }
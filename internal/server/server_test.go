@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/command"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/connection"
+	"github.com/Haya372/smtp-server/internal/metrics"
+	"github.com/Haya372/smtp-server/internal/mock"
+	"github.com/Haya372/smtp-server/internal/service"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func startTestServer(t *testing.T, ctrl *gomock.Controller, conf *config.ServerConfig, handlers ...command.CommandHandler) *Server {
+	return startTestServerWithRateLimit(t, ctrl, conf, &config.RateLimitConfig{}, handlers...)
+}
+
+func startTestServerWithRateLimit(t *testing.T, ctrl *gomock.Controller, conf *config.ServerConfig, rateLimitConf *config.RateLimitConfig, handlers ...command.CommandHandler) *Server {
+	log := mock.NewInitializedMockLogger(ctrl)
+	s := NewServer(log, conf, session.NewSessionFactory(log, conf), connection.NewSessionHandler(log, &config.SmtpConfig{}, handlers, mock.NewInitializedMockDnsblService(ctrl), mock.NewInitializedMockEarlyTalkerService(ctrl), mock.NewInitializedMockMilterService(ctrl), metrics.NewMetrics()), metrics.NewMetrics(), service.NewRateLimitService(rateLimitConf, session.NewSubnetGrouping(&config.SubnetConfig{})), session.NewSubnetGrouping(&config.SubnetConfig{}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	s.serve(ln)
+	return &s
+}
+
+func dial(t *testing.T, s *Server) (net.Conn, *textproto.Reader) {
+	conn, err := net.Dial("tcp", s.ln.Addr().String())
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	line, err := reader.ReadLine()
+	assert.Nil(t, err)
+	assert.Equal(t, command.ReplyGreet.String(), line+"\r\n")
+	return conn, reader
+}
+
+// waitDraining waits until Shutdown has marked the sessions.
+func waitDraining(s *Server) {
+	for {
+		s.mu.Lock()
+		draining := s.draining
+		s.mu.Unlock()
+		if draining {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	dataHandler := mock.NewInitializedMockCommandHandler(ctrl, command.DATA)
+	dataHandler.EXPECT().HandleCommand(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, s *session.Session, arg []string) error {
+			close(started)
+			<-finish
+			return s.Reply(command.ReplyOk)
+		},
+	)
+	s := startTestServer(t, ctrl, &config.ServerConfig{
+		MaxConnection:   10,
+		ShutdownTimeout: time.Minute,
+	}, dataHandler)
+
+	idleConn, idleReader := dial(t, s)
+	busyConn, busyReader := dial(t, s)
+
+	// transaction in progress
+	busyConn.Write([]byte("DATA\r\n"))
+	<-started
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+	waitDraining(s)
+
+	// new connection is refused
+	_, err := net.Dial("tcp", s.ln.Addr().String())
+	assert.NotNil(t, err)
+
+	// idle session is closed on the next command
+	idleConn.Write([]byte("NOOP\r\n"))
+	line, err := idleReader.ReadLine()
+	assert.Nil(t, err)
+	assert.Equal(t, command.ReplyShuttingDown.String(), line+"\r\n")
+
+	// transaction in progress completes
+	close(finish)
+	line, err = busyReader.ReadLine()
+	assert.Nil(t, err)
+	assert.Equal(t, command.ReplyOk.String(), line+"\r\n")
+	busyConn.Write([]byte("NOOP\r\n"))
+	line, err = busyReader.ReadLine()
+	assert.Nil(t, err)
+	assert.Equal(t, command.ReplyShuttingDown.String(), line+"\r\n")
+
+	assert.Nil(t, <-shutdown)
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := startTestServer(t, ctrl, &config.ServerConfig{
+		MaxConnection:   10,
+		ShutdownTimeout: 50 * time.Millisecond,
+	})
+	_, reader := dial(t, s)
+
+	// session which sends nothing is closed forcibly
+	assert.ErrorIs(t, s.Shutdown(context.Background()), context.DeadlineExceeded)
+	_, err := reader.ReadLine()
+	assert.NotNil(t, err)
+}
+
+func TestServer_TooManyConnections(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := startTestServer(t, ctrl, &config.ServerConfig{
+		MaxConnection:       10,
+		MaxConnectionsPerIp: 1,
+	})
+	dial(t, s)
+
+	conn, err := net.Dial("tcp", s.ln.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	line, err := textproto.NewReader(bufio.NewReader(conn)).ReadLine()
+	assert.Nil(t, err)
+	assert.Equal(t, command.ReplyTooManyConnections.String(), line+"\r\n")
+	assert.Equal(t, int64(1), s.metrics.Get(metrics.RejectedConnections, rejectIp))
+}
+
+func TestServer_ConnectionRate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := startTestServerWithRateLimit(t, ctrl, &config.ServerConfig{
+		MaxConnection: 10,
+	}, &config.RateLimitConfig{
+		Connections: config.RateLimitRule{
+			PerIp: config.RateLimit{Limit: 1, Window: time.Minute},
+		},
+	})
+	dial(t, s)
+
+	conn, err := net.Dial("tcp", s.ln.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	line, err := textproto.NewReader(bufio.NewReader(conn)).ReadLine()
+	assert.Nil(t, err)
+	assert.Equal(t, command.ReplyConnectionRate.String(), line+"\r\n")
+	assert.Equal(t, int64(1), s.metrics.Get(metrics.RejectedConnections, rejectRate))
+}
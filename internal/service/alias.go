@@ -0,0 +1,75 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// aliasMap maps a lower-cased key to its targets.
+type aliasMap map[string][]string
+
+// loadAliasFile loads sendmail-style aliases.
+// Each entry is "name: target, target", lines starting with white space continue the previous entry.
+func loadAliasFile(path string) (aliasMap, error) {
+	return loadMapFile(path, func(line string) (string, string, bool) {
+		return strings.Cut(line, ":")
+	})
+}
+
+// loadVirtualFile loads postfix-style virtual maps.
+// Each entry is "address target, target", the key "@domain" is the catch-all of the domain.
+func loadVirtualFile(path string) (aliasMap, error) {
+	return loadMapFile(path, func(line string) (string, string, bool) {
+		return strings.Cut(strings.Replace(line, "\t", " ", 1), " ")
+	})
+}
+
+func loadMapFile(path string, split func(line string) (string, string, bool)) (aliasMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make([]string, 0)
+	lineNos := make([]int, 0)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		// continuation line
+		if (line[0] == ' ' || line[0] == '\t') && len(entries) > 0 {
+			entries[len(entries)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+		entries = append(entries, strings.TrimSpace(line))
+		lineNos = append(lineNos, lineNo)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	m := make(aliasMap)
+	for idx, entry := range entries {
+		key, value, ok := split(entry)
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !ok || len(key) == 0 {
+			return nil, fmt.Errorf("%s:%d: invalid entry %q", path, lineNos[idx], entry)
+		}
+		targets := make([]string, 0)
+		for _, target := range strings.Split(value, ",") {
+			if target = strings.TrimSpace(target); len(target) > 0 {
+				targets = append(targets, target)
+			}
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("%s:%d: no target for %q", path, lineNos[idx], key)
+		}
+		m[key] = append(m[key], targets...)
+	}
+	return m, nil
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/clamd"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+const defaultAntivirusTimeout = 30 * time.Second
+
+// AntivirusService scans the message for viruses by clamd after DATA.
+type AntivirusService interface {
+	// Scan streams the message s.RawData to clamd.
+	Scan(ctx context.Context, s *session.Session) *data.VirusResult
+}
+
+type antivirusServiceImpl struct {
+	log      hlog.Logger
+	enable   bool
+	client   *clamd.Client
+	failOpen bool
+}
+
+func (a *antivirusServiceImpl) Scan(ctx context.Context, s *session.Session) *data.VirusResult {
+	if !a.enable || s.Discard {
+		return &data.VirusResult{}
+	}
+
+	res, err := a.client.Scan(ctx, s.RawData)
+	if err != nil {
+		a.log.WithError(err).Errorf("[%s] failed to scan message by %s.", s.Id, a.client)
+		if a.failOpen {
+			return &data.VirusResult{}
+		}
+		return &data.VirusResult{Action: data.VirusTempFail}
+	}
+	if !res.Infected {
+		return &data.VirusResult{}
+	}
+	a.log.Warnf("[%s] virus %s is found in the message from %s.", s.Id, res.Virus, s.IP())
+	return &data.VirusResult{Action: data.VirusInfected, Virus: res.Virus}
+}
+
+func NewAntivirusService(log hlog.Logger, conf *config.AntivirusConfig) (AntivirusService, error) {
+	impl := &antivirusServiceImpl{
+		log:      log,
+		enable:   conf.Enable,
+		failOpen: conf.FailOpen,
+	}
+	if !conf.Enable {
+		return impl, nil
+	}
+
+	if conf.Network != "tcp" && conf.Network != "unix" {
+		return nil, fmt.Errorf("invalid network %q of clamd", conf.Network)
+	}
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultAntivirusTimeout
+	}
+	impl.client = clamd.NewClient(conf.Network, conf.Address, timeout)
+	return impl, nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/clamd/clamdtest"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const testVirusSignature = "VIRUS-SIGNATURE"
+
+func newStubClamd(t *testing.T) *clamdtest.Server {
+	server, err := clamdtest.NewServer("tcp", "127.0.0.1:0", map[string]string{testVirusSignature: "Test-Virus"})
+	assert.Nil(t, err)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestAntivirusService(t *testing.T, conf *config.AntivirusConfig) AntivirusService {
+	ctrl := gomock.NewController(t)
+	a, err := NewAntivirusService(mock.NewInitializedMockLogger(ctrl), conf)
+	assert.Nil(t, err)
+	return a
+}
+
+func TestAntivirusService_Scan(t *testing.T) {
+	tests := []struct {
+		name     string
+		rawData  string
+		expected *data.VirusResult
+	}{
+		{
+			name:     "clean",
+			rawData:  "Subject: test\n\nbody\n",
+			expected: &data.VirusResult{},
+		},
+		{
+			name:     "infected",
+			rawData:  "Subject: test\n\n" + testVirusSignature + "\n",
+			expected: &data.VirusResult{Action: data.VirusInfected, Virus: "Test-Virus"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newStubClamd(t)
+			a := newTestAntivirusService(t, &config.AntivirusConfig{Enable: true, Network: "tcp", Address: server.Addr(), Timeout: time.Second})
+			s := newSpamSession()
+			s.RawData = []byte(test.rawData)
+
+			assert.Equal(t, test.expected, a.Scan(context.Background(), s))
+			assert.Equal(t, [][]byte{[]byte(test.rawData)}, server.Streams())
+		})
+	}
+}
+
+func TestAntivirusService_ScannerDown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := ln.Addr().String()
+	ln.Close()
+
+	tests := []struct {
+		name     string
+		failOpen bool
+		action   data.VirusAction
+	}{
+		{name: "fail open", failOpen: true, action: data.VirusClean},
+		{name: "fail closed", failOpen: false, action: data.VirusTempFail},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := newTestAntivirusService(t, &config.AntivirusConfig{Enable: true, Network: "tcp", Address: address, FailOpen: test.failOpen})
+
+			assert.Equal(t, test.action, a.Scan(context.Background(), newSpamSession()).Action)
+		})
+	}
+}
+
+func TestAntivirusService_NotScanned(t *testing.T) {
+	server := newStubClamd(t)
+
+	// disabled
+	a := newTestAntivirusService(t, &config.AntivirusConfig{Enable: false, Network: "tcp", Address: server.Addr()})
+	s := newSpamSession()
+	s.RawData = []byte(testVirusSignature)
+	assert.Equal(t, &data.VirusResult{}, a.Scan(context.Background(), s))
+
+	// discarded messages are not delivered
+	a = newTestAntivirusService(t, &config.AntivirusConfig{Enable: true, Network: "tcp", Address: server.Addr()})
+	s.Discard = true
+	assert.Equal(t, &data.VirusResult{}, a.Scan(context.Background(), s))
+
+	assert.Empty(t, server.Streams())
+}
+
+func TestNewAntivirusService_InvalidConfig(t *testing.T) {
+	_, err := NewAntivirusService(nil, &config.AntivirusConfig{Enable: true, Network: "udp", Address: "127.0.0.1:3310"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeClock is a frozen clock which replaces now of the services, tests move it forward by Advance.
+type fakeClock struct {
+	now time.Time
+}
+
+// freezeClock replaces the clock of the service, now is the field of the service.
+func freezeClock(now *func() time.Time) *fakeClock {
+	c := &fakeClock{now: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	*now = c.Now
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// storeDbPaths returns the database of each store of the services, the memory store has no database.
+func storeDbPaths(t *testing.T) map[string]string {
+	return map[string]string{
+		"memory": "",
+		"sqlite": filepath.Join(t.TempDir(), "store.db"),
+	}
+}
@@ -0,0 +1,489 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/maildir"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/Haya372/smtp-server/internal/sieve"
+	"github.com/google/uuid"
+)
+
+// Sieve scripts larger than this are not executed
+const maxSieveScriptSize = 1 << 20
+
+// DeliveryService stores accepted messages into the Maildirs of the local recipients and relays the others.
+type DeliveryService interface {
+	// Deliver executes the Sieve script of each local recipient and takes its actions. Recipients of other
+	// domains are relayed to the relay host. Discarded messages are not stored, quarantined ones are stored into
+	// the quarantine folder without Sieve and are not relayed.
+	Deliver(ctx context.Context, s *session.Session) *data.DeliveryResult
+}
+
+type sieveScript struct {
+	modTime time.Time
+	size    int64
+	script  *sieve.Script
+	err     error
+}
+
+type deliveryServiceImpl struct {
+	log              hlog.Logger
+	enable           bool
+	maildirPath      string
+	sievePath        string
+	quarantineFolder string
+	delimiter        string
+	recipient        RecipientService
+	srs              SrsService
+	sender           MailSender
+	vacation         VacationService
+
+	mu sync.Mutex
+	// compiled scripts by the path, recompiled when the file is changed
+	scripts map[string]*sieveScript
+}
+
+// sieveOutcome is the result of the script of a recipient.
+type sieveOutcome struct {
+	recipient string
+	rejected  bool
+	reason    string
+}
+
+// recipient which could not be delivered, err is reported to the sender by the delivery status notification
+type deliveryFailure struct {
+	recipient string
+	err       error
+}
+
+func (d *deliveryServiceImpl) Deliver(ctx context.Context, s *session.Session) *data.DeliveryResult {
+	if !d.enable || s.Discard {
+		return &data.DeliveryResult{}
+	}
+
+	local := make([]mail.Address, 0, len(s.EnvelopeTo))
+	remote := make([]string, 0)
+	for _, to := range s.EnvelopeTo {
+		if _, domain := splitAddress(to.Address); d.recipient.IsLocalDomain(domain) {
+			local = append(local, to)
+		} else {
+			remote = append(remote, to.Address)
+		}
+	}
+
+	// recipients are delivered independently, a failure must not make the client retry delivered recipients
+	delivered := 0
+	failed := make([]deliveryFailure, 0)
+	if len(remote) > 0 {
+		if err := d.relay(ctx, s, remote); err != nil {
+			d.log.WithError(err).Errorf("[%s] failed to relay message to %s.", s.Id, strings.Join(remote, ", "))
+			for _, to := range remote {
+				failed = append(failed, deliveryFailure{recipient: to, err: err})
+			}
+		} else {
+			delivered += len(remote)
+		}
+	}
+
+	rejected := make([]sieveOutcome, 0)
+	for _, to := range local {
+		outcome, err := d.deliverTo(ctx, s, to.Address)
+		if err != nil {
+			d.log.WithError(err).Errorf("[%s] failed to deliver message to %s.", s.Id, to.Address)
+			failed = append(failed, deliveryFailure{recipient: to.Address, err: err})
+			continue
+		}
+		if outcome.rejected {
+			rejected = append(rejected, outcome)
+		} else {
+			delivered++
+		}
+	}
+
+	// nothing has been delivered, the client retries the whole transaction
+	if len(failed) > 0 && delivered == 0 {
+		return &data.DeliveryResult{Action: data.DeliveryTempFail}
+	}
+	// the message is refused in SMTP only when every recipient rejects it
+	// https://tex2e.github.io/rfc-translater/html/rfc5429.html#2-1--Action-reject
+	if len(rejected) > 0 && len(rejected) == len(s.EnvelopeTo) {
+		return &data.DeliveryResult{Action: data.DeliveryReject, Reason: rejected[0].reason}
+	}
+	// other recipients accept the message, the sender is notified instead of the SMTP reply
+	for _, outcome := range rejected {
+		d.notifyRejection(ctx, s, outcome.recipient, outcome.reason)
+	}
+	if len(failed) > 0 {
+		d.notifyFailure(ctx, s, failed)
+	}
+	return &data.DeliveryResult{}
+}
+
+// deliverTo executes the Sieve script of the recipient.
+func (d *deliveryServiceImpl) deliverTo(ctx context.Context, s *session.Session, recipient string) (sieveOutcome, error) {
+	box, scriptPath := d.paths(recipient)
+	msg := data.PrependHeaders(s.RawData, "Delivered-To: "+recipient)
+
+	if s.Quarantine {
+		_, err := box.Deliver(d.quarantineFolder, msg)
+		return sieveOutcome{}, err
+	}
+
+	sieveMsg := &sieve.Message{To: recipient, Raw: s.RawData}
+	if s.EnvelopeFrom != nil {
+		sieveMsg.From = s.EnvelopeFrom.Address
+	}
+	res := d.runSieve(s, scriptPath, sieveMsg)
+
+	if res.Reject {
+		d.log.Infof("[%s] message to %s is rejected by Sieve.", s.Id, recipient)
+		return sieveOutcome{recipient: recipient, rejected: true, reason: res.RejectReason}, nil
+	}
+
+	keep := res.Keep
+	for _, address := range res.Redirect {
+		if err := d.redirect(ctx, s, sieveMsg, recipient, address, msg); err != nil {
+			// failed redirect falls back to keep
+			d.log.WithError(err).Errorf("[%s] failed to redirect message for %s to %s.", s.Id, recipient, address)
+			keep = true
+		}
+	}
+	for _, folder := range res.FileInto {
+		if _, err := box.Path(folder); err != nil {
+			d.log.WithError(err).Warnf("[%s] message for %s is kept in the inbox.", s.Id, recipient)
+			keep = true
+			continue
+		}
+		if _, err := box.Deliver(folder, msg); err != nil {
+			return sieveOutcome{}, err
+		}
+	}
+	if keep {
+		if _, err := box.Deliver(maildir.Inbox, msg); err != nil {
+			return sieveOutcome{}, err
+		}
+	}
+
+	if res.Vacation != nil {
+		vacation := *res.Vacation
+		// the mailbox address without subaddress is also the user's address
+		vacation.Addresses = append(vacation.Addresses, d.mailboxAddress(recipient))
+		if err := d.vacation.Respond(ctx, recipient, sieveMsg, &vacation); err != nil {
+			d.log.WithError(err).Errorf("[%s] failed to send vacation reply of %s.", s.Id, recipient)
+		}
+	} else if keep || len(res.FileInto) > 0 {
+		// the auto-reply of the mailbox is used when the script does not reply by itself
+		if err := d.vacation.AutoReply(ctx, recipient, d.mailboxAddress(recipient), sieveMsg); err != nil {
+			d.log.WithError(err).Errorf("[%s] failed to send auto-reply of %s.", s.Id, recipient)
+		}
+	}
+	return sieveOutcome{}, nil
+}
+
+// runSieve executes the script of the recipient, the message is kept when the script is not available.
+func (d *deliveryServiceImpl) runSieve(s *session.Session, path string, msg *sieve.Message) *sieve.Result {
+	script, err := d.script(path)
+	if err != nil {
+		d.log.WithError(err).Errorf("[%s] failed to load Sieve script %s.", s.Id, path)
+	}
+	if script == nil {
+		return &sieve.Result{Keep: true}
+	}
+
+	res, err := script.Run(msg)
+	if err != nil {
+		d.log.WithError(err).Errorf("[%s] failed to execute Sieve script %s.", s.Id, path)
+	}
+	return res
+}
+
+// script returns the compiled script, nil is returned without error when the file does not exist.
+func (d *deliveryServiceImpl) script(path string) (*sieve.Script, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxSieveScriptSize {
+		return nil, fmt.Errorf("script of %d bytes is too large", info.Size())
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cached, ok := d.scripts[path]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.script, cached.err
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	script, err := sieve.Compile(string(src))
+	// invalid scripts are cached not to compile them for every message
+	d.scripts[path] = &sieveScript{modTime: info.ModTime(), size: info.Size(), script: script, err: err}
+	return script, err
+}
+
+// redirect forwards the message, the sender of other domains is rewritten by SRS.
+// https://tex2e.github.io/rfc-translater/html/rfc5228.html#4-2--Action-redirect
+func (d *deliveryServiceImpl) redirect(ctx context.Context, s *session.Session, sieveMsg *sieve.Message, recipient, address string, msg []byte) error {
+	// the message has been delivered to the address by this server, which is a loop
+	for _, delivered := range sieveMsg.Header("Delivered-To") {
+		if strings.EqualFold(strings.TrimSpace(delivered), address) {
+			return fmt.Errorf("redirect loop to %s", address)
+		}
+	}
+
+	from, err := d.forwardSender(s)
+	if err != nil {
+		return err
+	}
+	if err := d.sender.Send(ctx, from, []string{address}, msg, s.BodyType); err != nil {
+		return err
+	}
+	d.log.Infof("[%s] message for %s is redirected to %s.", s.Id, recipient, address)
+	return nil
+}
+
+// relay transfers the message to the recipients of other domains, such as forwards by aliases, redirects by
+// content filters and relays of authenticated clients.
+func (d *deliveryServiceImpl) relay(ctx context.Context, s *session.Session, to []string) error {
+	if s.Quarantine {
+		d.log.Infof("[%s] quarantined message is not relayed to %s.", s.Id, strings.Join(to, ", "))
+		return nil
+	}
+	from, err := d.forwardSender(s)
+	if err != nil {
+		return err
+	}
+	if err := d.sender.Send(ctx, from, to, s.RawData, s.BodyType); err != nil {
+		return err
+	}
+	d.log.Infof("[%s] message is relayed to %s.", s.Id, strings.Join(to, ", "))
+	return nil
+}
+
+// forwardSender returns the envelope sender of the message sent to other domains, the sender of other domains is
+// rewritten by SRS so that the message passes SPF check of the destination.
+func (d *deliveryServiceImpl) forwardSender(s *session.Session) (string, error) {
+	if s.EnvelopeFrom == nil || len(s.EnvelopeFrom.Address) == 0 {
+		return "", nil
+	}
+	if s.ForwardFrom != nil {
+		return s.ForwardFrom.Address, nil
+	}
+	if _, domain := splitAddress(s.EnvelopeFrom.Address); d.recipient.IsLocalDomain(domain) {
+		return s.EnvelopeFrom.Address, nil
+	}
+	rewritten, err := d.srs.Forward(*s.EnvelopeFrom)
+	if err != nil {
+		return "", err
+	}
+	return rewritten.Address, nil
+}
+
+// notifyRejection sends a message disposition notification of the rejection to the envelope sender.
+// https://tex2e.github.io/rfc-translater/html/rfc5429.html#2-1-1--Rejecting-a-Message-by-Sending-an-MDN
+func (d *deliveryServiceImpl) notifyRejection(ctx context.Context, s *session.Session, recipient, reason string) {
+	if s.EnvelopeFrom == nil || len(s.EnvelopeFrom.Address) == 0 {
+		return
+	}
+	notice := rejectionNotice(d.sender.Hostname(), recipient, s.EnvelopeFrom.Address, reason, s.RawData, time.Now())
+	if err := d.sender.Send(ctx, "", []string{s.EnvelopeFrom.Address}, notice, session.Body8BitMime); err != nil {
+		d.log.WithError(err).Errorf("[%s] failed to send rejection notice of %s.", s.Id, recipient)
+	}
+}
+
+func rejectionNotice(hostname, recipient, sender, reason string, raw []byte, now time.Time) []byte {
+	boundary := uuid.New().String()
+	subject := ""
+	if msg, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: Mail Delivery Subsystem <MAILER-DAEMON@%s>\r\n", hostname)
+	fmt.Fprintf(&buf, "To: %s\r\n", sender)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Message rejected: "+subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", uuid.New(), hostname)
+	buf.WriteString("Auto-Submitted: auto-replied (rejected)\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/report; report-type=disposition-notification; boundary=\"%s\"\r\n", boundary)
+	buf.WriteString("\r\n")
+
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&buf, "Your message to %s was automatically rejected:\r\n", recipient)
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(reason, "\r\n", "\n"), "\n", "\r\n"))
+	buf.WriteString("\r\n")
+
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	buf.WriteString("Content-Type: message/disposition-notification\r\n\r\n")
+	fmt.Fprintf(&buf, "Reporting-UA: %s; Sieve\r\n", hostname)
+	fmt.Fprintf(&buf, "Final-Recipient: rfc822; %s\r\n", recipient)
+	buf.WriteString("Disposition: automatic-action/MDN-sent-automatically; deleted\r\n")
+
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	buf.WriteString("Content-Type: text/rfc822-headers\r\n\r\n")
+	buf.Write(headerSection(raw))
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes()
+}
+
+// notifyFailure sends a delivery status notification of the recipients which could not be delivered while the
+// message has been delivered to the others.
+// https://tex2e.github.io/rfc-translater/html/rfc3464.html
+func (d *deliveryServiceImpl) notifyFailure(ctx context.Context, s *session.Session, failures []deliveryFailure) {
+	recipients := make([]string, 0, len(failures))
+	for _, failure := range failures {
+		recipients = append(recipients, failure.recipient)
+	}
+	if s.EnvelopeFrom == nil || len(s.EnvelopeFrom.Address) == 0 {
+		d.log.Warnf("[%s] message to %s is lost, the sender is null.", s.Id, strings.Join(recipients, ", "))
+		return
+	}
+	notice := failureNotice(d.sender.Hostname(), s.EnvelopeFrom.Address, failures, s.RawData, time.Now())
+	if err := d.sender.Send(ctx, "", []string{s.EnvelopeFrom.Address}, notice, session.Body8BitMime); err != nil {
+		d.log.WithError(err).Errorf("[%s] failed to send failure notice of %s.", s.Id, strings.Join(recipients, ", "))
+	}
+}
+
+func failureNotice(hostname, sender string, failures []deliveryFailure, raw []byte, now time.Time) []byte {
+	boundary := uuid.New().String()
+	subject := ""
+	if msg, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: Mail Delivery Subsystem <MAILER-DAEMON@%s>\r\n", hostname)
+	fmt.Fprintf(&buf, "To: %s\r\n", sender)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Undelivered Mail: "+subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", uuid.New(), hostname)
+	buf.WriteString("Auto-Submitted: auto-replied\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/report; report-type=delivery-status; boundary=\"%s\"\r\n", boundary)
+	buf.WriteString("\r\n")
+
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	buf.WriteString("Your message could not be delivered to the following recipients:\r\n")
+	for _, failure := range failures {
+		fmt.Fprintf(&buf, "  %s\r\n", failure.recipient)
+	}
+
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	buf.WriteString("Content-Type: message/delivery-status\r\n\r\n")
+	fmt.Fprintf(&buf, "Reporting-MTA: dns; %s\r\n", hostname)
+	fmt.Fprintf(&buf, "Arrival-Date: %s\r\n", now.Format(time.RFC1123Z))
+	for _, failure := range failures {
+		status, diagnostic := failureStatus(failure.err)
+		fmt.Fprintf(&buf, "\r\nFinal-Recipient: rfc822; %s\r\n", failure.recipient)
+		buf.WriteString("Action: failed\r\n")
+		fmt.Fprintf(&buf, "Status: %s\r\n", status)
+		if len(diagnostic) > 0 {
+			fmt.Fprintf(&buf, "Diagnostic-Code: smtp; %s\r\n", diagnostic)
+		}
+	}
+
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	buf.WriteString("Content-Type: text/rfc822-headers\r\n\r\n")
+	buf.Write(headerSection(raw))
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes()
+}
+
+// failureStatus returns the status of the recipient and the reply of the relay host, which is empty when the
+// relay host has not replied. The status is permanent because the message is not retried after the notification,
+// the subject and detail of the relay host are kept.
+// https://tex2e.github.io/rfc-translater/html/rfc3464.html#2-3-4--Status-field
+func failureStatus(err error) (session.EnhancedCode, string) {
+	status := session.EnhancedCode{5, 3, 0}
+	var reply *textproto.Error
+	if !errors.As(err, &reply) {
+		return status, ""
+	}
+	field, _, _ := strings.Cut(reply.Msg, " ")
+	if parts := strings.Split(field, "."); len(parts) == 3 && (parts[0] == "4" || parts[0] == "5") {
+		subject, err1 := strconv.Atoi(parts[1])
+		detail, err2 := strconv.Atoi(parts[2])
+		if err1 == nil && err2 == nil {
+			status = session.EnhancedCode{5, subject, detail}
+		}
+	}
+	// lines of the multiline reply are joined not to break the field
+	return status, fmt.Sprintf("%d %s", reply.Code, strings.ReplaceAll(reply.Msg, "\n", " "))
+}
+
+// headerSection returns the header fields of the message with CRLF line breaks.
+func headerSection(raw []byte) []byte {
+	header := raw
+	if end := bytes.Index(raw, []byte("\n\n")); end >= 0 {
+		header = raw[:end+1]
+	} else if end := bytes.Index(raw, []byte("\r\n\r\n")); end >= 0 {
+		header = raw[:end+2]
+	}
+	return bytes.ReplaceAll(bytes.ReplaceAll(header, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+}
+
+// paths returns the Maildir and the Sieve script of the recipient.
+func (d *deliveryServiceImpl) paths(recipient string) (*maildir.Maildir, string) {
+	localPart, domain := splitAddress(strings.ToLower(d.mailboxAddress(recipient)))
+	replacer := strings.NewReplacer("{domain}", safePathElement(domain), "{localpart}", safePathElement(localPart))
+	return maildir.New(replacer.Replace(d.maildirPath), d.sender.Hostname()), replacer.Replace(d.sievePath)
+}
+
+// mailboxAddress removes the subaddress, "user+tag@example.com" becomes "user@example.com".
+func (d *deliveryServiceImpl) mailboxAddress(recipient string) string {
+	localPart, domain := splitAddress(recipient)
+	if len(d.delimiter) > 0 {
+		if idx := strings.Index(localPart, d.delimiter); idx > 0 {
+			localPart = localPart[:idx]
+		}
+	}
+	return localPart + "@" + domain
+}
+
+// safePathElement prevents the address from escaping the directory of the template.
+func safePathElement(s string) string {
+	s = strings.NewReplacer("/", "_", "\\", "_", "\x00", "_").Replace(s)
+	if s == "." || s == ".." || len(s) == 0 {
+		return "_" + s
+	}
+	return s
+}
+
+func NewDeliveryService(log hlog.Logger, conf *config.DeliveryConfig, recipientConf *config.RecipientConfig, recipient RecipientService, srs SrsService, sender MailSender, vacation VacationService) DeliveryService {
+	return &deliveryServiceImpl{
+		log:              log,
+		enable:           conf.Enable,
+		maildirPath:      conf.MaildirPath,
+		sievePath:        conf.SievePath,
+		quarantineFolder: conf.QuarantineFolder,
+		delimiter:        recipientConf.RecipientDelimiter,
+		recipient:        recipient,
+		srs:              srs,
+		sender:           sender,
+		vacation:         vacation,
+		scripts:          make(map[string]*sieveScript),
+	}
+}
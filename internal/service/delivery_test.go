@@ -0,0 +1,347 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/mock"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/Haya372/smtp-server/internal/sieve"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+type deliveryTest struct {
+	dir      string
+	sender   *mock.MockMailSender
+	vacation *mock.MockVacationService
+	target   DeliveryService
+	// recipients and mailboxes whose auto-reply is requested
+	autoReplied []string
+}
+
+func newDeliveryTest(t *testing.T) *deliveryTest {
+	ctrl := gomock.NewController(t)
+	dir := t.TempDir()
+
+	recipient := mock.NewMockRecipientService(ctrl)
+	recipient.EXPECT().IsLocalDomain(gomock.Any()).DoAndReturn(func(domain string) bool {
+		return strings.EqualFold(domain, "example.com")
+	}).AnyTimes()
+	srs := mock.NewMockSrsService(ctrl)
+	srs.EXPECT().Forward(gomock.Any()).DoAndReturn(func(sender mail.Address) (mail.Address, error) {
+		return mail.Address{Address: "SRS0=hash=tt=example.net=alice@example.com"}, nil
+	}).AnyTimes()
+	sender := mock.NewMockMailSender(ctrl)
+	sender.EXPECT().Hostname().Return("mx.example.com").AnyTimes()
+	vacation := mock.NewMockVacationService(ctrl)
+	d := &deliveryTest{dir: dir, sender: sender, vacation: vacation}
+	vacation.EXPECT().AutoReply(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, recipient, mailbox string, msg *sieve.Message) error {
+			d.autoReplied = append(d.autoReplied, recipient+" "+mailbox)
+			return nil
+		}).AnyTimes()
+
+	conf := &config.DeliveryConfig{
+		Enable:           true,
+		MaildirPath:      filepath.Join(dir, "mail", "{domain}", "{localpart}"),
+		SievePath:        filepath.Join(dir, "sieve", "{domain}", "{localpart}.sieve"),
+		QuarantineFolder: "Junk",
+	}
+	d.target = NewDeliveryService(mock.NewInitializedMockLogger(ctrl), conf, &config.RecipientConfig{RecipientDelimiter: "+"}, recipient, srs, sender, vacation)
+	return d
+}
+
+func (d *deliveryTest) writeScript(t *testing.T, user, script string) {
+	path := filepath.Join(d.dir, "sieve", "example.com", user+".sieve")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	assert.Nil(t, os.WriteFile(path, []byte(script), 0o600))
+}
+
+// blockMailbox makes the delivery to the user fail by a file at the path of the Maildir.
+func (d *deliveryTest) blockMailbox(t *testing.T, user string) {
+	path := filepath.Join(d.dir, "mail", "example.com", user)
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	assert.Nil(t, os.WriteFile(path, nil, 0o600))
+}
+
+// messages returns the messages in "new" of the folder.
+func (d *deliveryTest) messages(t *testing.T, user, folder string) []string {
+	dir := filepath.Join(d.dir, "mail", "example.com", user)
+	if len(folder) > 0 {
+		dir = filepath.Join(dir, "."+folder)
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	assert.Nil(t, err)
+
+	messages := make([]string, 0)
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, "new", entry.Name()))
+		assert.Nil(t, err)
+		messages = append(messages, string(content))
+	}
+	return messages
+}
+
+func newDeliverySession(from string, to ...string) *session.Session {
+	s := newSpamSession()
+	s.EnvelopeFrom = &mail.Address{Address: from}
+	s.EnvelopeTo = make([]mail.Address, 0)
+	for _, address := range to {
+		s.EnvelopeTo = append(s.EnvelopeTo, mail.Address{Address: address})
+	}
+	s.RawData = []byte("Subject: meeting\r\nTo: bob@example.com\r\n\r\nbody\r\n")
+	return s
+}
+
+func TestDeliveryService_Deliver(t *testing.T) {
+	t.Run("kept without script", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		s := newDeliverySession("alice@example.net", "bob+news@example.com", "carol@example.net")
+		d.sender.EXPECT().Send(gomock.Any(), "SRS0=hash=tt=example.net=alice@example.com", []string{"carol@example.net"}, s.RawData, s.BodyType).Return(nil)
+
+		assert.Equal(t, &data.DeliveryResult{}, d.target.Deliver(context.Background(), s))
+		// the mailbox without subaddress receives the message, the remote recipient is relayed
+		assert.Equal(t, []string{"Delivered-To: bob+news@example.com\nSubject: meeting\nTo: bob@example.com\n\nbody\n"}, d.messages(t, "bob", ""))
+		assert.Equal(t, []string{"bob+news@example.com bob@example.com"}, d.autoReplied)
+	})
+
+	t.Run("relayed to other domains", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		s := newDeliverySession("bob@example.com", "carol@example.net", "dave@example.org")
+		s.BodyType = session.BodyBinaryMime
+		// the sender of the local domain is not rewritten, the body type is passed to convert binary content
+		d.sender.EXPECT().Send(gomock.Any(), "bob@example.com", []string{"carol@example.net", "dave@example.org"}, s.RawData, session.BodyBinaryMime).Return(nil)
+
+		assert.Equal(t, &data.DeliveryResult{}, d.target.Deliver(context.Background(), s))
+	})
+
+	t.Run("forward sender rewritten by RCPT", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		s := newDeliverySession("alice@example.net", "carol@example.net")
+		s.ForwardFrom = &mail.Address{Address: "SRS0=rcpt=tt=example.net=alice@example.com"}
+		d.sender.EXPECT().Send(gomock.Any(), "SRS0=rcpt=tt=example.net=alice@example.com", []string{"carol@example.net"}, s.RawData, s.BodyType).Return(nil)
+
+		assert.Equal(t, &data.DeliveryResult{}, d.target.Deliver(context.Background(), s))
+	})
+
+	t.Run("relay failure", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		d.sender.EXPECT().Send(gomock.Any(), gomock.Any(), []string{"carol@example.net"}, gomock.Any(), gomock.Any()).Return(errors.New("relay down"))
+
+		res := d.target.Deliver(context.Background(), newDeliverySession("alice@example.net", "carol@example.net"))
+		assert.Equal(t, &data.DeliveryResult{Action: data.DeliveryTempFail}, res)
+	})
+
+	t.Run("failure of some recipients", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		d.blockMailbox(t, "carol")
+		d.sender.EXPECT().Send(gomock.Any(), gomock.Any(), []string{"dave@example.net"}, gomock.Any(), gomock.Any()).Return(&textproto.Error{Code: 550, Msg: "5.1.1 no such user"})
+		d.sender.EXPECT().Send(gomock.Any(), "", []string{"alice@example.net"}, gomock.Any(), session.Body8BitMime).DoAndReturn(
+			func(ctx context.Context, from string, to []string, msg []byte, body session.BodyType) error {
+				assert.Contains(t, string(msg), "Content-Type: message/delivery-status\r\n")
+				assert.Contains(t, string(msg), "Final-Recipient: rfc822; dave@example.net\r\nAction: failed\r\nStatus: 5.1.1\r\nDiagnostic-Code: smtp; 550 5.1.1 no such user\r\n")
+				assert.Contains(t, string(msg), "Final-Recipient: rfc822; carol@example.com\r\nAction: failed\r\nStatus: 5.3.0\r\n")
+				assert.NotContains(t, string(msg), "bob@example.com\r\nAction")
+				return nil
+			})
+
+		// the message is accepted not to deliver it to bob again by the retry
+		res := d.target.Deliver(context.Background(), newDeliverySession("alice@example.net", "dave@example.net", "carol@example.com", "bob@example.com"))
+		assert.Equal(t, &data.DeliveryResult{}, res)
+		assert.Len(t, d.messages(t, "bob", ""), 1)
+	})
+
+	t.Run("failure of every recipient", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		d.blockMailbox(t, "bob")
+		d.blockMailbox(t, "carol")
+
+		res := d.target.Deliver(context.Background(), newDeliverySession("alice@example.net", "bob@example.com", "carol@example.com"))
+		assert.Equal(t, &data.DeliveryResult{Action: data.DeliveryTempFail}, res)
+	})
+
+	t.Run("fileinto", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		d.writeScript(t, "bob", `require ["fileinto", "envelope"]; if envelope :domain "from" "example.net" { fileinto "Work.Meetings"; fileinto "../escape"; }`)
+
+		assert.Equal(t, &data.DeliveryResult{}, d.target.Deliver(context.Background(), newDeliverySession("alice@example.net", "bob@example.com")))
+		assert.Len(t, d.messages(t, "bob", "Work.Meetings"), 1)
+		// the invalid folder falls back to the inbox
+		assert.Len(t, d.messages(t, "bob", ""), 1)
+	})
+
+	t.Run("redirect", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		d.writeScript(t, "bob", `redirect "bob@example.org";`)
+		d.sender.EXPECT().Send(gomock.Any(), "SRS0=hash=tt=example.net=alice@example.com", []string{"bob@example.org"}, gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, from string, to []string, msg []byte, body session.BodyType) error {
+				assert.True(t, strings.HasPrefix(string(msg), "Delivered-To: bob@example.com\r\n"))
+				return nil
+			})
+
+		assert.Equal(t, &data.DeliveryResult{}, d.target.Deliver(context.Background(), newDeliverySession("alice@example.net", "bob@example.com")))
+		assert.Empty(t, d.messages(t, "bob", ""))
+		// the message is not in the mailbox
+		assert.Empty(t, d.autoReplied)
+	})
+
+	t.Run("failed redirect is kept", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		d.writeScript(t, "bob", `redirect "bob@example.org";`)
+		d.sender.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("relay down"))
+
+		assert.Equal(t, &data.DeliveryResult{}, d.target.Deliver(context.Background(), newDeliverySession("alice@example.net", "bob@example.com")))
+		assert.Len(t, d.messages(t, "bob", ""), 1)
+	})
+
+	t.Run("redirect loop is kept", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		d.writeScript(t, "bob", `redirect "carol@example.com";`)
+		s := newDeliverySession("alice@example.net", "bob@example.com")
+		s.RawData = append([]byte("Delivered-To: carol@example.com\r\n"), s.RawData...)
+
+		assert.Equal(t, &data.DeliveryResult{}, d.target.Deliver(context.Background(), s))
+		assert.Len(t, d.messages(t, "bob", ""), 1)
+	})
+
+	t.Run("rejected by every recipient", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		d.writeScript(t, "bob", `require "reject"; reject "not now";`)
+
+		res := d.target.Deliver(context.Background(), newDeliverySession("alice@example.net", "bob@example.com"))
+		assert.Equal(t, &data.DeliveryResult{Action: data.DeliveryReject, Reason: "not now"}, res)
+		assert.Empty(t, d.messages(t, "bob", ""))
+	})
+
+	t.Run("rejected by one of recipients", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		d.writeScript(t, "bob", `require "reject"; reject "not now";`)
+		d.sender.EXPECT().Send(gomock.Any(), "", []string{"alice@example.net"}, gomock.Any(), session.Body8BitMime).DoAndReturn(
+			func(ctx context.Context, from string, to []string, msg []byte, body session.BodyType) error {
+				assert.Contains(t, string(msg), "Final-Recipient: rfc822; bob@example.com\r\n")
+				assert.Contains(t, string(msg), "not now")
+				return nil
+			})
+
+		res := d.target.Deliver(context.Background(), newDeliverySession("alice@example.net", "bob@example.com", "carol@example.com"))
+		assert.Equal(t, &data.DeliveryResult{}, res)
+		assert.Empty(t, d.messages(t, "bob", ""))
+		assert.Len(t, d.messages(t, "carol", ""), 1)
+		assert.Equal(t, []string{"carol@example.com carol@example.com"}, d.autoReplied)
+	})
+
+	t.Run("vacation", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		d.writeScript(t, "bob", `require "vacation"; vacation :handle "trip" "I am away.";`)
+		d.vacation.EXPECT().Respond(gomock.Any(), "bob+work@example.com", gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, recipient string, msg *sieve.Message, v *sieve.Vacation) error {
+				assert.Equal(t, "alice@example.net", msg.From)
+				assert.Equal(t, []string{"bob@example.com"}, v.Addresses)
+				return errors.New("relay down")
+			})
+
+		assert.Equal(t, &data.DeliveryResult{}, d.target.Deliver(context.Background(), newDeliverySession("alice@example.net", "bob+work@example.com")))
+		assert.Len(t, d.messages(t, "bob", ""), 1)
+		// the vacation of Sieve replaces the auto-reply of the mailbox
+		assert.Empty(t, d.autoReplied)
+	})
+
+	t.Run("invalid script is kept", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		d.writeScript(t, "bob", `discard`)
+
+		assert.Equal(t, &data.DeliveryResult{}, d.target.Deliver(context.Background(), newDeliverySession("alice@example.net", "bob@example.com")))
+		assert.Len(t, d.messages(t, "bob", ""), 1)
+	})
+
+	t.Run("quarantine skips Sieve", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		d.writeScript(t, "bob", `discard;`)
+		s := newDeliverySession("alice@example.net", "bob@example.com", "carol@example.net")
+		s.Quarantine = true
+		// quarantined message is not relayed
+
+		assert.Equal(t, &data.DeliveryResult{}, d.target.Deliver(context.Background(), s))
+		assert.Len(t, d.messages(t, "bob", "Junk"), 1)
+		assert.Empty(t, d.autoReplied)
+	})
+
+	t.Run("discard", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		s := newDeliverySession("alice@example.net", "bob@example.com")
+		s.Discard = true
+
+		assert.Equal(t, &data.DeliveryResult{}, d.target.Deliver(context.Background(), s))
+		assert.Empty(t, d.messages(t, "bob", ""))
+	})
+
+	t.Run("mailbox not writable", func(t *testing.T) {
+		d := newDeliveryTest(t)
+		assert.Nil(t, os.MkdirAll(filepath.Join(d.dir, "mail", "example.com"), 0o700))
+		assert.Nil(t, os.WriteFile(filepath.Join(d.dir, "mail", "example.com", "bob"), nil, 0o600))
+
+		res := d.target.Deliver(context.Background(), newDeliverySession("alice@example.net", "bob@example.com"))
+		assert.Equal(t, &data.DeliveryResult{Action: data.DeliveryTempFail}, res)
+	})
+}
+
+func TestDeliveryService_Disabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	target := NewDeliveryService(mock.NewInitializedMockLogger(ctrl), &config.DeliveryConfig{}, &config.RecipientConfig{}, nil, nil, nil, nil)
+
+	assert.Equal(t, &data.DeliveryResult{}, target.Deliver(context.Background(), newDeliverySession("alice@example.net", "bob@example.com")))
+}
+
+func TestFailureStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     session.EnhancedCode
+		diagnostic string
+	}{
+		{
+			name:   "not replied",
+			err:    errors.New("connection refused"),
+			status: session.EnhancedCode{5, 3, 0},
+		},
+		{
+			name:       "permanent reply",
+			err:        &textproto.Error{Code: 550, Msg: "5.1.1 no such user"},
+			status:     session.EnhancedCode{5, 1, 1},
+			diagnostic: "550 5.1.1 no such user",
+		},
+		{
+			name:       "temporary reply is not retried",
+			err:        &textproto.Error{Code: 452, Msg: "4.2.2 mailbox full"},
+			status:     session.EnhancedCode{5, 2, 2},
+			diagnostic: "452 4.2.2 mailbox full",
+		},
+		{
+			name:       "reply without enhanced code",
+			err:        fmt.Errorf("relay: %w", &textproto.Error{Code: 554, Msg: "first\nlast"}),
+			status:     session.EnhancedCode{5, 3, 0},
+			diagnostic: "554 first last",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, diagnostic := failureStatus(test.err)
+			assert.Equal(t, test.status, status)
+			assert.Equal(t, test.diagnostic, diagnostic)
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"blitiri.com.ar/go/spf"
+	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+const defaultDnsblTimeout = 5 * time.Second
+
+var (
+	// default return codes of DNS lists
+	dnsListedNetwork = &net.IPNet{IP: net.IPv4(127, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)}
+	// some lists return 127.255.255.x when the query is refused
+	dnsErrorNetwork = &net.IPNet{IP: net.IPv4(127, 255, 255, 0).To4(), Mask: net.CIDRMask(24, 32)}
+)
+
+type DnsblService interface {
+	// Check looks up the client IP address in the blocklists and allowlists.
+	Check(ctx context.Context, s *session.Session) (*data.DnsblResult, error)
+	// IsRejected reports whether the client should be rejected by the result.
+	IsRejected(res *data.DnsblResult) bool
+}
+
+// NewDNSResolver returns the resolver of the system, tests replace it with a fake.
+func NewDNSResolver() spf.DNSResolver {
+	return net.DefaultResolver
+}
+
+type dnsList struct {
+	name        string
+	zone        string
+	weight      float64
+	returnCodes []*net.IPNet
+	allow       bool
+}
+
+// matches reports whether one of the answers means the client is listed.
+func (l *dnsList) matches(addrs []net.IPAddr) bool {
+	for _, addr := range addrs {
+		if len(l.returnCodes) == 0 {
+			if dnsListedNetwork.Contains(addr.IP) && !dnsErrorNetwork.Contains(addr.IP) {
+				return true
+			}
+			continue
+		}
+		for _, code := range l.returnCodes {
+			if code.Contains(addr.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type dnsblServiceImpl struct {
+	log         hlog.Logger
+	enable      bool
+	lists       []*dnsList
+	rejectScore float64
+	timeout     time.Duration
+	resolver    spf.DNSResolver
+}
+
+func (d *dnsblServiceImpl) Check(ctx context.Context, s *session.Session) (*data.DnsblResult, error) {
+	res := &data.DnsblResult{}
+	ip := s.IP()
+	if !d.enable || ip == nil || len(d.lists) == 0 {
+		return res, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	query := dnsQueryName(ip)
+	listed := make([]bool, len(d.lists))
+	errs := make([]error, len(d.lists))
+	var wg sync.WaitGroup
+	for idx, list := range d.lists {
+		wg.Add(1)
+		go func(idx int, list *dnsList) {
+			defer wg.Done()
+			listed[idx], errs[idx] = d.lookup(ctx, query, list)
+		}(idx, list)
+	}
+	wg.Wait()
+
+	hits := make([]*dnsList, 0)
+	for idx, list := range d.lists {
+		if errs[idx] != nil {
+			// a list which does not respond is ignored
+			d.log.WithError(errs[idx]).Warnf("[%s] failed to look up %s in %s", s.Id, ip, list.zone)
+			continue
+		}
+		if listed[idx] {
+			hits = append(hits, list)
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].weight > hits[j].weight
+	})
+	for _, list := range hits {
+		if list.allow {
+			res.Score -= list.weight
+			res.Allowlisted = append(res.Allowlisted, list.name)
+		} else {
+			res.Score += list.weight
+			res.Blocklisted = append(res.Blocklisted, list.name)
+		}
+	}
+	return res, nil
+}
+
+func (d *dnsblServiceImpl) lookup(ctx context.Context, query string, list *dnsList) (bool, error) {
+	addrs, err := d.resolver.LookupIPAddr(ctx, query+"."+list.zone)
+	if err != nil {
+		// NXDOMAIN means the client is not listed
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return list.matches(addrs), nil
+}
+
+func (d *dnsblServiceImpl) IsRejected(res *data.DnsblResult) bool {
+	return d.rejectScore > 0 && res.Score >= d.rejectScore
+}
+
+// dnsQueryName converts the IP address to the query of DNS lists.
+// IPv4 is reversed by octets and IPv6 is reversed by nibbles.
+// https://tex2e.github.io/rfc-translater/html/rfc5782.html#2-1--IP-Address-DNSxL
+func dnsQueryName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d", v4[3], v4[2], v4[1], v4[0])
+	}
+	const hexDigits = "0123456789abcdef"
+	ip = ip.To16()
+	nibbles := make([]string, 0, 32)
+	for idx := len(ip) - 1; idx >= 0; idx-- {
+		nibbles = append(nibbles, string(hexDigits[ip[idx]&0x0f]), string(hexDigits[ip[idx]>>4]))
+	}
+	return strings.Join(nibbles, ".")
+}
+
+func newDnsList(conf config.DnsList, allow bool) (*dnsList, error) {
+	if len(conf.Zone) == 0 {
+		return nil, errors.New("zone of DNS list is not configured")
+	}
+	list := &dnsList{
+		name:   conf.Name,
+		zone:   strings.TrimSuffix(conf.Zone, "."),
+		weight: conf.Weight,
+		allow:  allow,
+	}
+	if len(list.name) == 0 {
+		list.name = list.zone
+	}
+	if list.weight == 0 {
+		list.weight = 1
+	}
+	for _, code := range conf.ReturnCodes {
+		if !strings.Contains(code, "/") {
+			code += "/32"
+		}
+		_, network, err := net.ParseCIDR(code)
+		if err != nil {
+			return nil, fmt.Errorf("invalid return code of %s: %w", list.zone, err)
+		}
+		list.returnCodes = append(list.returnCodes, network)
+	}
+	return list, nil
+}
+
+func NewDnsblService(log hlog.Logger, conf *config.DnsblConfig, resolver spf.DNSResolver) (DnsblService, error) {
+	d := &dnsblServiceImpl{
+		log:         log,
+		enable:      conf.Enable,
+		rejectScore: conf.RejectScore,
+		timeout:     conf.Timeout,
+		resolver:    resolver,
+	}
+	if d.timeout <= 0 {
+		d.timeout = defaultDnsblTimeout
+	}
+	for _, c := range conf.Blocklists {
+		list, err := newDnsList(c, false)
+		if err != nil {
+			return nil, err
+		}
+		d.lists = append(d.lists, list)
+	}
+	for _, c := range conf.Allowlists {
+		list, err := newDnsList(c, true)
+		if err != nil {
+			return nil, err
+		}
+		d.lists = append(d.lists, list)
+	}
+	return d, nil
+}
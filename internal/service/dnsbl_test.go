@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeResolver answers by the queried name, unknown names are NXDOMAIN.
+type fakeResolver struct {
+	ipAddr map[string][]net.IPAddr
+	addr   map[string][]string
+	err    map[string]error
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if err, ok := r.err[host]; ok {
+		return nil, err
+	}
+	if addrs, ok := r.ipAddr[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if err, ok := r.err[addr]; ok {
+		return nil, err
+	}
+	if names, ok := r.addr[addr]; ok {
+		return names, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func ipAddrs(ips ...string) []net.IPAddr {
+	res := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		res = append(res, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return res
+}
+
+func TestDnsQueryName(t *testing.T) {
+	// examples of RFC 5782
+	assert.Equal(t, "2.2.0.192", dnsQueryName(net.ParseIP("192.0.2.2")))
+	assert.Equal(t, "b.a.9.8.7.6.5.0.4.0.0.0.3.0.0.0.2.0.0.0.1.0.0.0.8.b.d.0.1.0.0.2",
+		dnsQueryName(net.ParseIP("2001:db8:1:2:3:4:567:89ab")))
+}
+
+func TestDnsblService_Check(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	log := mock.NewInitializedMockLogger(ctrl)
+	conf := &config.DnsblConfig{
+		Enable: true,
+		Blocklists: []config.DnsList{
+			{Name: "light", Zone: "light.example", Weight: 0.5},
+			{Zone: "heavy.example", Weight: 2},
+			{Name: "codes", Zone: "codes.example", ReturnCodes: []string{"127.0.0.4", "127.0.1.0/24"}},
+			{Name: "broken", Zone: "broken.example"},
+		},
+		Allowlists: []config.DnsList{
+			{Name: "allow", Zone: "allow.example", Weight: 3},
+		},
+		RejectScore: 2,
+	}
+
+	tests := []struct {
+		name     string
+		ip       string
+		answers  map[string][]net.IPAddr
+		expected *data.DnsblResult
+		rejected bool
+	}{
+		{
+			name:     "not listed",
+			ip:       "192.0.2.1",
+			expected: &data.DnsblResult{},
+		},
+		{
+			name: "listed in blocklists",
+			ip:   "192.0.2.1",
+			answers: map[string][]net.IPAddr{
+				"1.2.0.192.light.example": ipAddrs("127.0.0.2"),
+				"1.2.0.192.heavy.example": ipAddrs("127.0.0.2"),
+			},
+			expected: &data.DnsblResult{Score: 2.5, Blocklisted: []string{"heavy.example", "light"}},
+			rejected: true,
+		},
+		{
+			name: "return code matching",
+			ip:   "192.0.2.1",
+			answers: map[string][]net.IPAddr{
+				"1.2.0.192.codes.example": ipAddrs("127.0.0.2", "127.0.1.10"),
+			},
+			expected: &data.DnsblResult{Score: 1, Blocklisted: []string{"codes"}},
+		},
+		{
+			name: "return code not matched",
+			ip:   "192.0.2.1",
+			answers: map[string][]net.IPAddr{
+				"1.2.0.192.codes.example": ipAddrs("127.0.0.2"),
+				// refused query
+				"1.2.0.192.heavy.example": ipAddrs("127.255.255.254"),
+				// not a DNS list answer
+				"1.2.0.192.light.example": ipAddrs("192.0.2.1"),
+			},
+			expected: &data.DnsblResult{},
+		},
+		{
+			name: "allowlisted",
+			ip:   "192.0.2.1",
+			answers: map[string][]net.IPAddr{
+				"1.2.0.192.heavy.example": ipAddrs("127.0.0.2"),
+				"1.2.0.192.allow.example": ipAddrs("127.0.10.1"),
+			},
+			expected: &data.DnsblResult{Score: -1, Blocklisted: []string{"heavy.example"}, Allowlisted: []string{"allow"}},
+		},
+		{
+			name: "IPv6",
+			ip:   "2001:db8::1",
+			answers: map[string][]net.IPAddr{
+				"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.heavy.example": ipAddrs("127.0.0.2"),
+			},
+			expected: &data.DnsblResult{Score: 2, Blocklisted: []string{"heavy.example"}},
+			rejected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := &fakeResolver{
+				ipAddr: test.answers,
+				// lists which do not respond are ignored
+				err: map[string]error{
+					dnsQueryName(net.ParseIP(test.ip)) + ".broken.example": errors.New("timeout"),
+				},
+			}
+			target, err := NewDnsblService(log, conf, resolver)
+			assert.Nil(t, err)
+
+			res, err := target.Check(context.Background(), newRateLimitSession(test.ip))
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, res)
+			assert.Equal(t, test.rejected, target.IsRejected(res))
+		})
+	}
+}
+
+func TestDnsblService_Disabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	resolver := &fakeResolver{
+		ipAddr: map[string][]net.IPAddr{"1.2.0.192.bl.example": ipAddrs("127.0.0.2")},
+	}
+	target, err := NewDnsblService(mock.NewInitializedMockLogger(ctrl), &config.DnsblConfig{
+		Blocklists:  []config.DnsList{{Zone: "bl.example"}},
+		RejectScore: 1,
+	}, resolver)
+	assert.Nil(t, err)
+
+	res, err := target.Check(context.Background(), newRateLimitSession("192.0.2.1"))
+	assert.Nil(t, err)
+	assert.Equal(t, &data.DnsblResult{}, res)
+	assert.False(t, target.IsRejected(res))
+}
+
+func TestNewDnsblService_InvalidConfig(t *testing.T) {
+	_, err := NewDnsblService(nil, &config.DnsblConfig{
+		Blocklists: []config.DnsList{{Name: "no zone"}},
+	}, nil)
+	assert.NotNil(t, err)
+
+	_, err = NewDnsblService(nil, &config.DnsblConfig{
+		Allowlists: []config.DnsList{{Zone: "wl.example", ReturnCodes: []string{"invalid"}}},
+	}, nil)
+	assert.NotNil(t, err)
+}
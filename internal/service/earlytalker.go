@@ -0,0 +1,77 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+// EarlyTalkerService decides the delay before the greeting, clients talking in it are rejected as spambots.
+// https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-3-1--Sequencing-Overview
+type EarlyTalkerService interface {
+	// GreetingDelay returns the delay, 0 means the greeting is sent immediately.
+	GreetingDelay() time.Duration
+	// IsExempt reports whether the client is greeted without the delay.
+	IsExempt(s *session.Session) bool
+}
+
+type earlyTalkerServiceImpl struct {
+	delay             time.Duration
+	exempt            []*net.IPNet
+	exemptAllowlisted bool
+}
+
+func (e *earlyTalkerServiceImpl) GreetingDelay() time.Duration {
+	return e.delay
+}
+
+func (e *earlyTalkerServiceImpl) IsExempt(s *session.Session) bool {
+	if e.exemptAllowlisted && s.DnsblScore < 0 {
+		return true
+	}
+	ip := s.IP()
+	if ip == nil {
+		return false
+	}
+	for _, network := range e.exempt {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetwork parses an IP address or a CIDR, an address is a network of itself.
+func parseNetwork(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", value)
+		}
+		if v4 := ip.To4(); v4 != nil {
+			return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	return network, err
+}
+
+func NewEarlyTalkerService(conf *config.EarlyTalkerConfig) (EarlyTalkerService, error) {
+	e := &earlyTalkerServiceImpl{
+		delay:             conf.GreetingDelay,
+		exemptAllowlisted: conf.ExemptAllowlisted,
+	}
+	for _, value := range conf.Exempt {
+		network, err := parseNetwork(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid early talker exemption: %w", err)
+		}
+		e.exempt = append(e.exempt, network)
+	}
+	return e, nil
+}
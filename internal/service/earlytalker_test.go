@@ -0,0 +1,53 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEarlyTalker_IsExempt(t *testing.T) {
+	target, err := NewEarlyTalkerService(&config.EarlyTalkerConfig{
+		GreetingDelay:     5 * time.Second,
+		Exempt:            []string{"192.0.2.1", "198.51.100.0/24", "2001:db8::/32"},
+		ExemptAllowlisted: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Second, target.GreetingDelay())
+
+	tests := []struct {
+		name       string
+		ip         string
+		dnsblScore float64
+		expected   bool
+	}{
+		{name: "exempt address", ip: "192.0.2.1", expected: true},
+		{name: "other address", ip: "192.0.2.2", expected: false},
+		{name: "exempt network", ip: "198.51.100.200", expected: true},
+		{name: "exempt ipv6 network", ip: "2001:db8::1", expected: true},
+		{name: "allowlisted", ip: "203.0.113.1", dnsblScore: -1, expected: true},
+		{name: "blocklisted", ip: "203.0.113.1", dnsblScore: 1, expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newRateLimitSession(test.ip)
+			s.DnsblScore = test.dnsblScore
+			assert.Equal(t, test.expected, target.IsExempt(s))
+		})
+	}
+}
+
+func TestEarlyTalker_InvalidExempt(t *testing.T) {
+	_, err := NewEarlyTalkerService(&config.EarlyTalkerConfig{
+		Exempt: []string{"192.0.2.256"},
+	})
+	assert.NotNil(t, err)
+
+	_, err = NewEarlyTalkerService(&config.EarlyTalkerConfig{
+		Exempt: []string{"192.0.2.0/33"},
+	})
+	assert.NotNil(t, err)
+}
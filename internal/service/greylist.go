@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+const (
+	// interval to remove expired state of greylisting
+	greylistPurgeInterval      = time.Hour
+	defaultGreylistRetryWindow = 48 * time.Hour
+	defaultGreylistExpire      = 35 * 24 * time.Hour
+)
+
+type GreylistService interface {
+	// Check reports whether the recipient is accepted, false means the client must retry later.
+	// Authenticated clients and whitelisted clients are never greylisted.
+	Check(ctx context.Context, s *session.Session, recipient mail.Address) (bool, error)
+}
+
+type greylistServiceImpl struct {
+	log                hlog.Logger
+	enable             bool
+	delay              time.Duration
+	retryWindow        time.Duration
+	expire             time.Duration
+	autoWhitelistCount int
+	subnets            *session.SubnetGrouping
+
+	mu         sync.Mutex
+	store      greylistStore
+	now        func() time.Time
+	lastPurged time.Time
+}
+
+func (g *greylistServiceImpl) Check(ctx context.Context, s *session.Session, recipient mail.Address) (bool, error) {
+	ip := s.IP()
+	if !g.enable || len(s.AuthUser) > 0 || ip == nil {
+		return true, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	if now.Sub(g.lastPurged) >= greylistPurgeInterval {
+		g.lastPurged = now
+		if err := g.store.purge(ctx, now.Add(-g.retryWindow), now.Add(-g.expire)); err != nil {
+			g.log.WithError(err).Warn("failed to purge greylist.", nil)
+		}
+	}
+
+	subnet := g.subnets.Subnet(ip).String()
+	client, err := g.store.getClient(ctx, subnet)
+	if err != nil {
+		return false, err
+	}
+	if g.isWhitelisted(client, now) {
+		client.lastPassed = now
+		return true, g.store.putClient(ctx, subnet, client)
+	}
+
+	key := greylistTriplet{subnet: subnet, recipient: strings.ToLower(recipient.Address)}
+	if s.EnvelopeFrom != nil {
+		key.sender = strings.ToLower(s.EnvelopeFrom.Address)
+	}
+	entry, err := g.store.getTriplet(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case entry == nil || (!entry.passed && now.Sub(entry.firstSeen) > g.retryWindow) || (entry.passed && now.Sub(entry.lastSeen) > g.expire):
+		// first attempt, or the previous attempt has been forgotten
+		return false, g.store.putTriplet(ctx, key, &greylistEntry{firstSeen: now, lastSeen: now})
+	case entry.passed:
+		entry.lastSeen = now
+		return true, g.store.putTriplet(ctx, key, entry)
+	case now.Sub(entry.firstSeen) < g.delay:
+		// retried too early, the delay is not restarted
+		entry.lastSeen = now
+		return false, g.store.putTriplet(ctx, key, entry)
+	}
+
+	// retried after the delay
+	entry.lastSeen = now
+	entry.passed = true
+	if err := g.store.putTriplet(ctx, key, entry); err != nil {
+		return false, err
+	}
+	if client == nil || now.Sub(client.lastPassed) > g.expire {
+		client = &greylistClient{}
+	}
+	client.passedCount++
+	client.lastPassed = now
+	return true, g.store.putClient(ctx, subnet, client)
+}
+
+// isWhitelisted reports whether the client has passed greylisting enough times recently.
+func (g *greylistServiceImpl) isWhitelisted(client *greylistClient, now time.Time) bool {
+	return g.autoWhitelistCount > 0 && client != nil &&
+		client.passedCount >= g.autoWhitelistCount && now.Sub(client.lastPassed) <= g.expire
+}
+
+func NewGreylistService(log hlog.Logger, conf *config.GreylistConfig, subnets *session.SubnetGrouping) (GreylistService, error) {
+	g := &greylistServiceImpl{
+		log:                log,
+		enable:             conf.Enable,
+		delay:              conf.Delay,
+		retryWindow:        conf.RetryWindow,
+		expire:             conf.Expire,
+		autoWhitelistCount: conf.AutoWhitelistCount,
+		subnets:            subnets,
+		now:                time.Now,
+	}
+	if !g.enable {
+		return g, nil
+	}
+	if g.retryWindow <= 0 {
+		g.retryWindow = defaultGreylistRetryWindow
+	}
+	if g.expire <= 0 {
+		g.expire = defaultGreylistExpire
+	}
+
+	if len(conf.DbPath) == 0 {
+		g.store = newMemoryGreylistStore()
+		return g, nil
+	}
+	store, err := newSqliteGreylistStore(conf.DbPath)
+	if err != nil {
+		return nil, err
+	}
+	g.store = store
+	return g, nil
+}
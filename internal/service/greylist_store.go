@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// greylistTriplet identifies a delivery attempt, addresses are lower-cased.
+type greylistTriplet struct {
+	subnet    string
+	sender    string
+	recipient string
+}
+
+type greylistEntry struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+	// the client has retried after the delay
+	passed bool
+}
+
+type greylistClient struct {
+	// number of triplets which the client has passed
+	passedCount int
+	lastPassed  time.Time
+}
+
+// greylistStore keeps the state of greylisting, nil is returned for unknown keys.
+type greylistStore interface {
+	getTriplet(ctx context.Context, key greylistTriplet) (*greylistEntry, error)
+	putTriplet(ctx context.Context, key greylistTriplet, entry *greylistEntry) error
+	getClient(ctx context.Context, subnet string) (*greylistClient, error)
+	putClient(ctx context.Context, subnet string, client *greylistClient) error
+	// purge removes triplets which are not retried since retryBefore, passed triplets and clients inactive since expireBefore.
+	purge(ctx context.Context, retryBefore, expireBefore time.Time) error
+}
+
+type memoryGreylistStore struct {
+	triplets map[greylistTriplet]greylistEntry
+	clients  map[string]greylistClient
+}
+
+func (s *memoryGreylistStore) getTriplet(ctx context.Context, key greylistTriplet) (*greylistEntry, error) {
+	entry, ok := s.triplets[key]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (s *memoryGreylistStore) putTriplet(ctx context.Context, key greylistTriplet, entry *greylistEntry) error {
+	s.triplets[key] = *entry
+	return nil
+}
+
+func (s *memoryGreylistStore) getClient(ctx context.Context, subnet string) (*greylistClient, error) {
+	client, ok := s.clients[subnet]
+	if !ok {
+		return nil, nil
+	}
+	return &client, nil
+}
+
+func (s *memoryGreylistStore) putClient(ctx context.Context, subnet string, client *greylistClient) error {
+	s.clients[subnet] = *client
+	return nil
+}
+
+func (s *memoryGreylistStore) purge(ctx context.Context, retryBefore, expireBefore time.Time) error {
+	for key, entry := range s.triplets {
+		if (!entry.passed && entry.firstSeen.Before(retryBefore)) || (entry.passed && entry.lastSeen.Before(expireBefore)) {
+			delete(s.triplets, key)
+		}
+	}
+	for subnet, client := range s.clients {
+		if client.lastPassed.Before(expireBefore) {
+			delete(s.clients, subnet)
+		}
+	}
+	return nil
+}
+
+func newMemoryGreylistStore() greylistStore {
+	return &memoryGreylistStore{
+		triplets: make(map[greylistTriplet]greylistEntry),
+		clients:  make(map[string]greylistClient),
+	}
+}
+
+const greylistSchema = `
+CREATE TABLE IF NOT EXISTS greylist_triplets (
+	subnet     TEXT    NOT NULL,
+	sender     TEXT    NOT NULL,
+	recipient  TEXT    NOT NULL,
+	first_seen INTEGER NOT NULL,
+	last_seen  INTEGER NOT NULL,
+	passed     INTEGER NOT NULL,
+	PRIMARY KEY (subnet, sender, recipient)
+);
+CREATE TABLE IF NOT EXISTS greylist_clients (
+	subnet       TEXT    NOT NULL PRIMARY KEY,
+	passed_count INTEGER NOT NULL,
+	last_passed  INTEGER NOT NULL
+);
+`
+
+// sqliteGreylistStore keeps times as unix seconds.
+type sqliteGreylistStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteGreylistStore) getTriplet(ctx context.Context, key greylistTriplet) (*greylistEntry, error) {
+	var firstSeen, lastSeen int64
+	var passed bool
+	err := s.db.QueryRowContext(ctx,
+		"SELECT first_seen, last_seen, passed FROM greylist_triplets WHERE subnet = ? AND sender = ? AND recipient = ?",
+		key.subnet, key.sender, key.recipient,
+	).Scan(&firstSeen, &lastSeen, &passed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &greylistEntry{
+		firstSeen: time.Unix(firstSeen, 0),
+		lastSeen:  time.Unix(lastSeen, 0),
+		passed:    passed,
+	}, nil
+}
+
+func (s *sqliteGreylistStore) putTriplet(ctx context.Context, key greylistTriplet, entry *greylistEntry) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO greylist_triplets (subnet, sender, recipient, first_seen, last_seen, passed) VALUES (?, ?, ?, ?, ?, ?)",
+		key.subnet, key.sender, key.recipient, entry.firstSeen.Unix(), entry.lastSeen.Unix(), entry.passed,
+	)
+	return err
+}
+
+func (s *sqliteGreylistStore) getClient(ctx context.Context, subnet string) (*greylistClient, error) {
+	var passedCount int
+	var lastPassed int64
+	err := s.db.QueryRowContext(ctx,
+		"SELECT passed_count, last_passed FROM greylist_clients WHERE subnet = ?",
+		subnet,
+	).Scan(&passedCount, &lastPassed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &greylistClient{
+		passedCount: passedCount,
+		lastPassed:  time.Unix(lastPassed, 0),
+	}, nil
+}
+
+func (s *sqliteGreylistStore) putClient(ctx context.Context, subnet string, client *greylistClient) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO greylist_clients (subnet, passed_count, last_passed) VALUES (?, ?, ?)",
+		subnet, client.passedCount, client.lastPassed.Unix(),
+	)
+	return err
+}
+
+func (s *sqliteGreylistStore) purge(ctx context.Context, retryBefore, expireBefore time.Time) error {
+	if _, err := s.db.ExecContext(ctx,
+		"DELETE FROM greylist_triplets WHERE (passed = 0 AND first_seen < ?) OR (passed = 1 AND last_seen < ?)",
+		retryBefore.Unix(), expireBefore.Unix(),
+	); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx,
+		"DELETE FROM greylist_clients WHERE last_passed < ?",
+		expireBefore.Unix(),
+	)
+	return err
+}
+
+// newSqliteGreylistStore opens the database, the tables are created when they do not exist.
+func newSqliteGreylistStore(path string) (greylistStore, error) {
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows only one writer
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(greylistSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteGreylistStore{
+		db: db,
+	}, nil
+}
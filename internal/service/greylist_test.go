@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"net/mail"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/mock"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGreylistSession(ip, from string) *session.Session {
+	s := newRateLimitSession(ip)
+	s.EnvelopeFrom = &mail.Address{Address: from}
+	return s
+}
+
+func newTestGreylistService(t *testing.T, conf *config.GreylistConfig) (*greylistServiceImpl, *fakeClock) {
+	ctrl := gomock.NewController(t)
+	g, err := NewGreylistService(mock.NewInitializedMockLogger(ctrl), conf, session.NewSubnetGrouping(&config.SubnetConfig{}))
+	assert.Nil(t, err)
+
+	impl := g.(*greylistServiceImpl)
+	return impl, freezeClock(&impl.now)
+}
+
+// greylistConfig returns the config of the store in dbPath, the memory store is used when it is empty.
+func greylistConfig(dbPath string) *config.GreylistConfig {
+	return &config.GreylistConfig{
+		Enable:             true,
+		Delay:              5 * time.Minute,
+		RetryWindow:        time.Hour,
+		Expire:             24 * time.Hour,
+		AutoWhitelistCount: 2,
+		DbPath:             dbPath,
+	}
+}
+
+func TestGreylistService_Check(t *testing.T) {
+	for name, dbPath := range storeDbPaths(t) {
+		t.Run(name, func(t *testing.T) {
+			g, clock := newTestGreylistService(t, greylistConfig(dbPath))
+			ctx := context.Background()
+			to := mail.Address{Address: "to@example.com"}
+
+			check := func(s *session.Session, to mail.Address) bool {
+				ok, err := g.Check(ctx, s, to)
+				assert.Nil(t, err)
+				return ok
+			}
+
+			// first attempt
+			assert.False(t, check(newGreylistSession("192.0.2.1", "from@example.com"), to))
+			// retried too early
+			clock.Advance(time.Minute)
+			assert.False(t, check(newGreylistSession("192.0.2.1", "from@example.com"), to))
+			// retried from another host of the same subnet after the delay, addresses are case-insensitive
+			clock.Advance(5 * time.Minute)
+			assert.True(t, check(newGreylistSession("192.0.2.2", "FROM@example.com"), mail.Address{Address: "TO@example.com"}))
+			assert.True(t, check(newGreylistSession("192.0.2.1", "from@example.com"), to))
+
+			// other triplets are greylisted
+			assert.False(t, check(newGreylistSession("192.0.2.1", "other@example.com"), to))
+			assert.False(t, check(newGreylistSession("198.51.100.1", "from@example.com"), to))
+
+			// attempt which is not retried within the window is forgotten
+			clock.Advance(2 * time.Hour)
+			assert.False(t, check(newGreylistSession("198.51.100.1", "from@example.com"), to))
+		})
+	}
+}
+
+func TestGreylistService_AutoWhitelist(t *testing.T) {
+	for name, dbPath := range storeDbPaths(t) {
+		t.Run(name, func(t *testing.T) {
+			g, clock := newTestGreylistService(t, greylistConfig(dbPath))
+			ctx := context.Background()
+
+			for _, from := range []string{"a@example.com", "b@example.com"} {
+				ok, err := g.Check(ctx, newGreylistSession("192.0.2.1", from), mail.Address{Address: "to@example.com"})
+				assert.Nil(t, err)
+				assert.False(t, ok)
+			}
+			clock.Advance(10 * time.Minute)
+			for _, from := range []string{"a@example.com", "b@example.com"} {
+				ok, err := g.Check(ctx, newGreylistSession("192.0.2.1", from), mail.Address{Address: "to@example.com"})
+				assert.Nil(t, err)
+				assert.True(t, ok)
+			}
+
+			// the client has passed twice
+			ok, err := g.Check(ctx, newGreylistSession("192.0.2.10", "c@example.com"), mail.Address{Address: "to@example.com"})
+			assert.Nil(t, err)
+			assert.True(t, ok)
+
+			// whitelisting expires when the client is inactive
+			clock.Advance(48 * time.Hour)
+			ok, err = g.Check(ctx, newGreylistSession("192.0.2.10", "d@example.com"), mail.Address{Address: "to@example.com"})
+			assert.Nil(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestGreylistService_Persist(t *testing.T) {
+	conf := greylistConfig(filepath.Join(t.TempDir(), "greylist.db"))
+	ctx := context.Background()
+	s := newGreylistSession("192.0.2.1", "from@example.com")
+	to := mail.Address{Address: "to@example.com"}
+
+	g, _ := newTestGreylistService(t, conf)
+	ok, err := g.Check(ctx, s, to)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// restarted
+	restarted, clock := newTestGreylistService(t, conf)
+	clock.Advance(10 * time.Minute)
+	ok, err = restarted.Check(ctx, s, to)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestGreylistService_Purge(t *testing.T) {
+	for name, dbPath := range storeDbPaths(t) {
+		t.Run(name, func(t *testing.T) {
+			g, clock := newTestGreylistService(t, greylistConfig(dbPath))
+			ctx := context.Background()
+			key := greylistTriplet{subnet: "192.0.2.0/24", sender: "from@example.com", recipient: "to@example.com"}
+
+			g.Check(ctx, newGreylistSession("192.0.2.1", "from@example.com"), mail.Address{Address: "to@example.com"})
+			entry, err := g.store.getTriplet(ctx, key)
+			assert.Nil(t, err)
+			assert.NotNil(t, entry)
+
+			clock.Advance(2 * time.Hour)
+			g.Check(ctx, newGreylistSession("198.51.100.1", "from@example.com"), mail.Address{Address: "to@example.com"})
+			entry, err = g.store.getTriplet(ctx, key)
+			assert.Nil(t, err)
+			assert.Nil(t, entry)
+		})
+	}
+}
+
+func TestGreylistService_Exempt(t *testing.T) {
+	ctx := context.Background()
+	to := mail.Address{Address: "to@example.com"}
+
+	disabled, _ := newTestGreylistService(t, &config.GreylistConfig{})
+	ok, err := disabled.Check(ctx, newGreylistSession("192.0.2.1", "from@example.com"), to)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	g, _ := newTestGreylistService(t, greylistConfig(""))
+	s := newGreylistSession("192.0.2.1", "from@example.com")
+	s.AuthUser = "user"
+	ok, err = g.Check(ctx, s, to)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"blitiri.com.ar/go/spf"
+	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+const (
+	defaultHeloTimeout = 5 * time.Second
+	// PTR names checked at most, which protects from clients with a huge number of names
+	maxPtrNames = 10
+)
+
+type HeloService interface {
+	// VerifyReverseDns looks up forward-confirmed reverse DNS of the client and returns the confirmed name.
+	VerifyReverseDns(ctx context.Context, s *session.Session) (session.ReverseDnsStatus, string)
+	// CheckHelo validates the argument of HELO/EHLO received from the client.
+	CheckHelo(s *session.Session, helo string) session.HeloStatus
+}
+
+type heloServiceImpl struct {
+	log             hlog.Logger
+	checkReverseDns bool
+	checkHelo       bool
+	ownHostnames    map[string]struct{}
+	timeout         time.Duration
+	resolver        spf.DNSResolver
+}
+
+// https://tex2e.github.io/rfc-translater/html/rfc8601.html#2-7-3--iprev
+func (h *heloServiceImpl) VerifyReverseDns(ctx context.Context, s *session.Session) (session.ReverseDnsStatus, string) {
+	ip := s.IP()
+	if !h.checkReverseDns || ip == nil {
+		return session.ReverseDnsNone, ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	names, err := h.resolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		if isNotFound(err) {
+			return session.ReverseDnsFail, ""
+		}
+		h.log.WithError(err).Warnf("[%s] failed to look up PTR of %s", s.Id, ip)
+		return session.ReverseDnsTempError, ""
+	}
+	if len(names) > maxPtrNames {
+		names = names[:maxPtrNames]
+	}
+
+	status := session.ReverseDnsFail
+	for _, name := range names {
+		addrs, err := h.resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			if !isNotFound(err) {
+				h.log.WithError(err).Warnf("[%s] failed to look up %s", s.Id, name)
+				status = session.ReverseDnsTempError
+			}
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(ip) {
+				return session.ReverseDnsPass, strings.TrimSuffix(name, ".")
+			}
+		}
+	}
+	return status, ""
+}
+
+// https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-1-1-1--Extended-HELLO--EHLO--or-HELLO--HELO-
+func (h *heloServiceImpl) CheckHelo(s *session.Session, helo string) session.HeloStatus {
+	if !h.checkHelo {
+		return session.HeloNone
+	}
+
+	if strings.HasPrefix(helo, "[") {
+		literal, ok := parseAddressLiteral(helo)
+		if !ok {
+			return session.HeloInvalid
+		}
+		if ip := s.IP(); ip != nil && !literal.Equal(ip) {
+			return session.HeloMismatch
+		}
+		return session.HeloValid
+	}
+
+	domain := strings.ToLower(strings.TrimSuffix(helo, "."))
+	if !isFqdn(domain) {
+		return session.HeloInvalid
+	}
+	if _, ok := h.ownHostnames[domain]; ok {
+		return session.HeloOwnHostname
+	}
+	return session.HeloValid
+}
+
+// parseAddressLiteral parses "[192.0.2.1]" or "[IPv6:2001:db8::1]".
+// https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-1-3--Address-Literals
+func parseAddressLiteral(literal string) (net.IP, bool) {
+	if !strings.HasPrefix(literal, "[") || !strings.HasSuffix(literal, "]") {
+		return nil, false
+	}
+	addr := literal[1 : len(literal)-1]
+	if len(addr) > 5 && strings.EqualFold(addr[:5], "IPv6:") {
+		ip := net.ParseIP(addr[5:])
+		return ip, ip != nil && ip.To4() == nil
+	}
+	ip := net.ParseIP(addr)
+	return ip, ip != nil && ip.To4() != nil && !strings.Contains(addr, ":")
+}
+
+// isFqdn reports whether the domain has two or more valid labels and the top level label is not numeric.
+func isFqdn(domain string) bool {
+	if len(domain) == 0 || len(domain) > 253 {
+		return false
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	// bare IP address is not a domain
+	tld := labels[len(labels)-1]
+	return strings.Trim(tld, "0123456789") != ""
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+func NewHeloService(log hlog.Logger, conf *config.HeloConfig, resolver spf.DNSResolver) HeloService {
+	h := &heloServiceImpl{
+		log:             log,
+		checkReverseDns: conf.CheckReverseDns,
+		checkHelo:       conf.CheckHelo,
+		ownHostnames:    make(map[string]struct{}),
+		timeout:         conf.Timeout,
+		resolver:        resolver,
+	}
+	if h.timeout <= 0 {
+		h.timeout = defaultHeloTimeout
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		h.ownHostnames[strings.ToLower(hostname)] = struct{}{}
+	}
+	for _, hostname := range conf.OwnHostnames {
+		h.ownHostnames[strings.ToLower(strings.TrimSuffix(hostname, "."))] = struct{}{}
+	}
+	return h
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/mock"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHelo_VerifyReverseDns(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tests := []struct {
+		name     string
+		ip       string
+		resolver *fakeResolver
+		status   session.ReverseDnsStatus
+		hostname string
+	}{
+		{
+			name: "pass",
+			ip:   "192.0.2.1",
+			resolver: &fakeResolver{
+				addr:   map[string][]string{"192.0.2.1": {"mx.example.com."}},
+				ipAddr: map[string][]net.IPAddr{"mx.example.com.": ipAddrs("192.0.2.1")},
+			},
+			status:   session.ReverseDnsPass,
+			hostname: "mx.example.com",
+		},
+		{
+			name: "second name is confirmed",
+			ip:   "2001:db8::1",
+			resolver: &fakeResolver{
+				addr: map[string][]string{"2001:db8::1": {"a.example.com", "b.example.com"}},
+				ipAddr: map[string][]net.IPAddr{
+					"a.example.com": ipAddrs("2001:db8::2"),
+					"b.example.com": ipAddrs("2001:db8::1"),
+				},
+			},
+			status:   session.ReverseDnsPass,
+			hostname: "b.example.com",
+		},
+		{
+			name:     "no ptr",
+			ip:       "192.0.2.1",
+			resolver: &fakeResolver{},
+			status:   session.ReverseDnsFail,
+		},
+		{
+			name: "forward lookup does not match",
+			ip:   "192.0.2.1",
+			resolver: &fakeResolver{
+				addr:   map[string][]string{"192.0.2.1": {"mx.example.com."}},
+				ipAddr: map[string][]net.IPAddr{"mx.example.com.": ipAddrs("192.0.2.2")},
+			},
+			status: session.ReverseDnsFail,
+		},
+		{
+			name: "ptr lookup error",
+			ip:   "192.0.2.1",
+			resolver: &fakeResolver{
+				err: map[string]error{"192.0.2.1": errors.New("timeout")},
+			},
+			status: session.ReverseDnsTempError,
+		},
+		{
+			name: "forward lookup error",
+			ip:   "192.0.2.1",
+			resolver: &fakeResolver{
+				addr: map[string][]string{"192.0.2.1": {"mx.example.com."}},
+				err:  map[string]error{"mx.example.com.": errors.New("timeout")},
+			},
+			status: session.ReverseDnsTempError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := NewHeloService(mock.NewInitializedMockLogger(ctrl), &config.HeloConfig{CheckReverseDns: true}, test.resolver)
+
+			status, hostname := target.VerifyReverseDns(context.TODO(), newRateLimitSession(test.ip))
+			assert.Equal(t, test.status, status)
+			assert.Equal(t, test.hostname, hostname)
+		})
+	}
+}
+
+func TestHelo_VerifyReverseDnsDisabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	target := NewHeloService(mock.NewInitializedMockLogger(ctrl), &config.HeloConfig{}, &fakeResolver{})
+
+	status, hostname := target.VerifyReverseDns(context.TODO(), newRateLimitSession("192.0.2.1"))
+	assert.Equal(t, session.ReverseDnsNone, status)
+	assert.Empty(t, hostname)
+}
+
+func TestHelo_CheckHelo(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tests := []struct {
+		name   string
+		ip     string
+		helo   string
+		status session.HeloStatus
+	}{
+		{name: "fqdn", ip: "192.0.2.1", helo: "mx.example.com", status: session.HeloValid},
+		{name: "fqdn with trailing dot", ip: "192.0.2.1", helo: "MX.Example.com.", status: session.HeloValid},
+		{name: "bare name", ip: "192.0.2.1", helo: "localhost", status: session.HeloInvalid},
+		{name: "bare ip address", ip: "192.0.2.1", helo: "192.0.2.1", status: session.HeloInvalid},
+		{name: "invalid character", ip: "192.0.2.1", helo: "mx_1.example.com", status: session.HeloInvalid},
+		{name: "address literal", ip: "192.0.2.1", helo: "[192.0.2.1]", status: session.HeloValid},
+		{name: "address literal mismatch", ip: "192.0.2.1", helo: "[192.0.2.2]", status: session.HeloMismatch},
+		{name: "ipv6 address literal", ip: "2001:db8::1", helo: "[IPv6:2001:db8::1]", status: session.HeloValid},
+		{name: "ipv6 address literal without tag", ip: "2001:db8::1", helo: "[2001:db8::1]", status: session.HeloInvalid},
+		{name: "broken address literal", ip: "192.0.2.1", helo: "[192.0.2.1", status: session.HeloInvalid},
+		{name: "own hostname", ip: "192.0.2.1", helo: "mail.example.net", status: session.HeloOwnHostname},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := NewHeloService(mock.NewInitializedMockLogger(ctrl), &config.HeloConfig{
+				CheckHelo:    true,
+				OwnHostnames: []string{"Mail.Example.net."},
+			}, &fakeResolver{})
+
+			assert.Equal(t, test.status, target.CheckHelo(newRateLimitSession(test.ip), test.helo))
+		})
+	}
+}
+
+func TestHelo_CheckHeloDisabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	target := NewHeloService(mock.NewInitializedMockLogger(ctrl), &config.HeloConfig{}, &fakeResolver{})
+
+	assert.Equal(t, session.HeloNone, target.CheckHelo(newRateLimitSession("192.0.2.1"), "localhost"))
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// MailboxSource looks up whether the mailbox exists in the local domain.
+type MailboxSource interface {
+	Exists(ctx context.Context, localPart, domain string) (bool, error)
+}
+
+// MailboxSourceFunc is a callback used as MailboxSource.
+type MailboxSourceFunc func(ctx context.Context, localPart, domain string) (bool, error)
+
+func (f MailboxSourceFunc) Exists(ctx context.Context, localPart, domain string) (bool, error) {
+	return f(ctx, localPart, domain)
+}
+
+// mailboxes are compared case-insensitively, though RFC 5321 permits case-sensitive local parts
+func mailboxKey(localPart, domain string) string {
+	return strings.ToLower(localPart + "@" + domain)
+}
+
+type fileMailboxSource struct {
+	mailboxes map[string]struct{}
+}
+
+func (s *fileMailboxSource) Exists(ctx context.Context, localPart, domain string) (bool, error) {
+	_, ok := s.mailboxes[mailboxKey(localPart, domain)]
+	return ok, nil
+}
+
+// NewFileMailboxSource loads the file which has one mailbox "local-part@domain" per line.
+// Empty lines and lines starting with "#" are ignored.
+func NewFileMailboxSource(path string) (MailboxSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mailboxes := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		at := strings.LastIndex(line, "@")
+		if at <= 0 || at == len(line)-1 {
+			return nil, fmt.Errorf("%s:%d: invalid mailbox %q", path, lineNo, line)
+		}
+		mailboxes[mailboxKey(line[:at], line[at+1:])] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &fileMailboxSource{
+		mailboxes: mailboxes,
+	}, nil
+}
+
+type sqliteMailboxSource struct {
+	db *sql.DB
+}
+
+func (s *sqliteMailboxSource) Exists(ctx context.Context, localPart, domain string) (bool, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM mailboxes WHERE lower(local_part) = lower(?) AND lower(domain) = lower(?)",
+		localPart, domain,
+	).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// NewSqliteMailboxSource opens the database which has a table "mailboxes(local_part, domain)".
+func NewSqliteMailboxSource(path string) (MailboxSource, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteMailboxSource{
+		db: db,
+	}, nil
+}
+
+// NewMailboxSource creates MailboxSource selected by the config.
+// Every mailbox exists when no source is configured.
+func NewMailboxSource(conf *config.RecipientConfig) (MailboxSource, error) {
+	switch conf.MailboxSource {
+	case config.MailboxSourceNone:
+		return MailboxSourceFunc(func(ctx context.Context, localPart, domain string) (bool, error) {
+			return true, nil
+		}), nil
+	case config.MailboxSourceFile:
+		return NewFileMailboxSource(conf.MailboxFilePath)
+	case config.MailboxSourceSqlite:
+		return NewSqliteMailboxSource(conf.MailboxDbPath)
+	default:
+		return nil, fmt.Errorf("unknown mailbox source %q", conf.MailboxSource)
+	}
+}
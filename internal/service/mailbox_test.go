@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMailboxSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mailboxes")
+	content := "# local users\nuser@example.com\n\n  Admin@Example.net  \n"
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+
+	source, err := NewMailboxSource(&config.RecipientConfig{
+		MailboxSource:   config.MailboxSourceFile,
+		MailboxFilePath: path,
+	})
+	assert.Nil(t, err)
+
+	for _, test := range []struct {
+		localPart, domain string
+		expect            bool
+	}{
+		{"user", "example.com", true},
+		{"admin", "example.net", true},
+		{"user", "example.net", false},
+	} {
+		ok, err := source.Exists(context.TODO(), test.localPart, test.domain)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expect, ok, test.localPart+"@"+test.domain)
+	}
+}
+
+func TestFileMailboxSource_Err(t *testing.T) {
+	_, err := NewFileMailboxSource(filepath.Join(t.TempDir(), "not-found"))
+	assert.NotNil(t, err)
+
+	path := filepath.Join(t.TempDir(), "mailboxes")
+	assert.Nil(t, os.WriteFile(path, []byte("no-domain\n"), 0600))
+	_, err = NewFileMailboxSource(path)
+	assert.NotNil(t, err)
+}
+
+func TestSqliteMailboxSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mailboxes.db")
+	db, err := sql.Open("sqlite3", path)
+	assert.Nil(t, err)
+	_, err = db.Exec("CREATE TABLE mailboxes (local_part TEXT NOT NULL, domain TEXT NOT NULL)")
+	assert.Nil(t, err)
+	_, err = db.Exec("INSERT INTO mailboxes VALUES ('user', 'example.com')")
+	assert.Nil(t, err)
+	db.Close()
+
+	source, err := NewMailboxSource(&config.RecipientConfig{
+		MailboxSource: config.MailboxSourceSqlite,
+		MailboxDbPath: path,
+	})
+	assert.Nil(t, err)
+
+	ok, err := source.Exists(context.TODO(), "User", "example.com")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = source.Exists(context.TODO(), "unknown", "example.com")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestNewMailboxSource_Unknown(t *testing.T) {
+	_, err := NewMailboxSource(&config.RecipientConfig{MailboxSource: "ldap"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,426 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/milter"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultMilterConnectTimeout = 30 * time.Second
+	defaultMilterCommandTimeout = 30 * time.Second
+	// value of the macro "{daemon_name}"
+	milterDaemonName = "smtp-server"
+)
+
+// MilterService calls external content filters by the Sendmail milter protocol at each stage of the session.
+type MilterService interface {
+	// Connect opens the connections to the milters and sends the client information.
+	Connect(ctx context.Context, s *session.Session) *data.MilterResult
+	Helo(ctx context.Context, s *session.Session, helo string) *data.MilterResult
+	// Mail starts a message of the sender s.EnvelopeFrom, args are ESMTP arguments such as "BODY=8BITMIME".
+	Mail(ctx context.Context, s *session.Session, args []string) *data.MilterResult
+	Rcpt(ctx context.Context, s *session.Session, recipient mail.Address, args []string) *data.MilterResult
+	// Data sends the message s.RawData, the changes of the message and the envelope requested by milters are
+	// applied to the session.
+	Data(ctx context.Context, s *session.Session) *data.MilterResult
+	// Close ends the conversation with the milters of the session.
+	Close(s *session.Session)
+}
+
+type milterConn struct {
+	conf   config.Milter
+	name   string
+	client *milter.Client
+	// the milter has accepted the connection or the message, it is not called until the end of them
+	acceptedConnection bool
+	acceptedMessage    bool
+	// the milter has received MAIL of the message which has not finished
+	inMessage bool
+}
+
+type milterSession struct {
+	conns []*milterConn
+	// a milter has discarded the connection, all messages of it are discarded
+	discardAll bool
+}
+
+type milterServiceImpl struct {
+	log      hlog.Logger
+	enable   bool
+	milters  []config.Milter
+	hostname string
+
+	mu       sync.Mutex
+	sessions map[uuid.UUID]*milterSession
+}
+
+func milterName(conf config.Milter) string {
+	if len(conf.Name) > 0 {
+		return conf.Name
+	}
+	return conf.Network + ":" + conf.Address
+}
+
+func (m *milterServiceImpl) session(s *session.Session) *milterSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sessions[s.Id]
+}
+
+func (m *milterServiceImpl) Connect(ctx context.Context, s *session.Session) *data.MilterResult {
+	if !m.enable || len(m.milters) == 0 {
+		return &data.MilterResult{}
+	}
+
+	ms := &milterSession{}
+	m.mu.Lock()
+	m.sessions[s.Id] = ms
+	m.mu.Unlock()
+
+	for _, conf := range m.milters {
+		c := &milterConn{conf: conf, name: milterName(conf)}
+		client, err := m.dial(ctx, conf)
+		if err != nil {
+			m.log.WithError(err).Errorf("[%s] failed to connect to milter %s.", s.Id, c.name)
+			if res := m.defaultResult(c); res != nil {
+				return res
+			}
+			continue
+		}
+		c.client = client
+		ms.conns = append(ms.conns, c)
+	}
+
+	ip, port := remoteAddr(s)
+	hostname := "[unknown]"
+	clientAddr := ""
+	if ip != nil {
+		hostname = "[" + ip.String() + "]"
+		clientAddr = ip.String()
+	}
+	return m.call(s, ms, "connect", true, func(c *milterConn) (*milter.Response, error) {
+		err := c.client.Macros(milter.CmdConnect,
+			"j", m.hostname,
+			"{daemon_name}", milterDaemonName,
+			"{client_addr}", clientAddr,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return c.client.Connect(hostname, ip, port)
+	})
+}
+
+func (m *milterServiceImpl) dial(ctx context.Context, conf config.Milter) (*milter.Client, error) {
+	connectTimeout := conf.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = defaultMilterConnectTimeout
+	}
+	commandTimeout := conf.CommandTimeout
+	if commandTimeout <= 0 {
+		commandTimeout = defaultMilterCommandTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	return milter.Dial(ctx, conf.Network, conf.Address, commandTimeout)
+}
+
+func remoteAddr(s *session.Session) (net.IP, int) {
+	if s.Conn == nil {
+		return nil, 0
+	}
+	addr := s.Conn.RemoteAddr()
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP, tcpAddr.Port
+	}
+	return session.RemoteIP(addr), 0
+}
+
+func (m *milterServiceImpl) Helo(ctx context.Context, s *session.Session, helo string) *data.MilterResult {
+	ms := m.session(s)
+	if ms == nil {
+		return &data.MilterResult{}
+	}
+	// HELO resets the transaction in progress
+	m.abort(ms)
+	return m.call(s, ms, "HELO", true, func(c *milterConn) (*milter.Response, error) {
+		return c.client.Helo(helo)
+	})
+}
+
+func (m *milterServiceImpl) Mail(ctx context.Context, s *session.Session, args []string) *data.MilterResult {
+	ms := m.session(s)
+	if ms == nil {
+		return &data.MilterResult{}
+	}
+	m.abort(ms)
+	for _, c := range ms.conns {
+		c.acceptedMessage = false
+	}
+	if ms.discardAll {
+		s.Discard = true
+		return &data.MilterResult{}
+	}
+
+	return m.call(s, ms, "MAIL", false, func(c *milterConn) (*milter.Response, error) {
+		c.inMessage = true
+		err := c.client.Macros(milter.CmdMail,
+			"i", s.Id.String(),
+			"{mail_addr}", s.EnvelopeFrom.Address,
+			"{auth_authen}", s.AuthUser,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return c.client.Mail("<"+s.EnvelopeFrom.Address+">", args)
+	})
+}
+
+func (m *milterServiceImpl) Rcpt(ctx context.Context, s *session.Session, recipient mail.Address, args []string) *data.MilterResult {
+	ms := m.session(s)
+	if ms == nil || s.Discard {
+		return &data.MilterResult{}
+	}
+	return m.call(s, ms, "RCPT", false, func(c *milterConn) (*milter.Response, error) {
+		if err := c.client.Macros(milter.CmdRcpt, "{rcpt_addr}", recipient.Address); err != nil {
+			return nil, err
+		}
+		return c.client.Rcpt("<"+recipient.Address+">", args)
+	})
+}
+
+func (m *milterServiceImpl) Data(ctx context.Context, s *session.Session) *data.MilterResult {
+	ms := m.session(s)
+	if ms == nil || s.Discard {
+		return &data.MilterResult{}
+	}
+	return m.call(s, ms, "DATA", false, func(c *milterConn) (*milter.Response, error) {
+		msg := milter.ParseMessage(s.RawData)
+		res, err := m.sendMessage(c, msg)
+		if err != nil || res.Code != milter.RespContinue {
+			return res, err
+		}
+
+		res, err = c.client.EndOfMessage()
+		if err != nil {
+			return nil, err
+		}
+		c.inMessage = false
+		if len(res.Modifications) > 0 && (res.Code == milter.RespContinue || res.Code == milter.RespAccept) {
+			// the following milters see the changed message
+			msg.Apply(res.Modifications)
+			s.RawData = msg.Bytes()
+			m.applyEnvelope(s, c, res.Modifications)
+		}
+		return res, nil
+	})
+}
+
+// sendMessage sends the message until the end of body, responses other than continue stop it.
+func (m *milterServiceImpl) sendMessage(c *milterConn, msg *milter.Message) (*milter.Response, error) {
+	res, err := c.client.Data()
+	if err != nil || res.Code != milter.RespContinue {
+		return res, err
+	}
+	for _, h := range msg.Headers {
+		res, err := c.client.Header(h.Name, h.MilterValue())
+		if err != nil || res.Code != milter.RespContinue {
+			return res, err
+		}
+	}
+	res, err = c.client.EndOfHeaders()
+	if err != nil || res.Code != milter.RespContinue {
+		return res, err
+	}
+	res, err = c.client.Body(msg.MilterBody())
+	if err != nil {
+		return nil, err
+	}
+	// the milter does not need the rest of the body
+	if res.Code == milter.RespSkip {
+		return &milter.Response{Code: milter.RespContinue}, nil
+	}
+	return res, nil
+}
+
+// applyEnvelope applies the changes of the sender and recipients.
+func (m *milterServiceImpl) applyEnvelope(s *session.Session, c *milterConn, mods []milter.Modification) {
+	for _, mod := range mods {
+		address := strings.TrimSuffix(strings.TrimPrefix(mod.Name, "<"), ">")
+		switch mod.Code {
+		case milter.RespAddRcpt, milter.RespAddRcptPar:
+			m.log.Infof("[%s] milter %s added recipient %s.", s.Id, c.name, address)
+			s.AddEnvelopeTo(mail.Address{Address: address})
+		case milter.RespDelRcpt:
+			m.log.Infof("[%s] milter %s deleted recipient %s.", s.Id, c.name, address)
+			to := make([]mail.Address, 0, len(s.EnvelopeTo))
+			for _, rcpt := range s.EnvelopeTo {
+				if !strings.EqualFold(rcpt.Address, address) {
+					to = append(to, rcpt)
+				}
+			}
+			s.EnvelopeTo = to
+		case milter.RespChgFrom:
+			m.log.Infof("[%s] milter %s changed sender to %s.", s.Id, c.name, address)
+			s.EnvelopeFrom = &mail.Address{Address: address}
+		}
+	}
+	// nobody receives the message
+	if len(s.EnvelopeTo) == 0 {
+		s.Discard = true
+	}
+}
+
+// call calls the milters in order until one of them decides the action.
+// Milters accepting at the connection stage (connection is true) are not called for the connection,
+// and others are not called until the end of the message.
+func (m *milterServiceImpl) call(s *session.Session, ms *milterSession, stage string, connection bool, f func(c *milterConn) (*milter.Response, error)) *data.MilterResult {
+	for _, c := range ms.conns {
+		if c.client == nil || c.acceptedConnection || c.acceptedMessage {
+			continue
+		}
+
+		res, err := f(c)
+		if err == nil && res.Code == milter.RespReplyCode {
+			var result *data.MilterResult
+			result, err = replyCodeResult(res.Text)
+			if err == nil {
+				result.Milter = c.name
+				m.log.Infof("[%s] milter %s replied %q at %s.", s.Id, c.name, res.Text, stage)
+				return result
+			}
+		}
+		if err != nil {
+			m.log.WithError(err).Errorf("[%s] milter %s failed at %s.", s.Id, c.name, stage)
+			// the broken connection is not used anymore
+			c.client.Close()
+			c.client = nil
+			if res := m.defaultResult(c); res != nil {
+				return res
+			}
+			continue
+		}
+
+		switch res.Code {
+		case milter.RespAccept:
+			if connection {
+				c.acceptedConnection = true
+			} else {
+				c.acceptedMessage = true
+			}
+		case milter.RespReject:
+			m.log.Infof("[%s] milter %s rejected at %s.", s.Id, c.name, stage)
+			return &data.MilterResult{Action: data.MilterReject, Milter: c.name}
+		case milter.RespTempFail:
+			m.log.Infof("[%s] milter %s temporarily failed at %s.", s.Id, c.name, stage)
+			return &data.MilterResult{Action: data.MilterTempFail, Milter: c.name}
+		case milter.RespDiscard:
+			m.log.Infof("[%s] milter %s discarded at %s.", s.Id, c.name, stage)
+			if connection {
+				ms.discardAll = true
+			} else {
+				s.Discard = true
+			}
+			return &data.MilterResult{Action: data.MilterDiscard, Milter: c.name}
+		}
+	}
+	return &data.MilterResult{}
+}
+
+// replyCodeResult converts the reply given by the milter, 4xx is temporary failure and 5xx is rejection.
+func replyCodeResult(text string) (*data.MilterResult, error) {
+	code, enhanced, lines, err := milter.ParseReplyCode(text)
+	if err != nil {
+		return nil, err
+	}
+	reply := session.NewReply(code, session.EnhancedCode(enhanced), lines...)
+	if code < 500 {
+		return &data.MilterResult{Action: data.MilterTempFail, Reply: &reply}, nil
+	}
+	return &data.MilterResult{Action: data.MilterReject, Reply: &reply}, nil
+}
+
+// defaultResult returns the action when the milter is not available, nil means the milter is skipped.
+func (m *milterServiceImpl) defaultResult(c *milterConn) *data.MilterResult {
+	switch c.conf.DefaultAction {
+	case config.MilterActionAccept:
+		return nil
+	case config.MilterActionReject:
+		return &data.MilterResult{Action: data.MilterReject, Milter: c.name}
+	default:
+		return &data.MilterResult{Action: data.MilterTempFail, Milter: c.name}
+	}
+}
+
+// abort cancels the message which has not finished.
+func (m *milterServiceImpl) abort(ms *milterSession) {
+	for _, c := range ms.conns {
+		if c.client != nil && c.inMessage {
+			if err := c.client.Abort(); err != nil {
+				m.log.WithError(err).Warnf("failed to abort milter %s.", c.name)
+			}
+		}
+		c.inMessage = false
+	}
+}
+
+func (m *milterServiceImpl) Close(s *session.Session) {
+	m.mu.Lock()
+	ms := m.sessions[s.Id]
+	delete(m.sessions, s.Id)
+	m.mu.Unlock()
+	if ms == nil {
+		return
+	}
+
+	m.abort(ms)
+	for _, c := range ms.conns {
+		if c.client != nil {
+			c.client.Close()
+		}
+	}
+}
+
+func NewMilterService(log hlog.Logger, conf *config.MilterConfig) (MilterService, error) {
+	for _, mc := range conf.Milters {
+		if mc.Network != "tcp" && mc.Network != "unix" {
+			return nil, fmt.Errorf("invalid network %q of milter %s", mc.Network, milterName(mc))
+		}
+		switch mc.DefaultAction {
+		case "", config.MilterActionAccept, config.MilterActionTempFail, config.MilterActionReject:
+		default:
+			return nil, fmt.Errorf("invalid default action %q of milter %s", mc.DefaultAction, milterName(mc))
+		}
+	}
+
+	hostname := conf.Hostname
+	if len(hostname) == 0 {
+		name, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname for milter: %w", err)
+		}
+		hostname = name
+	}
+
+	return &milterServiceImpl{
+		log:      log,
+		enable:   conf.Enable,
+		milters:  conf.Milters,
+		hostname: hostname,
+		sessions: make(map[uuid.UUID]*milterSession),
+	}, nil
+}
@@ -0,0 +1,342 @@
+package service
+
+import (
+	"context"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/milter"
+	"github.com/Haya372/smtp-server/internal/milter/miltertest"
+	"github.com/Haya372/smtp-server/internal/mock"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMilterSession() *session.Session {
+	s := newRateLimitSession("192.0.2.1")
+	s.Id = uuid.New()
+	s.EnvelopeFrom = &mail.Address{Address: "from@example.com"}
+	s.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+	return s
+}
+
+func newFakeMilter(t *testing.T, actions uint32, handler func(cmd *milter.Packet) []*milter.Packet) *miltertest.Milter {
+	f, err := miltertest.NewMilter(actions, 0, handler)
+	assert.Nil(t, err)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func newTestMilterService(t *testing.T, milters ...config.Milter) MilterService {
+	ctrl := gomock.NewController(t)
+	m, err := NewMilterService(mock.NewInitializedMockLogger(ctrl), &config.MilterConfig{
+		Enable:   true,
+		Milters:  milters,
+		Hostname: "mx.example.com",
+	})
+	assert.Nil(t, err)
+	return m
+}
+
+func milterConf(f *miltertest.Milter) config.Milter {
+	return config.Milter{Network: "tcp", Address: f.Addr(), CommandTimeout: time.Second}
+}
+
+// replyAt replies the packet to the command, others are continued.
+func replyAt(code byte, reply *milter.Packet) func(cmd *milter.Packet) []*milter.Packet {
+	return func(cmd *milter.Packet) []*milter.Packet {
+		if cmd.Code == code {
+			return []*milter.Packet{reply}
+		}
+		return nil
+	}
+}
+
+// runTransaction calls the milters from the connection to the end of message, the result of the first
+// stage which is not continued is returned.
+func runTransaction(m MilterService, s *session.Session) *data.MilterResult {
+	ctx := context.Background()
+	stages := []func() *data.MilterResult{
+		func() *data.MilterResult { return m.Connect(ctx, s) },
+		func() *data.MilterResult { return m.Helo(ctx, s, "client.example.com") },
+		func() *data.MilterResult { return m.Mail(ctx, s, nil) },
+		func() *data.MilterResult { return m.Rcpt(ctx, s, s.EnvelopeTo[0], nil) },
+		func() *data.MilterResult { return m.Data(ctx, s) },
+	}
+	for _, stage := range stages {
+		if res := stage(); res.Action != data.MilterContinue {
+			return res
+		}
+	}
+	return &data.MilterResult{}
+}
+
+func TestMilterService_Actions(t *testing.T) {
+	reply := session.NewReply(550, session.EnhancedCode{5, 7, 1}, "spam")
+	tempReply := session.NewReply(451, session.EnhancedCode{}, "later")
+
+	tests := []struct {
+		name     string
+		handler  func(cmd *milter.Packet) []*milter.Packet
+		expected *data.MilterResult
+		discard  bool
+		commands string
+	}{
+		{
+			name:     "continue",
+			handler:  replyAt(0, nil),
+			expected: &data.MilterResult{},
+			commands: "DCHDMDRTLNBE",
+		},
+		{
+			name:     "reject at connect",
+			handler:  replyAt(milter.CmdConnect, &milter.Packet{Code: milter.RespReject}),
+			expected: &data.MilterResult{Action: data.MilterReject, Milter: "test"},
+			commands: "DC",
+		},
+		{
+			name:     "tempfail at RCPT",
+			handler:  replyAt(milter.CmdRcpt, &milter.Packet{Code: milter.RespTempFail}),
+			expected: &data.MilterResult{Action: data.MilterTempFail, Milter: "test"},
+			commands: "DCHDMDR",
+		},
+		{
+			name:     "reply code at end of message",
+			handler:  replyAt(milter.CmdEndOfBody, miltertest.ReplyCode("550 5.7.1 spam")),
+			expected: &data.MilterResult{Action: data.MilterReject, Reply: &reply, Milter: "test"},
+			commands: "DCHDMDRTLNBE",
+		},
+		{
+			name:     "temporary reply code at MAIL",
+			handler:  replyAt(milter.CmdMail, miltertest.ReplyCode("451 later")),
+			expected: &data.MilterResult{Action: data.MilterTempFail, Reply: &tempReply, Milter: "test"},
+			commands: "DCHDM",
+		},
+		{
+			name:     "accept at connect skips the rest",
+			handler:  replyAt(milter.CmdConnect, &milter.Packet{Code: milter.RespAccept}),
+			expected: &data.MilterResult{},
+			commands: "DC",
+		},
+		{
+			name:     "accept at MAIL skips the message",
+			handler:  replyAt(milter.CmdMail, &milter.Packet{Code: milter.RespAccept}),
+			expected: &data.MilterResult{},
+			commands: "DCHDM",
+		},
+		{
+			name:     "discard at header",
+			handler:  replyAt(milter.CmdHeader, &milter.Packet{Code: milter.RespDiscard}),
+			expected: &data.MilterResult{Action: data.MilterDiscard, Milter: "test"},
+			discard:  true,
+			commands: "DCHDMDRTL",
+		},
+		{
+			name:     "skip of body is continued",
+			handler:  replyAt(milter.CmdBody, &milter.Packet{Code: milter.RespSkip}),
+			expected: &data.MilterResult{},
+			commands: "DCHDMDRTLNBE",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFakeMilter(t, 0, test.handler)
+			conf := milterConf(f)
+			conf.Name = "test"
+			m := newTestMilterService(t, conf)
+			s := newMilterSession()
+			s.RawData = []byte("Subject: hello\n\nbody\n")
+
+			res := runTransaction(m, s)
+			m.Close(s)
+
+			assert.Equal(t, test.expected, res)
+			assert.Equal(t, test.discard, s.Discard)
+			assert.Eventually(t, func() bool {
+				codes := f.CommandCodes()
+				// the message in progress is aborted before QUIT
+				return codes == test.commands+"Q" || codes == test.commands+"AQ"
+			}, time.Second, 10*time.Millisecond, f.CommandCodes())
+		})
+	}
+}
+
+func TestMilterService_Macros(t *testing.T) {
+	f := newFakeMilter(t, 0, replyAt(0, nil))
+	m := newTestMilterService(t, milterConf(f))
+	s := newMilterSession()
+	s.AuthUser = "user"
+	s.RawData = []byte("Subject: hello\n\nbody\n")
+
+	runTransaction(m, s)
+	m.Close(s)
+
+	macros := make([][]string, 0)
+	for _, cmd := range f.Commands() {
+		if cmd.Code == milter.CmdMacro {
+			macros = append(macros, append([]string{string(cmd.Data[0])}, (&milter.Packet{Data: cmd.Data[1:]}).Strings()...))
+		}
+	}
+	assert.Equal(t, [][]string{
+		{"C", "j", "mx.example.com", "{daemon_name}", "smtp-server", "{client_addr}", "192.0.2.1"},
+		{"M", "i", s.Id.String(), "{mail_addr}", "from@example.com", "{auth_authen}", "user"},
+		{"R", "{rcpt_addr}", "to@example.com"},
+	}, macros)
+}
+
+func TestMilterService_Modifications(t *testing.T) {
+	actions := milter.ActAddHeader | milter.ActChgHeader | milter.ActChgBody | milter.ActAddRcpt | milter.ActDelRcpt | milter.ActChgFrom
+	first := newFakeMilter(t, actions, func(cmd *milter.Packet) []*milter.Packet {
+		if cmd.Code != milter.CmdEndOfBody {
+			return nil
+		}
+		return []*milter.Packet{
+			miltertest.AddHeader("X-Spam", "yes"),
+			miltertest.ChangeHeader(1, "Subject", "[SPAM] hello"),
+			miltertest.ReplaceBody([]byte("new body\r\n")),
+			miltertest.AddRcpt("<quarantine@example.com>"),
+			miltertest.DeleteRcpt("<to@example.com>"),
+			miltertest.ChangeFrom("<bounce@example.com>"),
+			{Code: milter.RespContinue},
+		}
+	})
+	// the second milter sees the changed message
+	var headers []string
+	var body []byte
+	second := newFakeMilter(t, 0, func(cmd *milter.Packet) []*milter.Packet {
+		switch cmd.Code {
+		case milter.CmdHeader:
+			headers = append(headers, cmd.Strings()...)
+		case milter.CmdBody:
+			body = cmd.Data
+		}
+		return nil
+	})
+	m := newTestMilterService(t, milterConf(first), milterConf(second))
+	s := newMilterSession()
+	s.RawData = []byte("Subject: hello\n\nbody\n")
+
+	res := runTransaction(m, s)
+	m.Close(s)
+
+	assert.Equal(t, &data.MilterResult{}, res)
+	assert.Equal(t, "Subject: [SPAM] hello\nX-Spam: yes\n\nnew body\n", string(s.RawData))
+	assert.Equal(t, []mail.Address{{Address: "quarantine@example.com"}}, s.EnvelopeTo)
+	assert.Equal(t, &mail.Address{Address: "bounce@example.com"}, s.EnvelopeFrom)
+	assert.False(t, s.Discard)
+	assert.Equal(t, []string{"Subject", "[SPAM] hello", "X-Spam", "yes"}, headers)
+	assert.Equal(t, "new body\r\n", string(body))
+}
+
+func TestMilterService_DeleteAllRecipients(t *testing.T) {
+	f := newFakeMilter(t, milter.ActDelRcpt, func(cmd *milter.Packet) []*milter.Packet {
+		if cmd.Code == milter.CmdEndOfBody {
+			return []*milter.Packet{miltertest.DeleteRcpt("<to@example.com>"), {Code: milter.RespContinue}}
+		}
+		return nil
+	})
+	m := newTestMilterService(t, milterConf(f))
+	s := newMilterSession()
+	s.RawData = []byte("Subject: hello\n\nbody\n")
+
+	res := runTransaction(m, s)
+	m.Close(s)
+
+	assert.Equal(t, &data.MilterResult{}, res)
+	assert.Empty(t, s.EnvelopeTo)
+	assert.True(t, s.Discard)
+}
+
+func TestMilterService_DefaultAction(t *testing.T) {
+	tests := []struct {
+		action   string
+		expected data.MilterAction
+	}{
+		{action: "", expected: data.MilterTempFail},
+		{action: config.MilterActionTempFail, expected: data.MilterTempFail},
+		{action: config.MilterActionReject, expected: data.MilterReject},
+		{action: config.MilterActionAccept, expected: data.MilterContinue},
+	}
+
+	for _, test := range tests {
+		t.Run(test.action, func(t *testing.T) {
+			// nobody listens on the port of the closed milter
+			f := newFakeMilter(t, 0, replyAt(0, nil))
+			conf := milterConf(f)
+			f.Close()
+			conf.DefaultAction = test.action
+			m := newTestMilterService(t, conf)
+			s := newMilterSession()
+
+			res := m.Connect(context.Background(), s)
+			m.Close(s)
+
+			assert.Equal(t, test.expected, res.Action)
+		})
+	}
+}
+
+func TestMilterService_BrokenMilter(t *testing.T) {
+	// the milter replies to MAIL with a modification which is allowed only at the end of message
+	f := newFakeMilter(t, 0, replyAt(milter.CmdMail, miltertest.AddHeader("X-Spam", "yes")))
+	conf := milterConf(f)
+	conf.DefaultAction = config.MilterActionAccept
+	m := newTestMilterService(t, conf)
+	s := newMilterSession()
+	s.RawData = []byte("Subject: hello\n\nbody\n")
+
+	res := runTransaction(m, s)
+	m.Close(s)
+
+	// the broken milter is not called anymore
+	assert.Equal(t, &data.MilterResult{}, res)
+	assert.Eventually(t, func() bool { return f.CommandCodes() == "DCHDMQ" }, time.Second, 10*time.Millisecond, f.CommandCodes())
+}
+
+func TestMilterService_AbortOnNewMail(t *testing.T) {
+	f := newFakeMilter(t, 0, replyAt(0, nil))
+	m := newTestMilterService(t, milterConf(f))
+	s := newMilterSession()
+	ctx := context.Background()
+
+	m.Connect(ctx, s)
+	m.Mail(ctx, s, []string{"BODY=8BITMIME"})
+	m.Rcpt(ctx, s, s.EnvelopeTo[0], nil)
+	// RSET and another MAIL
+	m.Mail(ctx, s, nil)
+	m.Close(s)
+
+	assert.Eventually(t, func() bool { return f.CommandCodes() == "DCDMDRADMAQ" }, time.Second, 10*time.Millisecond, f.CommandCodes())
+	assert.Equal(t, []string{"<from@example.com>", "BODY=8BITMIME"}, f.Commands()[3].Strings())
+}
+
+func TestMilterService_Disabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	m, err := NewMilterService(mock.NewInitializedMockLogger(ctrl), &config.MilterConfig{
+		Milters:  []config.Milter{{Network: "tcp", Address: "127.0.0.1:1"}},
+		Hostname: "mx.example.com",
+	})
+	assert.Nil(t, err)
+	s := newMilterSession()
+
+	assert.Equal(t, &data.MilterResult{}, runTransaction(m, s))
+	m.Close(s)
+}
+
+func TestNewMilterService_InvalidConfig(t *testing.T) {
+	tests := []config.Milter{
+		{Network: "udp", Address: "127.0.0.1:8891"},
+		{Network: "tcp", Address: "127.0.0.1:8891", DefaultAction: "discard"},
+	}
+
+	for _, conf := range tests {
+		_, err := NewMilterService(nil, &config.MilterConfig{Milters: []config.Milter{conf}, Hostname: "mx.example.com"})
+		assert.NotNil(t, err)
+	}
+}
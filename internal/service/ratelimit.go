@@ -0,0 +1,193 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+// interval to remove buckets which have been refilled
+const rateLimitSweepInterval = time.Minute
+
+type RateLimitService interface {
+	// AllowConnection is checked when the client connects, the IP address and subnet are limited.
+	AllowConnection(s *session.Session) bool
+	// AllowMessage is checked when a transaction is started by MAIL, the envelope sender must be set.
+	AllowMessage(s *session.Session) bool
+	// AllowRecipient is checked for each RCPT.
+	AllowRecipient(s *session.Session) bool
+}
+
+// tokenBucket has Limit tokens at most and is refilled by Limit tokens per Window.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	limit   config.RateLimit
+	buckets map[string]*tokenBucket
+}
+
+// available returns the bucket refilled until now, nil is returned when no token is available.
+func (l *rateLimiter) available(key string, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.limit.Limit), last: now}
+		l.buckets[key] = b
+	}
+	rate := float64(l.limit.Limit) / float64(l.limit.Window)
+	b.tokens += float64(now.Sub(b.last)) * rate
+	if b.tokens > float64(l.limit.Limit) {
+		b.tokens = float64(l.limit.Limit)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return nil
+	}
+	return b
+}
+
+// sweep removes buckets which would be full by now, so that state of inactive clients expires.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.limit.Window {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type rateLimitServiceImpl struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	lastSweep time.Time
+	subnets   *session.SubnetGrouping
+
+	connections []*rateLimiterSet
+	messages    []*rateLimiterSet
+	recipients  []*rateLimiterSet
+}
+
+// rateLimiterSet is the limiter of a key type, key returns empty string when it is not applied to the session.
+type rateLimiterSet struct {
+	*rateLimiter
+	key func(s *session.Session) string
+}
+
+func (r *rateLimitServiceImpl) AllowConnection(s *session.Session) bool {
+	return r.allow(r.connections, s)
+}
+
+func (r *rateLimitServiceImpl) AllowMessage(s *session.Session) bool {
+	return r.allow(r.messages, s)
+}
+
+func (r *rateLimitServiceImpl) AllowRecipient(s *session.Session) bool {
+	return r.allow(r.recipients, s)
+}
+
+// allow takes a token from every bucket, no token is taken when any of them is empty.
+func (r *rateLimitServiceImpl) allow(limiters []*rateLimiterSet, s *session.Session) bool {
+	if len(limiters) == 0 {
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if now.Sub(r.lastSweep) >= rateLimitSweepInterval {
+		r.sweep(now)
+	}
+
+	buckets := make([]*tokenBucket, 0, len(limiters))
+	for _, l := range limiters {
+		key := l.key(s)
+		if len(key) == 0 {
+			continue
+		}
+		b := l.available(key, now)
+		if b == nil {
+			return false
+		}
+		buckets = append(buckets, b)
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true
+}
+
+func (r *rateLimitServiceImpl) sweep(now time.Time) {
+	r.lastSweep = now
+	for _, limiters := range [][]*rateLimiterSet{r.connections, r.messages, r.recipients} {
+		for _, l := range limiters {
+			l.sweep(now)
+		}
+	}
+}
+
+func (r *rateLimitServiceImpl) ipKey(s *session.Session) string {
+	if ip := s.IP(); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+func (r *rateLimitServiceImpl) subnetKey(s *session.Session) string {
+	if ip := s.IP(); ip != nil {
+		return r.subnets.Subnet(ip).String()
+	}
+	return ""
+}
+
+func userKey(s *session.Session) string {
+	return s.AuthUser
+}
+
+func senderDomainKey(s *session.Session) string {
+	if s.EnvelopeFrom == nil {
+		return ""
+	}
+	_, domain := splitAddress(s.EnvelopeFrom.Address)
+	return strings.ToLower(domain)
+}
+
+func (r *rateLimitServiceImpl) limiters(rule config.RateLimitRule, withSender bool) []*rateLimiterSet {
+	type entry struct {
+		limit config.RateLimit
+		key   func(s *session.Session) string
+	}
+	entries := []entry{
+		{rule.PerIp, r.ipKey},
+		{rule.PerSubnet, r.subnetKey},
+	}
+	if withSender {
+		entries = append(entries, entry{rule.PerUser, userKey}, entry{rule.PerSenderDomain, senderDomainKey})
+	}
+
+	res := make([]*rateLimiterSet, 0)
+	for _, e := range entries {
+		if e.limit.Limit <= 0 || e.limit.Window <= 0 {
+			continue
+		}
+		res = append(res, &rateLimiterSet{
+			rateLimiter: &rateLimiter{limit: e.limit, buckets: make(map[string]*tokenBucket)},
+			key:         e.key,
+		})
+	}
+	return res
+}
+
+func NewRateLimitService(conf *config.RateLimitConfig, subnets *session.SubnetGrouping) RateLimitService {
+	r := &rateLimitServiceImpl{
+		now:     time.Now,
+		subnets: subnets,
+	}
+	r.connections = r.limiters(conf.Connections, false)
+	r.messages = r.limiters(conf.Messages, true)
+	r.recipients = r.limiters(conf.Recipients, true)
+	return r
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"net"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/stretchr/testify/assert"
+)
+
+type remoteAddrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *remoteAddrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func newRateLimitSession(ip string) *session.Session {
+	return &session.Session{
+		Conn: &remoteAddrConn{addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 12345}},
+	}
+}
+
+func newTestRateLimitService(conf *config.RateLimitConfig) (*rateLimitServiceImpl, *time.Time) {
+	now := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRateLimitService(conf, session.NewSubnetGrouping(&config.SubnetConfig{})).(*rateLimitServiceImpl)
+	r.now = func() time.Time { return now }
+	return r, &now
+}
+
+func TestRateLimitService_Connection(t *testing.T) {
+	r, now := newTestRateLimitService(&config.RateLimitConfig{
+		Connections: config.RateLimitRule{
+			PerIp:     config.RateLimit{Limit: 2, Window: time.Minute},
+			PerSubnet: config.RateLimit{Limit: 3, Window: time.Minute},
+		},
+	})
+
+	assert.True(t, r.AllowConnection(newRateLimitSession("192.0.2.1")))
+	assert.True(t, r.AllowConnection(newRateLimitSession("192.0.2.1")))
+	assert.False(t, r.AllowConnection(newRateLimitSession("192.0.2.1")))
+
+	// same subnet
+	assert.True(t, r.AllowConnection(newRateLimitSession("192.0.2.2")))
+	assert.False(t, r.AllowConnection(newRateLimitSession("192.0.2.3")))
+	// other subnet
+	assert.True(t, r.AllowConnection(newRateLimitSession("198.51.100.1")))
+
+	// a token is refilled in half of the window for the IP, a third of the window for the subnet
+	*now = now.Add(30 * time.Second)
+	assert.True(t, r.AllowConnection(newRateLimitSession("192.0.2.1")))
+	assert.False(t, r.AllowConnection(newRateLimitSession("192.0.2.1")))
+
+	// messages and recipients are not limited
+	assert.True(t, r.AllowMessage(newRateLimitSession("192.0.2.1")))
+	assert.True(t, r.AllowRecipient(newRateLimitSession("192.0.2.1")))
+}
+
+func TestRateLimitService_Message(t *testing.T) {
+	r, _ := newTestRateLimitService(&config.RateLimitConfig{
+		Messages: config.RateLimitRule{
+			PerIp:           config.RateLimit{Limit: 3, Window: time.Hour},
+			PerUser:         config.RateLimit{Limit: 1, Window: time.Hour},
+			PerSenderDomain: config.RateLimit{Limit: 1, Window: time.Hour},
+		},
+	})
+
+	newSession := func(user, from string) *session.Session {
+		s := newRateLimitSession("192.0.2.1")
+		s.AuthUser = user
+		s.EnvelopeFrom = &mail.Address{Address: from}
+		return s
+	}
+
+	assert.True(t, r.AllowMessage(newSession("", "a@example.com")))
+	// sender domain is case-insensitive, no token of the IP is taken when the message is refused
+	assert.False(t, r.AllowMessage(newSession("", "b@EXAMPLE.com")))
+	// null sender is not limited by the domain
+	assert.True(t, r.AllowMessage(newSession("", "")))
+	assert.True(t, r.AllowMessage(newSession("user", "a@example.net")))
+	// IP limit is reached
+	assert.False(t, r.AllowMessage(newSession("", "a@example.org")))
+}
+
+func TestRateLimitService_User(t *testing.T) {
+	r, _ := newTestRateLimitService(&config.RateLimitConfig{
+		Recipients: config.RateLimitRule{
+			PerUser: config.RateLimit{Limit: 1, Window: time.Hour},
+		},
+	})
+
+	user := newRateLimitSession("192.0.2.1")
+	user.AuthUser = "user"
+	assert.True(t, r.AllowRecipient(user))
+	assert.False(t, r.AllowRecipient(user))
+
+	// the user is limited from any IP address
+	other := newRateLimitSession("198.51.100.1")
+	other.AuthUser = "user"
+	assert.False(t, r.AllowRecipient(other))
+
+	// unauthenticated clients are not limited
+	anonymous := newRateLimitSession("192.0.2.1")
+	assert.True(t, r.AllowRecipient(anonymous))
+	assert.True(t, r.AllowRecipient(anonymous))
+}
+
+func TestRateLimitService_Expire(t *testing.T) {
+	r, now := newTestRateLimitService(&config.RateLimitConfig{
+		Connections: config.RateLimitRule{
+			PerIp: config.RateLimit{Limit: 1, Window: 10 * time.Minute},
+		},
+	})
+
+	assert.True(t, r.AllowConnection(newRateLimitSession("192.0.2.1")))
+	assert.Len(t, r.connections[0].buckets, 1)
+
+	// the bucket is kept until it is refilled
+	*now = now.Add(5 * time.Minute)
+	assert.True(t, r.AllowConnection(newRateLimitSession("198.51.100.1")))
+	assert.Len(t, r.connections[0].buckets, 2)
+
+	*now = now.Add(10 * time.Minute)
+	assert.True(t, r.AllowConnection(newRateLimitSession("203.0.113.1")))
+	assert.Len(t, r.connections[0].buckets, 1)
+}
+
+func TestRateLimitService_Disabled(t *testing.T) {
+	r, _ := newTestRateLimitService(&config.RateLimitConfig{
+		Connections: config.RateLimitRule{
+			// window is required
+			PerIp: config.RateLimit{Limit: 1},
+		},
+	})
+
+	s := newRateLimitSession("192.0.2.1")
+	for i := 0; i < 10; i++ {
+		assert.True(t, r.AllowConnection(s))
+	}
+	assert.Empty(t, r.connections)
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"strings"
+
+	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+)
+
+const defaultMaxAliasDepth = 10
+
+var errAliasLoop = errors.New("alias loop detected")
+
+type RecipientService interface {
+	// Resolve decides whether the recipient is accepted and expands it by aliases and virtual maps.
+	// Authenticated clients may relay to other domains.
+	Resolve(ctx context.Context, address mail.Address, authenticated bool) (*data.RecipientResult, error)
+	// IsLocalDomain reports whether this server accepts mail for the domain, virtual domains included.
+	IsLocalDomain(domain string) bool
+}
+
+type recipientServiceImpl struct {
+	log            hlog.Logger
+	localDomains   map[string]struct{}
+	virtualDomains map[string]struct{}
+	aliases        aliasMap
+	virtual        aliasMap
+	delimiter      string
+	maxDepth       int
+	source         MailboxSource
+	srs            SrsService
+}
+
+func (s *recipientServiceImpl) Resolve(ctx context.Context, address mail.Address, authenticated bool) (*data.RecipientResult, error) {
+	// mail for other domains is relayed only for authenticated clients, "Postmaster" without domain is local
+	// https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-5-1--Minimum-Implementation
+	if _, domain := splitAddress(address.Address); len(domain) > 0 && !s.IsLocalDomain(domain) {
+		if !authenticated {
+			return &data.RecipientResult{Status: data.RecipientRelayDenied}, nil
+		}
+		return &data.RecipientResult{
+			Status:    data.RecipientAccepted,
+			Addresses: []mail.Address{address},
+		}, nil
+	}
+
+	// bounce to the address rewritten by SRS is returned to the original sender
+	if s.srs.IsSrsAddress(address) {
+		original, err := s.srs.Reverse(address)
+		if err != nil {
+			s.log.WithError(err).Warnf("failed to reverse SRS address %s", address.Address)
+			return &data.RecipientResult{Status: data.RecipientUnknownUser}, nil
+		}
+		return &data.RecipientResult{
+			Status:    data.RecipientAccepted,
+			Addresses: []mail.Address{original},
+		}, nil
+	}
+
+	res := &data.RecipientResult{
+		Addresses: make([]mail.Address, 0),
+	}
+	if err := s.expand(ctx, address.Address, nil, res); err != nil {
+		if errors.Is(err, errAliasLoop) {
+			s.log.WithError(err).Warnf("failed to expand %s", address.Address)
+			return &data.RecipientResult{Status: data.RecipientLoop}, nil
+		}
+		return nil, err
+	}
+	if len(res.Addresses) == 0 {
+		return &data.RecipientResult{Status: data.RecipientUnknownUser}, nil
+	}
+	res.Status = data.RecipientAccepted
+	return res, nil
+}
+
+// expand resolves the address recursively, path has the aliases which lead to the address.
+func (s *recipientServiceImpl) expand(ctx context.Context, address string, path []string, res *data.RecipientResult) error {
+	key := strings.ToLower(address)
+	for _, p := range path {
+		if p == key {
+			return errAliasLoop
+		}
+	}
+	if len(path) > s.maxDepth {
+		return errAliasLoop
+	}
+
+	localPart, domain := splitAddress(address)
+	// forward to other domain
+	if len(domain) > 0 && !s.IsLocalDomain(domain) {
+		addAddress(res, address)
+		res.Forwarded = true
+		return nil
+	}
+
+	targets, ok := s.lookup(localPart, domain)
+	if !ok {
+		exists, err := s.mailboxExists(ctx, localPart, domain)
+		if err != nil {
+			return err
+		}
+		if exists {
+			addAddress(res, address)
+			return nil
+		}
+		// catch-all is used only when the mailbox does not exist
+		if targets, ok = s.virtual["@"+strings.ToLower(domain)]; !ok {
+			if len(path) > 0 {
+				s.log.Warnf("alias target %s not found", address)
+			}
+			return nil
+		}
+	}
+
+	path = append(path, key)
+	for _, target := range targets {
+		target = resolveTarget(target, localPart, domain)
+		// alias to itself delivers to the mailbox as well as the other targets
+		if strings.EqualFold(target, address) {
+			exists, err := s.mailboxExists(ctx, localPart, domain)
+			if err != nil {
+				return err
+			}
+			if exists {
+				addAddress(res, address)
+			}
+			continue
+		}
+		if err := s.expand(ctx, target, path, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup finds the targets in the virtual map and aliases, the subaddress is tried after the full local part.
+func (s *recipientServiceImpl) lookup(localPart, domain string) ([]string, bool) {
+	localParts := []string{strings.ToLower(localPart)}
+	if base := s.baseLocalPart(localPart); base != localPart {
+		localParts = append(localParts, strings.ToLower(base))
+	}
+
+	for _, lp := range localParts {
+		if targets, ok := s.virtual[lp+"@"+strings.ToLower(domain)]; ok {
+			return targets, true
+		}
+	}
+	if s.isVirtualDomain(domain) {
+		return nil, false
+	}
+	for _, lp := range localParts {
+		if targets, ok := s.aliases[lp]; ok {
+			return targets, true
+		}
+	}
+	return nil, false
+}
+
+func (s *recipientServiceImpl) mailboxExists(ctx context.Context, localPart, domain string) (bool, error) {
+	// postmaster of every local domain must be available
+	if len(domain) == 0 || strings.EqualFold(localPart, "postmaster") {
+		return true, nil
+	}
+	// virtual domains have no mailboxes
+	if s.isVirtualDomain(domain) {
+		return false, nil
+	}
+
+	ok, err := s.source.Exists(ctx, localPart, domain)
+	if err != nil || ok {
+		return ok, err
+	}
+	if base := s.baseLocalPart(localPart); base != localPart {
+		return s.source.Exists(ctx, base, domain)
+	}
+	return false, nil
+}
+
+// baseLocalPart removes the subaddress, "user+tag" becomes "user".
+func (s *recipientServiceImpl) baseLocalPart(localPart string) string {
+	if len(s.delimiter) == 0 {
+		return localPart
+	}
+	if idx := strings.Index(localPart, s.delimiter); idx > 0 {
+		return localPart[:idx]
+	}
+	return localPart
+}
+
+func (s *recipientServiceImpl) IsLocalDomain(domain string) bool {
+	_, ok := s.localDomains[strings.ToLower(domain)]
+	return ok || s.isVirtualDomain(domain)
+}
+
+func (s *recipientServiceImpl) isVirtualDomain(domain string) bool {
+	_, ok := s.virtualDomains[strings.ToLower(domain)]
+	return ok
+}
+
+// resolveTarget completes the alias target, "@domain" keeps the local part and "name" keeps the domain.
+func resolveTarget(target, localPart, domain string) string {
+	if strings.HasPrefix(target, "@") {
+		return localPart + target
+	}
+	if !strings.Contains(target, "@") && len(domain) > 0 {
+		return target + "@" + domain
+	}
+	return target
+}
+
+func splitAddress(address string) (string, string) {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return address, ""
+	}
+	return address[:at], address[at+1:]
+}
+
+func addAddress(res *data.RecipientResult, address string) {
+	for _, a := range res.Addresses {
+		if strings.EqualFold(a.Address, address) {
+			return
+		}
+	}
+	res.Addresses = append(res.Addresses, mail.Address{Address: address})
+}
+
+func NewRecipientService(log hlog.Logger, conf *config.RecipientConfig, source MailboxSource, srs SrsService) (RecipientService, error) {
+	s := &recipientServiceImpl{
+		log:            log,
+		localDomains:   make(map[string]struct{}),
+		virtualDomains: make(map[string]struct{}),
+		aliases:        make(aliasMap),
+		virtual:        make(aliasMap),
+		delimiter:      conf.RecipientDelimiter,
+		maxDepth:       conf.MaxAliasDepth,
+		source:         source,
+		srs:            srs,
+	}
+	if s.maxDepth <= 0 {
+		s.maxDepth = defaultMaxAliasDepth
+	}
+	for _, domain := range conf.LocalDomains {
+		s.localDomains[strings.ToLower(domain)] = struct{}{}
+	}
+	for _, domain := range conf.VirtualDomains {
+		s.virtualDomains[strings.ToLower(domain)] = struct{}{}
+	}
+
+	var err error
+	if len(conf.AliasFilePath) > 0 {
+		if s.aliases, err = loadAliasFile(conf.AliasFilePath); err != nil {
+			return nil, err
+		}
+	}
+	if len(conf.VirtualFilePath) > 0 {
+		if s.virtual, err = loadVirtualFile(conf.VirtualFilePath); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
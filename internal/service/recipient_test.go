@@ -0,0 +1,375 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecipientService_Resolve(t *testing.T) {
+	conf := &config.RecipientConfig{
+		LocalDomains: []string{"Example.com", "example.net"},
+	}
+	source := MailboxSourceFunc(func(ctx context.Context, localPart, domain string) (bool, error) {
+		switch mailboxKey(localPart, domain) {
+		case "user@example.com", "user@example.net":
+			return true, nil
+		case "error@example.com":
+			return false, errors.New("test error")
+		}
+		return false, nil
+	})
+
+	tests := []struct {
+		name          string
+		address       string
+		authenticated bool
+		expect        data.RecipientStatus
+		expectErr     bool
+	}{
+		{
+			name:    "local user",
+			address: "user@example.com",
+			expect:  data.RecipientAccepted,
+		},
+		{
+			name:    "case insensitive domain",
+			address: "User@EXAMPLE.NET",
+			expect:  data.RecipientAccepted,
+		},
+		{
+			name:    "unknown user",
+			address: "unknown@example.com",
+			expect:  data.RecipientUnknownUser,
+		},
+		{
+			name:    "postmaster without domain",
+			address: "Postmaster",
+			expect:  data.RecipientAccepted,
+		},
+		{
+			name:    "postmaster of local domain",
+			address: "postmaster@example.com",
+			expect:  data.RecipientAccepted,
+		},
+		{
+			name:    "relay denied",
+			address: "user@example.org",
+			expect:  data.RecipientRelayDenied,
+		},
+		{
+			name:          "relay by authenticated client",
+			address:       "user@example.org",
+			authenticated: true,
+			expect:        data.RecipientAccepted,
+		},
+		{
+			name:          "unknown local user of authenticated client",
+			address:       "unknown@example.com",
+			authenticated: true,
+			expect:        data.RecipientUnknownUser,
+		},
+		{
+			name:      "lookup error",
+			address:   "error@example.com",
+			expect:    data.RecipientUnknownUser,
+			expectErr: true,
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	target, err := NewRecipientService(mock.NewInitializedMockLogger(ctrl), conf, source, disabledSrs(t))
+	assert.Nil(t, err)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := target.Resolve(context.TODO(), mail.Address{Address: test.address}, test.authenticated)
+			assert.Equal(t, test.expectErr, err != nil)
+			if err == nil {
+				assert.Equal(t, test.expect, res.Status)
+			}
+		})
+	}
+}
+
+func TestRecipientService_Expand(t *testing.T) {
+	dir := t.TempDir()
+	aliasPath := filepath.Join(dir, "aliases")
+	aliases := `# sendmail-style aliases
+postmaster: admin
+staff: alice,
+	bob, carol@example.org
+team: staff, alice
+self: self, forward@example.org
+loop1: loop2
+loop2: loop1
+broken: nobody
+`
+	assert.Nil(t, os.WriteFile(aliasPath, []byte(aliases), 0600))
+
+	virtualPath := filepath.Join(dir, "virtual")
+	virtual := `info@virtual.example    alice@example.com
+sales@virtual.example   staff@example.com, dave@example.org
+@virtual.example        @example.com
+@example.com            catchall@example.com
+`
+	assert.Nil(t, os.WriteFile(virtualPath, []byte(virtual), 0600))
+
+	conf := &config.RecipientConfig{
+		LocalDomains:       []string{"example.com"},
+		VirtualDomains:     []string{"virtual.example"},
+		AliasFilePath:      aliasPath,
+		VirtualFilePath:    virtualPath,
+		RecipientDelimiter: "+",
+		MaxAliasDepth:      5,
+	}
+	source := MailboxSourceFunc(func(ctx context.Context, localPart, domain string) (bool, error) {
+		switch localPart {
+		case "alice", "bob", "admin", "self", "catchall":
+			return true, nil
+		}
+		return false, nil
+	})
+
+	tests := []struct {
+		name    string
+		address string
+		status  data.RecipientStatus
+		expect  []string
+	}{
+		{
+			name:    "mailbox",
+			address: "alice@example.com",
+			status:  data.RecipientAccepted,
+			expect:  []string{"alice@example.com"},
+		},
+		{
+			name:    "subaddress",
+			address: "alice+news@example.com",
+			status:  data.RecipientAccepted,
+			expect:  []string{"alice+news@example.com"},
+		},
+		{
+			name:    "alias with continuation line",
+			address: "staff@example.com",
+			status:  data.RecipientAccepted,
+			expect:  []string{"alice@example.com", "bob@example.com", "carol@example.org"},
+		},
+		{
+			name:    "nested alias without duplication",
+			address: "team@example.com",
+			status:  data.RecipientAccepted,
+			expect:  []string{"alice@example.com", "bob@example.com", "carol@example.org"},
+		},
+		{
+			name:    "alias of subaddress",
+			address: "staff+tag@example.com",
+			status:  data.RecipientAccepted,
+			expect:  []string{"alice@example.com", "bob@example.com", "carol@example.org"},
+		},
+		{
+			name:    "alias to itself",
+			address: "self@example.com",
+			status:  data.RecipientAccepted,
+			expect:  []string{"self@example.com", "forward@example.org"},
+		},
+		{
+			name:    "postmaster alias",
+			address: "Postmaster",
+			status:  data.RecipientAccepted,
+			expect:  []string{"admin"},
+		},
+		{
+			name:    "virtual address",
+			address: "info@virtual.example",
+			status:  data.RecipientAccepted,
+			expect:  []string{"alice@example.com"},
+		},
+		{
+			name:    "virtual address to alias and forward",
+			address: "sales@virtual.example",
+			status:  data.RecipientAccepted,
+			expect:  []string{"alice@example.com", "bob@example.com", "carol@example.org", "dave@example.org"},
+		},
+		{
+			name:    "virtual domain map",
+			address: "bob@virtual.example",
+			status:  data.RecipientAccepted,
+			expect:  []string{"bob@example.com"},
+		},
+		{
+			name:    "catch-all",
+			address: "unknown@example.com",
+			status:  data.RecipientAccepted,
+			expect:  []string{"catchall@example.com"},
+		},
+		{
+			name:    "virtual domain map to catch-all",
+			address: "unknown@virtual.example",
+			status:  data.RecipientAccepted,
+			expect:  []string{"catchall@example.com"},
+		},
+		{
+			name:    "alias loop",
+			address: "loop1@example.com",
+			status:  data.RecipientLoop,
+		},
+		{
+			name:    "relay denied",
+			address: "alice@example.org",
+			status:  data.RecipientRelayDenied,
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	target, err := NewRecipientService(mock.NewInitializedMockLogger(ctrl), conf, source, disabledSrs(t))
+	assert.Nil(t, err)
+	assert.True(t, target.IsLocalDomain("VIRTUAL.example"))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := target.Resolve(context.TODO(), mail.Address{Address: test.address}, false)
+			assert.Nil(t, err)
+			assert.Equal(t, test.status, res.Status)
+
+			addresses := make([]string, 0)
+			for _, address := range res.Addresses {
+				addresses = append(addresses, address.Address)
+			}
+			if test.expect == nil {
+				test.expect = []string{}
+			}
+			assert.Equal(t, test.expect, addresses)
+		})
+	}
+}
+
+func TestRecipientService_NoCatchAll(t *testing.T) {
+	dir := t.TempDir()
+	aliasPath := filepath.Join(dir, "aliases")
+	assert.Nil(t, os.WriteFile(aliasPath, []byte("broken: nobody\ndeep1: deep2\ndeep2: deep3\ndeep3: alice\n"), 0600))
+
+	conf := &config.RecipientConfig{
+		LocalDomains:  []string{"example.com"},
+		AliasFilePath: aliasPath,
+		MaxAliasDepth: 2,
+	}
+	source := MailboxSourceFunc(func(ctx context.Context, localPart, domain string) (bool, error) {
+		return localPart == "alice", nil
+	})
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	target, err := NewRecipientService(mock.NewInitializedMockLogger(ctrl), conf, source, disabledSrs(t))
+	assert.Nil(t, err)
+
+	// alias to unknown mailbox
+	res, err := target.Resolve(context.TODO(), mail.Address{Address: "broken@example.com"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, data.RecipientUnknownUser, res.Status)
+
+	// subaddressing disabled
+	res, err = target.Resolve(context.TODO(), mail.Address{Address: "alice+tag@example.com"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, data.RecipientUnknownUser, res.Status)
+
+	// too deep nesting
+	res, err = target.Resolve(context.TODO(), mail.Address{Address: "deep1@example.com"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, data.RecipientLoop, res.Status)
+}
+
+func TestNewRecipientService_Err(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aliases")
+	assert.Nil(t, os.WriteFile(path, []byte("no-separator\n"), 0600))
+
+	_, err := NewRecipientService(nil, &config.RecipientConfig{AliasFilePath: path}, nil, disabledSrs(t))
+	assert.NotNil(t, err)
+
+	_, err = NewRecipientService(nil, &config.RecipientConfig{VirtualFilePath: filepath.Join(t.TempDir(), "not-found")}, nil, disabledSrs(t))
+	assert.NotNil(t, err)
+}
+
+func disabledSrs(t *testing.T) SrsService {
+	srs, err := NewSrsService(&config.SrsConfig{})
+	assert.Nil(t, err)
+	return srs
+}
+
+func TestRecipientService_Srs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	srs, err := NewSrsService(&config.SrsConfig{
+		Enable:  true,
+		Domain:  "example.com",
+		Secrets: []string{"secret"},
+	})
+	assert.Nil(t, err)
+
+	conf := &config.RecipientConfig{
+		LocalDomains: []string{"example.com"},
+	}
+	source := MailboxSourceFunc(func(ctx context.Context, localPart, domain string) (bool, error) {
+		return false, nil
+	})
+	target, err := NewRecipientService(mock.NewInitializedMockLogger(ctrl), conf, source, srs)
+	assert.Nil(t, err)
+
+	rewritten, err := srs.Forward(mail.Address{Address: "user@example.org"})
+	assert.Nil(t, err)
+
+	// bounce is returned to the original sender even for unauthenticated clients
+	res, err := target.Resolve(context.TODO(), rewritten, false)
+	assert.Nil(t, err)
+	assert.Equal(t, data.RecipientAccepted, res.Status)
+	assert.Equal(t, []mail.Address{{Address: "user@example.org"}}, res.Addresses)
+
+	res, err = target.Resolve(context.TODO(), mail.Address{Address: "SRS0=AAAA=AA=example.org=user@example.com"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, data.RecipientUnknownUser, res.Status)
+}
+
+func TestRecipientService_Forwarded(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	path := filepath.Join(t.TempDir(), "aliases")
+	assert.Nil(t, os.WriteFile(path, []byte("fwd: user@example.org\n"), 0600))
+
+	conf := &config.RecipientConfig{
+		LocalDomains:  []string{"example.com"},
+		AliasFilePath: path,
+	}
+	source := MailboxSourceFunc(func(ctx context.Context, localPart, domain string) (bool, error) {
+		return localPart == "local", nil
+	})
+	target, err := NewRecipientService(mock.NewInitializedMockLogger(ctrl), conf, source, disabledSrs(t))
+	assert.Nil(t, err)
+
+	res, err := target.Resolve(context.TODO(), mail.Address{Address: "fwd@example.com"}, false)
+	assert.Nil(t, err)
+	assert.True(t, res.Forwarded)
+
+	res, err = target.Resolve(context.TODO(), mail.Address{Address: "local@example.com"}, false)
+	assert.Nil(t, err)
+	assert.False(t, res.Forwarded)
+
+	// relay by authenticated client is not forwarding
+	res, err = target.Resolve(context.TODO(), mail.Address{Address: "user@example.org"}, true)
+	assert.Nil(t, err)
+	assert.False(t, res.Forwarded)
+}
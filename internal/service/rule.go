@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"net/mail"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/rule"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+// RuleService evaluates the content rules in the YAML file after DATA.
+type RuleService interface {
+	// Evaluate applies the rules to the message s.RawData. Headers are added to s.RawData, s.EnvelopeTo is
+	// replaced by redirection and s.Quarantine and s.Discard are set by the matched rules.
+	Evaluate(ctx context.Context, s *session.Session) *data.RuleResult
+}
+
+type ruleServiceImpl struct {
+	log            hlog.Logger
+	enable         bool
+	filePath       string
+	reloadInterval time.Duration
+	now            func() time.Time
+
+	mu        sync.Mutex
+	rules     *rule.RuleSet
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+}
+
+func (r *ruleServiceImpl) Evaluate(ctx context.Context, s *session.Session) *data.RuleResult {
+	if !r.enable || s.Discard {
+		return &data.RuleResult{}
+	}
+
+	res := r.ruleSet().Evaluate(ruleMessage(s))
+	if len(res.Matched) == 0 {
+		return &data.RuleResult{}
+	}
+	r.log.Infof("[%s] rules %s matched, score %.1f.", s.Id, strings.Join(res.Matched, ","), res.Score)
+
+	result := &data.RuleResult{Rules: res.Matched, Score: res.Score}
+	if res.Reject != nil {
+		// the default text is used when the rule has no message
+		reply := session.NewReply(res.Reject.Code, res.Reject.EnhancedCode())
+		if len(res.Reject.Message) > 0 {
+			reply.Lines = []string{res.Reject.Message}
+		}
+		result.Action = data.RuleReject
+		result.Reply = &reply
+		return result
+	}
+
+	if len(res.Headers) > 0 {
+		s.RawData = data.PrependHeaders(s.RawData, res.Headers...)
+	}
+	if len(res.Redirect) > 0 {
+		r.log.Infof("[%s] message is redirected to %s.", s.Id, strings.Join(res.Redirect, ","))
+		s.EnvelopeTo = make([]mail.Address, 0, len(res.Redirect))
+		for _, address := range res.Redirect {
+			s.AddEnvelopeTo(mail.Address{Address: address})
+		}
+	}
+	s.Quarantine = s.Quarantine || res.Quarantine
+	s.Discard = s.Discard || res.Discard
+	return result
+}
+
+func ruleMessage(s *session.Session) *rule.Message {
+	msg := &rule.Message{
+		Helo: s.SenderDomain,
+		Raw:  s.RawData,
+	}
+	if s.EnvelopeFrom != nil {
+		msg.From = s.EnvelopeFrom.Address
+	}
+	for _, to := range s.EnvelopeTo {
+		msg.To = append(msg.To, to.Address)
+	}
+	return msg
+}
+
+// ruleSet returns the current rules, reloading the file when it is changed.
+// The previous rules are kept when the file can not be loaded.
+func (r *ruleServiceImpl) ruleSet() *rule.RuleSet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.checkedAt) < r.reloadInterval {
+		return r.rules
+	}
+	r.checkedAt = now
+
+	info, err := os.Stat(r.filePath)
+	if err != nil {
+		r.log.WithError(err).Errorf("failed to check rule file %s.", r.filePath)
+		return r.rules
+	}
+	if info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return r.rules
+	}
+
+	rules, err := rule.Load(r.filePath)
+	if err != nil {
+		r.log.WithError(err).Errorf("failed to reload rule file, previous rules are kept.")
+		return r.rules
+	}
+	r.log.Infof("rule file %s is reloaded.", r.filePath)
+	r.rules, r.modTime, r.size = rules, info.ModTime(), info.Size()
+	return r.rules
+}
+
+func NewRuleService(log hlog.Logger, conf *config.RuleConfig) (RuleService, error) {
+	impl := &ruleServiceImpl{
+		log:            log,
+		enable:         conf.Enable,
+		filePath:       conf.FilePath,
+		reloadInterval: conf.ReloadInterval,
+		now:            time.Now,
+	}
+	if !conf.Enable {
+		return impl, nil
+	}
+
+	// invalid rules at startup are an error
+	info, err := os.Stat(conf.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := rule.Load(conf.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	impl.rules, impl.modTime, impl.size = rules, info.ModTime(), info.Size()
+	impl.checkedAt = impl.now()
+	return impl, nil
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/mock"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeRuleFile(t *testing.T, path, content string, modTime time.Time) {
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o644))
+	// the modification time is set explicitly because the file may be rewritten within the timestamp resolution
+	assert.Nil(t, os.Chtimes(path, modTime, modTime))
+}
+
+func newTestRuleService(t *testing.T, content string) (*ruleServiceImpl, string) {
+	ctrl := gomock.NewController(t)
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRuleFile(t, path, content, time.Unix(1000, 0))
+
+	r, err := NewRuleService(mock.NewInitializedMockLogger(ctrl), &config.RuleConfig{Enable: true, FilePath: path, ReloadInterval: 10 * time.Second})
+	assert.Nil(t, err)
+	return r.(*ruleServiceImpl), path
+}
+
+func TestRuleService_Evaluate(t *testing.T) {
+	tests := []struct {
+		name       string
+		rules      string
+		expected   *data.RuleResult
+		rawData    string
+		to         []mail.Address
+		quarantine bool
+		discard    bool
+	}{
+		{
+			name:     "no rule matched",
+			rules:    "rules: [{match: {from: '@example\\.net$'}, actions: [{discard: true}]}]",
+			expected: &data.RuleResult{},
+			rawData:  "Subject: test\n\nbody\n",
+			to:       []mail.Address{{Address: "to@example.com"}},
+		},
+		{
+			name:  "reject with custom reply",
+			rules: "rules: [{name: test, match: {headers: [{name: Subject, pattern: test}]}, actions: [{reject: {code: 451, enhanced: '4.7.1', message: 'later'}}]}]",
+			expected: &data.RuleResult{
+				Action: data.RuleReject,
+				Reply:  &session.Reply{Code: 451, EnhancedCode: session.EnhancedCode{4, 7, 1}, Lines: []string{"later"}},
+				Rules:  []string{"test"},
+			},
+			rawData: "Subject: test\n\nbody\n",
+			to:      []mail.Address{{Address: "to@example.com"}},
+		},
+		{
+			name:  "reject by default reply",
+			rules: "rules: [{name: test, actions: [{reject: {}}]}]",
+			expected: &data.RuleResult{
+				Action: data.RuleReject,
+				Reply:  &session.Reply{Code: 550, EnhancedCode: session.EnhancedCode{5, 7, 1}},
+				Rules:  []string{"test"},
+			},
+			rawData: "Subject: test\n\nbody\n",
+			to:      []mail.Address{{Address: "to@example.com"}},
+		},
+		{
+			name: "modifications",
+			rules: `
+rules:
+  - name: body
+    match: {body: body}
+    actions:
+      - addHeader: "X-Rule: body"
+      - redirect: review@example.com
+      - quarantine: true
+        score: 2
+  - name: from
+    match: {from: "^from@"}
+    actions: [{discard: true}]
+`,
+			expected:   &data.RuleResult{Rules: []string{"body", "from"}, Score: 2},
+			rawData:    "X-Rule: body\nSubject: test\n\nbody\n",
+			to:         []mail.Address{{Address: "review@example.com"}},
+			quarantine: true,
+			discard:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, _ := newTestRuleService(t, test.rules)
+			s := newSpamSession()
+
+			assert.Equal(t, test.expected, r.Evaluate(context.Background(), s))
+			assert.Equal(t, test.rawData, string(s.RawData))
+			assert.Equal(t, test.to, s.EnvelopeTo)
+			assert.Equal(t, test.quarantine, s.Quarantine)
+			assert.Equal(t, test.discard, s.Discard)
+		})
+	}
+}
+
+func TestRuleService_Disabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	// the rule file is not read when disabled
+	r, err := NewRuleService(mock.NewInitializedMockLogger(ctrl), &config.RuleConfig{FilePath: "missing.yaml"})
+	assert.Nil(t, err)
+
+	s := newSpamSession()
+	assert.Equal(t, &data.RuleResult{}, r.Evaluate(context.Background(), s))
+
+	_, err = NewRuleService(mock.NewInitializedMockLogger(ctrl), &config.RuleConfig{Enable: true, FilePath: "missing.yaml"})
+	assert.NotNil(t, err)
+}
+
+func TestRuleService_Reload(t *testing.T) {
+	r, path := newTestRuleService(t, "rules: [{name: first, actions: [{score: 1}]}]")
+	now := time.Unix(2000, 0)
+	r.now = func() time.Time { return now }
+	r.checkedAt = now
+	evaluate := func() []string {
+		return r.Evaluate(context.Background(), newSpamSession()).Rules
+	}
+
+	assert.Equal(t, []string{"first"}, evaluate())
+
+	// the change is not noticed until the interval has passed
+	writeRuleFile(t, path, "rules: [{name: second, actions: [{score: 1}]}]", time.Unix(1001, 0))
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, []string{"first"}, evaluate())
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, []string{"second"}, evaluate())
+
+	// invalid rules are not loaded
+	writeRuleFile(t, path, "rules: [{match: {body: '('}, actions: [{score: 1}]}]", time.Unix(1003, 0))
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, []string{"second"}, evaluate())
+
+	// removed file keeps the rules
+	assert.Nil(t, os.Remove(path))
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, []string{"second"}, evaluate())
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/session"
+)
+
+const defaultRelayTimeout = 30 * time.Second
+
+var errNoRelayHost = errors.New("relay host is not configured")
+
+// MailSender submits messages which this server generates, such as redirects and auto-replies, to the relay host.
+type MailSender interface {
+	// Send transfers the message, from is empty for the null sender. body is the body type of the message, which
+	// is received by BODY parameter of MAIL for relayed messages.
+	Send(ctx context.Context, from string, to []string, msg []byte, body session.BodyType) error
+	// Hostname is the name of this server used in generated messages.
+	Hostname() string
+}
+
+type mailSenderImpl struct {
+	relayHost string
+	hostname  string
+	timeout   time.Duration
+	// 8-bit content is converted when the relay host does not advertise 8BITMIME, it is not sent otherwise
+	downgrade bool
+}
+
+func (m *mailSenderImpl) Hostname() string {
+	return m.hostname
+}
+
+func (m *mailSenderImpl) Send(ctx context.Context, from string, to []string, msg []byte, body session.BodyType) error {
+	if len(m.relayHost) == 0 {
+		return errNoRelayHost
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.relayHost)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	host, _, _ := net.SplitHostPort(m.relayHost)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Hello(m.hostname); err != nil {
+		return err
+	}
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	content := &data.MimeData{RawData: msg, BodyType: body}
+	// the message is always sent by DATA, which cannot carry binary content even if the relay host supports
+	// BINARYMIME and CHUNKING
+	if err := content.PrepareForDataTransport(); err != nil {
+		return fmt.Errorf("binary content cannot be converted: %w", err)
+	}
+	// https://tex2e.github.io/rfc-translater/html/rfc6152.html#3--The-8bit-MIMEtransport-service-extension
+	if ok, _ := c.Extension("8BITMIME"); !ok {
+		if err := content.PrepareFor7BitTransport(m.downgrade); err != nil {
+			return fmt.Errorf("relay host does not support 8BITMIME: %w", err)
+		}
+	}
+	msg = content.RawData
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	// the writer converts LF to CRLF
+	if _, err := w.Write(bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func NewMailSender(conf *config.DeliveryConfig, smtpConf *config.SmtpConfig) MailSender {
+	hostname := conf.Hostname
+	if len(hostname) == 0 {
+		if name, err := os.Hostname(); err == nil {
+			hostname = name
+		} else {
+			hostname = "localhost"
+		}
+	}
+	timeout := conf.RelayTimeout
+	if timeout <= 0 {
+		timeout = defaultRelayTimeout
+	}
+	return &mailSenderImpl{
+		relayHost: conf.RelayHost,
+		hostname:  hostname,
+		timeout:   timeout,
+		downgrade: smtpConf.Downgrade8BitMime,
+	}
+}
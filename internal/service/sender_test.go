@@ -0,0 +1,125 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubRelay accepts a session and records the commands and the message.
+type stubRelay struct {
+	listener net.Listener
+	commands chan string
+	data     chan string
+}
+
+func newStubRelay(t *testing.T, rcptReply string, extensions ...string) *stubRelay {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	r := &stubRelay{listener: listener, commands: make(chan string, 10), data: make(chan string, 1)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("220 relay.example.com ESMTP\r\n"))
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			r.commands <- line
+			switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+			case "EHLO":
+				reply := "250-relay.example.com\r\n"
+				for _, extension := range extensions {
+					reply += "250-" + extension + "\r\n"
+				}
+				conn.Write([]byte(reply + "250 HELP\r\n"))
+			case "RCPT":
+				conn.Write([]byte(rcptReply + "\r\n"))
+			case "DATA":
+				conn.Write([]byte("354 go ahead\r\n"))
+				var sb strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					sb.WriteString(line)
+				}
+				r.data <- sb.String()
+				conn.Write([]byte("250 queued\r\n"))
+			case "QUIT":
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+	}()
+	return r
+}
+
+func TestMailSender_Send(t *testing.T) {
+	relay := newStubRelay(t, "250 ok", "8BITMIME")
+	sender := NewMailSender(&config.DeliveryConfig{Hostname: "mx.example.com", RelayHost: relay.listener.Addr().String(), RelayTimeout: time.Second}, &config.SmtpConfig{})
+
+	err := sender.Send(context.Background(), "", []string{"a@example.net", "b@example.net"}, []byte("Subject: test\n\n.line\n"), session.Body7Bit)
+	assert.Nil(t, err)
+	assert.Equal(t, "mx.example.com", sender.Hostname())
+
+	commands := make([]string, 0)
+	for i := 0; i < 6; i++ {
+		commands = append(commands, <-relay.commands)
+	}
+	assert.Equal(t, []string{"EHLO mx.example.com", "MAIL FROM:<> BODY=8BITMIME", "RCPT TO:<a@example.net>", "RCPT TO:<b@example.net>", "DATA", "QUIT"}, commands)
+	// line breaks are CRLF and leading dots are stuffed
+	assert.Equal(t, "Subject: test\r\n\r\n..line\r\n", <-relay.data)
+}
+
+func TestMailSender_Errors(t *testing.T) {
+	sender := NewMailSender(&config.DeliveryConfig{}, &config.SmtpConfig{})
+	assert.ErrorIs(t, sender.Send(context.Background(), "", []string{"a@example.net"}, []byte("Subject: test\n\n"), session.Body7Bit), errNoRelayHost)
+
+	relay := newStubRelay(t, "550 no such user", "8BITMIME")
+	sender = NewMailSender(&config.DeliveryConfig{RelayHost: relay.listener.Addr().String(), RelayTimeout: time.Second}, &config.SmtpConfig{})
+	assert.NotNil(t, sender.Send(context.Background(), "a@example.com", []string{"a@example.net"}, []byte("Subject: test\n\n"), session.Body7Bit))
+}
+
+func TestMailSender_Send_Without8BitMime(t *testing.T) {
+	msg := []byte("Subject: test\nContent-Type: text/plain; charset=utf-8\n\nテスト\n")
+
+	relay := newStubRelay(t, "250 ok")
+	sender := NewMailSender(&config.DeliveryConfig{RelayHost: relay.listener.Addr().String(), RelayTimeout: time.Second}, &config.SmtpConfig{Downgrade8BitMime: true})
+	assert.Nil(t, sender.Send(context.Background(), "a@example.com", []string{"a@example.net"}, msg, session.Body8BitMime))
+	assert.Equal(t, "Subject: test\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n=E3=83=86=E3=82=B9=E3=83=88\r\n", <-relay.data)
+
+	// the message is not sent when downgrade is disabled
+	relay = newStubRelay(t, "250 ok")
+	sender = NewMailSender(&config.DeliveryConfig{RelayHost: relay.listener.Addr().String(), RelayTimeout: time.Second}, &config.SmtpConfig{})
+	assert.ErrorIs(t, sender.Send(context.Background(), "a@example.com", []string{"a@example.net"}, msg, session.Body8BitMime), data.Err8BitContent)
+}
+
+func TestMailSender_Send_BinaryMime(t *testing.T) {
+	msg := []byte("Subject: test\r\nContent-Type: application/octet-stream\r\nContent-Transfer-Encoding: binary\r\n\r\n\x00\xff\r\n")
+
+	// binary content is converted even if the relay host supports BINARYMIME, the message is sent by DATA
+	relay := newStubRelay(t, "250 ok", "8BITMIME", "BINARYMIME", "CHUNKING")
+	sender := NewMailSender(&config.DeliveryConfig{RelayHost: relay.listener.Addr().String(), RelayTimeout: time.Second}, &config.SmtpConfig{})
+	assert.Nil(t, sender.Send(context.Background(), "a@example.com", []string{"a@example.net"}, msg, session.BodyBinaryMime))
+	assert.Equal(t, "Subject: test\r\nContent-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n\r\nAP8NCg==\r\n", <-relay.data)
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/Haya372/smtp-server/internal/spam"
+)
+
+const defaultSpamTimeout = 30 * time.Second
+
+// SpamService scores the message by a spam scanner after DATA.
+type SpamService interface {
+	// Scan submits the message s.RawData to the scanner. X-Spam headers are added to s.RawData and
+	// s.Quarantine is set by the score.
+	Scan(ctx context.Context, s *session.Session) *data.SpamResult
+}
+
+type spamServiceImpl struct {
+	log             hlog.Logger
+	enable          bool
+	scanner         spam.Scanner
+	maxSize         int
+	headerScore     float64
+	quarantineScore float64
+	rejectScore     float64
+	failOpen        bool
+}
+
+func (sp *spamServiceImpl) Scan(ctx context.Context, s *session.Session) *data.SpamResult {
+	if !sp.enable || s.Discard {
+		return &data.SpamResult{}
+	}
+	if sp.maxSize > 0 && len(s.RawData) > sp.maxSize {
+		sp.log.Debugf("[%s] message of %d bytes is too large to scan.", s.Id, len(s.RawData))
+		return &data.SpamResult{}
+	}
+
+	res, err := sp.scanner.Scan(ctx, spamRequest(s))
+	if err != nil {
+		sp.log.WithError(err).Errorf("[%s] failed to scan message by %s.", s.Id, sp.scanner)
+		if sp.failOpen {
+			return &data.SpamResult{}
+		}
+		return &data.SpamResult{Action: data.SpamTempFail}
+	}
+
+	result := &data.SpamResult{
+		Action:  sp.action(res.Score),
+		Score:   res.Score,
+		Symbols: res.Symbols,
+	}
+	sp.log.Infof("[%s] spam score %.1f (%s), action %q.", s.Id, res.Score, strings.Join(res.Symbols, ","), result.Action)
+
+	switch result.Action {
+	case data.SpamQuarantine:
+		s.Quarantine = true
+		fallthrough
+	case data.SpamTag:
+		s.RawData = data.PrependHeaders(s.RawData, spamHeaders(res)...)
+	}
+	return result
+}
+
+func spamRequest(s *session.Session) *spam.Request {
+	req := &spam.Request{
+		Message:  s.RawData,
+		IP:       s.IP(),
+		Helo:     s.SenderDomain,
+		Hostname: s.ClientHostname,
+		QueueId:  s.Id.String(),
+		User:     s.AuthUser,
+	}
+	if s.EnvelopeFrom != nil {
+		req.From = s.EnvelopeFrom.Address
+	}
+	for _, to := range s.EnvelopeTo {
+		req.Rcpt = append(req.Rcpt, to.Address)
+	}
+	return req
+}
+
+// action maps the score to the heaviest action whose threshold is reached, thresholds of 0 are disabled.
+func (sp *spamServiceImpl) action(score float64) data.SpamAction {
+	switch {
+	case sp.rejectScore > 0 && score >= sp.rejectScore:
+		return data.SpamReject
+	case sp.quarantineScore > 0 && score >= sp.quarantineScore:
+		return data.SpamQuarantine
+	case sp.headerScore > 0 && score >= sp.headerScore:
+		return data.SpamTag
+	default:
+		return data.SpamAccept
+	}
+}
+
+// spamHeaders returns the header fields in the format of SpamAssassin.
+func spamHeaders(res *spam.Result) []string {
+	tests := "none"
+	if len(res.Symbols) > 0 {
+		tests = strings.Join(res.Symbols, ",")
+	}
+	return []string{
+		"X-Spam-Flag: YES",
+		fmt.Sprintf("X-Spam-Score: %.1f", res.Score),
+		fmt.Sprintf("X-Spam-Status: Yes, score=%.1f required=%.1f tests=%s", res.Score, res.Required, tests),
+	}
+}
+
+func newSpamScanner(conf *config.SpamConfig) (spam.Scanner, error) {
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultSpamTimeout
+	}
+
+	switch conf.Scanner {
+	case config.SpamScannerSpamd:
+		if conf.Network != "tcp" && conf.Network != "unix" {
+			return nil, fmt.Errorf("invalid network %q of spamd", conf.Network)
+		}
+		return spam.NewSpamdScanner(conf.Network, conf.Address, conf.User, timeout), nil
+	case config.SpamScannerRspamd:
+		if !strings.HasPrefix(conf.Address, "http://") && !strings.HasPrefix(conf.Address, "https://") {
+			return nil, fmt.Errorf("invalid URL %q of rspamd", conf.Address)
+		}
+		return spam.NewRspamdScanner(conf.Address, conf.Password, timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown spam scanner %q", conf.Scanner)
+	}
+}
+
+func NewSpamService(log hlog.Logger, conf *config.SpamConfig) (SpamService, error) {
+	impl := &spamServiceImpl{
+		log:             log,
+		enable:          conf.Enable,
+		maxSize:         conf.MaxSize,
+		headerScore:     conf.HeaderScore,
+		quarantineScore: conf.QuarantineScore,
+		rejectScore:     conf.RejectScore,
+		failOpen:        conf.FailOpen,
+	}
+	if !conf.Enable {
+		return impl, nil
+	}
+
+	scanner, err := newSpamScanner(conf)
+	if err != nil {
+		return nil, err
+	}
+	impl.scanner = scanner
+	return impl, nil
+}
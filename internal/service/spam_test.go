@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"testing"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/data"
+	"github.com/Haya372/smtp-server/internal/mock"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/Haya372/smtp-server/internal/spam"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeScanner struct {
+	res *spam.Result
+	err error
+	req *spam.Request
+}
+
+func (f *fakeScanner) Scan(ctx context.Context, req *spam.Request) (*spam.Result, error) {
+	f.req = req
+	return f.res, f.err
+}
+
+func (f *fakeScanner) String() string {
+	return "fake"
+}
+
+func newTestSpamService(t *testing.T, conf *config.SpamConfig, scanner spam.Scanner) SpamService {
+	ctrl := gomock.NewController(t)
+	sp, err := NewSpamService(mock.NewInitializedMockLogger(ctrl), conf)
+	assert.Nil(t, err)
+	sp.(*spamServiceImpl).scanner = scanner
+	return sp
+}
+
+func newSpamSession() *session.Session {
+	s := newRateLimitSession("192.0.2.1")
+	s.Id = uuid.New()
+	s.SenderDomain = "client.example.com"
+	s.EnvelopeFrom = &mail.Address{Address: "from@example.com"}
+	s.EnvelopeTo = []mail.Address{{Address: "to@example.com"}}
+	s.RawData = []byte("Subject: test\n\nbody\n")
+	return s
+}
+
+func TestSpamService_Scan(t *testing.T) {
+	conf := &config.SpamConfig{
+		Enable:          true,
+		Scanner:         config.SpamScannerSpamd,
+		Network:         "tcp",
+		Address:         "127.0.0.1:783",
+		HeaderScore:     5,
+		QuarantineScore: 10,
+		RejectScore:     15,
+	}
+
+	tests := []struct {
+		name       string
+		score      float64
+		action     data.SpamAction
+		quarantine bool
+		rawData    string
+	}{
+		{
+			name:    "ham",
+			score:   1,
+			action:  data.SpamAccept,
+			rawData: "Subject: test\n\nbody\n",
+		},
+		{
+			name:    "tagged",
+			score:   5,
+			action:  data.SpamTag,
+			rawData: "X-Spam-Flag: YES\nX-Spam-Score: 5.0\nX-Spam-Status: Yes, score=5.0 required=5.0 tests=A,B\nSubject: test\n\nbody\n",
+		},
+		{
+			name:       "quarantined",
+			score:      12.34,
+			action:     data.SpamQuarantine,
+			quarantine: true,
+			rawData:    "X-Spam-Flag: YES\nX-Spam-Score: 12.3\nX-Spam-Status: Yes, score=12.3 required=5.0 tests=A,B\nSubject: test\n\nbody\n",
+		},
+		{
+			name:    "rejected",
+			score:   20,
+			action:  data.SpamReject,
+			rawData: "Subject: test\n\nbody\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scanner := &fakeScanner{res: &spam.Result{Score: test.score, Required: 5, Symbols: []string{"A", "B"}}}
+			sp := newTestSpamService(t, conf, scanner)
+			s := newSpamSession()
+
+			res := sp.Scan(context.Background(), s)
+			assert.Equal(t, test.action, res.Action)
+			assert.Equal(t, test.score, res.Score)
+			assert.Equal(t, test.quarantine, s.Quarantine)
+			assert.Equal(t, test.rawData, string(s.RawData))
+
+			assert.Equal(t, "192.0.2.1", scanner.req.IP.String())
+			assert.Equal(t, "client.example.com", scanner.req.Helo)
+			assert.Equal(t, "from@example.com", scanner.req.From)
+			assert.Equal(t, []string{"to@example.com"}, scanner.req.Rcpt)
+			assert.Equal(t, s.Id.String(), scanner.req.QueueId)
+		})
+	}
+}
+
+func TestSpamService_ScannerDown(t *testing.T) {
+	tests := []struct {
+		name     string
+		failOpen bool
+		action   data.SpamAction
+	}{
+		{name: "fail open", failOpen: true, action: data.SpamAccept},
+		{name: "fail closed", failOpen: false, action: data.SpamTempFail},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := &config.SpamConfig{Enable: true, Scanner: config.SpamScannerRspamd, Address: "http://127.0.0.1:11333", RejectScore: 15, FailOpen: test.failOpen}
+			sp := newTestSpamService(t, conf, &fakeScanner{err: errors.New("connection refused")})
+
+			res := sp.Scan(context.Background(), newSpamSession())
+			assert.Equal(t, test.action, res.Action)
+		})
+	}
+}
+
+func TestSpamService_NotScanned(t *testing.T) {
+	tests := []struct {
+		name  string
+		conf  *config.SpamConfig
+		setup func(s *session.Session)
+	}{
+		{
+			name: "disabled",
+			conf: &config.SpamConfig{Enable: false, RejectScore: 1},
+		},
+		{
+			name: "too large",
+			conf: &config.SpamConfig{Enable: true, Scanner: config.SpamScannerSpamd, Network: "unix", Address: "/run/spamd.sock", RejectScore: 1, MaxSize: 10},
+		},
+		{
+			name: "discarded",
+			conf: &config.SpamConfig{Enable: true, Scanner: config.SpamScannerSpamd, Network: "tcp", Address: "127.0.0.1:783", RejectScore: 1},
+			setup: func(s *session.Session) {
+				s.Discard = true
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scanner := &fakeScanner{res: &spam.Result{Score: 100}}
+			sp := newTestSpamService(t, test.conf, scanner)
+			s := newSpamSession()
+			if test.setup != nil {
+				test.setup(s)
+			}
+
+			res := sp.Scan(context.Background(), s)
+			assert.Equal(t, data.SpamAccept, res.Action)
+			assert.Nil(t, scanner.req)
+		})
+	}
+}
+
+func TestNewSpamService_InvalidConfig(t *testing.T) {
+	tests := []*config.SpamConfig{
+		{Enable: true, Scanner: "clamd", Network: "tcp", Address: "127.0.0.1:783"},
+		{Enable: true, Scanner: config.SpamScannerSpamd, Network: "udp", Address: "127.0.0.1:783"},
+		{Enable: true, Scanner: config.SpamScannerRspamd, Address: "127.0.0.1:11333"},
+	}
+
+	for _, conf := range tests {
+		_, err := NewSpamService(nil, conf)
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+)
+
+// Sender Rewriting Scheme
+// https://www.libsrs2.net/srs/srs.pdf
+const (
+	srs0Prefix       = "SRS0"
+	srs1Prefix       = "SRS1"
+	srsSeparator     = "="
+	srsHashLength    = 4
+	srsTimePrecision = 24 * time.Hour
+	// timestamp is 2 characters of base32
+	srsTimeSlots     = 1024
+	srsBase32        = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	defaultSrsMaxAge = 21
+)
+
+var (
+	ErrSrsInvalidFormat = errors.New("invalid SRS address")
+	ErrSrsInvalidHash   = errors.New("SRS hash mismatch")
+	ErrSrsExpired       = errors.New("SRS address expired")
+)
+
+type SrsService interface {
+	// Forward rewrites the envelope sender of a message forwarded to other domain.
+	Forward(sender mail.Address) (mail.Address, error)
+	// Reverse restores the address which is rewritten by Forward, it is used when a bounce comes back.
+	Reverse(address mail.Address) (mail.Address, error)
+	// IsSrsAddress reports whether the address is rewritten by SRS, false is always returned when SRS is disabled.
+	IsSrsAddress(address mail.Address) bool
+}
+
+type srsServiceImpl struct {
+	enable  bool
+	domain  string
+	secrets [][]byte
+	maxAge  int
+	now     func() time.Time
+}
+
+func (s *srsServiceImpl) Forward(sender mail.Address) (mail.Address, error) {
+	if !s.enable {
+		return sender, nil
+	}
+	localPart, domain := splitAddress(sender.Address)
+	if len(localPart) == 0 || len(domain) == 0 {
+		return sender, fmt.Errorf("%w: %s", ErrSrsInvalidFormat, sender.Address)
+	}
+
+	prefix, rest, ok := cutSrsPrefix(localPart)
+	switch {
+	case ok && prefix == srs0Prefix:
+		// forwarded again, SRS0 address of the first forwarder is kept
+		suffix := localPart[len(srs0Prefix):]
+		return s.srs1Address(domain, suffix), nil
+	case ok && prefix == srs1Prefix:
+		// the first forwarder and the opaque part are kept, only the hash is replaced
+		parts := strings.SplitN(rest, srsSeparator, 3)
+		if len(parts) == 3 && len(parts[1]) > 0 {
+			return s.srs1Address(parts[1], parts[2]), nil
+		}
+	}
+
+	timestamp := encodeSrsTimestamp(s.now())
+	hash := s.hash(s.secrets[0], timestamp, domain, localPart)
+	return mail.Address{
+		Address: strings.Join([]string{srs0Prefix, hash, timestamp, domain, localPart}, srsSeparator) + "@" + s.domain,
+	}, nil
+}
+
+// srs1Address creates `SRS1=HHH=first-forwarder==HHH=TT=domain=local@srs-domain`.
+func (s *srsServiceImpl) srs1Address(host, srs0Suffix string) mail.Address {
+	hash := s.hash(s.secrets[0], host, srs0Suffix)
+	return mail.Address{
+		Address: strings.Join([]string{srs1Prefix, hash, host, srs0Suffix}, srsSeparator) + "@" + s.domain,
+	}
+}
+
+func (s *srsServiceImpl) Reverse(address mail.Address) (mail.Address, error) {
+	localPart, _ := splitAddress(address.Address)
+	prefix, rest, ok := cutSrsPrefix(localPart)
+	if !s.enable || !ok {
+		return address, fmt.Errorf("%w: %s", ErrSrsInvalidFormat, address.Address)
+	}
+
+	if prefix == srs1Prefix {
+		// SRS1 is returned to the first forwarder as SRS0 address
+		parts := strings.SplitN(rest, srsSeparator, 3)
+		if len(parts) != 3 || len(parts[1]) == 0 || len(parts[2]) == 0 {
+			return address, fmt.Errorf("%w: %s", ErrSrsInvalidFormat, address.Address)
+		}
+		if !s.verify(parts[0], parts[1], parts[2]) {
+			return address, fmt.Errorf("%w: %s", ErrSrsInvalidHash, address.Address)
+		}
+		return mail.Address{Address: srs0Prefix + parts[2] + "@" + parts[1]}, nil
+	}
+
+	// SRS0=HHH=TT=domain=local
+	parts := strings.SplitN(rest, srsSeparator, 4)
+	if len(parts) != 4 || len(parts[2]) == 0 || len(parts[3]) == 0 {
+		return address, fmt.Errorf("%w: %s", ErrSrsInvalidFormat, address.Address)
+	}
+	hash, timestamp, domain, local := parts[0], parts[1], parts[2], parts[3]
+	if !s.verify(hash, timestamp, domain, local) {
+		return address, fmt.Errorf("%w: %s", ErrSrsInvalidHash, address.Address)
+	}
+	if err := s.checkTimestamp(timestamp); err != nil {
+		return address, fmt.Errorf("%w: %s", err, address.Address)
+	}
+	return mail.Address{Address: local + "@" + domain}, nil
+}
+
+func (s *srsServiceImpl) IsSrsAddress(address mail.Address) bool {
+	if !s.enable {
+		return false
+	}
+	localPart, _ := splitAddress(address.Address)
+	_, _, ok := cutSrsPrefix(localPart)
+	return ok
+}
+
+// hash is the first characters of base64 HMAC-SHA1, it is case-insensitive because MTAs may change the case.
+func (s *srsServiceImpl) hash(secret []byte, values ...string) string {
+	mac := hmac.New(sha1.New, secret)
+	for _, value := range values {
+		mac.Write([]byte(strings.ToLower(value)))
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))[:srsHashLength]
+}
+
+func (s *srsServiceImpl) verify(hash string, values ...string) bool {
+	for _, secret := range s.secrets {
+		if strings.EqualFold(hash, s.hash(secret, values...)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *srsServiceImpl) checkTimestamp(timestamp string) error {
+	then, ok := decodeSrsTimestamp(timestamp)
+	if !ok {
+		return ErrSrsInvalidFormat
+	}
+	today := int(s.now().Unix()/int64(srsTimePrecision/time.Second)) % srsTimeSlots
+	if (today-then+srsTimeSlots)%srsTimeSlots > s.maxAge {
+		return ErrSrsExpired
+	}
+	return nil
+}
+
+// cutSrsPrefix separates "SRS0" or "SRS1" and the rest, the separator may be "=", "+" or "-".
+func cutSrsPrefix(localPart string) (string, string, bool) {
+	if len(localPart) < 5 || !strings.ContainsRune("=+-", rune(localPart[4])) {
+		return "", "", false
+	}
+	prefix := strings.ToUpper(localPart[:4])
+	if prefix != srs0Prefix && prefix != srs1Prefix {
+		return "", "", false
+	}
+	return prefix, localPart[5:], true
+}
+
+func encodeSrsTimestamp(t time.Time) string {
+	days := int(t.Unix()/int64(srsTimePrecision/time.Second)) % srsTimeSlots
+	return string([]byte{srsBase32[days>>5], srsBase32[days&31]})
+}
+
+func decodeSrsTimestamp(timestamp string) (int, bool) {
+	if len(timestamp) != 2 {
+		return 0, false
+	}
+	high := strings.IndexByte(srsBase32, strings.ToUpper(timestamp)[0])
+	low := strings.IndexByte(srsBase32, strings.ToUpper(timestamp)[1])
+	if high < 0 || low < 0 {
+		return 0, false
+	}
+	return high<<5 | low, true
+}
+
+func NewSrsService(conf *config.SrsConfig) (SrsService, error) {
+	s := &srsServiceImpl{
+		enable: conf.Enable,
+		domain: conf.Domain,
+		maxAge: conf.MaxAgeDays,
+		now:    time.Now,
+	}
+	if !s.enable {
+		return s, nil
+	}
+
+	if len(conf.Domain) == 0 {
+		return nil, errors.New("SRS domain is not configured")
+	}
+	if len(conf.Secrets) == 0 {
+		return nil, errors.New("SRS secrets are not configured")
+	}
+	for _, secret := range conf.Secrets {
+		s.secrets = append(s.secrets, []byte(secret))
+	}
+	if s.maxAge <= 0 {
+		s.maxAge = defaultSrsMaxAge
+	}
+	return s, nil
+}
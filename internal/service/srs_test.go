@@ -0,0 +1,178 @@
+package service
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSrsService(t *testing.T, secrets []string, now time.Time) *srsServiceImpl {
+	s, err := NewSrsService(&config.SrsConfig{
+		Enable:     true,
+		Domain:     "forwarder.example",
+		Secrets:    secrets,
+		MaxAgeDays: 21,
+	})
+	assert.Nil(t, err)
+	impl := s.(*srsServiceImpl)
+	impl.now = func() time.Time { return now }
+	return impl
+}
+
+func TestSrs_ForwardAndReverse(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	target := newTestSrsService(t, []string{"secret"}, now)
+
+	sender := mail.Address{Address: "user@example.org"}
+	rewritten, err := target.Forward(sender)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(rewritten.Address, "SRS0="))
+	assert.True(t, strings.HasSuffix(rewritten.Address, "=example.org=user@forwarder.example"))
+	assert.True(t, target.IsSrsAddress(rewritten))
+
+	original, err := target.Reverse(rewritten)
+	assert.Nil(t, err)
+	assert.Equal(t, sender, original)
+
+	// MTAs may change the case of the local part
+	original, err = target.Reverse(mail.Address{Address: strings.ToLower(rewritten.Address)})
+	assert.Nil(t, err)
+	assert.Equal(t, sender, original)
+}
+
+func TestSrs_Srs1(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	first := newTestSrsService(t, []string{"first"}, now)
+	first.domain = "first.example"
+	second := newTestSrsService(t, []string{"second"}, now)
+	third := newTestSrsService(t, []string{"third"}, now)
+	third.domain = "third.example"
+
+	srs0, err := first.Forward(mail.Address{Address: "user@example.org"})
+	assert.Nil(t, err)
+
+	// the second forwarder creates SRS1 which refers to the first forwarder
+	srs1, err := second.Forward(srs0)
+	assert.Nil(t, err)
+	localPart, _ := splitAddress(srs0.Address)
+	assert.True(t, strings.HasPrefix(srs1.Address, "SRS1="))
+	assert.True(t, strings.HasSuffix(srs1.Address, "=first.example="+localPart[len("SRS0"):]+"@forwarder.example"))
+
+	// the third forwarder keeps the first forwarder
+	srs1Again, err := third.Forward(srs1)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(srs1Again.Address, "=first.example="+localPart[len("SRS0"):]+"@third.example"))
+
+	// bounce to SRS1 is returned to the first forwarder
+	reversed, err := second.Reverse(srs1)
+	assert.Nil(t, err)
+	assert.Equal(t, srs0, reversed)
+
+	reversed, err = first.Reverse(reversed)
+	assert.Nil(t, err)
+	assert.Equal(t, "user@example.org", reversed.Address)
+}
+
+func TestSrs_Reverse_Err(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	target := newTestSrsService(t, []string{"secret"}, now)
+
+	valid, err := target.Forward(mail.Address{Address: "user@example.org"})
+	assert.Nil(t, err)
+
+	localPart, _ := splitAddress(valid.Address)
+	parts := strings.SplitN(localPart, "=", 5)
+
+	tests := []struct {
+		name    string
+		address string
+		now     time.Time
+		expect  error
+	}{
+		{
+			name:    "not SRS",
+			address: "user@forwarder.example",
+			now:     now,
+			expect:  ErrSrsInvalidFormat,
+		},
+		{
+			name:    "missing fields",
+			address: "SRS0=hash=TT@forwarder.example",
+			now:     now,
+			expect:  ErrSrsInvalidFormat,
+		},
+		{
+			name:    "forged hash",
+			address: "SRS0=AAAA=" + parts[2] + "=example.org=user@forwarder.example",
+			now:     now,
+			expect:  ErrSrsInvalidHash,
+		},
+		{
+			name:    "forged address",
+			address: strings.Join([]string{parts[0], parts[1], parts[2], "example.org", "other"}, "=") + "@forwarder.example",
+			now:     now,
+			expect:  ErrSrsInvalidHash,
+		},
+		{
+			name:    "expired",
+			address: valid.Address,
+			now:     now.Add(22 * 24 * time.Hour),
+			expect:  ErrSrsExpired,
+		},
+		{
+			name:    "forged SRS1",
+			address: "SRS1=AAAA=first.example==" + strings.Join(parts[1:], "=") + "@forwarder.example",
+			now:     now,
+			expect:  ErrSrsInvalidHash,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target.now = func() time.Time { return test.now }
+			_, err := target.Reverse(mail.Address{Address: test.address})
+			assert.ErrorIs(t, err, test.expect)
+		})
+	}
+
+	// within max age
+	target.now = func() time.Time { return now.Add(21 * 24 * time.Hour) }
+	_, err = target.Reverse(valid)
+	assert.Nil(t, err)
+}
+
+func TestSrs_SecretRotation(t *testing.T) {
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	old := newTestSrsService(t, []string{"old"}, now)
+	rotated := newTestSrsService(t, []string{"new", "old"}, now)
+
+	rewritten, err := old.Forward(mail.Address{Address: "user@example.org"})
+	assert.Nil(t, err)
+
+	original, err := rotated.Reverse(rewritten)
+	assert.Nil(t, err)
+	assert.Equal(t, "user@example.org", original.Address)
+}
+
+func TestSrs_Disabled(t *testing.T) {
+	target, err := NewSrsService(&config.SrsConfig{})
+	assert.Nil(t, err)
+
+	sender := mail.Address{Address: "user@example.org"}
+	rewritten, err := target.Forward(sender)
+	assert.Nil(t, err)
+	assert.Equal(t, sender, rewritten)
+	assert.False(t, target.IsSrsAddress(mail.Address{Address: "SRS0=hash=TT=example.org=user@example.com"}))
+}
+
+func TestNewSrsService_Err(t *testing.T) {
+	_, err := NewSrsService(&config.SrsConfig{Enable: true, Secrets: []string{"secret"}})
+	assert.NotNil(t, err)
+
+	_, err = NewSrsService(&config.SrsConfig{Enable: true, Domain: "example.com"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,303 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/Haya372/smtp-server/internal/sieve"
+	"github.com/google/uuid"
+)
+
+// https://tex2e.github.io/rfc-translater/html/rfc5230.html#4-1--Days-Parameter
+const (
+	minVacationDays       = 1
+	maxVacationDays       = 30
+	defaultVacationPeriod = 7 * 24 * time.Hour
+	// interval to remove replies which are older than any period
+	vacationPurgeInterval = time.Hour
+)
+
+// headers of the original message which show that no auto-reply should be sent
+// https://tex2e.github.io/rfc-translater/html/rfc3834.html#2--When-to-Send-Automatic-Responses
+var listHeaders = []string{"List-Id", "List-Help", "List-Subscribe", "List-Unsubscribe", "List-Post", "List-Owner", "List-Archive"}
+
+// headers which the user's address must appear in
+// https://tex2e.github.io/rfc-translater/html/rfc5230.html#4-5--Address-Parameter-and-Limiting-Replies-to-Personal-Messages
+var recipientHeaders = []string{"To", "Cc", "Bcc", "Resent-To", "Resent-Cc", "Resent-Bcc"}
+
+// VacationService sends auto-replies of the mailboxes and the vacation action of Sieve.
+type VacationService interface {
+	// Respond replies to the envelope sender of the message for the recipient, unless the message must not be
+	// replied by RFC 3834 or the sender has been replied with the same handle within the days.
+	Respond(ctx context.Context, recipient string, msg *sieve.Message, v *sieve.Vacation) error
+	// AutoReply replies with the auto-reply message of the mailbox while it exists, the same sender is replied
+	// once in the configured period.
+	AutoReply(ctx context.Context, recipient, mailbox string, msg *sieve.Message) error
+}
+
+// autoReply is the content of the reply.
+type autoReply struct {
+	from    string
+	subject string
+	reason  string
+	// the reason has its own MIME headers
+	mime bool
+}
+
+type vacationServiceImpl struct {
+	log         hlog.Logger
+	enable      bool
+	messagePath string
+	period      time.Duration
+	sender      MailSender
+
+	mu         sync.Mutex
+	store      vacationStore
+	now        func() time.Time
+	lastPurged time.Time
+}
+
+func (v *vacationServiceImpl) Respond(ctx context.Context, recipient string, msg *sieve.Message, vacation *sieve.Vacation) error {
+	if !v.enable {
+		return nil
+	}
+	days := vacation.Days
+	if days < minVacationDays {
+		days = minVacationDays
+	} else if days > maxVacationDays {
+		days = maxVacationDays
+	}
+	reply := &autoReply{
+		from:    vacation.From,
+		subject: vacation.Subject,
+		reason:  vacation.Reason,
+		mime:    vacation.Mime,
+	}
+	return v.reply(ctx, recipient, vacation.Handle, time.Duration(days)*24*time.Hour, vacation.Addresses, msg, reply)
+}
+
+func (v *vacationServiceImpl) AutoReply(ctx context.Context, recipient, mailbox string, msg *sieve.Message) error {
+	if !v.enable || len(v.messagePath) == 0 {
+		return nil
+	}
+	localPart, domain := splitAddress(strings.ToLower(mailbox))
+	path := strings.NewReplacer("{domain}", safePathElement(domain), "{localpart}", safePathElement(localPart)).Replace(v.messagePath)
+	src, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return v.reply(ctx, recipient, "", v.period, []string{mailbox}, msg, parseAutoReply(src))
+}
+
+// reply sends the reply unless the sender has been replied within the period, own has the other addresses of the user.
+func (v *vacationServiceImpl) reply(ctx context.Context, recipient, handle string, period time.Duration, own []string, msg *sieve.Message, reply *autoReply) error {
+	if reason := noReplyReason(recipient, own, msg); len(reason) > 0 {
+		v.log.Debugf("auto-reply from %s to %s is not sent: %s.", recipient, msg.From, reason)
+		return nil
+	}
+
+	key := vacationKey{
+		recipient: strings.ToLower(recipient),
+		sender:    strings.ToLower(msg.From),
+		handle:    handle,
+	}
+	v.mu.Lock()
+	now := v.now()
+	if now.Sub(v.lastPurged) >= vacationPurgeInterval {
+		v.lastPurged = now
+		keep := time.Duration(maxVacationDays) * 24 * time.Hour
+		if v.period > keep {
+			keep = v.period
+		}
+		if err := v.store.purge(ctx, now.Add(-keep)); err != nil {
+			v.log.WithError(err).Warn("failed to purge auto-reply history.", nil)
+		}
+	}
+	last, err := v.store.getReplied(ctx, key)
+	if err == nil && !last.IsZero() && now.Sub(last) < period {
+		v.mu.Unlock()
+		v.log.Debugf("%s has been replied by %s within %s.", msg.From, recipient, period)
+		return nil
+	}
+	if err == nil {
+		err = v.store.putReplied(ctx, key, now)
+	}
+	v.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// the null sender prevents replies to the auto-reply
+	// https://tex2e.github.io/rfc-translater/html/rfc5230.html#5--Responding-to-Messages
+	if err := v.sender.Send(ctx, "", []string{msg.From}, vacationReply(v.sender.Hostname(), recipient, msg, reply, now), session.Body8BitMime); err != nil {
+		// the sender is replied again by the next message
+		v.mu.Lock()
+		if err := v.store.deleteReplied(ctx, key); err != nil {
+			v.log.WithError(err).Warnf("failed to forget auto-reply to %s.", msg.From)
+		}
+		v.mu.Unlock()
+		return err
+	}
+	v.log.Infof("auto-reply from %s is sent to %s.", recipient, msg.From)
+	return nil
+}
+
+// parseAutoReply reads the auto-reply message, the whole file is the text when it has no header section.
+func parseAutoReply(src []byte) *autoReply {
+	m, err := mail.ReadMessage(bytes.NewReader(src))
+	if err != nil {
+		return &autoReply{reason: string(src)}
+	}
+	body, err := io.ReadAll(m.Body)
+	if err != nil {
+		return &autoReply{reason: string(src)}
+	}
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		subject = m.Header.Get("Subject")
+	}
+	return &autoReply{
+		from:    m.Header.Get("From"),
+		subject: subject,
+		reason:  string(body),
+	}
+}
+
+// noReplyReason returns why the message must not be replied, empty when it may be replied.
+func noReplyReason(recipient string, addresses []string, msg *sieve.Message) string {
+	if len(msg.From) == 0 {
+		return "null sender"
+	}
+	localPart, _ := splitAddress(strings.ToLower(msg.From))
+	if localPart == "mailer-daemon" || localPart == "listserv" || localPart == "majordomo" ||
+		strings.HasPrefix(localPart, "owner-") || strings.HasSuffix(localPart, "-request") || strings.HasSuffix(localPart, "-owner") {
+		return "sender is a mailing list or a daemon"
+	}
+	for _, value := range msg.Header("Auto-Submitted") {
+		if !strings.EqualFold(strings.TrimSpace(value), "no") {
+			return "message is auto-submitted"
+		}
+	}
+	for _, value := range msg.Header("Precedence") {
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "bulk", "list", "junk":
+			return "message is bulk"
+		}
+	}
+	for _, name := range listHeaders {
+		if len(msg.Header(name)) > 0 {
+			return "message is from a mailing list"
+		}
+	}
+
+	own := append([]string{recipient}, addresses...)
+	for _, address := range own {
+		if strings.EqualFold(address, msg.From) {
+			return "sender is the recipient"
+		}
+	}
+	for _, name := range recipientHeaders {
+		for _, value := range msg.Header(name) {
+			for _, address := range headerAddresses(value) {
+				for _, o := range own {
+					if strings.EqualFold(address, o) {
+						return ""
+					}
+				}
+			}
+		}
+	}
+	return "recipient is not in the header"
+}
+
+// headerAddresses returns the addresses in the value, the value is returned when it is not an address list.
+func headerAddresses(value string) []string {
+	list, err := mail.ParseAddressList(value)
+	if err != nil {
+		return []string{strings.TrimSpace(value)}
+	}
+	addresses := make([]string, 0, len(list))
+	for _, a := range list {
+		addresses = append(addresses, a.Address)
+	}
+	return addresses
+}
+
+// vacationReply builds the reply of RFC 3834.
+// https://tex2e.github.io/rfc-translater/html/rfc3834.html#3--Format-of-Automatic-Responses
+func vacationReply(hostname, recipient string, msg *sieve.Message, reply *autoReply, now time.Time) []byte {
+	from := reply.from
+	if len(from) == 0 {
+		from = recipient
+	}
+	subject := reply.subject
+	if len(subject) == 0 {
+		subject = "Auto: " + strings.TrimSpace(strings.Join(msg.Header("Subject"), " "))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.From)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", uuid.New(), hostname)
+	if ids := msg.Header("Message-ID"); len(ids) > 0 {
+		id := strings.TrimSpace(ids[0])
+		fmt.Fprintf(&buf, "In-Reply-To: %s\r\n", id)
+		references := strings.TrimSpace(strings.Join(msg.Header("References"), " "))
+		fmt.Fprintf(&buf, "References: %s\r\n", strings.TrimSpace(references+" "+id))
+	}
+	buf.WriteString("Auto-Submitted: auto-replied (vacation)\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	if !reply.mime {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(reply.reason, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+func NewVacationService(log hlog.Logger, conf *config.VacationConfig, sender MailSender) (VacationService, error) {
+	v := &vacationServiceImpl{
+		log:         log,
+		enable:      conf.Enable,
+		messagePath: conf.MessagePath,
+		period:      conf.Period,
+		sender:      sender,
+		now:         time.Now,
+	}
+	if !v.enable {
+		return v, nil
+	}
+	if v.period <= 0 {
+		v.period = defaultVacationPeriod
+	}
+
+	if len(conf.DbPath) == 0 {
+		v.store = newMemoryVacationStore()
+		return v, nil
+	}
+	store, err := newSqliteVacationStore(conf.DbPath)
+	if err != nil {
+		return nil, err
+	}
+	v.store = store
+	return v, nil
+}
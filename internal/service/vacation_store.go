@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// vacationKey identifies the auto-reply to a sender, addresses are lower-cased.
+type vacationKey struct {
+	recipient string
+	sender    string
+	// handle of the Sieve vacation action, empty for the auto-reply of the mailbox
+	handle string
+}
+
+// vacationStore keeps the time when each sender has been replied, the zero time is returned for unknown keys.
+type vacationStore interface {
+	getReplied(ctx context.Context, key vacationKey) (time.Time, error)
+	putReplied(ctx context.Context, key vacationKey, replied time.Time) error
+	deleteReplied(ctx context.Context, key vacationKey) error
+	// purge removes the replies before the time.
+	purge(ctx context.Context, before time.Time) error
+}
+
+type memoryVacationStore struct {
+	replied map[vacationKey]time.Time
+}
+
+func (s *memoryVacationStore) getReplied(ctx context.Context, key vacationKey) (time.Time, error) {
+	return s.replied[key], nil
+}
+
+func (s *memoryVacationStore) putReplied(ctx context.Context, key vacationKey, replied time.Time) error {
+	s.replied[key] = replied
+	return nil
+}
+
+func (s *memoryVacationStore) deleteReplied(ctx context.Context, key vacationKey) error {
+	delete(s.replied, key)
+	return nil
+}
+
+func (s *memoryVacationStore) purge(ctx context.Context, before time.Time) error {
+	for key, replied := range s.replied {
+		if replied.Before(before) {
+			delete(s.replied, key)
+		}
+	}
+	return nil
+}
+
+func newMemoryVacationStore() vacationStore {
+	return &memoryVacationStore{
+		replied: make(map[vacationKey]time.Time),
+	}
+}
+
+const vacationSchema = `
+CREATE TABLE IF NOT EXISTS vacation_replies (
+	recipient TEXT    NOT NULL,
+	sender    TEXT    NOT NULL,
+	handle    TEXT    NOT NULL,
+	replied   INTEGER NOT NULL,
+	PRIMARY KEY (recipient, sender, handle)
+);
+`
+
+// sqliteVacationStore keeps times as unix seconds.
+type sqliteVacationStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteVacationStore) getReplied(ctx context.Context, key vacationKey) (time.Time, error) {
+	var replied int64
+	err := s.db.QueryRowContext(ctx,
+		"SELECT replied FROM vacation_replies WHERE recipient = ? AND sender = ? AND handle = ?",
+		key.recipient, key.sender, key.handle,
+	).Scan(&replied)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(replied, 0), nil
+}
+
+func (s *sqliteVacationStore) putReplied(ctx context.Context, key vacationKey, replied time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO vacation_replies (recipient, sender, handle, replied) VALUES (?, ?, ?, ?)",
+		key.recipient, key.sender, key.handle, replied.Unix(),
+	)
+	return err
+}
+
+func (s *sqliteVacationStore) deleteReplied(ctx context.Context, key vacationKey) error {
+	_, err := s.db.ExecContext(ctx,
+		"DELETE FROM vacation_replies WHERE recipient = ? AND sender = ? AND handle = ?",
+		key.recipient, key.sender, key.handle,
+	)
+	return err
+}
+
+func (s *sqliteVacationStore) purge(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM vacation_replies WHERE replied < ?", before.Unix())
+	return err
+}
+
+// newSqliteVacationStore opens the database, the table is created when it does not exist.
+func newSqliteVacationStore(path string) (vacationStore, error) {
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows only one writer
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(vacationSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteVacationStore{
+		db: db,
+	}, nil
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/Haya372/smtp-server/internal/mock"
+	"github.com/Haya372/smtp-server/internal/session"
+	"github.com/Haya372/smtp-server/internal/sieve"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newVacationMessage(from, headers string) *sieve.Message {
+	return &sieve.Message{
+		From: from,
+		To:   "bob@example.com",
+		Raw:  []byte("From: " + from + "\nTo: Bob <bob@example.com>\nSubject: Hello\nMessage-ID: <1@example.net>\n" + headers + "\nbody\n"),
+	}
+}
+
+func newTestVacationService(t *testing.T, conf *config.VacationConfig, sender MailSender) (*vacationServiceImpl, *fakeClock) {
+	ctrl := gomock.NewController(t)
+	v, err := NewVacationService(mock.NewInitializedMockLogger(ctrl), conf, sender)
+	assert.Nil(t, err)
+
+	impl := v.(*vacationServiceImpl)
+	return impl, freezeClock(&impl.now)
+}
+
+// vacationConfig returns the config of the store in dbPath, the auto-reply messages are in the directory.
+func vacationConfig(dir, dbPath string) *config.VacationConfig {
+	return &config.VacationConfig{
+		Enable:      true,
+		MessagePath: filepath.Join(dir, "{domain}", "{localpart}.msg"),
+		Period:      24 * time.Hour,
+		DbPath:      dbPath,
+	}
+}
+
+func writeAutoReply(t *testing.T, dir, user, content string) {
+	path := filepath.Join(dir, "example.com", user+".msg")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestVacationService_Respond_NotReplied(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		headers string
+	}{
+		{name: "null sender", from: ""},
+		{name: "daemon", from: "MAILER-DAEMON@example.net"},
+		{name: "list request address", from: "dev-request@lists.example.net"},
+		{name: "auto-submitted", from: "alice@example.net", headers: "Auto-Submitted: auto-replied\n"},
+		{name: "bulk", from: "alice@example.net", headers: "Precedence: bulk\n"},
+		{name: "mailing list", from: "alice@example.net", headers: "List-Id: <dev.lists.example.net>\n"},
+		{name: "own address", from: "bob@example.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			// no message is sent
+			sender := mock.NewMockMailSender(ctrl)
+			v, _ := newTestVacationService(t, vacationConfig(t.TempDir(), ""), sender)
+
+			err := v.Respond(context.Background(), "bob@example.com", newVacationMessage(test.from, test.headers), &sieve.Vacation{Reason: "away", Days: 7})
+			assert.Nil(t, err)
+		})
+	}
+
+	t.Run("not addressed to the user", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		v, _ := newTestVacationService(t, vacationConfig(t.TempDir(), ""), mock.NewMockMailSender(ctrl))
+		msg := &sieve.Message{From: "alice@example.net", Raw: []byte("To: all@example.com\nCc: jimbob@example.com\n\nbody\n")}
+
+		assert.Nil(t, v.Respond(context.Background(), "bob@example.com", msg, &sieve.Vacation{Reason: "away", Days: 7}))
+	})
+}
+
+func TestVacationService_Respond(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	sender := mock.NewMockMailSender(ctrl)
+	sender.EXPECT().Hostname().Return("mx.example.com").AnyTimes()
+	impl, clock := newTestVacationService(t, vacationConfig(t.TempDir(), ""), sender)
+
+	var sent []byte
+	sender.EXPECT().Send(gomock.Any(), "", []string{"alice@example.net"}, gomock.Any(), session.Body8BitMime).DoAndReturn(
+		func(ctx context.Context, from string, to []string, msg []byte, body session.BodyType) error {
+			sent = msg
+			return nil
+		}).Times(2)
+
+	vacation := &sieve.Vacation{Reason: "I am away.\n", Subject: "休暇", Days: 2, Handle: "h"}
+	msg := newVacationMessage("alice@example.net", "References: <0@example.net>\n")
+	assert.Nil(t, impl.Respond(context.Background(), "bob@example.com", msg, vacation))
+
+	reply := string(sent)
+	assert.Contains(t, reply, "From: bob@example.com\r\nTo: alice@example.net\r\nSubject: =?utf-8?q?=E4=BC=91=E6=9A=87?=\r\n")
+	assert.Contains(t, reply, "Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n")
+	assert.Contains(t, reply, "In-Reply-To: <1@example.net>\r\nReferences: <0@example.net> <1@example.net>\r\n")
+	assert.Contains(t, reply, "Auto-Submitted: auto-replied (vacation)\r\n")
+	assert.Contains(t, reply, "\r\n\r\nI am away.\r\n")
+
+	// the same sender is not replied within the days
+	clock.Advance(47 * time.Hour)
+	assert.Nil(t, impl.Respond(context.Background(), "bob@example.com", msg, vacation))
+	clock.Advance(time.Hour)
+	assert.Nil(t, impl.Respond(context.Background(), "bob@example.com", msg, vacation))
+}
+
+func TestVacationService_Respond_SendError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	sender := mock.NewMockMailSender(ctrl)
+	sender.EXPECT().Hostname().Return("mx.example.com").AnyTimes()
+	sender.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("relay down"))
+	sender.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	v, _ := newTestVacationService(t, vacationConfig(t.TempDir(), filepath.Join(t.TempDir(), "vacation.db")), sender)
+
+	// the failed reply is not recorded and sent again
+	msg := newVacationMessage("alice@example.net", "")
+	vacation := &sieve.Vacation{Reason: "away", Days: 7, Mime: true}
+	assert.NotNil(t, v.Respond(context.Background(), "bob@example.com", msg, vacation))
+	assert.Nil(t, v.Respond(context.Background(), "bob@example.com", msg, vacation))
+}
+
+func TestVacationService_AutoReply(t *testing.T) {
+	dir := t.TempDir()
+	writeAutoReply(t, dir, "bob", "Subject: Out of office\nFrom: Bob <bob@example.com>\n\nBack on Monday.\n")
+	writeAutoReply(t, dir, "carol", "Away.\n")
+
+	for name, dbPath := range storeDbPaths(t) {
+		t.Run(name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			sender := mock.NewMockMailSender(ctrl)
+			sender.EXPECT().Hostname().Return("mx.example.com").AnyTimes()
+			v, clock := newTestVacationService(t, vacationConfig(dir, dbPath), sender)
+			ctx := context.Background()
+
+			replies := make([]string, 0)
+			sender.EXPECT().Send(gomock.Any(), "", []string{"alice@example.net"}, gomock.Any(), session.Body8BitMime).DoAndReturn(
+				func(ctx context.Context, from string, to []string, msg []byte, body session.BodyType) error {
+					replies = append(replies, string(msg))
+					return nil
+				}).AnyTimes()
+
+			msg := newVacationMessage("alice@example.net", "")
+			assert.Nil(t, v.AutoReply(ctx, "bob+work@example.com", "bob@example.com", msg))
+			assert.Len(t, replies, 1)
+			assert.Contains(t, replies[0], "From: Bob <bob@example.com>\r\nTo: alice@example.net\r\nSubject: Out of office\r\n")
+			assert.Contains(t, replies[0], "\r\n\r\nBack on Monday.\r\n")
+
+			// replied once in the period
+			clock.Advance(23 * time.Hour)
+			assert.Nil(t, v.AutoReply(ctx, "bob+work@example.com", "bob@example.com", msg))
+			assert.Len(t, replies, 1)
+			clock.Advance(time.Hour)
+			assert.Nil(t, v.AutoReply(ctx, "bob+work@example.com", "bob@example.com", msg))
+			assert.Len(t, replies, 2)
+
+			// the file without header section is the text
+			carol := newVacationMessage("alice@example.net", "Cc: carol@example.com\n")
+			assert.Nil(t, v.AutoReply(ctx, "carol@example.com", "carol@example.com", carol))
+			assert.Len(t, replies, 3)
+			assert.Contains(t, replies[2], "From: carol@example.com\r\n")
+			assert.Contains(t, replies[2], "Subject: Auto: Hello\r\n")
+			assert.Contains(t, replies[2], "\r\n\r\nAway.\r\n")
+
+			// no message
+			assert.Nil(t, v.AutoReply(ctx, "dave@example.com", "dave@example.com", newVacationMessage("alice@example.net", "Cc: dave@example.com\n")))
+			assert.Len(t, replies, 3)
+		})
+	}
+}
+
+func TestVacationService_Persist(t *testing.T) {
+	dir := t.TempDir()
+	writeAutoReply(t, dir, "bob", "Away.\n")
+	conf := vacationConfig(dir, filepath.Join(t.TempDir(), "vacation.db"))
+	ctx := context.Background()
+	msg := newVacationMessage("alice@example.net", "")
+
+	ctrl := gomock.NewController(t)
+	sender := mock.NewMockMailSender(ctrl)
+	sender.EXPECT().Hostname().Return("mx.example.com").AnyTimes()
+	sender.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+
+	v, _ := newTestVacationService(t, conf, sender)
+	assert.Nil(t, v.AutoReply(ctx, "bob@example.com", "bob@example.com", msg))
+
+	// restarted
+	restarted, clock := newTestVacationService(t, conf, sender)
+	clock.Advance(time.Hour)
+	assert.Nil(t, restarted.AutoReply(ctx, "bob@example.com", "bob@example.com", msg))
+}
+
+func TestVacationService_Purge(t *testing.T) {
+	for name, dbPath := range storeDbPaths(t) {
+		t.Run(name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			sender := mock.NewMockMailSender(ctrl)
+			sender.EXPECT().Hostname().Return("mx.example.com").AnyTimes()
+			sender.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+			v, clock := newTestVacationService(t, vacationConfig(t.TempDir(), dbPath), sender)
+			ctx := context.Background()
+			key := vacationKey{recipient: "bob@example.com", sender: "alice@example.net", handle: "h"}
+
+			vacation := &sieve.Vacation{Reason: "away", Days: 7, Handle: "h"}
+			assert.Nil(t, v.Respond(ctx, "bob@example.com", newVacationMessage("alice@example.net", ""), vacation))
+			replied, err := v.store.getReplied(ctx, key)
+			assert.Nil(t, err)
+			assert.Equal(t, clock.Now().Unix(), replied.Unix())
+
+			// replies older than any period are removed
+			clock.Advance(31 * 24 * time.Hour)
+			assert.Nil(t, v.Respond(ctx, "bob@example.com", newVacationMessage("carol@example.net", ""), vacation))
+			replied, err = v.store.getReplied(ctx, key)
+			assert.Nil(t, err)
+			assert.True(t, replied.IsZero())
+		})
+	}
+}
+
+func TestVacationService_Disabled(t *testing.T) {
+	dir := t.TempDir()
+	writeAutoReply(t, dir, "bob", "Away.\n")
+	conf := vacationConfig(dir, "")
+	conf.Enable = false
+
+	ctrl := gomock.NewController(t)
+	// no message is sent
+	v, _ := newTestVacationService(t, conf, mock.NewMockMailSender(ctrl))
+	msg := newVacationMessage("alice@example.net", "")
+	assert.Nil(t, v.AutoReply(context.Background(), "bob@example.com", "bob@example.com", msg))
+	assert.Nil(t, v.Respond(context.Background(), "bob@example.com", msg, &sieve.Vacation{Reason: "away", Days: 7}))
+}
@@ -0,0 +1,18 @@
+package session
+
+// value of BODY parameter of MAIL
+type BodyType string
+
+const (
+	// https://tex2e.github.io/rfc-translater/html/rfc6152.html
+	Body7Bit     BodyType = "7BIT"
+	Body8BitMime BodyType = "8BITMIME"
+	// https://tex2e.github.io/rfc-translater/html/rfc3030.html#3--Binary-MIME-Extension
+	BodyBinaryMime BodyType = "BINARYMIME"
+)
+
+// Allows8Bit reports whether the message may contain octets outside of US-ASCII.
+// BODY=7BIT is assumed when the parameter is omitted.
+func (b BodyType) Allows8Bit() bool {
+	return b == Body8BitMime || b == BodyBinaryMime
+}
@@ -5,8 +5,10 @@ import (
 	"net"
 	"net/mail"
 	"net/textproto"
+	"time"
 
 	"github.com/Haya372/hlog"
+	"github.com/Haya372/smtp-server/internal/config"
 	"github.com/google/uuid"
 )
 
@@ -15,22 +17,35 @@ type SessionFactory interface {
 }
 
 type SessionFactoryImpl struct {
-	log hlog.Logger
+	log      hlog.Logger
+	timeouts Timeouts
 }
 
 func (f *SessionFactoryImpl) CreateSession(conn net.Conn) *Session {
-	return &Session{
+	s := &Session{
 		Id:         uuid.New(),
 		EnvelopeTo: make([]mail.Address, 0),
 		Conn:       conn,
 		log:        f.log,
-		reader:     *textproto.NewReader(bufio.NewReader(conn)),
-		writer:     *textproto.NewWriter(bufio.NewWriter(conn)),
+		writer:     bufio.NewWriter(conn),
+		timeouts:   f.timeouts,
 	}
+	if f.timeouts.Session > 0 {
+		s.expiresAt = time.Now().Add(f.timeouts.Session)
+	}
+	s.reader = *textproto.NewReader(bufio.NewReader(&timeoutReader{s: s, r: conn}))
+	return s
 }
 
-func NewSessionFactory(log hlog.Logger) SessionFactory {
+func NewSessionFactory(log hlog.Logger, conf *config.ServerConfig) SessionFactory {
 	return &SessionFactoryImpl{
 		log: log,
+		timeouts: Timeouts{
+			Greeting:  conf.GreetingTimeout,
+			Command:   conf.CommandTimeout,
+			DataInit:  conf.DataInitTimeout,
+			DataBlock: conf.DataBlockTimeout,
+			Session:   conf.ConnectionTimeout,
+		},
 	}
 }
@@ -0,0 +1,56 @@
+package session
+
+import (
+	"fmt"
+	"net"
+)
+
+// result of forward-confirmed reverse DNS of the client
+type ReverseDnsStatus string
+
+const (
+	// not checked
+	ReverseDnsNone ReverseDnsStatus = ""
+	// a PTR name of the client IP address resolves to the address
+	ReverseDnsPass ReverseDnsStatus = "pass"
+	// no PTR record, or no PTR name resolves to the address
+	ReverseDnsFail      ReverseDnsStatus = "fail"
+	ReverseDnsTempError ReverseDnsStatus = "temperror"
+)
+
+// result of the validation of HELO/EHLO argument
+type HeloStatus string
+
+const (
+	// not checked
+	HeloNone  HeloStatus = ""
+	HeloValid HeloStatus = "valid"
+	// neither a fully-qualified domain name nor an address literal
+	HeloInvalid HeloStatus = "invalid"
+	// the address literal is not the client IP address
+	HeloMismatch HeloStatus = "mismatch"
+	// the client claims to be this server
+	HeloOwnHostname HeloStatus = "own-hostname"
+)
+
+// ReceivedFrom formats the From-domain and TCP-info of Received header, e.g. "helo.example (ptr.example [192.0.2.1])".
+// https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-4--Trace-Information
+func (s *Session) ReceivedFrom() string {
+	ip := s.IP()
+	literal := "[unknown]"
+	if ip != nil {
+		literal = addressLiteral(ip)
+	}
+	if len(s.ClientHostname) > 0 {
+		return fmt.Sprintf("%s (%s %s)", s.SenderDomain, s.ClientHostname, literal)
+	}
+	return fmt.Sprintf("%s (%s)", s.SenderDomain, literal)
+}
+
+// https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-1-3--Address-Literals
+func addressLiteral(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return "[" + v4.String() + "]"
+	}
+	return "[IPv6:" + ip.String() + "]"
+}
@@ -0,0 +1,55 @@
+package session
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession_ReceivedFrom(t *testing.T) {
+	tests := []struct {
+		name           string
+		ip             string
+		clientHostname string
+		expected       string
+	}{
+		{
+			name:           "confirmed hostname",
+			ip:             "192.0.2.1",
+			clientHostname: "mx.example.com",
+			expected:       "helo.example.com (mx.example.com [192.0.2.1])",
+		},
+		{
+			name:     "unknown hostname",
+			ip:       "192.0.2.1",
+			expected: "helo.example.com ([192.0.2.1])",
+		},
+		{
+			name:     "ipv6",
+			ip:       "2001:db8::1",
+			expected: "helo.example.com ([IPv6:2001:db8::1])",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Session{
+				Conn:           &addrConn{addr: &net.TCPAddr{IP: net.ParseIP(test.ip), Port: 25}},
+				SenderDomain:   "helo.example.com",
+				ClientHostname: test.clientHostname,
+			}
+			assert.Equal(t, test.expected, s.ReceivedFrom())
+		})
+	}
+}
+
+// addrConn is a connection which only knows the remote address.
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
@@ -0,0 +1,59 @@
+package session
+
+import (
+	"net"
+
+	"github.com/Haya372/smtp-server/internal/config"
+)
+
+const (
+	defaultSubnetPrefixV4 = 24
+	defaultSubnetPrefixV6 = 64
+)
+
+// RemoteIP extracts the IP address of the peer, nil is returned when addr has no IP address.
+func RemoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
+
+// SubnetGrouping groups client addresses into subnets, connection limits, rate limits and greylisting share it.
+type SubnetGrouping struct {
+	v4Prefix int
+	v6Prefix int
+}
+
+// Subnet returns the network which ip belongs to.
+func (g *SubnetGrouping) Subnet(ip net.IP) *net.IPNet {
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4.Mask(net.CIDRMask(g.v4Prefix, 32)), Mask: net.CIDRMask(g.v4Prefix, 32)}
+	}
+	return &net.IPNet{IP: ip.Mask(net.CIDRMask(g.v6Prefix, 128)), Mask: net.CIDRMask(g.v6Prefix, 128)}
+}
+
+// NewSubnetGrouping uses the default prefix lengths instead of invalid ones.
+func NewSubnetGrouping(conf *config.SubnetConfig) *SubnetGrouping {
+	g := &SubnetGrouping{
+		v4Prefix: conf.PrefixV4,
+		v6Prefix: conf.PrefixV6,
+	}
+	if g.v4Prefix <= 0 || g.v4Prefix > 32 {
+		g.v4Prefix = defaultSubnetPrefixV4
+	}
+	if g.v6Prefix <= 0 || g.v6Prefix > 128 {
+		g.v6Prefix = defaultSubnetPrefixV6
+	}
+	return g
+}
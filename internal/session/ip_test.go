@@ -0,0 +1,94 @@
+package session
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		addr     net.Addr
+		expected net.IP
+	}{
+		{
+			name:     "tcp address",
+			addr:     &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 25},
+			expected: net.ParseIP("192.0.2.1"),
+		},
+		{
+			name:     "other address",
+			addr:     &net.IPAddr{IP: net.ParseIP("2001:db8::1")},
+			expected: net.ParseIP("2001:db8::1"),
+		},
+		{
+			name:     "no IP address",
+			addr:     &net.UnixAddr{Name: "/tmp/smtp.sock", Net: "unix"},
+			expected: nil,
+		},
+		{
+			name:     "nil",
+			addr:     nil,
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.True(t, test.expected.Equal(RemoteIP(test.addr)))
+		})
+	}
+}
+
+func TestSubnet(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     config.SubnetConfig
+		ip       string
+		expected string
+	}{
+		{
+			name:     "IPv4",
+			conf:     config.SubnetConfig{PrefixV4: 24, PrefixV6: 64},
+			ip:       "192.0.2.123",
+			expected: "192.0.2.0/24",
+		},
+		{
+			name:     "IPv4 /16",
+			conf:     config.SubnetConfig{PrefixV4: 16, PrefixV6: 48},
+			ip:       "192.0.2.123",
+			expected: "192.0.0.0/16",
+		},
+		{
+			name:     "IPv6 /48",
+			conf:     config.SubnetConfig{PrefixV4: 16, PrefixV6: 48},
+			ip:       "2001:db8:1:2:3:4:5:6",
+			expected: "2001:db8:1::/48",
+		},
+		{
+			name:     "invalid prefix lengths",
+			conf:     config.SubnetConfig{PrefixV4: 33, PrefixV6: -1},
+			ip:       "192.0.2.123",
+			expected: "192.0.2.0/24",
+		},
+		{
+			name:     "IPv4-mapped IPv6",
+			ip:       "::ffff:192.0.2.123",
+			expected: "192.0.2.0/24",
+		},
+		{
+			name:     "IPv6",
+			ip:       "2001:db8:1:2:3:4:5:6",
+			expected: "2001:db8:1:2::/64",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, NewSubnetGrouping(&test.conf).Subnet(net.ParseIP(test.ip)).String())
+		})
+	}
+}
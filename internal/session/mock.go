@@ -2,7 +2,6 @@ package session
 
 import (
 	"bufio"
-	"fmt"
 	"net/textproto"
 	"strings"
 
@@ -24,19 +23,15 @@ func NewMockSession(ctrl *gomock.Controller) *MockSession {
 	return &MockSession{
 		Session: &Session{
 			Id:     uuid.New(),
-			writer: *textproto.NewWriter(bufio.NewWriter(writer)),
+			writer: bufio.NewWriter(writer),
 		},
 		ctrl:   ctrl,
 		Writer: writer,
 	}
 }
 
-func (s *MockSession) ExpectResponse(code int, msg string) {
-	s.expectResponseStr(fmt.Sprintf("%d %s\r\n", code, msg))
-}
-
-func (s *MockSession) ExpectResponseLine(code int, msg string) {
-	s.expectResponseStr(fmt.Sprintf("%d-%s\r\n", code, msg))
+func (s *MockSession) ExpectReply(r Reply) {
+	s.expectResponseStr(r.String())
 }
 
 func (s *MockSession) expectResponseStr(msg string) {
@@ -0,0 +1,106 @@
+package session
+
+import (
+	"fmt"
+	"strings"
+)
+
+// https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-5-3-1-5--Reply-Line
+// maximum length of a reply line including the reply code and <CRLF>
+const MaxReplyLineLength = 512
+
+// enhanced mail system status code (class.subject.detail)
+// https://tex2e.github.io/rfc-translater/html/rfc3463.html
+type EnhancedCode [3]int
+
+// NoEnhancedCode is used for replies which should not have enhanced status code (e.g. greeting, 354)
+var NoEnhancedCode = EnhancedCode{}
+
+func (c EnhancedCode) IsZero() bool {
+	return c == NoEnhancedCode
+}
+
+func (c EnhancedCode) String() string {
+	return fmt.Sprintf("%d.%d.%d", c[0], c[1], c[2])
+}
+
+type Reply struct {
+	// three digit reply code
+	Code int
+	// RFC 3463 enhanced status code, omitted when zero
+	EnhancedCode EnhancedCode
+	// reply texts, a multiline reply is sent when there are two or more lines
+	Lines []string
+}
+
+func NewReply(code int, enhancedCode EnhancedCode, lines ...string) Reply {
+	return Reply{
+		Code:         code,
+		EnhancedCode: enhancedCode,
+		Lines:        lines,
+	}
+}
+
+// WithLines returns a copy of the reply whose texts are replaced by lines.
+func (r Reply) WithLines(lines ...string) Reply {
+	r.Lines = lines
+	return r
+}
+
+// Format returns reply lines without <CRLF>.
+// Lines longer than MaxReplyLineLength are wrapped into continuation lines.
+func (r Reply) Format() []string {
+	prefix := ""
+	if !r.EnhancedCode.IsZero() {
+		prefix = r.EnhancedCode.String() + " "
+	}
+	// "xyz" + separator + enhanced code + text + <CRLF>
+	maxTextLen := MaxReplyLineLength - 4 - len(prefix) - 2
+
+	texts := make([]string, 0, len(r.Lines))
+	for _, line := range r.Lines {
+		texts = append(texts, wrapReplyText(line, maxTextLen)...)
+	}
+	if len(texts) == 0 {
+		texts = append(texts, "")
+	}
+
+	res := make([]string, len(texts))
+	for idx, text := range texts {
+		sep := "-"
+		if idx == len(texts)-1 {
+			sep = " "
+		}
+		res[idx] = strings.TrimRight(fmt.Sprintf("%d%s%s%s", r.Code, sep, prefix, text), " ")
+	}
+	return res
+}
+
+// String returns the reply as it is written to the client.
+func (r Reply) String() string {
+	var sb strings.Builder
+	for _, line := range r.Format() {
+		sb.WriteString(line)
+		sb.WriteString("\r\n")
+	}
+	return sb.String()
+}
+
+func wrapReplyText(text string, maxLen int) []string {
+	// reply texts must not contain line breaks
+	text = strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+
+	res := make([]string, 0, 1)
+	for len(text) > maxLen {
+		idx := strings.LastIndex(text[:maxLen+1], " ")
+		if idx <= 0 {
+			// no space to break, split forcibly
+			res = append(res, text[:maxLen])
+			text = text[maxLen:]
+			continue
+		}
+		res = append(res, text[:idx])
+		text = strings.TrimLeft(text[idx:], " ")
+	}
+	return append(res, text)
+}
@@ -0,0 +1,82 @@
+package session
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReply_Format(t *testing.T) {
+	longWord := strings.Repeat("a", MaxReplyLineLength)
+	longText := strings.Repeat("word ", 200)
+
+	tests := []struct {
+		name   string
+		reply  Reply
+		expect []string
+	}{
+		{
+			name:   "single line",
+			reply:  NewReply(250, NoEnhancedCode, "OK"),
+			expect: []string{"250 OK"},
+		},
+		{
+			name:   "enhanced code",
+			reply:  NewReply(250, EnhancedCode{2, 1, 0}, "OK"),
+			expect: []string{"250 2.1.0 OK"},
+		},
+		{
+			name:   "multiline",
+			reply:  NewReply(250, NoEnhancedCode, "example.com greets test", "PIPELINING", "HELP"),
+			expect: []string{"250-example.com greets test", "250-PIPELINING", "250 HELP"},
+		},
+		{
+			name:   "multiline with enhanced code",
+			reply:  NewReply(214, EnhancedCode{2, 0, 0}, "first", "second"),
+			expect: []string{"214-2.0.0 first", "214 2.0.0 second"},
+		},
+		{
+			name:   "no text",
+			reply:  NewReply(250, NoEnhancedCode),
+			expect: []string{"250"},
+		},
+		{
+			name:   "line breaks in text",
+			reply:  NewReply(250, NoEnhancedCode, "a\r\nb"),
+			expect: []string{"250 a  b"},
+		},
+		{
+			name:  "long word is split forcibly",
+			reply: NewReply(250, NoEnhancedCode, longWord),
+			expect: []string{
+				"250-" + longWord[:MaxReplyLineLength-6],
+				"250 " + longWord[MaxReplyLineLength-6:],
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expect, test.reply.Format())
+		})
+	}
+
+	t.Run("long text is wrapped at spaces", func(t *testing.T) {
+		lines := NewReply(550, EnhancedCode{5, 1, 1}, longText).Format()
+		assert.Greater(t, len(lines), 1)
+		for idx, line := range lines {
+			assert.LessOrEqual(t, len(line)+2, MaxReplyLineLength)
+			if idx == len(lines)-1 {
+				assert.True(t, strings.HasPrefix(line, "550 5.1.1 "))
+			} else {
+				assert.True(t, strings.HasPrefix(line, "550-5.1.1 "))
+			}
+		}
+	})
+}
+
+func TestReply_String(t *testing.T) {
+	reply := NewReply(250, NoEnhancedCode, "first", "second")
+	assert.Equal(t, "250-first\r\n250 second\r\n", reply.String())
+}
@@ -3,9 +3,13 @@ package session
 import (
 	"bufio"
 	"crypto/tls"
+	"io"
 	"net"
 	"net/mail"
 	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/Haya372/hlog"
 	"github.com/google/uuid"
@@ -16,29 +20,92 @@ type Session struct {
 	Id uuid.UUID
 	// when this flag is true, connection will close immediately
 	ShouldClose bool
+	// argument of the current command as the client sent it, whitespace in quoted strings is kept
+	Argument string
 	// domain name received by HELO/EHLO
 	SenderDomain string
 	// sender address received by MAIL
 	EnvelopeFrom *mail.Address
 	// recipient addresses received by RCPT
 	EnvelopeTo []mail.Address
+	// sender rewritten by SRS, which is used when the message is relayed to recipients forwarded by aliases
+	ForwardFrom *mail.Address
 	// raw mail data
 	RawData []byte
+	// body type received by BODY parameter of MAIL
+	BodyType BodyType
+	// BDAT transaction is in progress
+	Chunking bool
+	// the message is accepted but not delivered, which is requested by content filters.
+	// Later filters and delivery skip it because nobody receives the message.
+	Discard bool
+	// the message is delivered to the quarantine instead of the recipients, which is requested by content filters
+	Quarantine bool
+	// SMTPUTF8 parameter is received by MAIL, UTF-8 addresses are permitted in the transaction
+	SmtpUtf8 bool
+	// the client greeted by EHLO, the protocol is ESMTP
+	Esmtp bool
+	// PIPELINING extension is negotiated by EHLO
+	Pipelining bool
+	// user name authenticated by AUTH, empty when the client is not authenticated
+	AuthUser string
+	// number of messages accepted in this connection
+	MessageCount int
+	// number of invalid commands received in this connection
+	InvalidCommands int
+	// number of misbehaviors of the client, the replies are delayed by tarpitting
+	Strikes int
+	// total delay of tarpitting in this connection
+	TarpitDelay time.Duration
+	// score of DNSBL and DNSWL lookups at connect time, positive values mean the client is suspicious
+	DnsblScore float64
+	// names of DNS blocklists which list the client
+	DnsblListed []string
+	// forward-confirmed reverse DNS of the client, ClientHostname is the confirmed name
+	ReverseDns     ReverseDnsStatus
+	ClientHostname string
+	// validation result of the HELO/EHLO argument
+	HeloStatus HeloStatus
+
+	lastReplyCode int
+	timeouts      Timeouts
+	// the session must finish before this time
+	expiresAt time.Time
+	// timeout of each read, which is used while reading message content
+	readTimeout time.Duration
+	// the server is shutting down, the session is closed on the next command
+	draining int32
 
 	Conn   net.Conn
 	log    hlog.Logger
 	reader textproto.Reader
-	writer textproto.Writer
+	writer *bufio.Writer
 }
 
 func (s *Session) IP() net.IP {
-	return net.IP(s.Conn.RemoteAddr().Network())
+	if s.Conn == nil {
+		return nil
+	}
+	return RemoteIP(s.Conn.RemoteAddr())
 }
 
+// AddEnvelopeTo adds the recipient, duplicated addresses are ignored.
 func (s *Session) AddEnvelopeTo(address mail.Address) {
+	if s.HasEnvelopeTo(address) {
+		return
+	}
 	s.EnvelopeTo = append(s.EnvelopeTo, address)
 }
 
+func (s *Session) HasEnvelopeTo(address mail.Address) bool {
+	for _, to := range s.EnvelopeTo {
+		if strings.EqualFold(to.Address, address.Address) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Session) ReadLine() (string, error) {
 	return s.reader.ReadLine()
 }
@@ -47,24 +114,84 @@ func (s *Session) ReadRawData() ([]byte, error) {
 	return s.reader.ReadDotBytes()
 }
 
+// ReadChunk reads exactly size bytes of BDAT chunk data.
+func (s *Session) ReadChunk(size int) ([]byte, error) {
+	chunk := make([]byte, size)
+	if _, err := io.ReadFull(s.reader.R, chunk); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// DiscardChunk reads and throws away size bytes of BDAT chunk data which is not accepted.
+func (s *Session) DiscardChunk(size int64) error {
+	_, err := io.CopyN(io.Discard, s.reader.R, size)
+	return err
+}
+
+// Drain asks the session to finish, it is safe to call from other goroutines.
+func (s *Session) Drain() {
+	atomic.StoreInt32(&s.draining, 1)
+}
+
+func (s *Session) IsDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
 func (s *Session) Close() {
+	s.writer.Flush()
 	s.Conn.Close()
 }
 
-func (s *Session) Response(code int, msg string) error {
-	return s.writer.PrintfLine("%d %s", code, msg)
+// Reply writes all lines of the reply and flushes them to the client.
+// While the client pipelines commands, replies are kept in the buffer until the input buffer is drained.
+// https://tex2e.github.io/rfc-translater/html/rfc2920.html#3-2--Server-support-of-pipelining
+func (s *Session) Reply(r Reply) error {
+	s.lastReplyCode = r.Code
+	if _, err := s.writer.WriteString(r.String()); err != nil {
+		return err
+	}
+	if s.Pipelining && s.HasPendingInput() {
+		return nil
+	}
+	return s.writer.Flush()
+}
+
+// LastReplyCode returns the code of the reply which is sent last.
+func (s *Session) LastReplyCode() int {
+	return s.lastReplyCode
+}
+
+// Flush sends buffered replies to the client.
+func (s *Session) Flush() error {
+	return s.writer.Flush()
 }
 
-func (s *Session) ResponseLine(line string) error {
-	return s.writer.PrintfLine(line)
+// HasPendingInput reports whether the client has already sent data which is not read yet.
+func (s *Session) HasPendingInput() bool {
+	return s.reader.R != nil && s.reader.R.Buffered() > 0
 }
 
 func (s *Session) Reset() {
 	s.SenderDomain = ""
+	s.Esmtp = false
+	s.HeloStatus = HeloNone
 	s.ShouldClose = false
+	s.AuthUser = ""
+	s.ResetTransaction()
+}
+
+// ResetTransaction clears the mail transaction state, the HELO/EHLO state is kept.
+func (s *Session) ResetTransaction() {
 	s.EnvelopeFrom = nil
 	s.EnvelopeTo = make([]mail.Address, 0)
+	s.ForwardFrom = nil
 	s.RawData = make([]byte, 0)
+	s.BodyType = ""
+	s.Chunking = false
+	s.SmtpUtf8 = false
+	s.Discard = false
+	s.Quarantine = false
 }
 
 func (s *Session) IsTls() bool {
@@ -82,7 +209,7 @@ func (s *Session) ConvertToTls(tlsConf *tls.Config) error {
 		return err
 	}
 	s.Conn = conn
-	s.reader = *textproto.NewReader(bufio.NewReader(conn))
-	s.writer = *textproto.NewWriter(bufio.NewWriter(conn))
+	s.reader = *textproto.NewReader(bufio.NewReader(&timeoutReader{s: s, r: conn}))
+	s.writer = bufio.NewWriter(conn)
 	return nil
 }
@@ -0,0 +1,118 @@
+package session
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// time allowed to send the last reply after a timeout
+const closeReplyTimeout = 10 * time.Second
+
+// Timeouts of each phase of the session, no timeout is applied when the value is 0.
+// https://tex2e.github.io/rfc-translater/html/rfc5321.html#4-5-3-2--Timeouts
+type Timeouts struct {
+	// sending the greeting
+	Greeting time.Duration
+	// waiting for the next command and replying to it
+	Command time.Duration
+	// sending 354 reply of DATA
+	DataInit time.Duration
+	// each read of message content
+	DataBlock time.Duration
+	// total lifetime of the session
+	Session time.Duration
+}
+
+type Phase int
+
+const (
+	PhaseGreeting Phase = iota
+	PhaseCommand
+	PhaseDataInit
+	PhaseDataBlock
+)
+
+// EnterPhase sets the connection deadline for the phase, the deadline never exceeds the session lifetime.
+func (s *Session) EnterPhase(phase Phase) error {
+	if s.timeouts == (Timeouts{}) {
+		return nil
+	}
+
+	var timeout time.Duration
+	switch phase {
+	case PhaseGreeting:
+		timeout = s.timeouts.Greeting
+	case PhaseCommand:
+		timeout = s.timeouts.Command
+	case PhaseDataInit:
+		timeout = s.timeouts.DataInit
+	case PhaseDataBlock:
+		timeout = s.timeouts.DataBlock
+	}
+
+	// deadline of message content is extended on each read
+	s.readTimeout = 0
+	if phase == PhaseDataBlock {
+		s.readTimeout = timeout
+	}
+	return s.Conn.SetDeadline(s.deadline(timeout))
+}
+
+// deadline returns the time after timeout, which is limited by the session lifetime.
+func (s *Session) deadline(timeout time.Duration) time.Time {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if !s.expiresAt.IsZero() && (deadline.IsZero() || s.expiresAt.Before(deadline)) {
+		deadline = s.expiresAt
+	}
+	return deadline
+}
+
+// ReplyOnTimeout sends the reply after the deadline has been exceeded.
+func (s *Session) ReplyOnTimeout(r Reply) error {
+	if err := s.Conn.SetWriteDeadline(time.Now().Add(closeReplyTimeout)); err != nil {
+		return err
+	}
+	return s.Reply(r)
+}
+
+// IsTimeout reports whether the error is caused by the connection deadline.
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// timeoutReader extends the read deadline before each read while reading message content.
+type timeoutReader struct {
+	s *Session
+	r io.Reader
+}
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	if r.s.readTimeout > 0 {
+		if err := r.s.Conn.SetReadDeadline(r.s.deadline(r.s.readTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return r.r.Read(p)
+}
+
+// ReceivesWithin reports whether the client sends any data within d before the greeting, the data is kept for the next read.
+// The read deadline of the greeting phase is restored after it.
+func (s *Session) ReceivesWithin(d time.Duration) (bool, error) {
+	if err := s.Conn.SetReadDeadline(s.deadline(d)); err != nil {
+		return false, err
+	}
+	defer s.Conn.SetReadDeadline(s.deadline(s.timeouts.Greeting))
+	if _, err := s.reader.R.Peek(1); err != nil {
+		if IsTimeout(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,127 @@
+package session
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Haya372/smtp-server/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPipeSession(t *testing.T, conf *config.ServerConfig) (*Session, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return NewSessionFactory(nil, conf).CreateSession(server), client
+}
+
+func TestSession_CommandTimeout(t *testing.T) {
+	s, _ := newPipeSession(t, &config.ServerConfig{
+		CommandTimeout: 50 * time.Millisecond,
+	})
+
+	assert.Nil(t, s.EnterPhase(PhaseCommand))
+	_, err := s.ReadLine()
+	assert.True(t, IsTimeout(err))
+}
+
+func TestSession_DataBlockTimeout(t *testing.T) {
+	s, client := newPipeSession(t, &config.ServerConfig{
+		DataBlockTimeout: 100 * time.Millisecond,
+	})
+
+	// each block arrives within the timeout though the total exceeds it
+	go func() {
+		for _, line := range []string{"Subject: test\r\n", "\r\n", "body\r\n", ".\r\n"} {
+			time.Sleep(50 * time.Millisecond)
+			client.Write([]byte(line))
+		}
+	}()
+
+	assert.Nil(t, s.EnterPhase(PhaseDataBlock))
+	data, err := s.ReadRawData()
+	assert.Nil(t, err)
+	assert.Equal(t, "Subject: test\n\nbody\n", string(data))
+
+	// no more block
+	_, err = s.ReadLine()
+	assert.True(t, IsTimeout(err))
+}
+
+func TestSession_Lifetime(t *testing.T) {
+	s, client := newPipeSession(t, &config.ServerConfig{
+		ConnectionTimeout: 100 * time.Millisecond,
+		DataBlockTimeout:  time.Minute,
+	})
+
+	go func() {
+		for i := 0; i < 10; i++ {
+			time.Sleep(30 * time.Millisecond)
+			if _, err := client.Write([]byte("a")); err != nil {
+				return
+			}
+		}
+	}()
+
+	// session lifetime is not extended by the data block timeout
+	assert.Nil(t, s.EnterPhase(PhaseDataBlock))
+	_, err := s.ReadRawData()
+	assert.True(t, IsTimeout(err))
+}
+
+func TestSession_ReplyOnTimeout(t *testing.T) {
+	s, client := newPipeSession(t, &config.ServerConfig{
+		CommandTimeout: 10 * time.Millisecond,
+	})
+
+	assert.Nil(t, s.EnterPhase(PhaseCommand))
+	time.Sleep(20 * time.Millisecond)
+
+	// deadline is extended for the last reply
+	reply := NewReply(421, EnhancedCode{4, 4, 2}, "Timeout")
+	go s.ReplyOnTimeout(reply)
+
+	buf := make([]byte, 64)
+	n, err := client.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, reply.String(), string(buf[:n]))
+}
+
+func TestSession_NoTimeout(t *testing.T) {
+	s := &Session{}
+	// connection is never touched without timeouts
+	assert.Nil(t, s.EnterPhase(PhaseCommand))
+}
+
+func TestSession_ReceivesWithin(t *testing.T) {
+	t.Run("silent client", func(t *testing.T) {
+		s, client := newPipeSession(t, &config.ServerConfig{})
+
+		received, err := s.ReceivesWithin(50 * time.Millisecond)
+		assert.Nil(t, err)
+		assert.False(t, received)
+
+		// the connection is readable after the wait
+		go client.Write([]byte("EHLO example.com\r\n"))
+		line, err := s.ReadLine()
+		assert.Nil(t, err)
+		assert.Equal(t, "EHLO example.com", line)
+	})
+
+	t.Run("early talker", func(t *testing.T) {
+		s, client := newPipeSession(t, &config.ServerConfig{})
+
+		go client.Write([]byte("EHLO example.com\r\n"))
+		received, err := s.ReceivesWithin(time.Second)
+		assert.Nil(t, err)
+		assert.True(t, received)
+
+		// the data is not consumed
+		line, err := s.ReadLine()
+		assert.Nil(t, err)
+		assert.Equal(t, "EHLO example.com", line)
+	})
+}
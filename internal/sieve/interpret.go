@@ -0,0 +1,432 @@
+package sieve
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// https://tex2e.github.io/rfc-translater/html/rfc5230.html#4-1--Days-Parameter
+	defaultVacationDays = 7
+	// redirects more than this in a script are an error to prevent mail loops
+	maxRedirects = 10
+	// variables longer than this are truncated
+	maxVariableLength = 4096
+)
+
+// Result has the actions taken by the script.
+// https://tex2e.github.io/rfc-translater/html/rfc5228.html#2-10-2--Implicit-Keep
+type Result struct {
+	// the message is delivered to the inbox by keep or implicit keep
+	Keep bool
+	// mailboxes which the message is filed into
+	FileInto []string
+	Redirect []string
+	// the message is refused with the reason
+	Reject       bool
+	RejectReason string
+	// auto-reply requested by the vacation action
+	Vacation *Vacation
+}
+
+// Vacation is the auto-reply of the vacation action, the response is decided by the caller.
+// https://tex2e.github.io/rfc-translater/html/rfc5230.html
+type Vacation struct {
+	Reason  string
+	Subject string
+	From    string
+	// addresses of the user other than the envelope recipient
+	Addresses []string
+	// days in which the same sender is not replied again
+	Days int
+	// the reason is a MIME entity with its headers
+	Mime bool
+	// identifies the vacation response, derived from the arguments when not given
+	Handle string
+}
+
+// Run executes the script for the message. On a runtime error the message is kept and the error is returned
+// with the result of the implicit keep.
+func (s *Script) Run(msg *Message) (*Result, error) {
+	in := &interpreter{
+		script:    s,
+		msg:       msg,
+		variables: make(map[string]string),
+	}
+	if err := in.execute(s.commands); err != nil && !errors.Is(err, errStop) {
+		return &Result{Keep: true}, err
+	}
+
+	res := &in.result
+	if !in.cancelKeep {
+		res.Keep = true
+	}
+	// https://tex2e.github.io/rfc-translater/html/rfc5429.html#2-3--Interaction-with-Other-Sieve-Actions
+	if res.Reject && (res.Keep || len(res.FileInto) > 0 || len(res.Redirect) > 0 || res.Vacation != nil) {
+		return &Result{Keep: true}, errors.New("reject is incompatible with the other actions")
+	}
+	return res, nil
+}
+
+// errStop ends the script by the stop command
+var errStop = errors.New("stop")
+
+type interpreter struct {
+	script *Script
+	msg    *Message
+	result Result
+	// implicit keep is canceled by fileinto, redirect, discard and reject
+	cancelKeep bool
+	variables  map[string]string
+	// ${0} to ${9} set by the last successful :matches
+	matchVariables []string
+}
+
+type node interface {
+	execute(in *interpreter) error
+}
+
+type testNode interface {
+	evaluate(in *interpreter) bool
+}
+
+func (in *interpreter) execute(nodes []node) error {
+	for _, n := range nodes {
+		if err := n.execute(in); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type branch struct {
+	cond  testNode
+	block []node
+}
+
+type ifNode struct {
+	branches  []branch
+	elseBlock []node
+}
+
+func (n *ifNode) execute(in *interpreter) error {
+	for _, b := range n.branches {
+		if b.cond.evaluate(in) {
+			return in.execute(b.block)
+		}
+	}
+	return in.execute(n.elseBlock)
+}
+
+type actionNode struct {
+	name  string
+	value string
+}
+
+func (n *actionNode) execute(in *interpreter) error {
+	value := in.expand(n.value)
+	switch n.name {
+	case "stop":
+		return errStop
+	case "keep":
+		in.result.Keep = true
+	case "discard":
+		in.cancelKeep = true
+	case "fileinto":
+		in.cancelKeep = true
+		if !contains(in.result.FileInto, value) {
+			in.result.FileInto = append(in.result.FileInto, value)
+		}
+	case "redirect":
+		in.cancelKeep = true
+		address, err := mail.ParseAddress(value)
+		if err != nil {
+			return fmt.Errorf("invalid redirect address %q", value)
+		}
+		if contains(in.result.Redirect, address.Address) {
+			return nil
+		}
+		if len(in.result.Redirect) >= maxRedirects {
+			return errors.New("too many redirects")
+		}
+		in.result.Redirect = append(in.result.Redirect, address.Address)
+	case "reject":
+		if in.result.Reject {
+			return errors.New("reject is executed twice")
+		}
+		in.cancelKeep = true
+		in.result.Reject = true
+		in.result.RejectReason = value
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type setNode struct {
+	name      string
+	value     string
+	modifiers []string
+}
+
+// https://tex2e.github.io/rfc-translater/html/rfc5229.html#4--Action-set
+func (n *setNode) execute(in *interpreter) error {
+	value := in.expand(n.value)
+	for _, modifier := range n.modifiers {
+		switch modifier {
+		case "lower":
+			value = strings.ToLower(value)
+		case "upper":
+			value = strings.ToUpper(value)
+		case "lowerfirst":
+			value = changeFirst(value, strings.ToLower)
+		case "upperfirst":
+			value = changeFirst(value, strings.ToUpper)
+		case "quotewildcard":
+			value = quoteWildcard(value)
+		case "length":
+			value = strconv.Itoa(utf8.RuneCountInString(value))
+		}
+	}
+	in.variables[n.name] = truncate(value)
+	return nil
+}
+
+func changeFirst(s string, f func(string) string) string {
+	_, size := utf8.DecodeRuneInString(s)
+	return f(s[:size]) + s[size:]
+}
+
+func truncate(s string) string {
+	if len(s) <= maxVariableLength {
+		return s
+	}
+	// the last character is not broken
+	end := maxVariableLength
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end]
+}
+
+type vacationNode struct {
+	days      int
+	subject   string
+	from      string
+	addresses []string
+	mime      bool
+	handle    string
+	reason    string
+}
+
+func (n *vacationNode) execute(in *interpreter) error {
+	if in.result.Vacation != nil {
+		return errors.New("vacation is executed twice")
+	}
+	v := &Vacation{
+		Reason:    in.expand(n.reason),
+		Subject:   in.expand(n.subject),
+		From:      in.expand(n.from),
+		Addresses: in.expandAll(n.addresses),
+		Days:      n.days,
+		Mime:      n.mime,
+		Handle:    in.expand(n.handle),
+	}
+	// https://tex2e.github.io/rfc-translater/html/rfc5230.html#4-2--Previous-Response-Tracking
+	if len(v.Handle) == 0 {
+		v.Handle = fmt.Sprintf("%s\x00%s\x00%s\x00%t", v.Reason, v.Subject, v.From, v.Mime)
+	}
+	in.result.Vacation = v
+	return nil
+}
+
+// expand replaces ${name} by the variable when the variables extension is required.
+// https://tex2e.github.io/rfc-translater/html/rfc5229.html#3--Interpretation-of-Strings
+func (in *interpreter) expand(s string) string {
+	if !in.script.extensions["variables"] || !strings.Contains(s, "${") {
+		return s
+	}
+
+	var sb strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			break
+		}
+		name := s[start+2 : start+end]
+		value, ok := in.variable(name)
+		if !ok {
+			// not a variable reference, "${" is kept as it is
+			sb.WriteString(s[:start+2])
+			s = s[start+2:]
+			continue
+		}
+		sb.WriteString(s[:start])
+		sb.WriteString(value)
+		s = s[start+end+1:]
+	}
+	sb.WriteString(s)
+	return sb.String()
+}
+
+// variable returns the value of the variable name, false is returned when name is not a variable name.
+// Unknown variables are empty.
+func (in *interpreter) variable(name string) (string, bool) {
+	if len(name) > 0 && strings.Trim(name, "0123456789") == "" {
+		n, err := strconv.Atoi(name)
+		if err != nil || n >= len(in.matchVariables) {
+			return "", true
+		}
+		return in.matchVariables[n], true
+	}
+	// namespaced variables such as ${env.name} are not supported and empty
+	for _, part := range strings.Split(name, ".") {
+		if !isVariableName(part) {
+			return "", false
+		}
+	}
+	return in.variables[strings.ToLower(name)], true
+}
+
+func (in *interpreter) expandAll(values []string) []string {
+	expanded := make([]string, 0, len(values))
+	for _, v := range values {
+		expanded = append(expanded, in.expand(v))
+	}
+	return expanded
+}
+
+type constantTest bool
+
+func (t constantTest) evaluate(in *interpreter) bool {
+	return bool(t)
+}
+
+type logicalTest struct {
+	name  string
+	tests []testNode
+}
+
+func (t *logicalTest) evaluate(in *interpreter) bool {
+	switch t.name {
+	case "not":
+		return !t.tests[0].evaluate(in)
+	case "allof":
+		for _, test := range t.tests {
+			if !test.evaluate(in) {
+				return false
+			}
+		}
+		return true
+	default:
+		for _, test := range t.tests {
+			if test.evaluate(in) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type existsTest struct {
+	headers []string
+}
+
+func (t *existsTest) evaluate(in *interpreter) bool {
+	for _, name := range in.expandAll(t.headers) {
+		if len(in.msg.Header(name)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type sizeTest struct {
+	over  bool
+	limit int64
+}
+
+func (t *sizeTest) evaluate(in *interpreter) bool {
+	size := int64(len(in.msg.Raw))
+	if t.over {
+		return size > t.limit
+	}
+	return size < t.limit
+}
+
+type matchTestNode struct {
+	name    string
+	matcher matcher
+	// header names, envelope parts or source strings
+	sources []string
+	keys    []string
+	// :all, :localpart or :domain of address and envelope
+	addressPart string
+	// :raw, :content or :text of body
+	bodyTransform string
+	contentTypes  []string
+}
+
+func (t *matchTestNode) evaluate(in *interpreter) bool {
+	keys := in.expandAll(t.keys)
+	for _, value := range t.values(in) {
+		for _, key := range keys {
+			ok, groups := t.matcher.match(value, key)
+			if !ok {
+				continue
+			}
+			if t.matcher.matchType == "matches" {
+				in.matchVariables = groups
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// values returns the strings compared with the keys.
+func (t *matchTestNode) values(in *interpreter) []string {
+	sources := in.expandAll(t.sources)
+	values := make([]string, 0)
+	switch t.name {
+	case "header":
+		for _, name := range sources {
+			values = append(values, in.msg.Header(name)...)
+		}
+	case "address":
+		for _, name := range sources {
+			for _, address := range in.msg.addresses(name) {
+				values = append(values, addressPart(address, t.addressPart))
+			}
+		}
+	case "envelope":
+		for _, part := range sources {
+			address := in.msg.From
+			if part == "to" {
+				address = in.msg.To
+			}
+			// the null sender is compared as the empty string with any address part
+			values = append(values, addressPart(address, t.addressPart))
+		}
+	case "body":
+		values = in.msg.bodyTexts(t.bodyTransform, in.expandAll(t.contentTypes))
+	case "string":
+		values = sources
+	}
+	return values
+}
@@ -0,0 +1,260 @@
+package sieve
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// https://tex2e.github.io/rfc-translater/html/rfc5228.html#8-1--Lexical-Tokens
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdentifier
+	tokenTag
+	tokenNumber
+	tokenString
+	tokenLeftBracket
+	tokenRightBracket
+	tokenLeftParen
+	tokenRightParen
+	tokenLeftBrace
+	tokenRightBrace
+	tokenComma
+	tokenSemicolon
+)
+
+type token struct {
+	typ tokenType
+	// identifier and tag in lower case, decoded string
+	text   string
+	number int64
+	line   int
+}
+
+func (t token) String() string {
+	switch t.typ {
+	case tokenEOF:
+		return "end of script"
+	case tokenTag:
+		return ":" + t.text
+	case tokenNumber:
+		return strconv.FormatInt(t.number, 10)
+	case tokenString:
+		return strconv.Quote(t.text)
+	default:
+		return t.text
+	}
+}
+
+type lexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (l *lexer) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+// tokenize splits the script into tokens, comments and white spaces are removed.
+func tokenize(src string) ([]token, error) {
+	l := &lexer{src: src, line: 1}
+	tokens := make([]token, 0)
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+		if t.typ == tokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skipSpaces(); err != nil {
+		return token{}, err
+	}
+	if l.pos >= len(l.src) {
+		return token{typ: tokenEOF, line: l.line}, nil
+	}
+
+	c := l.src[l.pos]
+	line := l.line
+	if typ, ok := specials[c]; ok {
+		l.pos++
+		return token{typ: typ, text: string(c), line: line}, nil
+	}
+	switch {
+	case c == '"':
+		s, err := l.quotedString()
+		return token{typ: tokenString, text: s, line: line}, err
+	case c == ':':
+		l.pos++
+		name := l.identifier()
+		if len(name) == 0 {
+			return token{}, l.errorf("tag without name")
+		}
+		return token{typ: tokenTag, text: strings.ToLower(name), line: line}, nil
+	case isDigit(c):
+		n, err := l.number()
+		return token{typ: tokenNumber, number: n, line: line}, err
+	case isIdentifierStart(c):
+		name := l.identifier()
+		if strings.EqualFold(name, "text") && l.pos < len(l.src) && l.src[l.pos] == ':' {
+			l.pos++
+			s, err := l.multiLine()
+			return token{typ: tokenString, text: s, line: line}, err
+		}
+		return token{typ: tokenIdentifier, text: strings.ToLower(name), line: line}, nil
+	default:
+		return token{}, l.errorf("unexpected character %q", c)
+	}
+}
+
+var specials = map[byte]tokenType{
+	'[': tokenLeftBracket,
+	']': tokenRightBracket,
+	'(': tokenLeftParen,
+	')': tokenRightParen,
+	'{': tokenLeftBrace,
+	'}': tokenRightBrace,
+	',': tokenComma,
+	';': tokenSemicolon,
+}
+
+func (l *lexer) skipSpaces() error {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				return l.errorf("unterminated comment")
+			}
+			comment := l.src[l.pos : l.pos+2+end+2]
+			l.line += strings.Count(comment, "\n")
+			l.pos += len(comment)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func (l *lexer) identifier() string {
+	start := l.pos
+	for l.pos < len(l.src) && (isIdentifierStart(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+		l.pos++
+	}
+	return l.src[start:l.pos]
+}
+
+// number has an optional quantifier K, M or G.
+func (l *lexer) number() (int64, error) {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	n, err := strconv.ParseInt(l.src[start:l.pos], 10, 64)
+	if err != nil {
+		return 0, l.errorf("invalid number %s", l.src[start:l.pos])
+	}
+	if l.pos < len(l.src) {
+		shift := 0
+		switch l.src[l.pos] {
+		case 'K', 'k':
+			shift = 10
+		case 'M', 'm':
+			shift = 20
+		case 'G', 'g':
+			shift = 30
+		}
+		if shift > 0 {
+			l.pos++
+			n <<= shift
+		}
+	}
+	return n, nil
+}
+
+// quotedString removes the backslash of every escaped character.
+func (l *lexer) quotedString() (string, error) {
+	var sb strings.Builder
+	for l.pos++; l.pos < len(l.src); l.pos++ {
+		c := l.src[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return sb.String(), nil
+		case '\\':
+			if l.pos+1 < len(l.src) {
+				l.pos++
+				c = l.src[l.pos]
+			}
+		case '\n':
+			l.line++
+		}
+		sb.WriteByte(c)
+	}
+	return "", l.errorf("unterminated string")
+}
+
+// multiLine reads lines until a line of a single ".", a leading ".." is unstuffed.
+func (l *lexer) multiLine() (string, error) {
+	// the rest of the first line must be white spaces or a comment
+	for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t') {
+		l.pos++
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '#' {
+		for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+			l.pos++
+		}
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos >= len(l.src) || l.src[l.pos] != '\n' {
+		return "", l.errorf("text: must be followed by a line break")
+	}
+	l.pos++
+	l.line++
+
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		end := strings.IndexByte(l.src[l.pos:], '\n')
+		if end < 0 {
+			break
+		}
+		line := l.src[l.pos : l.pos+end+1]
+		l.pos += end + 1
+		l.line++
+		if strings.TrimRight(line, "\r\n") == "." {
+			return sb.String(), nil
+		}
+		if strings.HasPrefix(line, "..") {
+			line = line[1:]
+		}
+		sb.WriteString(line)
+	}
+	return "", l.errorf("unterminated multi-line string")
+}
@@ -0,0 +1,111 @@
+package sieve
+
+import (
+	"regexp"
+	"strings"
+)
+
+// https://tex2e.github.io/rfc-translater/html/rfc5228.html#2-7-3--Comparators
+const (
+	comparatorOctet        = "i;octet"
+	comparatorAsciiCasemap = "i;ascii-casemap"
+)
+
+type matcher struct {
+	comparator string
+	// "is", "contains" or "matches"
+	matchType string
+}
+
+// match compares the value with the key, the strings matched by wildcards are returned for :matches.
+func (m matcher) match(value, key string) (bool, []string) {
+	if m.comparator == comparatorAsciiCasemap {
+		// positions of the lowered strings are the same as the original
+		lowered, loweredKey := asciiLower(value), asciiLower(key)
+		switch m.matchType {
+		case "contains":
+			return strings.Contains(lowered, loweredKey), nil
+		case "matches":
+			return matchWildcard(lowered, value, loweredKey)
+		default:
+			return lowered == loweredKey, nil
+		}
+	}
+
+	switch m.matchType {
+	case "contains":
+		return strings.Contains(value, key), nil
+	case "matches":
+		return matchWildcard(value, value, key)
+	default:
+		return value == key, nil
+	}
+}
+
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// matchWildcard matches the pattern of "*" and "?" with the compared string, the groups are taken from
+// the original string. "*" matches as few characters as possible.
+// https://tex2e.github.io/rfc-translater/html/rfc5229.html#3-2--Match-Variables
+func matchWildcard(compared, original, pattern string) (bool, []string) {
+	re := wildcardRegexp(pattern)
+	loc := re.FindStringSubmatchIndex(compared)
+	if loc == nil {
+		return false, nil
+	}
+	groups := make([]string, 0, len(loc)/2)
+	for i := 0; i < len(loc); i += 2 {
+		groups = append(groups, original[loc[i]:loc[i+1]])
+	}
+	return true, groups
+}
+
+func wildcardRegexp(pattern string) *regexp.Regexp {
+	var sb, literal strings.Builder
+	flush := func() {
+		sb.WriteString(regexp.QuoteMeta(literal.String()))
+		literal.Reset()
+	}
+
+	sb.WriteString(`(?s)^`)
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			flush()
+			sb.WriteString(`(.*?)`)
+		case '?':
+			flush()
+			sb.WriteString(`(.)`)
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			literal.WriteByte(pattern[i])
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+	sb.WriteString(`$`)
+	return regexp.MustCompile(sb.String())
+}
+
+// quoteWildcard escapes the characters which have a special meaning in :matches.
+func quoteWildcard(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '*' || s[i] == '?' || s[i] == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
@@ -0,0 +1,200 @@
+package sieve
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// nested multiparts deeper than this are not parsed
+const maxMimeDepth = 10
+
+// Message is the message and its envelope which the script is executed for.
+type Message struct {
+	// envelope sender, empty for the null sender
+	From string
+	// envelope recipient who owns the script
+	To  string
+	Raw []byte
+
+	parsed  bool
+	headers textproto.MIMEHeader
+	body    []byte
+	parts   []bodyPart
+}
+
+type bodyPart struct {
+	contentType string
+	// decoded content
+	content string
+}
+
+var wordDecoder = &mime.WordDecoder{
+	// other charsets are compared as they are encoded
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	},
+}
+
+func (m *Message) parse() {
+	if m.parsed {
+		return
+	}
+	m.parsed = true
+
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Raw))
+	if err != nil {
+		// the message has no header section
+		m.headers = textproto.MIMEHeader{}
+		m.body = m.Raw
+		m.parts = []bodyPart{{contentType: "text/plain", content: string(m.Raw)}}
+		return
+	}
+	m.headers = textproto.MIMEHeader(msg.Header)
+	m.body, _ = io.ReadAll(msg.Body)
+	m.walk(m.headers, bytes.NewReader(m.body), 0)
+}
+
+// Header returns the values of the header fields decoded by RFC 2047.
+func (m *Message) Header(name string) []string {
+	m.parse()
+	values := make([]string, 0)
+	for _, v := range m.headers.Values(name) {
+		if decoded, err := wordDecoder.DecodeHeader(v); err == nil {
+			v = decoded
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// https://tex2e.github.io/rfc-translater/html/rfc5173.html#5--Body-Transform
+func (m *Message) bodyTexts(transform string, contentTypes []string) []string {
+	m.parse()
+	if transform == "raw" {
+		return []string{string(m.body)}
+	}
+	if transform == "text" {
+		contentTypes = []string{"text"}
+	}
+
+	texts := make([]string, 0)
+	for _, p := range m.parts {
+		for _, t := range contentTypes {
+			if matchContentType(p.contentType, t) {
+				texts = append(texts, p.content)
+				break
+			}
+		}
+	}
+	return texts
+}
+
+// matchContentType matches "type/subtype" with "type/subtype", "type" or "" for all types.
+func matchContentType(contentType, pattern string) bool {
+	pattern = strings.ToLower(pattern)
+	if len(pattern) == 0 || contentType == pattern {
+		return true
+	}
+	return !strings.Contains(pattern, "/") && strings.HasPrefix(contentType, pattern+"/")
+}
+
+func (m *Message) walk(header textproto.MIMEHeader, body io.Reader, depth int) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < maxMimeDepth {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			p, err := reader.NextRawPart()
+			if err != nil {
+				return
+			}
+			m.walk(p.Header, p, depth+1)
+		}
+	}
+	if mediaType == "message/rfc822" && depth < maxMimeDepth {
+		if msg, err := mail.ReadMessage(body); err == nil {
+			m.walk(textproto.MIMEHeader(msg.Header), msg.Body, depth+1)
+			return
+		}
+	}
+
+	var decoded io.Reader
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		decoded = base64.NewDecoder(base64.StdEncoding, &newlineRemover{r: body})
+	case "quoted-printable":
+		decoded = quotedprintable.NewReader(body)
+	default:
+		decoded = body
+	}
+	content, _ := io.ReadAll(decoded)
+	m.parts = append(m.parts, bodyPart{contentType: mediaType, content: string(content)})
+}
+
+// newlineRemover removes line breaks in base64 content.
+type newlineRemover struct {
+	r io.Reader
+}
+
+func (n *newlineRemover) Read(p []byte) (int, error) {
+	for {
+		size, err := n.r.Read(p)
+		kept := 0
+		for _, b := range p[:size] {
+			if b != '\r' && b != '\n' {
+				p[kept] = b
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// addresses returns the addresses in the header fields, unparsable values are returned as they are.
+func (m *Message) addresses(name string) []string {
+	m.parse()
+	addresses := make([]string, 0)
+	// encoded words are decoded by the parser
+	for _, v := range m.headers.Values(name) {
+		list, err := mail.ParseAddressList(v)
+		if err != nil {
+			addresses = append(addresses, strings.TrimSpace(v))
+			continue
+		}
+		for _, a := range list {
+			addresses = append(addresses, a.Address)
+		}
+	}
+	return addresses
+}
+
+// addressPart returns the part of the address, the domain of addresses without "@" is empty.
+func addressPart(address, part string) string {
+	at := strings.LastIndex(address, "@")
+	switch part {
+	case "localpart":
+		if at < 0 {
+			return address
+		}
+		return address[:at]
+	case "domain":
+		if at < 0 {
+			return ""
+		}
+		return address[at+1:]
+	default:
+		return address
+	}
+}
@@ -0,0 +1,214 @@
+package sieve
+
+import "fmt"
+
+// https://tex2e.github.io/rfc-translater/html/rfc5228.html#8-2--Grammar
+type argumentType int
+
+const (
+	argumentTag argumentType = iota
+	argumentNumber
+	argumentStrings
+)
+
+type argument struct {
+	typ    argumentType
+	tag    string
+	number int64
+	// a single string is a list of one string
+	strings []string
+	// the strings are written in brackets
+	isList bool
+	line   int
+}
+
+type test struct {
+	name  string
+	args  []argument
+	tests []*test
+	line  int
+}
+
+type command struct {
+	name  string
+	args  []argument
+	tests []*test
+	// nil when the command has no block
+	block []*command
+	line  int
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func parse(src string) ([]*command, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	commands, err := p.commands()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokenEOF {
+		return nil, p.unexpected(t)
+	}
+	return commands, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) unexpected(t token) error {
+	return fmt.Errorf("line %d: unexpected %s", t.line, t)
+}
+
+func (p *parser) expect(typ tokenType) (token, error) {
+	t := p.advance()
+	if t.typ != typ {
+		return t, p.unexpected(t)
+	}
+	return t, nil
+}
+
+// commands parses commands until "}" or the end of the script.
+func (p *parser) commands() ([]*command, error) {
+	commands := make([]*command, 0)
+	for {
+		t := p.peek()
+		if t.typ != tokenIdentifier {
+			return commands, nil
+		}
+		cmd, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+}
+
+func (p *parser) command() (*command, error) {
+	name := p.advance()
+	cmd := &command{name: name.text, line: name.line}
+
+	var err error
+	if cmd.args, cmd.tests, err = p.arguments(); err != nil {
+		return nil, err
+	}
+
+	switch t := p.advance(); t.typ {
+	case tokenSemicolon:
+		return cmd, nil
+	case tokenLeftBrace:
+		if cmd.block, err = p.commands(); err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRightBrace); err != nil {
+			return nil, err
+		}
+		return cmd, nil
+	default:
+		return nil, p.unexpected(t)
+	}
+}
+
+// arguments parses arguments followed by a test or a test list.
+func (p *parser) arguments() ([]argument, []*test, error) {
+	args := make([]argument, 0)
+	for {
+		t := p.peek()
+		switch t.typ {
+		case tokenTag:
+			p.advance()
+			args = append(args, argument{typ: argumentTag, tag: t.text, line: t.line})
+		case tokenNumber:
+			p.advance()
+			args = append(args, argument{typ: argumentNumber, number: t.number, line: t.line})
+		case tokenString:
+			p.advance()
+			args = append(args, argument{typ: argumentStrings, strings: []string{t.text}, line: t.line})
+		case tokenLeftBracket:
+			list, err := p.stringList()
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, list)
+		case tokenIdentifier:
+			tst, err := p.test()
+			if err != nil {
+				return nil, nil, err
+			}
+			return args, []*test{tst}, nil
+		case tokenLeftParen:
+			tests, err := p.testList()
+			if err != nil {
+				return nil, nil, err
+			}
+			return args, tests, nil
+		default:
+			return args, nil, nil
+		}
+	}
+}
+
+func (p *parser) stringList() (argument, error) {
+	start := p.advance()
+	arg := argument{typ: argumentStrings, isList: true, line: start.line}
+	for {
+		t, err := p.expect(tokenString)
+		if err != nil {
+			return arg, err
+		}
+		arg.strings = append(arg.strings, t.text)
+		switch t := p.advance(); t.typ {
+		case tokenComma:
+		case tokenRightBracket:
+			return arg, nil
+		default:
+			return arg, p.unexpected(t)
+		}
+	}
+}
+
+func (p *parser) test() (*test, error) {
+	name, err := p.expect(tokenIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	tst := &test{name: name.text, line: name.line}
+	if tst.args, tst.tests, err = p.arguments(); err != nil {
+		return nil, err
+	}
+	return tst, nil
+}
+
+func (p *parser) testList() ([]*test, error) {
+	p.advance()
+	tests := make([]*test, 0)
+	for {
+		tst, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, tst)
+		switch t := p.advance(); t.typ {
+		case tokenComma:
+		case tokenRightParen:
+			return tests, nil
+		default:
+			return nil, p.unexpected(t)
+		}
+	}
+}
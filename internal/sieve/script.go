@@ -0,0 +1,517 @@
+package sieve
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// extensions which can be required by scripts
+// https://tex2e.github.io/rfc-translater/html/rfc5228.html#3-2--Control-require
+var supportedExtensions = map[string]bool{
+	"fileinto":                   true,
+	"reject":                     true,
+	"envelope":                   true,
+	"body":                       true,
+	"variables":                  true,
+	"vacation":                   true,
+	"comparator-i;octet":         true,
+	"comparator-i;ascii-casemap": true,
+}
+
+// extensions required by commands and tests, core commands are not listed
+var requiredExtensions = map[string]string{
+	"fileinto": "fileinto",
+	"reject":   "reject",
+	"vacation": "vacation",
+	"set":      "variables",
+	"envelope": "envelope",
+	"body":     "body",
+	"string":   "variables",
+}
+
+// Script is a compiled Sieve script.
+type Script struct {
+	extensions map[string]bool
+	commands   []node
+}
+
+// Compile parses the script and validates the arguments of every command and test.
+func Compile(src string) (*Script, error) {
+	commands, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &compiler{extensions: make(map[string]bool)}
+	// require is allowed only at the beginning of the script
+	for len(commands) > 0 && commands[0].name == "require" {
+		if err := c.require(commands[0]); err != nil {
+			return nil, err
+		}
+		commands = commands[1:]
+	}
+	nodes, err := c.commands(commands)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{extensions: c.extensions, commands: nodes}, nil
+}
+
+type compiler struct {
+	extensions map[string]bool
+}
+
+func errorAt(line int, format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (c *compiler) require(cmd *command) error {
+	if len(cmd.args) != 1 || cmd.args[0].typ != argumentStrings || len(cmd.tests) > 0 || cmd.block != nil {
+		return errorAt(cmd.line, "require needs a string list")
+	}
+	for _, ext := range cmd.args[0].strings {
+		if !supportedExtensions[ext] {
+			return errorAt(cmd.line, "unsupported extension %q", ext)
+		}
+		c.extensions[ext] = true
+	}
+	return nil
+}
+
+func (c *compiler) checkRequired(name string, line int) error {
+	if ext, ok := requiredExtensions[name]; ok && !c.extensions[ext] {
+		return errorAt(line, "%s requires extension %q", name, ext)
+	}
+	return nil
+}
+
+func (c *compiler) commands(commands []*command) ([]node, error) {
+	nodes := make([]node, 0, len(commands))
+	for i := 0; i < len(commands); i++ {
+		cmd := commands[i]
+		if cmd.name == "if" {
+			n, next, err := c.ifCommand(commands, i)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, n)
+			i = next
+			continue
+		}
+
+		n, err := c.command(cmd)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// ifCommand compiles "if" and the following "elsif" and "else", the index of the last one is returned.
+func (c *compiler) ifCommand(commands []*command, i int) (node, int, error) {
+	n := &ifNode{}
+	for ; i < len(commands); i++ {
+		cmd := commands[i]
+		if cmd.block == nil {
+			return nil, i, errorAt(cmd.line, "%s needs a block", cmd.name)
+		}
+		block, err := c.commands(cmd.block)
+		if err != nil {
+			return nil, i, err
+		}
+
+		if cmd.name == "else" {
+			if len(cmd.args) > 0 || len(cmd.tests) > 0 {
+				return nil, i, errorAt(cmd.line, "else takes no arguments")
+			}
+			n.elseBlock = block
+			return n, i, nil
+		}
+		if len(cmd.args) > 0 || len(cmd.tests) != 1 {
+			return nil, i, errorAt(cmd.line, "%s needs a test", cmd.name)
+		}
+		cond, err := c.test(cmd.tests[0])
+		if err != nil {
+			return nil, i, err
+		}
+		n.branches = append(n.branches, branch{cond: cond, block: block})
+
+		if i+1 >= len(commands) || (commands[i+1].name != "elsif" && commands[i+1].name != "else") {
+			return n, i, nil
+		}
+	}
+	return n, i, nil
+}
+
+func (c *compiler) command(cmd *command) (node, error) {
+	if err := c.checkRequired(cmd.name, cmd.line); err != nil {
+		return nil, err
+	}
+	if cmd.block != nil || len(cmd.tests) > 0 {
+		return nil, errorAt(cmd.line, "%s takes no block and no test", cmd.name)
+	}
+
+	switch cmd.name {
+	case "require":
+		return nil, errorAt(cmd.line, "require must be at the beginning of the script")
+	case "elsif", "else":
+		return nil, errorAt(cmd.line, "%s without if", cmd.name)
+	case "stop", "keep", "discard":
+		if len(cmd.args) > 0 {
+			return nil, errorAt(cmd.line, "%s takes no arguments", cmd.name)
+		}
+		return &actionNode{name: cmd.name}, nil
+	case "fileinto", "reject":
+		args, err := c.arguments(cmd.name, cmd.line, cmd.args, nil, 1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := singleString(cmd.name, args.positional[0])
+		if err != nil {
+			return nil, err
+		}
+		return &actionNode{name: cmd.name, value: value}, nil
+	case "redirect":
+		args, err := c.arguments(cmd.name, cmd.line, cmd.args, nil, 1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := singleString(cmd.name, args.positional[0])
+		if err != nil {
+			return nil, err
+		}
+		// addresses with variables are validated at runtime
+		if !strings.Contains(value, "${") {
+			if _, err := mail.ParseAddress(value); err != nil {
+				return nil, errorAt(cmd.line, "invalid redirect address %q", value)
+			}
+		}
+		return &actionNode{name: cmd.name, value: value}, nil
+	case "set":
+		return c.set(cmd)
+	case "vacation":
+		return c.vacation(cmd)
+	default:
+		return nil, errorAt(cmd.line, "unknown command %s", cmd.name)
+	}
+}
+
+// set modifiers and their precedence
+// https://tex2e.github.io/rfc-translater/html/rfc5229.html#4-1--Modifiers
+var setModifiers = map[string]int{
+	"lower":         40,
+	"upper":         40,
+	"lowerfirst":    30,
+	"upperfirst":    30,
+	"quotewildcard": 20,
+	"length":        10,
+}
+
+func (c *compiler) set(cmd *command) (node, error) {
+	tags := make(map[string]argumentType)
+	for modifier := range setModifiers {
+		tags[modifier] = argumentNone
+	}
+	args, err := c.arguments(cmd.name, cmd.line, cmd.args, tags, 2)
+	if err != nil {
+		return nil, err
+	}
+	name, err := singleString(cmd.name, args.positional[0])
+	if err != nil {
+		return nil, err
+	}
+	if !isVariableName(name) {
+		return nil, errorAt(cmd.line, "invalid variable name %q", name)
+	}
+	value, err := singleString(cmd.name, args.positional[1])
+	if err != nil {
+		return nil, err
+	}
+
+	n := &setNode{name: strings.ToLower(name), value: value}
+	// modifiers are applied in the order of decreasing precedence
+	for precedence := 40; precedence > 0; precedence -= 10 {
+		found := ""
+		for _, tag := range args.order {
+			if setModifiers[tag] != precedence {
+				continue
+			}
+			if len(found) > 0 {
+				return nil, errorAt(cmd.line, "modifiers :%s and :%s conflict", found, tag)
+			}
+			found = tag
+		}
+		if len(found) > 0 {
+			n.modifiers = append(n.modifiers, found)
+		}
+	}
+	return n, nil
+}
+
+func isVariableName(name string) bool {
+	if len(name) == 0 || !isIdentifierStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isIdentifierStart(name[i]) && !isDigit(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// https://tex2e.github.io/rfc-translater/html/rfc5230.html#4--Action-vacation
+func (c *compiler) vacation(cmd *command) (node, error) {
+	args, err := c.arguments(cmd.name, cmd.line, cmd.args, map[string]argumentType{
+		"days":      argumentNumber,
+		"subject":   argumentStrings,
+		"from":      argumentStrings,
+		"addresses": argumentStrings,
+		"mime":      argumentNone,
+		"handle":    argumentStrings,
+	}, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &vacationNode{days: defaultVacationDays}
+	if n.reason, err = singleString(cmd.name, args.positional[0]); err != nil {
+		return nil, err
+	}
+	if days, ok := args.tags["days"]; ok {
+		n.days = int(days.number)
+	}
+	stringTags := []struct {
+		tag   string
+		value *string
+	}{
+		{"subject", &n.subject},
+		{"from", &n.from},
+		{"handle", &n.handle},
+	}
+	for _, t := range stringTags {
+		if arg, ok := args.tags[t.tag]; ok {
+			if *t.value, err = singleString(":"+t.tag, arg); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if arg, ok := args.tags["addresses"]; ok {
+		n.addresses = arg.strings
+	}
+	_, n.mime = args.tags["mime"]
+	return n, nil
+}
+
+func (c *compiler) tests(tests []*test) ([]testNode, error) {
+	nodes := make([]testNode, 0, len(tests))
+	for _, t := range tests {
+		n, err := c.test(t)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+func (c *compiler) test(t *test) (testNode, error) {
+	if err := c.checkRequired(t.name, t.line); err != nil {
+		return nil, err
+	}
+	switch t.name {
+	case "allof", "anyof", "not":
+		if len(t.args) > 0 || len(t.tests) == 0 || (t.name == "not" && len(t.tests) != 1) {
+			return nil, errorAt(t.line, "invalid tests of %s", t.name)
+		}
+		tests, err := c.tests(t.tests)
+		if err != nil {
+			return nil, err
+		}
+		return &logicalTest{name: t.name, tests: tests}, nil
+	}
+
+	if len(t.tests) > 0 {
+		return nil, errorAt(t.line, "%s takes no tests", t.name)
+	}
+	switch t.name {
+	case "true", "false":
+		if len(t.args) > 0 {
+			return nil, errorAt(t.line, "%s takes no arguments", t.name)
+		}
+		return constantTest(t.name == "true"), nil
+	case "exists":
+		args, err := c.arguments(t.name, t.line, t.args, nil, 1)
+		if err != nil {
+			return nil, err
+		}
+		return &existsTest{headers: args.positional[0].strings}, nil
+	case "size":
+		args, err := c.arguments(t.name, t.line, t.args, map[string]argumentType{"over": argumentNone, "under": argumentNone}, 1)
+		if err != nil {
+			return nil, err
+		}
+		_, over := args.tags["over"]
+		_, under := args.tags["under"]
+		if over == under || args.positional[0].typ != argumentNumber {
+			return nil, errorAt(t.line, "size needs either :over or :under and a number")
+		}
+		return &sizeTest{over: over, limit: args.positional[0].number}, nil
+	case "header", "address", "envelope", "body", "string":
+		return c.matchTest(t)
+	default:
+		return nil, errorAt(t.line, "unknown test %s", t.name)
+	}
+}
+
+var addressParts = []string{"all", "localpart", "domain"}
+var matchTypes = []string{"is", "contains", "matches"}
+var bodyTransforms = []string{"raw", "content", "text"}
+
+// matchTest compiles the tests which compare strings by a comparator and a match type.
+func (c *compiler) matchTest(t *test) (testNode, error) {
+	tags := map[string]argumentType{"comparator": argumentStrings}
+	for _, tag := range matchTypes {
+		tags[tag] = argumentNone
+	}
+	if t.name == "address" || t.name == "envelope" {
+		for _, tag := range addressParts {
+			tags[tag] = argumentNone
+		}
+	}
+	if t.name == "body" {
+		for _, tag := range bodyTransforms {
+			tags[tag] = argumentNone
+		}
+		tags["content"] = argumentStrings
+	}
+
+	positional := 2
+	if t.name == "body" {
+		positional = 1
+	}
+	args, err := c.arguments(t.name, t.line, t.args, tags, positional)
+	if err != nil {
+		return nil, err
+	}
+
+	m := matcher{comparator: comparatorAsciiCasemap, matchType: "is"}
+	if arg, ok := args.tags["comparator"]; ok {
+		name, err := singleString(":comparator", arg)
+		if err != nil {
+			return nil, err
+		}
+		if name != comparatorOctet && name != comparatorAsciiCasemap {
+			return nil, errorAt(t.line, "unsupported comparator %q", name)
+		}
+		m.comparator = name
+	}
+	if m.matchType, err = args.exclusive(t.line, matchTypes, "is"); err != nil {
+		return nil, err
+	}
+
+	n := &matchTestNode{name: t.name, matcher: m, keys: args.positional[len(args.positional)-1].strings}
+	if positional == 2 {
+		n.sources = args.positional[0].strings
+	}
+	switch t.name {
+	case "address", "envelope":
+		if n.addressPart, err = args.exclusive(t.line, addressParts, "all"); err != nil {
+			return nil, err
+		}
+		if t.name == "envelope" {
+			for i, part := range n.sources {
+				n.sources[i] = strings.ToLower(part)
+				if n.sources[i] != "from" && n.sources[i] != "to" {
+					return nil, errorAt(t.line, "unsupported envelope part %q", part)
+				}
+			}
+		}
+	case "body":
+		if n.bodyTransform, err = args.exclusive(t.line, bodyTransforms, "text"); err != nil {
+			return nil, err
+		}
+		if n.bodyTransform == "content" {
+			n.contentTypes = args.tags["content"].strings
+		}
+	}
+	return n, nil
+}
+
+// argumentNone is the type of tags which take no value
+const argumentNone argumentType = -1
+
+type arguments struct {
+	tags map[string]argument
+	// tags in the written order
+	order      []string
+	positional []argument
+}
+
+// exclusive returns the only one of the tags given, or the default value.
+func (a *arguments) exclusive(line int, tags []string, defaultTag string) (string, error) {
+	found := ""
+	for _, tag := range tags {
+		if _, ok := a.tags[tag]; !ok {
+			continue
+		}
+		if len(found) > 0 {
+			return "", errorAt(line, ":%s and :%s conflict", found, tag)
+		}
+		found = tag
+	}
+	if len(found) == 0 {
+		return defaultTag, nil
+	}
+	return found, nil
+}
+
+// arguments splits tagged arguments and the positional arguments following them.
+func (c *compiler) arguments(name string, line int, args []argument, tags map[string]argumentType, positional int) (*arguments, error) {
+	res := &arguments{tags: make(map[string]argument)}
+	i := 0
+	for ; i < len(args) && args[i].typ == argumentTag; i++ {
+		tag := args[i].tag
+		typ, ok := tags[tag]
+		if !ok {
+			return nil, errorAt(line, "unknown tag :%s of %s", tag, name)
+		}
+		if _, ok := res.tags[tag]; ok {
+			return nil, errorAt(line, "duplicated tag :%s of %s", tag, name)
+		}
+		res.order = append(res.order, tag)
+		if typ == argumentNone {
+			res.tags[tag] = args[i]
+			continue
+		}
+		if i+1 >= len(args) || args[i+1].typ != typ {
+			return nil, errorAt(line, "tag :%s of %s needs a value", tag, name)
+		}
+		i++
+		res.tags[tag] = args[i]
+	}
+
+	res.positional = args[i:]
+	if len(res.positional) != positional {
+		return nil, errorAt(line, "%s takes %d positional arguments", name, positional)
+	}
+	for _, arg := range res.positional {
+		if arg.typ == argumentTag {
+			return nil, errorAt(line, "tag :%s of %s must precede positional arguments", arg.tag, name)
+		}
+		// only size takes a number
+		if arg.typ == argumentNumber && name != "size" {
+			return nil, errorAt(line, "%s takes strings", name)
+		}
+	}
+	return res, nil
+}
+
+func singleString(name string, arg argument) (string, error) {
+	if arg.typ != argumentStrings || arg.isList || len(arg.strings) != 1 {
+		return "", errorAt(arg.line, "%s needs a string", name)
+	}
+	return arg.strings[0], nil
+}
@@ -0,0 +1,271 @@
+package sieve
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		err    bool
+	}{
+		{
+			name:   "empty",
+			script: "",
+		},
+		{
+			name: "extensions",
+			script: `# sort mail
+require ["fileinto", "reject", "envelope", "body", "variables", "vacation"];
+if allof (envelope :domain :is "from" "example.com", not exists "X-Spam-Flag") {
+	fileinto "Work";
+} elsif header :contains ["Subject", "X-Subject"] "sale" {
+	discard;
+	stop;
+} elsif body :text :contains "unsubscribe" {
+	set :lower "folder" "Lists";
+	fileinto "${folder}";
+} elsif size :over 1M {
+	reject text:
+The message is too large.
+.
+;
+} else {
+	vacation :days 14 :subject "Away" :addresses ["me@example.com"] "I am away.";
+	keep;
+}
+/* end */`,
+		},
+		{
+			name:   "unknown extension",
+			script: `require "imap4flags";`,
+			err:    true,
+		},
+		{
+			name:   "extension not required",
+			script: `fileinto "Work";`,
+			err:    true,
+		},
+		{
+			name:   "require after command",
+			script: `keep; require "fileinto";`,
+			err:    true,
+		},
+		{
+			name:   "unknown command",
+			script: `forward "a@example.com";`,
+			err:    true,
+		},
+		{
+			name:   "else without if",
+			script: `else { keep; }`,
+			err:    true,
+		},
+		{
+			name:   "if without test",
+			script: `if { keep; }`,
+			err:    true,
+		},
+		{
+			name:   "conflicting match types",
+			script: `if header :is :contains "Subject" "a" { keep; }`,
+			err:    true,
+		},
+		{
+			name:   "unsupported comparator",
+			script: `if header :comparator "i;ascii-numeric" "X-Priority" "1" { keep; }`,
+			err:    true,
+		},
+		{
+			name:   "invalid redirect address",
+			script: `redirect "not an address";`,
+			err:    true,
+		},
+		{
+			name:   "size without number",
+			script: `if size :over "1K" { discard; }`,
+			err:    true,
+		},
+		{
+			name:   "missing semicolon",
+			script: `keep`,
+			err:    true,
+		},
+		{
+			name:   "unterminated string",
+			script: `if header :is "Subject "a { keep; }`,
+			err:    true,
+		},
+		{
+			name:   "conflicting modifiers",
+			script: `require "variables"; set :lower :upper "a" "b";`,
+			err:    true,
+		},
+		{
+			name:   "unsupported envelope part",
+			script: `require "envelope"; if envelope "auth" "a" { keep; }`,
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Compile(test.script)
+			assert.Equal(t, test.err, err != nil, err)
+		})
+	}
+}
+
+const testMessage = "From: \"Alice\" <alice@example.com>\r\n" +
+	"To: bob@example.org, carol@example.org\r\n" +
+	"Subject: =?UTF-8?B?5Lya6K2w?= notes\r\n" +
+	"List-Id: <dev.lists.example.com>\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"b\"\r\n" +
+	"\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Please read the =\r\nminutes.\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>Please read</p>\r\n" +
+	"--b--\r\n"
+
+func TestScript_Run(t *testing.T) {
+	msg := func() *Message {
+		return &Message{From: "alice@example.com", To: "bob+dev@example.org", Raw: []byte(testMessage)}
+	}
+
+	tests := []struct {
+		name     string
+		script   string
+		expected *Result
+		err      bool
+	}{
+		{
+			name:     "implicit keep",
+			script:   "",
+			expected: &Result{Keep: true},
+		},
+		{
+			name:     "discard",
+			script:   `discard;`,
+			expected: &Result{},
+		},
+		{
+			name:     "decoded header",
+			script:   `require "fileinto"; if header :contains "subject" "会議" { fileinto "Meetings"; }`,
+			expected: &Result{FileInto: []string{"Meetings"}},
+		},
+		{
+			name:     "case-sensitive comparator",
+			script:   `require "fileinto"; if header :comparator "i;octet" :contains "Subject" "NOTES" { fileinto "Meetings"; }`,
+			expected: &Result{Keep: true},
+		},
+		{
+			name:     "address parts",
+			script:   `require "fileinto"; if address :domain :is "to" "EXAMPLE.org" { fileinto "Org"; } if address :localpart "from" "bob" { discard; }`,
+			expected: &Result{FileInto: []string{"Org"}},
+		},
+		{
+			name:     "envelope",
+			script:   `require ["envelope", "fileinto"]; if envelope :localpart :matches "to" "*+dev" { fileinto "Dev"; }`,
+			expected: &Result{FileInto: []string{"Dev"}},
+		},
+		{
+			name:     "match variables",
+			script:   `require ["fileinto", "variables"]; if header :matches "List-Id" "<*.lists.*>" { set :upperfirst "list" "${1}"; fileinto "Lists.${list}"; }`,
+			expected: &Result{FileInto: []string{"Lists.Dev"}},
+		},
+		{
+			name:     "decoded body",
+			script:   `require "body"; if body :contains "read the minutes" { redirect "archive@example.com"; }`,
+			expected: &Result{Redirect: []string{"archive@example.com"}},
+		},
+		{
+			name:     "body of content type",
+			script:   `require "body"; if body :content "text/html" :contains "minutes" { discard; }`,
+			expected: &Result{Keep: true},
+		},
+		{
+			name:     "raw body",
+			script:   `require "body"; if body :raw :contains "the =" { discard; }`,
+			expected: &Result{},
+		},
+		{
+			name:     "size and stop",
+			script:   `if size :under 10K { keep; stop; } discard;`,
+			expected: &Result{Keep: true},
+		},
+		{
+			name:   "vacation",
+			script: `require ["vacation", "variables"]; set "name" "Bob"; vacation :days 3 :subject "Away" :handle "h" "${name} is away.";`,
+			expected: &Result{
+				Keep:     true,
+				Vacation: &Vacation{Reason: "Bob is away.", Subject: "Away", Days: 3, Handle: "h", Addresses: []string{}},
+			},
+		},
+		{
+			name:     "reject",
+			script:   `require "reject"; if exists "List-Id" { reject "no lists"; }`,
+			expected: &Result{Reject: true, RejectReason: "no lists"},
+		},
+		{
+			name:     "reject with keep is an error",
+			script:   `require "reject"; keep; reject "no";`,
+			expected: &Result{Keep: true},
+			err:      true,
+		},
+		{
+			name:     "string and modifiers",
+			script:   `require ["variables", "fileinto"]; set :length "n" "日本"; if string :is "${n}" "2" { fileinto "Two"; }`,
+			expected: &Result{FileInto: []string{"Two"}},
+		},
+		{
+			name:     "unknown variable is empty",
+			script:   `require ["variables", "fileinto"]; fileinto "a${unknown}b${ x}";`,
+			expected: &Result{FileInto: []string{"ab${ x}"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := Compile(test.script)
+			assert.Nil(t, err)
+
+			res, err := script.Run(msg())
+			assert.Equal(t, test.err, err != nil, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestMatcher_Match(t *testing.T) {
+	tests := []struct {
+		matcher matcher
+		value   string
+		key     string
+		ok      bool
+		groups  []string
+	}{
+		{matcher{comparatorAsciiCasemap, "is"}, "Hello", "hELLO", true, nil},
+		{matcher{comparatorOctet, "is"}, "Hello", "hello", false, nil},
+		{matcher{comparatorAsciiCasemap, "contains"}, "Hello World", "O W", true, nil},
+		{matcher{comparatorAsciiCasemap, "matches"}, "Re: Hello", "re: *", true, []string{"Re: Hello", "Hello"}},
+		{matcher{comparatorAsciiCasemap, "matches"}, "a.b.c", "*.*", true, []string{"a.b.c", "a", "b.c"}},
+		{matcher{comparatorAsciiCasemap, "matches"}, "日本", "?本", true, []string{"日本", "日"}},
+		{matcher{comparatorAsciiCasemap, "matches"}, "a*b", "a\\*b", true, []string{"a*b"}},
+		{matcher{comparatorAsciiCasemap, "matches"}, "axb", "a\\*b", false, nil},
+	}
+
+	for _, test := range tests {
+		ok, groups := test.matcher.match(test.value, test.key)
+		assert.Equal(t, test.ok, ok, test.key)
+		assert.Equal(t, test.groups, groups, test.key)
+	}
+}
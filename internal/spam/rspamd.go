@@ -0,0 +1,105 @@
+package spam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rspamd HTTP protocol
+// https://rspamd.com/doc/developers/protocol.html
+const rspamdCheckPath = "/checkv2"
+
+// actions of rspamd which mean the message is not spam
+var rspamdHamActions = map[string]bool{
+	"no action": true,
+	"greylist":  true,
+}
+
+type rspamdScanner struct {
+	url string
+	// password of the controller, not sent when empty
+	password string
+	client   *http.Client
+}
+
+type rspamdResponse struct {
+	Score         float64                    `json:"score"`
+	RequiredScore float64                    `json:"required_score"`
+	Action        string                     `json:"action"`
+	Symbols       map[string]json.RawMessage `json:"symbols"`
+}
+
+// Scan posts the message to /checkv2, the envelope is sent in the request headers.
+func (c *rspamdScanner) Scan(ctx context.Context, req *Request) (*Result, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+rspamdCheckPath, bytes.NewReader(req.Message))
+	if err != nil {
+		return nil, err
+	}
+	if req.IP != nil {
+		httpReq.Header.Set("IP", req.IP.String())
+	}
+	setHeader(httpReq.Header, "Helo", req.Helo)
+	setHeader(httpReq.Header, "Hostname", req.Hostname)
+	setHeader(httpReq.Header, "From", req.From)
+	for _, rcpt := range req.Rcpt {
+		httpReq.Header.Add("Rcpt", rcpt)
+	}
+	setHeader(httpReq.Header, "Queue-Id", req.QueueId)
+	setHeader(httpReq.Header, "User", req.User)
+	setHeader(httpReq.Header, "Password", c.password)
+
+	httpRes, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpRes.Body.Close()
+	if httpRes.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(httpRes.Body, 512))
+		return nil, fmt.Errorf("rspamd error %s: %s", httpRes.Status, strings.TrimSpace(string(body)))
+	}
+
+	var res rspamdResponse
+	if err := json.NewDecoder(httpRes.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("invalid rspamd response: %w", err)
+	}
+	if len(res.Action) == 0 {
+		return nil, fmt.Errorf("rspamd response has no action")
+	}
+
+	result := &Result{
+		Score:    res.Score,
+		Required: res.RequiredScore,
+		Spam:     !rspamdHamActions[res.Action],
+	}
+	for symbol := range res.Symbols {
+		result.Symbols = append(result.Symbols, symbol)
+	}
+	sort.Strings(result.Symbols)
+	return result, nil
+}
+
+func setHeader(header http.Header, key, value string) {
+	if len(value) > 0 {
+		header.Set(key, value)
+	}
+}
+
+func (c *rspamdScanner) String() string {
+	return "rspamd " + c.url
+}
+
+// NewRspamdScanner returns the scanner posting to the worker of rspamd such as "http://localhost:11333".
+func NewRspamdScanner(url, password string, timeout time.Duration) Scanner {
+	return &rspamdScanner{
+		url:      strings.TrimSuffix(url, "/"),
+		password: password,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
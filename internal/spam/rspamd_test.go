@@ -0,0 +1,90 @@
+package spam
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRspamdScanner_Scan(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		expected *Result
+		err      bool
+	}{
+		{
+			name:     "spam",
+			status:   http.StatusOK,
+			response: `{"is_skipped":false,"score":16.5,"required_score":15,"action":"reject","symbols":{"GTUBE":{"score":15},"MISSING_DATE":{"score":1.5}}}`,
+			expected: &Result{Score: 16.5, Required: 15, Spam: true, Symbols: []string{"GTUBE", "MISSING_DATE"}},
+		},
+		{
+			name:     "ham",
+			status:   http.StatusOK,
+			response: `{"score":0.3,"required_score":15,"action":"no action","symbols":{}}`,
+			expected: &Result{Score: 0.3, Required: 15},
+		},
+		{
+			name:     "server error",
+			status:   http.StatusInternalServerError,
+			response: `{"error":"internal"}`,
+			err:      true,
+		},
+		{
+			name:     "broken response",
+			status:   http.StatusOK,
+			response: `{"score":`,
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req *http.Request
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req = r
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.response))
+			}))
+			defer server.Close()
+
+			scanner := NewRspamdScanner(server.URL+"/", "secret", time.Second)
+			res, err := scanner.Scan(context.Background(), &Request{
+				Message: []byte("Subject: test\n\nbody\n"),
+				IP:      net.ParseIP("192.0.2.1"),
+				Helo:    "client.example.com",
+				From:    "from@example.com",
+				Rcpt:    []string{"a@example.com", "b@example.com"},
+				QueueId: "queue-id",
+			})
+
+			assert.Equal(t, http.MethodPost, req.Method)
+			assert.Equal(t, "/checkv2", req.URL.Path)
+			assert.Equal(t, "192.0.2.1", req.Header.Get("IP"))
+			assert.Equal(t, "client.example.com", req.Header.Get("Helo"))
+			assert.Equal(t, "from@example.com", req.Header.Get("From"))
+			assert.Equal(t, []string{"a@example.com", "b@example.com"}, req.Header.Values("Rcpt"))
+			assert.Equal(t, "queue-id", req.Header.Get("Queue-Id"))
+			assert.Equal(t, "secret", req.Header.Get("Password"))
+			// empty values are not sent
+			assert.Empty(t, req.Header.Values("User"))
+			assert.Equal(t, "Subject: test\n\nbody\n", string(body))
+			if test.err {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package spam
+
+import (
+	"context"
+	"net"
+)
+
+// Request is the message submitted to the scanner with its envelope.
+type Request struct {
+	Message []byte
+	// client IP address, nil when unknown
+	IP net.IP
+	// domain received by HELO/EHLO
+	Helo string
+	// client hostname confirmed by reverse DNS, empty when unknown
+	Hostname string
+	From     string
+	Rcpt     []string
+	// identifier of the message shown in the logs of the scanner
+	QueueId string
+	// user authenticated by AUTH
+	User string
+}
+
+// Result is the verdict of the scanner.
+type Result struct {
+	Score float64
+	// score from which the scanner regards the message as spam
+	Required float64
+	Spam     bool
+	// names of the rules matched by the message
+	Symbols []string
+}
+
+// Scanner scores messages by an external spam scanner.
+type Scanner interface {
+	Scan(ctx context.Context, req *Request) (*Result, error)
+	// String returns the address of the scanner for logging.
+	String() string
+}
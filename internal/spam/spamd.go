@@ -0,0 +1,140 @@
+package spam
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SpamAssassin spamd protocol
+// https://svn.apache.org/repos/asf/spamassassin/trunk/spamd/PROTOCOL
+const spamcVersion = "SPAMC/1.5"
+
+type spamdScanner struct {
+	network string
+	address string
+	// user whose preferences are used by spamd, the default preferences are used when empty
+	user    string
+	timeout time.Duration
+}
+
+// Scan sends SYMBOLS command, the envelope is not sent because spamd reads only the message.
+func (c *spamdScanner) Scan(ctx context.Context, req *Request) (*Result, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if c.timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "SYMBOLS %s\r\n", spamcVersion)
+	fmt.Fprintf(&buf, "Content-length: %d\r\n", len(req.Message))
+	if len(c.user) > 0 {
+		fmt.Fprintf(&buf, "User: %s\r\n", c.user)
+	}
+	buf.WriteString("\r\n")
+	buf.Write(req.Message)
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	// spamd reads the message until EOF when it does not trust Content-length
+	if tcp, ok := conn.(interface{ CloseWrite() error }); ok {
+		tcp.CloseWrite()
+	}
+
+	return readSpamdResponse(bufio.NewReader(conn))
+}
+
+// readSpamdResponse parses "SPAMD/1.1 0 EX_OK", the headers and the symbols separated by commas.
+func readSpamdResponse(r *bufio.Reader) (*Result, error) {
+	reader := textproto.NewReader(r)
+	line, err := reader.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[0], "SPAMD/") {
+		return nil, fmt.Errorf("invalid spamd response %q", line)
+	}
+	if fields[1] != "0" {
+		return nil, fmt.Errorf("spamd error %s", strings.Join(fields[1:], " "))
+	}
+
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	res, err := parseSpamHeader(header.Get("Spam"))
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, symbol := range strings.Split(strings.TrimSpace(string(body)), ",") {
+		if symbol = strings.TrimSpace(symbol); len(symbol) > 0 {
+			res.Symbols = append(res.Symbols, symbol)
+		}
+	}
+	sort.Strings(res.Symbols)
+	return res, nil
+}
+
+// parseSpamHeader parses the value of Spam header such as "True ; 15.0 / 5.0".
+func parseSpamHeader(value string) (*Result, error) {
+	verdict, scores, ok := strings.Cut(value, ";")
+	if !ok {
+		return nil, fmt.Errorf("invalid spamd Spam header %q", value)
+	}
+	score, required, ok := strings.Cut(scores, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid spamd Spam header %q", value)
+	}
+
+	res := &Result{}
+	switch strings.ToLower(strings.TrimSpace(verdict)) {
+	case "true", "yes":
+		res.Spam = true
+	case "false", "no":
+	default:
+		return nil, fmt.Errorf("invalid spamd Spam header %q", value)
+	}
+	var err error
+	if res.Score, err = strconv.ParseFloat(strings.TrimSpace(score), 64); err != nil {
+		return nil, fmt.Errorf("invalid spamd score %q: %w", value, err)
+	}
+	if res.Required, err = strconv.ParseFloat(strings.TrimSpace(required), 64); err != nil {
+		return nil, fmt.Errorf("invalid spamd score %q: %w", value, err)
+	}
+	return res, nil
+}
+
+func (c *spamdScanner) String() string {
+	return "spamd " + c.network + ":" + c.address
+}
+
+// NewSpamdScanner returns the scanner connecting to spamd by "tcp" with "host:port" or "unix" with the socket path.
+func NewSpamdScanner(network, address, user string, timeout time.Duration) Scanner {
+	return &spamdScanner{
+		network: network,
+		address: address,
+		user:    user,
+		timeout: timeout,
+	}
+}
@@ -0,0 +1,108 @@
+package spam
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type spamdRequest struct {
+	command string
+	header  textproto.MIMEHeader
+	message string
+}
+
+// startSpamd starts a stub spamd which replies response to each request.
+func startSpamd(t *testing.T, response string) (string, <-chan spamdRequest) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	requests := make(chan spamdRequest, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			reader := textproto.NewReader(bufio.NewReader(conn))
+			command, _ := reader.ReadLine()
+			header, _ := reader.ReadMIMEHeader()
+			length, _ := strconv.Atoi(header.Get("Content-length"))
+			message := make([]byte, length)
+			io.ReadFull(reader.R, message)
+			requests <- spamdRequest{command: command, header: header, message: string(message)}
+
+			conn.Write([]byte(response))
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), requests
+}
+
+func TestSpamdScanner_Scan(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		expected *Result
+		err      bool
+	}{
+		{
+			name:     "spam",
+			response: "SPAMD/1.1 0 EX_OK\r\nContent-length: 24\r\nSpam: True ; 15.2 / 5.0\r\n\r\nGTUBE,MISSING_DATE\r\n",
+			expected: &Result{Score: 15.2, Required: 5, Spam: true, Symbols: []string{"GTUBE", "MISSING_DATE"}},
+		},
+		{
+			name:     "ham without symbols",
+			response: "SPAMD/1.1 0 EX_OK\r\nSpam: False ; -0.5 / 5.0\r\n\r\n",
+			expected: &Result{Score: -0.5, Required: 5},
+		},
+		{
+			name:     "error",
+			response: "SPAMD/1.0 76 Bad header line: foo\r\n",
+			err:      true,
+		},
+		{
+			name:     "broken score",
+			response: "SPAMD/1.1 0 EX_OK\r\nSpam: True ; high / 5.0\r\n\r\n",
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address, requests := startSpamd(t, test.response)
+			scanner := NewSpamdScanner("tcp", address, "filter", time.Second)
+
+			res, err := scanner.Scan(context.Background(), &Request{Message: []byte("Subject: test\r\n\r\nbody\r\n")})
+
+			req := <-requests
+			assert.Equal(t, "SYMBOLS SPAMC/1.5", req.command)
+			assert.Equal(t, "filter", req.header.Get("User"))
+			assert.Equal(t, "Subject: test\r\n\r\nbody\r\n", req.message)
+			if test.err {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, res)
+		})
+	}
+}
+
+func TestSpamdScanner_Unavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := ln.Addr().String()
+	ln.Close()
+
+	_, err = NewSpamdScanner("tcp", address, "", time.Second).Scan(context.Background(), &Request{Message: []byte("body\r\n")})
+	assert.NotNil(t, err)
+}